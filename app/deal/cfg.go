@@ -0,0 +1,40 @@
+package deal
+
+import (
+	"time"
+
+	"smecalculus/rolevod/lib/core"
+)
+
+type props struct {
+	Workers  int           `mapstructure:"workers"`
+	Interval time.Duration `mapstructure:"interval"`
+	Timeout  timeoutProps  `mapstructure:"timeout"`
+	Queue    queueProps    `mapstructure:"queue"`
+	// max delay between reduction attempts of failed proc
+	Backoff time.Duration `mapstructure:"backoff"`
+}
+
+// action taken on pending step expiry
+type timeoutProps struct {
+	// abort or inject
+	Action string `mapstructure:"action"`
+	// label injected into case with such branch, otherwise abort
+	Label core.Label `mapstructure:"label"`
+}
+
+// asynchronous sending on positive channels
+type queueProps struct {
+	// max number of msgs sent ahead of receiver, zero turns queueing off
+	Bound int `mapstructure:"bound"`
+}
+
+// pool which reduction workers share with api requests
+type poolProps struct {
+	MaxConns int `mapstructure:"max_conns"`
+}
+
+const (
+	abortAction  = "abort"
+	injectAction = "inject"
+)
@@ -1,15 +1,24 @@
 package deal
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"sync"
+	"time"
 
 	"golang.org/x/exp/maps"
 
 	"smecalculus/rolevod/lib/ak"
+	"smecalculus/rolevod/lib/core"
+	"smecalculus/rolevod/lib/data"
 	"smecalculus/rolevod/lib/id"
 	"smecalculus/rolevod/lib/ph"
 	"smecalculus/rolevod/lib/pol"
+	"smecalculus/rolevod/lib/sym"
 
 	"smecalculus/rolevod/internal/chnl"
 	"smecalculus/rolevod/internal/state"
@@ -43,6 +52,7 @@ type Environment struct {
 	sigs   map[sig.ID]sig.Root
 	roles  map[role.FQN]role.Root
 	states map[state.ID]state.Root
+	defs   state.Env
 }
 
 func (e Environment) Contains(id sig.ID) bool {
@@ -60,7 +70,7 @@ func (e Environment) LookupCEs(id sig.ID) []state.EP {
 	decl := e.sigs[id]
 	ces := []state.EP{}
 	for _, ce := range decl.CEs {
-		role := e.roles[decl.PE.Link]
+		role := e.roles[ce.Link]
 		ces = append(ces, state.EP{Z: ce.Link, C: e.states[role.StateID]})
 	}
 	return ces
@@ -69,6 +79,9 @@ func (e Environment) LookupCEs(id sig.ID) []state.EP {
 type Configuration struct {
 	chnls  map[chnl.ID]chnl.Root
 	states map[state.ID]state.Root
+	defs   state.Env
+	// Agent Access Key, empty if reduction is taken by engine
+	key ak.ADT
 }
 
 func (c *Configuration) LookupCh(id chnl.ID) (chnl.Root, bool) {
@@ -79,19 +92,19 @@ func (c *Configuration) LookupCh(id chnl.ID) (chnl.Root, bool) {
 	return ch, true
 }
 
-func (c *Configuration) LookupSt(id chnl.ID) (state.Root, bool) {
+func (c *Configuration) LookupSt(id chnl.ID) (state.Root, error) {
 	ch, ok := c.chnls[id]
 	if !ok {
-		return nil, false
+		return nil, chnl.ErrMissingInCfg(id)
 	}
 	if ch.StateID == nil {
-		return nil, false
+		return nil, chnl.ErrAlreadyClosed(id)
 	}
 	st, ok := c.states[*ch.StateID]
 	if !ok {
 		panic(state.ErrMissingInCfg(*ch.StateID))
 	}
-	return st, true
+	return state.Unfold(c.defs, st)
 }
 
 func (c *Configuration) Add(ch chnl.Root) {
@@ -116,8 +129,20 @@ type API interface {
 	Retrieve(ID) (Root, error)
 	RetreiveAll() ([]Ref, error)
 	Establish(KinshipSpec) error
-	Involve(PartSpec) (chnl.Root, error)
+	Involve(PartSpec) (PartRoot, error)
 	Take(TranSpec) error
+	Rotate(KeySpec) (ak.ADT, error)
+	Cancel(CancelSpec) error
+	RetrieveDeadlocks(ID) ([]Deadlock, error)
+	RetrieveHistory(HistorySpec) ([]step.Entry, error)
+	RetrieveSnapshot(SnapSpec) (Snapshot, error)
+	RetrieveObligations(ObligSpec) ([]Obligation, error)
+}
+
+// Tracker streams channel events, so that agents don't have to poll
+type Tracker interface {
+	// Track delivers events till channel closing or ctx cancellation
+	Track(context.Context, EventSpec) (<-chan chnl.Event, error)
 }
 
 type service struct {
@@ -128,7 +153,14 @@ type service struct {
 	steps    step.Repo
 	states   state.Repo
 	kinships kinshipRepo
-	log      *slog.Logger
+	keys     keyRepo
+	operator data.Operator
+	subs     data.Subscriber
+	// max number of msgs sent ahead of receiver
+	queueBound int
+	// wakes up reduction engine
+	ready chan struct{}
+	log   *slog.Logger
 }
 
 // for compilation purposes
@@ -144,11 +176,15 @@ func newService(
 	steps step.Repo,
 	states state.Repo,
 	kinships kinshipRepo,
+	keys keyRepo,
+	operator data.Operator,
+	subs data.Subscriber,
+	p *props,
 	l *slog.Logger,
 ) *service {
 	name := slog.String("name", "dealService")
 	return &service{
-		deals, roles, sigs, chnls, steps, states, kinships, l.With(name),
+		deals, roles, sigs, chnls, steps, states, kinships, keys, operator, subs, p.Queue.Bound, make(chan struct{}, 1), l.With(name),
 	}
 }
 
@@ -158,7 +194,10 @@ func (s *service) Create(spec Spec) (Root, error) {
 		ID:   id.New(),
 		Name: spec.Name,
 	}
-	err := s.deals.Insert(root)
+	ctx := context.Background()
+	err := s.operator.Explicit(ctx, func(ds data.Source) error {
+		return s.deals.Insert(ds, root)
+	})
 	if err != nil {
 		s.log.Error("deal insertion failed",
 			slog.Any("reason", err),
@@ -170,20 +209,29 @@ func (s *service) Create(spec Spec) (Root, error) {
 	return root, nil
 }
 
-func (s *service) Retrieve(id ID) (Root, error) {
-	root, err := s.deals.SelectByID(id)
-	if err != nil {
-		return Root{}, err
-	}
-	root.Children, err = s.deals.SelectChildren(id)
+func (s *service) Retrieve(id ID) (root Root, err error) {
+	ctx := context.Background()
+	err = s.operator.Implicit(ctx, func(ds data.Source) error {
+		root, err = s.deals.SelectByID(ds, id)
+		if err != nil {
+			return err
+		}
+		root.Children, err = s.deals.SelectChildren(ds, id)
+		return err
+	})
 	if err != nil {
 		return Root{}, err
 	}
 	return root, nil
 }
 
-func (s *service) RetreiveAll() ([]Ref, error) {
-	return s.deals.SelectAll()
+func (s *service) RetreiveAll() (refs []Ref, err error) {
+	ctx := context.Background()
+	err = s.operator.Implicit(ctx, func(ds data.Source) error {
+		refs, err = s.deals.SelectAll(ds)
+		return err
+	})
+	return refs, err
 }
 
 func (s *service) Establish(spec KinshipSpec) error {
@@ -196,7 +244,10 @@ func (s *service) Establish(spec KinshipSpec) error {
 		Parent:   Ref{ID: spec.ParentID},
 		Children: children,
 	}
-	err := s.kinships.Insert(root)
+	ctx := context.Background()
+	err := s.operator.Explicit(ctx, func(ds data.Source) error {
+		return s.kinships.Insert(ds, root)
+	})
 	if err != nil {
 		return err
 	}
@@ -204,39 +255,64 @@ func (s *service) Establish(spec KinshipSpec) error {
 	return nil
 }
 
-func (s *service) Involve(gotSpec PartSpec) (chnl.Root, error) {
+func (s *service) Involve(spec PartSpec) (part PartRoot, err error) {
+	ctx := context.Background()
+	err = s.operator.Explicit(ctx, func(ds data.Source) error {
+		part, err = s.involve(ds, spec)
+		return err
+	})
+	if err != nil {
+		return PartRoot{}, err
+	}
+	s.wakeUp()
+	return part, nil
+}
+
+func (s *service) involve(ds data.Source, gotSpec PartSpec) (PartRoot, error) {
 	s.log.Debug("sig involvement started", slog.Any("spec", gotSpec))
-	wantSig, err := s.sigs.SelectByID(gotSpec.Sig)
+	wantSig, err := s.sigs.SelectByID(ds, gotSpec.Sig)
 	if err != nil {
 		s.log.Error("signature selection failed",
 			slog.Any("reason", err),
 			slog.Any("id", gotSpec.Sig),
 		)
-		return chnl.Root{}, err
+		return PartRoot{}, err
 	}
-	wantRole, err := s.roles.SelectByFQN(wantSig.PE.Link)
+	wantRole, err := s.roles.SelectByFQN(ds, wantSig.PE.Link)
 	if err != nil {
 		s.log.Error("role selection failed",
 			slog.Any("reason", err),
 			slog.Any("fqn", wantSig.PE.Link),
 		)
-		return chnl.Root{}, err
+		return PartRoot{}, err
 	}
 	newPE := chnl.Root{
 		ID:      id.New(),
 		Key:     wantSig.PE.Key,
 		StateID: &wantRole.StateID,
 	}
-	err = s.chnls.Insert(newPE)
+	err = s.chnls.Insert(ds, newPE)
 	if err != nil {
 		s.log.Error("providable endpoint insertion failed",
 			slog.Any("reason", err),
 			slog.Any("pe", newPE),
 		)
-		return chnl.Root{}, err
+		return PartRoot{}, err
+	}
+	// spawned processes are members by clientship
+	if !gotSpec.Deal.IsEmpty() {
+		err = s.deals.InsertPart(ds, gotSpec.Deal, newPE.ID)
+		if err != nil {
+			s.log.Error("part insertion failed",
+				slog.Any("reason", err),
+				slog.Any("deal", gotSpec.Deal),
+				slog.Any("pe", newPE.ID),
+			)
+			return PartRoot{}, err
+		}
 	}
 	if len(gotSpec.TEs) > 0 {
-		err = s.chnls.Transfer(gotSpec.Owner, newPE.ID, gotSpec.TEs)
+		err = s.chnls.Transfer(ds, gotSpec.Owner, newPE.ID, gotSpec.TEs)
 		if err != nil {
 			s.log.Error("context transfer failed",
 				slog.Any("reason", err),
@@ -244,36 +320,158 @@ func (s *service) Involve(gotSpec PartSpec) (chnl.Root, error) {
 				slog.Any("to", newPE.ID),
 				slog.Any("tes", gotSpec.TEs),
 			)
-			return chnl.Root{}, err
+			return PartRoot{}, err
+		}
+	}
+	if wantSig.Body != nil {
+		err = s.checkTEs(ds, wantSig, gotSpec.TEs)
+		if err != nil {
+			s.log.Error("sig involvement failed",
+				slog.Any("reason", err),
+				slog.Any("tes", gotSpec.TEs),
+			)
+			return PartRoot{}, err
+		}
+		// server side process
+		newProc := step.ProcRoot{
+			ID:       id.New(),
+			PID:      newPE.ID,
+			Term:     instantiateBody(wantSig, newPE.ID, gotSpec.TEs),
+			Deadline: deadlineOf(wantSig),
 		}
+		err = s.schedule(ds, newProc)
+		if err != nil {
+			return PartRoot{}, err
+		}
+		s.log.Debug("sig involvement succeeded", slog.Any("proc", newProc))
+		return PartRoot{PE: newPE}, nil
+	}
+	// client side process driven by agent
+	newKey := KeyRoot{PID: newPE.ID, AK: ak.New()}
+	err = s.keys.Insert(ds, newKey)
+	if err != nil {
+		s.log.Error("access key insertion failed",
+			slog.Any("reason", err),
+			slog.Any("pid", newKey.PID),
+		)
+		return PartRoot{}, err
 	}
 	newProc := step.ProcRoot{
 		ID:  id.New(),
 		PID: newPE.ID,
 		Term: step.CTASpec{
-			AK:  ak.New(),
+			AK:  newKey.AK,
 			Sig: gotSpec.Sig,
 		},
 	}
-	err = s.steps.Insert(newProc)
+	err = s.steps.Insert(ds, newProc)
 	if err != nil {
 		s.log.Error("process insertion failed",
 			slog.Any("reason", err),
 			slog.Any("proc", newProc),
 		)
-		return chnl.Root{}, err
+		return PartRoot{}, err
 	}
 	s.log.Debug("sig involvement succeeded", slog.Any("proc", newProc))
-	return newPE, nil
+	return PartRoot{PE: newPE, AK: newKey.AK}, nil
+}
+
+// checks that transferred endpoints fit consumable endpoints of sig
+func (s *service) checkTEs(ds data.Source, wantSig sig.Root, tes []chnl.ID) error {
+	if len(tes) != len(wantSig.CEs) {
+		return fmt.Errorf("context mismatch: want %v items, got %v items", len(wantSig.CEs), len(tes))
+	}
+	gotTEs, err := s.chnls.SelectByIDs(ds, tes)
+	if err != nil {
+		return err
+	}
+	roles, err := s.roles.SelectEnv(ds, sig.CollectEnv([]sig.Root{wantSig}))
+	if err != nil {
+		return err
+	}
+	envIDs := role.CollectEnv(maps.Values(roles))
+	states, err := s.states.SelectEnv(ds, append(envIDs, chnl.CollectCtx(gotTEs)...))
+	if err != nil {
+		return err
+	}
+	// roles linked from CEs and TEs are needed to unfold them
+	err = s.selectDefs(ds, roles, states)
+	if err != nil {
+		return err
+	}
+	defs := convertToDefs(roles, states)
+	for i, te := range gotTEs {
+		if te.StateID == nil {
+			return chnl.ErrAlreadyClosed(te.ID)
+		}
+		wantCE := wantSig.CEs[i]
+		err = state.Subtype(defs, states[*te.StateID], states[roles[wantCE.Link].StateID])
+		if err != nil {
+			return fmt.Errorf("context mismatch at %v: %w", wantCE.Key, err)
+		}
+	}
+	return nil
 }
 
 func (s *service) Take(spec TranSpec) error {
 	if spec.Term == nil {
 		panic(step.ErrTermValueNil(spec.PID))
 	}
+	ctx := context.Background()
+	err := s.operator.Explicit(ctx, func(ds data.Source) error {
+		return s.take(ds, spec)
+	})
+	if err != nil {
+		return err
+	}
+	s.wakeUp()
+	return nil
+}
+
+func (s *service) take(ds data.Source, spec TranSpec) error {
 	s.log.Debug("transition taking started", slog.Any("spec", spec))
+	// access checking
+	curKey, err := s.keys.SelectByPID(ds, spec.PID)
+	if err != nil {
+		s.log.Error("access key selection failed",
+			slog.Any("reason", err),
+			slog.Any("pid", spec.PID),
+		)
+		return err
+	}
+	err = checkKey(spec.Key, curKey.AK)
+	if err != nil {
+		s.log.Error("transition taking failed",
+			slog.Any("reason", err),
+			slog.Any("pid", spec.PID),
+		)
+		return err
+	}
+	// retry checking
+	if spec.IK != "" {
+		digest, err := digestOf(spec)
+		if err != nil {
+			s.log.Error("request digestion failed",
+				slog.Any("reason", err),
+				slog.Any("ik", spec.IK),
+			)
+			return err
+		}
+		newReceipt := step.Receipt{IK: spec.IK, PID: spec.PID, Digest: digest, TakenAt: time.Now()}
+		fresh, err := s.steps.InsertReceipt(ds, newReceipt)
+		if err != nil {
+			s.log.Error("receipt insertion failed",
+				slog.Any("reason", err),
+				slog.Any("ik", spec.IK),
+			)
+			return err
+		}
+		if !fresh {
+			return s.replay(ds, newReceipt)
+		}
+	}
 	// proc checking
-	curStep, err := s.steps.SelectByPID(spec.PID)
+	curStep, err := s.steps.SelectByPID(ds, spec.PID)
 	if err != nil {
 		s.log.Error("process selection failed",
 			slog.Any("reason", err),
@@ -282,7 +480,18 @@ func (s *service) Take(spec TranSpec) error {
 		return err
 	}
 	if curStep == nil {
+		cancelled, err := s.steps.SelectCancelled(ds, spec.PID)
+		if err != nil {
+			s.log.Error("cancellation selection failed",
+				slog.Any("reason", err),
+				slog.Any("pid", spec.PID),
+			)
+			return err
+		}
 		err = step.ErrDoesNotExist(spec.PID)
+		if cancelled {
+			err = step.ErrProcCancelled(spec.PID)
+		}
 		s.log.Error("transition taking failed",
 			slog.Any("reason", err),
 			slog.Any("pid", spec.PID),
@@ -298,7 +507,7 @@ func (s *service) Take(spec TranSpec) error {
 		)
 		return err
 	}
-	_, ok = proc.Term.(step.CTASpec)
+	cta, ok := proc.Term.(step.CTASpec)
 	if !ok {
 		err = step.ErrTermTypeMismatch(spec.Term, step.CTASpec{})
 		s.log.Error("transition taking failed",
@@ -307,8 +516,23 @@ func (s *service) Take(spec TranSpec) error {
 		)
 		return err
 	}
+	// pending steps expire at deadline given by agent or by sig timeout
+	proc.Deadline = spec.Deadline
+	if proc.Deadline.IsZero() {
+		ctaSig, err := s.sigs.SelectByID(ds, cta.Sig)
+		if err != nil {
+			s.log.Error("signature selection failed",
+				slog.Any("reason", err),
+				slog.Any("id", cta.Sig),
+			)
+			return err
+		}
+		if ctaSig.Timeout > 0 {
+			proc.Deadline = time.Now().Add(ctaSig.Timeout)
+		}
+	}
 	sigIDs := step.CollectEnv(spec.Term)
-	sigs, err := s.sigs.SelectEnv(sigIDs)
+	sigs, err := s.sigs.SelectEnv(ds, sigIDs)
 	if err != nil {
 		s.log.Error("signatures selection failed",
 			slog.Any("reason", err),
@@ -318,7 +542,7 @@ func (s *service) Take(spec TranSpec) error {
 		return err
 	}
 	roleFQNs := sig.CollectEnv(maps.Values(sigs))
-	roles, err := s.roles.SelectEnv(roleFQNs)
+	roles, err := s.roles.SelectEnv(ds, roleFQNs)
 	if err != nil {
 		s.log.Error("roles selection failed",
 			slog.Any("reason", err),
@@ -327,7 +551,8 @@ func (s *service) Take(spec TranSpec) error {
 		)
 		return err
 	}
-	pe, err := s.chnls.SelectByID(proc.PID)
+	// sender goes on with continuation channel allocated by its queued msgs
+	pe, err := s.selectLatest(ds, proc.PID)
 	if err != nil {
 		s.log.Error("providable endpoint selection failed",
 			slog.Any("reason", err),
@@ -335,8 +560,16 @@ func (s *service) Take(spec TranSpec) error {
 		)
 		return err
 	}
+	if pe.StateID == nil {
+		err = chnl.ErrAlreadyClosed(pe.ID)
+		s.log.Error("transition taking failed",
+			slog.Any("reason", err),
+			slog.Any("pid", proc.PID),
+		)
+		return err
+	}
 	ceIDs := step.CollectCtx(proc.PID, spec.Term)
-	ces, err := s.chnls.SelectCtx(proc.PID, ceIDs)
+	ces, err := s.chnls.SelectCtx(ds, proc.PID, ceIDs)
 	if err != nil {
 		s.log.Error("consumable endpoints selection failed",
 			slog.Any("reason", err),
@@ -347,7 +580,7 @@ func (s *service) Take(spec TranSpec) error {
 	}
 	envIDs := role.CollectEnv(maps.Values(roles))
 	ctxIDs := chnl.CollectCtx(append(ces, pe))
-	states, err := s.states.SelectEnv(append(envIDs, ctxIDs...))
+	states, err := s.states.SelectEnv(ds, append(envIDs, ctxIDs...))
 	if err != nil {
 		s.log.Error("states selection failed",
 			slog.Any("reason", err),
@@ -356,8 +589,17 @@ func (s *service) Take(spec TranSpec) error {
 		)
 		return err
 	}
-	env := Environment{sigs, roles, states}
-	ctx := convertToCtx(ces, states)
+	err = s.selectDefs(ds, roles, states)
+	if err != nil {
+		s.log.Error("defs selection failed",
+			slog.Any("reason", err),
+			slog.Any("pid", proc.PID),
+		)
+		return err
+	}
+	defs := convertToDefs(roles, states)
+	env := Environment{sigs, roles, states, defs}
+	ctx := convertToCtx(ces, states, defs)
 	zc := state.EP{Z: pe.ID, C: states[*pe.StateID]}
 	// type checking
 	err = s.checkState(env, ctx, zc, spec.Term)
@@ -366,475 +608,1479 @@ func (s *service) Take(spec TranSpec) error {
 		return err
 	}
 	// step taking
-	cfg := Configuration{chnls: convertToCfg(append(ces, pe)), states: states}
+	cfg := Configuration{chnls: convertToCfg(append(ces, pe)), states: states, defs: defs, key: spec.Key}
 	proc.Term = spec.Term
-	return s.takeProcWith(proc, cfg)
+	return s.takeProcWith(ds, proc, cfg)
 }
 
-func (s *service) takeProc(
-	proc step.ProcRoot,
-) (err error) {
-	s.log.Debug("transition taking started", slog.Any("proc", proc))
-	pe, err := s.chnls.SelectByID(proc.PID)
+func (s *service) RetrieveHistory(spec HistorySpec) (entries []step.Entry, err error) {
+	ctx := context.Background()
+	err = s.operator.Implicit(ctx, func(ds data.Source) error {
+		pids, err := s.deals.SelectMembers(ds, spec.Deal)
+		if err != nil {
+			s.log.Error("deal members selection failed",
+				slog.Any("reason", err),
+				slog.Any("id", spec.Deal),
+			)
+			return err
+		}
+		entries, err = s.steps.SelectEntries(ds, pids, spec.After, spec.Limit)
+		if err != nil {
+			s.log.Error("journal entries selection failed",
+				slog.Any("reason", err),
+				slog.Any("id", spec.Deal),
+			)
+		}
+		return err
+	})
+	return entries, err
+}
+
+func (s *service) RetrieveSnapshot(spec SnapSpec) (snap Snapshot, err error) {
+	ctx := context.Background()
+	err = s.operator.Implicit(ctx, func(ds data.Source) error {
+		snap, err = s.retrieveSnapshot(ds, spec)
+		return err
+	})
+	return snap, err
+}
+
+func (s *service) retrieveSnapshot(ds data.Source, spec SnapSpec) (Snapshot, error) {
+	s.log.Debug("snapshot retrieval started", slog.Any("spec", spec))
+	pids, err := s.deals.SelectMembers(ds, spec.Deal)
 	if err != nil {
-		s.log.Error("providable endpoint selection failed",
+		s.log.Error("deal members selection failed",
 			slog.Any("reason", err),
-			slog.Any("id", proc.PID),
+			slog.Any("id", spec.Deal),
 		)
-		return err
+		return Snapshot{}, err
 	}
-	ceIDs := step.CollectCtx(proc.PID, proc.Term)
-	ces, err := s.chnls.SelectCtx(proc.PID, ceIDs)
+	lineage, err := s.chnls.SelectByIDs(ds, pids)
 	if err != nil {
-		s.log.Error("consumable endpoints selection failed",
+		s.log.Error("channels selection failed",
 			slog.Any("reason", err),
-			slog.Any("ids", ceIDs),
+			slog.Any("id", spec.Deal),
 		)
-		return err
+		return Snapshot{}, err
 	}
-	stIDs := chnl.CollectCtx(append(ces, pe))
-	states, err := s.states.SelectEnv(stIDs)
+	var entries []step.Entry
+	after := id.Empty()
+	for {
+		page, err := s.steps.SelectEntries(ds, pids, after, historyLimitMax)
+		if err != nil {
+			s.log.Error("journal entries selection failed",
+				slog.Any("reason", err),
+				slog.Any("id", spec.Deal),
+			)
+			return Snapshot{}, err
+		}
+		entries = append(entries, page...)
+		if len(page) < historyLimitMax {
+			break
+		}
+		after = page[len(page)-1].ID
+	}
+	snap, err := reconstruct(lineage, entries, spec.cut())
+	if err != nil {
+		s.log.Error("configuration reconstruction failed",
+			slog.Any("reason", err),
+			slog.Any("id", spec.Deal),
+		)
+		return Snapshot{}, err
+	}
+	states, err := s.states.SelectEnv(ds, chnl.CollectCtx(snap.Chnls))
 	if err != nil {
 		s.log.Error("states selection failed",
 			slog.Any("reason", err),
-			slog.Any("ids", stIDs),
+			slog.Any("id", spec.Deal),
 		)
-		return err
+		return Snapshot{}, err
+	}
+	for _, st := range states {
+		snap.States = append(snap.States, st)
 	}
-	cfg := Configuration{chnls: convertToCfg(append(ces, pe)), states: states}
-	return s.takeProcWith(proc, cfg)
+	s.log.Debug("snapshot retrieval succeeded", slog.Any("pos", snap.Pos))
+	return snap, nil
 }
 
-func (s *service) takeProcWith(
-	proc step.ProcRoot,
-	cfg Configuration,
-) (err error) {
-	switch term := proc.Term.(type) {
-	case step.CloseSpec:
-		viaID, ok := term.A.(chnl.ID)
-		if !ok {
-			err := chnl.ErrNotAnID(term.A)
-			s.log.Error("transition taking failed",
-				slog.Any("reason", err),
-			)
-			return err
+// reconstructs configuration from channel lineage and journal up to cut,
+// reductions aren't re-executed since they would mint other channel ids,
+// recorded outcomes are folded instead, so the same inputs always give
+// the same snapshot
+func reconstruct(lineage []chnl.Root, entries []step.Entry, cut id.ADT) (Snapshot, error) {
+	born := make(map[chnl.ID]chnl.Root, len(lineage))
+	for _, ch := range lineage {
+		if ch.ID.Compare(cut) <= 0 {
+			born[ch.ID] = ch
 		}
-		curVia, ok := cfg.LookupCh(viaID)
-		if !ok {
-			err := chnl.ErrMissingInCfg(viaID)
-			s.log.Error("transition taking failed",
-				slog.Any("reason", err),
-			)
-			return err
+	}
+	live := make(map[chnl.ID]bool, len(born))
+	for chID := range born {
+		live[chID] = true
+	}
+	for _, ch := range born {
+		if ch.PreID != nil {
+			delete(live, *ch.PreID)
+		}
+	}
+	pending := make(map[chnl.ID]step.Entry)
+	snap := Snapshot{Pos: id.Empty()}
+	for _, e := range entries {
+		if e.ID.Compare(cut) > 0 {
+			break
+		}
+		snap.Pos = e.ID
+		if e.PreVID.IsEmpty() {
+			continue
+		}
+		// half done, awaits counterpart
+		if e.VID.IsEmpty() {
+			pending[e.PreVID] = e
+			continue
+		}
+		next, ok := born[e.VID]
+		if !ok || next.PreID == nil || *next.PreID != e.PreVID {
+			return Snapshot{}, fmt.Errorf("reconstruction diverged: entry %v advances %v to %v out of lineage", e.ID, e.PreVID, e.VID)
+		}
+		delete(pending, e.PreVID)
+	}
+	for _, ch := range born {
+		// closed ones aren't in configuration
+		if live[ch.ID] && ch.StateID != nil {
+			snap.Chnls = append(snap.Chnls, ch)
+		}
+	}
+	slices.SortFunc(snap.Chnls, func(a, b chnl.Root) int { return a.ID.Compare(b.ID) })
+	for vid, e := range pending {
+		// interrupted ones got via advanced out of journal
+		if live[vid] {
+			snap.Pending = append(snap.Pending, e)
 		}
-		curSem, err := s.steps.SelectByVID(viaID)
+	}
+	slices.SortFunc(snap.Pending, func(a, b step.Entry) int { return a.ID.Compare(b.ID) })
+	return snap, nil
+}
+
+func (s *service) Track(ctx context.Context, spec EventSpec) (<-chan chnl.Event, error) {
+	s.log.Debug("tracking started", slog.Any("spec", spec))
+	err := s.operator.Implicit(ctx, func(ds data.Source) error {
+		return s.checkVisible(ds, spec.Deal, spec.VID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// subscription goes first, so that nothing falls between it and status
+	payloads, cancel := s.subs.Subscribe(chnl.Topic(spec.VID))
+	backlog, err := s.trackedStatus(spec.VID)
+	if err != nil {
+		cancel()
+		s.log.Error("status retrieval failed",
+			slog.Any("reason", err),
+			slog.Any("vid", spec.VID),
+		)
+		return nil, err
+	}
+	evs := make(chan chnl.Event)
+	go s.track(ctx, spec.VID, payloads, cancel, backlog, evs)
+	return evs, nil
+}
+
+// track follows channel versions till closing or cancellation
+func (s *service) track(
+	ctx context.Context,
+	vid chnl.ID,
+	payloads <-chan []byte,
+	cancel func(),
+	backlog []chnl.Event,
+	evs chan<- chnl.Event,
+) {
+	defer func() {
+		cancel()
+		close(evs)
+	}()
+	// status and notification could report the same event
+	seen := make(map[chnl.Event]bool)
+	resync := func() error {
+		cancel()
+		payloads, cancel = s.subs.Subscribe(chnl.Topic(vid))
+		var err error
+		backlog, err = s.trackedStatus(vid)
 		if err != nil {
-			s.log.Error("service selection failed",
+			s.log.Error("status retrieval failed",
 				slog.Any("reason", err),
-				slog.Any("vid", curVia.ID),
+				slog.Any("vid", vid),
 			)
-			return err
 		}
-		if curSem == nil {
-			newMsg := step.MsgRoot{
-				ID:  id.New(),
-				PID: proc.PID,
-				VID: curVia.ID,
-				Val: term,
+		return err
+	}
+	for {
+		for len(backlog) > 0 {
+			ev := backlog[0]
+			backlog = backlog[1:]
+			if ev.VID != vid || seen[ev] {
+				continue
+			}
+			seen[ev] = true
+			select {
+			case evs <- ev:
+			case <-ctx.Done():
+				return
+			}
+			if ev.Kind == chnl.Closed {
+				return
+			}
+			if ev.Kind != chnl.Advanced {
+				continue
 			}
-			err := s.steps.Insert(newMsg)
+			vid = ev.NextID
+			err := resync()
 			if err != nil {
-				s.log.Error("message insertion failed",
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case payload, ok := <-payloads:
+			// subscription evicted, events could be missed
+			if !ok {
+				err := resync()
+				if err != nil {
+					return
+				}
+				continue
+			}
+			ev, err := chnl.DataToEvent(payload)
+			if err != nil {
+				s.log.Error("event mapping failed",
 					slog.Any("reason", err),
-					slog.Any("msg", newMsg),
+					slog.String("payload", string(payload)),
 				)
-				return err
+				continue
 			}
-			s.log.Debug("transition taking half done", slog.Any("msg", newMsg))
-			return nil
+			backlog = append(backlog, ev)
 		}
-		srv, ok := curSem.(step.SrvRoot)
-		if !ok {
-			err = step.ErrRootTypeUnexpected(curSem)
-			s.log.Error("transition taking failed",
-				slog.Any("reason", err),
-			)
+	}
+}
+
+// trackedStatus derives events already happened to channel
+func (s *service) trackedStatus(vid chnl.ID) (evs []chnl.Event, err error) {
+	ctx := context.Background()
+	err = s.operator.Implicit(ctx, func(ds data.Source) error {
+		_, err := s.chnls.SelectByID(ds, vid)
+		if err != nil {
 			return err
 		}
-		wait, ok := srv.Cont.(step.WaitSpec)
-		if !ok {
-			err = fmt.Errorf("cont type mismatch: want %T, got %T", wait, srv.Cont)
-			s.log.Error("transition taking failed",
-				slog.Any("reason", err),
-				slog.Any("cont", srv.Cont),
-			)
+		curSem, err := s.steps.SelectByVID(ds, vid)
+		if err != nil {
 			return err
 		}
-		// consume and close channel
-		finVia := chnl.Root{
-			ID:      id.New(),
-			Key:     curVia.Key,
-			PreID:   &curVia.ID,
-			StateID: nil,
-		}
-		err = s.chnls.Insert(finVia)
+		nextVia, ok, err := s.chnls.SelectNext(ds, vid)
 		if err != nil {
-			s.log.Error("channel insertion failed",
-				slog.Any("reason", err),
-				slog.Any("via", finVia),
-			)
 			return err
 		}
-		newProc := step.ProcRoot{
-			ID:   id.New(),
-			PID:  srv.PID,
-			Term: wait.Cont,
+		switch sem := curSem.(type) {
+		case step.MsgRoot:
+			// queued msg arrives before channel advances
+			if !ok || !sem.NextVID.IsEmpty() {
+				evs = append(evs, chnl.Event{Kind: chnl.Arrived, VID: vid, SID: sem.ID})
+			}
+		case step.SrvRoot:
+			if !ok {
+				evs = append(evs, chnl.Event{Kind: chnl.Awaited, VID: vid, SID: sem.ID})
+			}
+		}
+		if ok {
+			evs = append(evs, statusOf(vid, nextVia))
+		}
+		return nil
+	})
+	return evs, err
+}
+
+func statusOf(vid chnl.ID, nextVia chnl.Root) chnl.Event {
+	if nextVia.StateID == nil {
+		return chnl.Event{Kind: chnl.Closed, VID: vid, NextID: nextVia.ID}
+	}
+	return chnl.Event{Kind: chnl.Advanced, VID: vid, NextID: nextVia.ID}
+}
+
+func (s *service) RetrieveObligations(spec ObligSpec) (obligs []Obligation, err error) {
+	ctx := context.Background()
+	err = s.operator.Implicit(ctx, func(ds data.Source) error {
+		obligs, err = s.retrieveObligations(ds, spec)
+		return err
+	})
+	return obligs, err
+}
+
+func (s *service) retrieveObligations(ds data.Source, spec ObligSpec) ([]Obligation, error) {
+	err := s.checkVisible(ds, spec.Deal, spec.VID)
+	if err != nil {
+		return nil, err
+	}
+	// agents may hold previous versions
+	curVia, err := s.selectLatest(ds, spec.VID)
+	if err != nil {
+		s.log.Error("channel selection failed",
+			slog.Any("reason", err),
+			slog.Any("id", spec.VID),
+		)
+		return nil, err
+	}
+	if curVia.StateID == nil {
+		return []Obligation{}, nil
+	}
+	states, err := s.states.SelectEnv(ds, []state.ID{*curVia.StateID})
+	if err != nil {
+		s.log.Error("states selection failed",
+			slog.Any("reason", err),
+			slog.Any("id", curVia.StateID),
+		)
+		return nil, err
+	}
+	roles := make(map[role.FQN]role.Root)
+	err = s.selectDefs(ds, roles, states)
+	if err != nil {
+		s.log.Error("defs selection failed",
+			slog.Any("reason", err),
+			slog.Any("vid", curVia.ID),
+		)
+		return nil, err
+	}
+	curSt, err := state.Unfold(convertToDefs(roles, states), states[*curVia.StateID])
+	if err != nil {
+		s.log.Error("state unfolding failed",
+			slog.Any("reason", err),
+			slog.Any("vid", curVia.ID),
+		)
+		return nil, err
+	}
+	provider, client, labels, err := deriveDuties(curSt)
+	if err != nil {
+		s.log.Error("duties derivation failed",
+			slog.Any("reason", err),
+			slog.Any("vid", curVia.ID),
+		)
+		return nil, err
+	}
+	curSem, err := s.steps.SelectByVID(ds, curVia.ID)
+	if err != nil {
+		s.log.Error("step selection failed",
+			slog.Any("reason", err),
+			slog.Any("vid", curVia.ID),
+		)
+		return nil, err
+	}
+	viaIDs := []chnl.ID{curVia.ID}
+	if curSem != nil {
+		_, semPID, _ := pendingOf(curSem)
+		viaIDs = append(viaIDs, semPID)
+	}
+	ends, err := s.chnls.SelectEnds(ds, viaIDs)
+	if err != nil {
+		s.log.Error("channel ends selection failed",
+			slog.Any("reason", err),
+			slog.Any("ids", viaIDs),
+		)
+		return nil, err
+	}
+	viaEnds := ends[curVia.ID]
+	// pending step fulfils obligation of the side taken it
+	var doneID chnl.ID
+	if curSem != nil {
+		_, semPID, _ := pendingOf(curSem)
+		doneID = ends[semPID].ProviderID
+	}
+	obligs := []Obligation{}
+	if viaEnds.ProviderID != doneID {
+		obligs = append(obligs, Obligation{PID: viaEnds.ProviderID, VID: curVia.ID, Kind: provider, Labels: labels})
+	}
+	if !viaEnds.ClientID.IsEmpty() && viaEnds.ClientID != doneID {
+		obligs = append(obligs, Obligation{PID: viaEnds.ClientID, VID: curVia.ID, Kind: client, Labels: labels})
+	}
+	return obligs, nil
+}
+
+// selects the latest version of channel
+func (s *service) selectLatest(ds data.Source, vid chnl.ID) (chnl.Root, error) {
+	curVia, err := s.chnls.SelectByID(ds, vid)
+	if err != nil {
+		return chnl.Root{}, err
+	}
+	for {
+		nextVia, ok, err := s.chnls.SelectNext(ds, curVia.ID)
+		if err != nil {
+			return chnl.Root{}, err
 		}
-		s.log.Debug("transition taking succeeded")
-		return s.takeProc(newProc)
-	case step.WaitSpec:
-		viaID, ok := term.X.(chnl.ID)
 		if !ok {
-			err := chnl.ErrNotAnID(term.X)
-			s.log.Error("transition taking failed",
+			return curVia, nil
+		}
+		curVia = nextVia
+	}
+}
+
+// derives steps expected at both channel ends, positive states are
+// driven by provider and negative ones by client
+func deriveDuties(st state.Root) (step.TermKind, step.TermKind, []core.Label, error) {
+	var send, recv step.TermKind
+	var labels []core.Label
+	switch st := st.(type) {
+	case state.OneRoot:
+		send, recv = step.Close, step.Wait
+	case state.TensorRoot, state.LolliRoot:
+		send, recv = step.Send, step.Recv
+	case state.PlusRoot:
+		send, recv = step.Lab, step.Case
+		labels = maps.Keys(st.Choices)
+	case state.WithRoot:
+		send, recv = step.Lab, step.Case
+		labels = maps.Keys(st.Choices)
+	case state.UpRoot:
+		return step.Accept, step.Acquire, nil, nil
+	case state.DownRoot:
+		return step.Detach, step.Release, nil, nil
+	default:
+		return "", "", nil, state.ErrRootTypeUnexpected(st)
+	}
+	slices.Sort(labels)
+	if st.Pol() == pol.Neg {
+		return recv, send, labels, nil
+	}
+	return send, recv, labels, nil
+}
+
+// deadlocks are detected among pending steps of deal members
+func (s *service) RetrieveDeadlocks(did ID) (deadlocks []Deadlock, err error) {
+	ctx := context.Background()
+	err = s.operator.Implicit(ctx, func(ds data.Source) error {
+		pids, err := s.deals.SelectMembers(ds, did)
+		if err != nil {
+			s.log.Error("deal members selection failed",
 				slog.Any("reason", err),
+				slog.Any("id", did),
 			)
 			return err
 		}
-		curVia, ok := cfg.LookupCh(viaID)
-		if !ok {
-			err = chnl.ErrMissingInCfg(viaID)
-			s.log.Error("transition taking failed",
-				slog.Any("reason", err),
-			)
+		sems, err := s.steps.SelectPending(ds, pids)
+		if err != nil {
+			s.log.Error("pending steps selection failed", slog.Any("reason", err))
 			return err
 		}
-		curSem, err := s.steps.SelectByVID(curVia.ID)
+		vids := make([]chnl.ID, 0, len(sems))
+		for _, sem := range sems {
+			vids = append(vids, viaOf(sem))
+		}
+		ends, err := s.chnls.SelectEnds(ds, vids)
 		if err != nil {
-			s.log.Error("message selection failed",
+			s.log.Error("channel ends selection failed",
 				slog.Any("reason", err),
-				slog.Any("vid", curVia.ID),
+				slog.Any("vids", vids),
 			)
 			return err
 		}
-		if curSem == nil {
-			newSrv := step.SrvRoot{
-				ID:   id.New(),
-				PID:  proc.PID,
-				VID:  curVia.ID,
-				Cont: term,
+		deadlocks = detectDeadlocks(collectWaits(sems, ends))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(deadlocks) > 0 {
+		s.log.Warn("deadlocks detected",
+			slog.Any("deal", did),
+			slog.Any("deadlocks", deadlocks),
+		)
+	}
+	return deadlocks, nil
+}
+
+// defers proc reduction to the engine
+func (s *service) schedule(ds data.Source, proc step.ProcRoot) error {
+	err := s.steps.Insert(ds, proc)
+	if err != nil {
+		s.log.Error("process insertion failed",
+			slog.Any("reason", err),
+			slog.Any("proc", proc),
+		)
+		return err
+	}
+	s.log.Debug("process scheduling succeeded", slog.Any("proc", proc))
+	return nil
+}
+
+// notifies reduction engine once scheduled procs are committed
+func (s *service) wakeUp() {
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// aka exec.step, reduces ready procs until ctx is done,
+// procs scheduled before restart are picked up from the repo
+func (s *service) reduce(ctx context.Context, p *props) {
+	workers := p.Workers
+	s.log.Debug("process reduction started", slog.Int("workers", workers))
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	sem := make(chan struct{}, workers)
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	// procs being reduced by workers, yet visible in the repo
+	var mu sync.Mutex
+	busy := make(map[step.ID]struct{}, workers)
+	// procs failed for a reason that may go away, yet visible in the repo
+	retries := make(map[step.ID]retry)
+	for {
+		s.expire(ctx, p.Timeout, workers)
+		mu.Lock()
+		limit := workers + len(busy) + len(retries)
+		mu.Unlock()
+		var procs []step.ProcRoot
+		err := s.operator.Implicit(ctx, func(ds data.Source) error {
+			var err error
+			procs, err = s.steps.SelectReady(ds, limit)
+			return err
+		})
+		if err != nil {
+			s.log.Error("ready processes selection failed", slog.Any("reason", err))
+		}
+		if err == nil && len(procs) < limit {
+			// all ready procs are seen, the rest are gone
+			mu.Lock()
+			for pid := range retries {
+				if !slices.ContainsFunc(procs, func(proc step.ProcRoot) bool { return proc.ID == pid }) {
+					delete(retries, pid)
+				}
 			}
-			err = s.steps.Insert(newSrv)
-			if err != nil {
-				s.log.Error("service insertion failed",
+			mu.Unlock()
+		}
+		dispatched := 0
+		now := time.Now()
+		for _, proc := range procs {
+			mu.Lock()
+			_, ok := busy[proc.ID]
+			r := retries[proc.ID]
+			mu.Unlock()
+			if ok || now.Before(r.at) {
+				continue
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			mu.Lock()
+			busy[proc.ID] = struct{}{}
+			mu.Unlock()
+			dispatched++
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() {
+					mu.Lock()
+					delete(busy, proc.ID)
+					mu.Unlock()
+					<-sem
+				}()
+				err := s.reduceOne(ctx, proc)
+				mu.Lock()
+				defer mu.Unlock()
+				if err == nil {
+					delete(retries, proc.ID)
+					return
+				}
+				r := retries[proc.ID]
+				r.attempts++
+				r.at = time.Now().Add(r.delay(p.Interval, p.Backoff))
+				retries[proc.ID] = r
+				s.log.Warn("process reduction retry scheduled",
 					slog.Any("reason", err),
-					slog.Any("srv", newSrv),
+					slog.Any("proc", proc),
+					slog.Int("attempts", r.attempts),
+					slog.Time("at", r.at),
 				)
-				return err
-			}
-			s.log.Debug("transition taking half done", slog.Any("srv", newSrv))
-			return nil
+			}()
 		}
-		msg, ok := curSem.(step.MsgRoot)
-		if !ok {
-			err = step.ErrRootTypeMismatch(curSem, step.MsgRoot{})
-			s.log.Error("transition taking failed",
+		if dispatched == workers {
+			// more procs may be ready
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			s.log.Debug("process reduction stopped")
+			return
+		case <-s.ready:
+		case <-ticker.C:
+		}
+	}
+}
+
+// fires timeout action on pending steps with deadline passed
+func (s *service) expire(ctx context.Context, tp timeoutProps, limit int) {
+	now := time.Now()
+	var sems []step.Root
+	err := s.operator.Implicit(ctx, func(ds data.Source) error {
+		var err error
+		sems, err = s.steps.SelectExpired(ds, now, limit)
+		return err
+	})
+	if err != nil {
+		s.log.Error("expired steps selection failed", slog.Any("reason", err))
+		return
+	}
+	for _, sem := range sems {
+		err := s.operator.Explicit(ctx, func(ds data.Source) error {
+			return s.expireOne(ds, tp, sem, now)
+		})
+		if errors.Is(err, chnl.ErrConcurrentTake) {
+			// step is being taken, expiry is rechecked later
+			continue
+		}
+		if err != nil {
+			s.log.Error("step expiry failed",
 				slog.Any("reason", err),
-				// TODO log some ID
+				slog.Any("sem", sem),
 			)
-			return err
+			continue
 		}
-		var newProc step.ProcRoot
-		switch val := msg.Val.(type) {
-		case step.CloseSpec:
-			// consume and close channel
-			finVia := chnl.Root{
+		s.wakeUp()
+	}
+}
+
+func (s *service) expireOne(
+	ds data.Source,
+	tp timeoutProps,
+	sem step.Root,
+	now time.Time,
+) error {
+	semID, _, viaID := pendingOf(sem)
+	err := s.chnls.Lock(ds, []chnl.ID{viaID})
+	if err != nil {
+		return err
+	}
+	// expiry is recorded in step history
+	pending, err := s.steps.Interrupt(ds, semID, step.Expiry, now)
+	if err != nil {
+		return err
+	}
+	if !pending {
+		s.log.Debug("step taken before expiry", slog.Any("id", semID))
+		return nil
+	}
+	curVia, err := s.chnls.SelectByID(ds, viaID)
+	if err != nil {
+		s.log.Error("channel selection failed",
+			slog.Any("reason", err),
+			slog.Any("id", viaID),
+		)
+		return err
+	}
+	_, err = s.interrupt(ds, sem, curVia, tp.Label, tp.Action == injectAction)
+	return err
+}
+
+// resumes proc waiting on via with label if both its case and via state
+// have such branch, otherwise closes via, so that proc stays aborted
+func (s *service) interrupt(
+	ds data.Source,
+	sem step.Root,
+	curVia chnl.Root,
+	label core.Label,
+	inject bool,
+) (bool, error) {
+	srv, ok := sem.(step.SrvRoot)
+	if ok && inject && curVia.StateID != nil {
+		cont, ok := srv.Cont.(step.CaseSpec)
+		if ok && cont.Conts[label] != nil {
+			nextSt, err := s.selectBranch(ds, *curVia.StateID, label)
+			if err != nil {
+				return false, err
+			}
+			if nextSt == nil {
+				return false, s.abort(ds, curVia)
+			}
+			nextID := nextSt.Ident()
+			newVia := chnl.Root{
 				ID:      id.New(),
 				Key:     curVia.Key,
 				PreID:   &curVia.ID,
-				StateID: nil,
+				StateID: &nextID,
 			}
-			err = s.chnls.Insert(finVia)
+			err = s.chnls.Insert(ds, newVia)
 			if err != nil {
 				s.log.Error("channel insertion failed",
 					slog.Any("reason", err),
-					slog.Any("via", finVia),
-				)
-				return err
-			}
-			newProc = step.ProcRoot{
-				ID:   id.New(),
-				PID:  proc.PID,
-				Term: term.Cont,
-			}
-		case step.FwdSpec:
-			d, ok := val.D.(chnl.ID)
-			if !ok {
-				err = chnl.ErrNotAnID(val.D)
-				s.log.Error("transition taking failed",
-					slog.Any("reason", err),
-				)
-				return err
-			}
-			err := s.chnls.Transfer(msg.PID, proc.PID, []chnl.ID{d})
-			if err != nil {
-				s.log.Error("channel transfer failed",
-					slog.Any("reason", err),
-					slog.Any("from", msg.PID),
-					slog.Any("to", proc.PID),
-					slog.Any("id", d),
+					slog.Any("via", newVia),
 				)
-				return err
+				return false, err
 			}
-			newProc = step.ProcRoot{
+			s.log.Debug("label injected", slog.Any("label", label), slog.Any("srv", srv))
+			newProc := step.ProcRoot{
 				ID:   id.New(),
-				PID:  proc.PID,
-				Term: step.Subst(term, val.C, d),
+				PID:  chnl.Subst(srv.PID, curVia.ID, newVia.ID),
+				Term: step.Subst(cont.Conts[label], cont.X, newVia.ID),
 			}
-		default:
-			panic(step.ErrValTypeUnexpected(msg.Val))
+			return true, s.schedule(ds, newProc)
 		}
-		s.log.Debug("transition taking succeeded")
-		return s.takeProc(newProc)
-	case step.SendSpec:
-		viaID, ok := term.A.(chnl.ID)
-		if !ok {
-			err := chnl.ErrNotAnID(term.A)
-			s.log.Error("transition taking failed",
-				slog.Any("reason", err),
-			)
-			return err
-		}
-		curVia, ok := cfg.LookupCh(viaID)
-		if !ok {
-			err = chnl.ErrMissingInCfg(viaID)
-			s.log.Error("transition taking failed",
-				slog.Any("reason", err),
-			)
-			return err
-		}
-		curSem, err := s.steps.SelectByVID(curVia.ID)
+	}
+	return false, s.abort(ds, curVia)
+}
+
+// closes via, so that proc waiting on it stays aborted
+func (s *service) abort(ds data.Source, curVia chnl.Root) error {
+	finVia := chnl.Root{
+		ID:      id.New(),
+		Key:     curVia.Key,
+		PreID:   &curVia.ID,
+		StateID: nil,
+	}
+	err := s.chnls.Insert(ds, finVia)
+	if err != nil {
+		s.log.Error("channel insertion failed",
+			slog.Any("reason", err),
+			slog.Any("via", finVia),
+		)
+		return err
+	}
+	s.log.Debug("channel closed", slog.Any("via", curVia.ID))
+	return nil
+}
+
+// selectBranch unfolds via state and selects its branch with label,
+// nil if state isn't a choice with such branch
+func (s *service) selectBranch(ds data.Source, stID state.ID, label core.Label) (state.Root, error) {
+	curSt, err := s.states.SelectByID(ds, stID)
+	if err != nil {
+		s.log.Error("state selection failed",
+			slog.Any("reason", err),
+			slog.Any("id", stID),
+		)
+		return nil, err
+	}
+	roles := make(map[role.FQN]role.Root)
+	states := map[state.ID]state.Root{stID: curSt}
+	err = s.selectDefs(ds, roles, states)
+	if err != nil {
+		s.log.Error("roles selection failed",
+			slog.Any("reason", err),
+			slog.Any("id", stID),
+		)
+		return nil, err
+	}
+	unfoldedSt, err := state.Unfold(convertToDefs(roles, states), curSt)
+	if err != nil {
+		s.log.Error("state unfolding failed",
+			slog.Any("reason", err),
+			slog.Any("id", stID),
+		)
+		return nil, err
+	}
+	var choices map[core.Label]state.Root
+	switch st := unfoldedSt.(type) {
+	case state.PlusRoot:
+		choices = st.Choices
+	case state.WithRoot:
+		choices = st.Choices
+	}
+	nextSt, ok := choices[label]
+	if !ok {
+		return nil, nil
+	}
+	// instantiated states exist in memory only
+	if unfoldedSt.Ident() != stID {
+		err = s.states.Insert(ds, unfoldedSt)
 		if err != nil {
-			s.log.Error("service selection failed",
+			s.log.Error("state insertion failed",
 				slog.Any("reason", err),
-				slog.Any("vid", curVia.ID),
+				slog.Any("id", unfoldedSt.Ident()),
 			)
-			return err
-		}
-		if curSem == nil {
-			newMsg := step.MsgRoot{
-				ID:  id.New(),
-				PID: proc.PID,
-				VID: curVia.ID,
-				Val: term,
-			}
-			err = s.steps.Insert(newMsg)
-			if err != nil {
-				s.log.Error("message insertion failed",
-					slog.Any("reason", err),
-					slog.Any("msg", newMsg),
-				)
-				return err
-			}
-			s.log.Debug("transition taking half done", slog.Any("msg", newMsg))
-			return nil
+			return nil, err
 		}
-		srv, ok := curSem.(step.SrvRoot)
-		if !ok {
-			err = step.ErrRootTypeMismatch(curSem, step.SrvRoot{})
-			s.log.Error("transition taking failed",
-				slog.Any("reason", err),
-				// TODO log some ID
-			)
+	}
+	return nextSt, nil
+}
+
+// replays outcome of transition already taken under the same key,
+// the key can't be reused for another process or another request
+func (s *service) replay(ds data.Source, newReceipt step.Receipt) error {
+	curReceipt, err := s.steps.SelectReceipt(ds, newReceipt.IK)
+	if err != nil {
+		s.log.Error("receipt selection failed",
+			slog.Any("reason", err),
+			slog.Any("ik", newReceipt.IK),
+		)
+		return err
+	}
+	if curReceipt.PID != newReceipt.PID {
+		err = step.ErrReceiptMismatch(newReceipt.IK, curReceipt.PID, newReceipt.PID)
+		s.log.Error("transition replay failed",
+			slog.Any("reason", err),
+			slog.Any("pid", newReceipt.PID),
+		)
+		return err
+	}
+	if curReceipt.Digest != newReceipt.Digest {
+		err = step.ErrDigestMismatch(newReceipt.IK, curReceipt.Digest, newReceipt.Digest)
+		s.log.Error("transition replay failed",
+			slog.Any("reason", err),
+			slog.Any("pid", newReceipt.PID),
+		)
+		return err
+	}
+	s.log.Debug("transition replayed", slog.Any("receipt", curReceipt))
+	return nil
+}
+
+// requests are equal if they take the same term on the same process
+func digestOf(spec TranSpec) (id.ADT, error) {
+	term, err := step.DataFromTermNilable(spec.Term)
+	if err != nil {
+		return id.Empty(), err
+	}
+	content, err := json.Marshal(term)
+	if err != nil {
+		return id.Empty(), err
+	}
+	content = append(content, spec.PID.String()...)
+	content = append(content, spec.Deadline.UTC().Format(time.RFC3339Nano)...)
+	return id.FromDigest(content), nil
+}
+
+func (s *service) Rotate(spec KeySpec) (key ak.ADT, err error) {
+	ctx := context.Background()
+	err = s.operator.Explicit(ctx, func(ds data.Source) error {
+		key, err = s.rotate(ds, spec)
+		return err
+	})
+	return key, err
+}
+
+// issues new key for process, so that previous holder loses access,
+// client of process channel may hand it over to another agent this way
+func (s *service) rotate(ds data.Source, spec KeySpec) (ak.ADT, error) {
+	s.log.Debug("access key rotation started", slog.Any("pid", spec.PID))
+	err := s.checkAccess(ds, spec.PID, spec.Key)
+	if err != nil {
+		return ak.ADT{}, err
+	}
+	newKey := KeyRoot{PID: spec.PID, AK: ak.New()}
+	err = s.keys.Update(ds, newKey)
+	if err != nil {
+		s.log.Error("access key update failed",
+			slog.Any("reason", err),
+			slog.Any("pid", spec.PID),
+		)
+		return ak.ADT{}, err
+	}
+	s.log.Debug("access key rotation succeeded", slog.Any("pid", spec.PID))
+	return newKey.AK, nil
+}
+
+// process is accessible with either its own key or the key of its client
+func (s *service) checkAccess(ds data.Source, pid chnl.ID, key ak.ADT) error {
+	curKey, err := s.keys.SelectByPID(ds, pid)
+	if err != nil {
+		s.log.Error("access key selection failed",
+			slog.Any("reason", err),
+			slog.Any("pid", pid),
+		)
+		return err
+	}
+	if checkKey(key, curKey.AK) == nil {
+		return nil
+	}
+	ends, err := s.chnls.SelectEnds(ds, []chnl.ID{pid})
+	if err != nil {
+		s.log.Error("channel ends selection failed",
+			slog.Any("reason", err),
+			slog.Any("pid", pid),
+		)
+		return err
+	}
+	clientID := ends[pid].ClientID
+	if clientID.IsEmpty() {
+		return ak.ErrUnexpectedKey(key)
+	}
+	clientKey, err := s.keys.SelectByPID(ds, clientID)
+	if err != nil {
+		s.log.Error("access key selection failed",
+			slog.Any("reason", err),
+			slog.Any("pid", clientID),
+		)
+		return err
+	}
+	return checkKey(key, clientKey.AK)
+}
+
+// processes without issued key are not accessible at all
+func checkKey(got ak.ADT, want ak.ADT) error {
+	if want == (ak.ADT{}) || got != want {
+		return ak.ErrUnexpectedKey(got)
+	}
+	return nil
+}
+
+func (s *service) Cancel(spec CancelSpec) error {
+	ctx := context.Background()
+	err := s.operator.Explicit(ctx, func(ds data.Source) error {
+		err := s.checkCancel(ds, spec)
+		if err != nil {
 			return err
 		}
-		recv, ok := srv.Cont.(step.RecvSpec)
-		if !ok {
-			err = fmt.Errorf("cont type mismatch: want %T, got %T", recv, srv.Cont)
-			s.log.Error("transition taking failed",
+		return s.cancel(ds, spec.PID, time.Now())
+	})
+	if err != nil {
+		return err
+	}
+	s.wakeUp()
+	return nil
+}
+
+// only channels provided by deal members are visible through deal
+func (s *service) checkVisible(ds data.Source, did ID, vid chnl.ID) error {
+	ends, err := s.chnls.SelectEnds(ds, []chnl.ID{vid})
+	if err != nil {
+		s.log.Error("channel ends selection failed",
+			slog.Any("reason", err),
+			slog.Any("id", vid),
+		)
+		return err
+	}
+	pids, err := s.deals.SelectMembers(ds, did)
+	if err != nil {
+		s.log.Error("deal members selection failed",
+			slog.Any("reason", err),
+			slog.Any("id", did),
+		)
+		return err
+	}
+	providerID := ends[vid].ProviderID
+	if !slices.Contains(pids, providerID) {
+		err = ErrMemberMissing(did, providerID)
+		s.log.Error("channel visibility checking failed",
+			slog.Any("reason", err),
+			slog.Any("vid", vid),
+		)
+		return err
+	}
+	return nil
+}
+
+// only deal members can be cancelled, either by the process itself
+// or by the client of process channel
+func (s *service) checkCancel(ds data.Source, spec CancelSpec) error {
+	pids, err := s.deals.SelectMembers(ds, spec.Deal)
+	if err != nil {
+		s.log.Error("deal members selection failed",
+			slog.Any("reason", err),
+			slog.Any("id", spec.Deal),
+		)
+		return err
+	}
+	if !slices.Contains(pids, spec.PID) {
+		err = ErrMemberMissing(spec.Deal, spec.PID)
+		s.log.Error("process cancellation failed",
+			slog.Any("reason", err),
+			slog.Any("pid", spec.PID),
+		)
+		return err
+	}
+	return s.checkAccess(ds, spec.PID, spec.Key)
+}
+
+// cancels proc and closes its channels, peers waiting on them
+// take cancel branch if any, otherwise they are cancelled as well
+func (s *service) cancel(ds data.Source, pid chnl.ID, now time.Time) error {
+	s.log.Debug("process cancellation started", slog.Any("pid", pid))
+	cancelled := make(map[chnl.ID]bool)
+	queue := []chnl.ID{pid}
+	for len(queue) > 0 {
+		curPID := queue[0]
+		queue = queue[1:]
+		if cancelled[curPID] {
+			continue
+		}
+		cancelled[curPID] = true
+		err := s.steps.Cancel(ds, curPID, now)
+		if err != nil {
+			s.log.Error("process cancellation failed",
 				slog.Any("reason", err),
-				slog.Any("cont", srv.Cont),
+				slog.Any("pid", curPID),
 			)
 			return err
 		}
-		curSt, ok := cfg.LookupSt(curVia.ID)
-		if !ok {
-			err = chnl.ErrMissingInCfg(curVia.ID)
-			s.log.Error("transition taking failed",
+		owned, err := s.chnls.SelectOwned(ds, curPID)
+		if err != nil {
+			s.log.Error("owned channels selection failed",
 				slog.Any("reason", err),
+				slog.Any("pid", curPID),
 			)
 			return err
 		}
-		nextID := curSt.(state.Prod).Next()
-		newVia := chnl.Root{
-			ID:      id.New(),
-			Key:     curVia.Key,
-			PreID:   &curVia.ID,
-			StateID: &nextID,
+		ownedIDs := make([]chnl.ID, 0, len(owned))
+		for _, ch := range owned {
+			ownedIDs = append(ownedIDs, ch.ID)
 		}
-		err = s.chnls.Insert(newVia)
+		err = s.chnls.Lock(ds, ownedIDs)
 		if err != nil {
-			s.log.Error("channel insertion failed",
-				slog.Any("reason", err),
-				slog.Any("via", newVia),
-			)
 			return err
 		}
-		bID, ok := term.B.(chnl.ID)
-		if !ok {
-			err := chnl.ErrNotAnID(term.B)
-			s.log.Error("transition taking failed",
+		for _, curVia := range owned {
+			curSem, err := s.steps.SelectByVID(ds, curVia.ID)
+			if err != nil {
+				s.log.Error("step selection failed",
+					slog.Any("reason", err),
+					slog.Any("vid", curVia.ID),
+				)
+				return err
+			}
+			var peerSem step.Root
+			var peerPID chnl.ID
+			if curSem != nil {
+				semID, semPID, _ := pendingOf(curSem)
+				// cancellation is recorded in step history
+				pending, err := s.steps.Interrupt(ds, semID, step.Cancellation, now)
+				if err != nil {
+					return err
+				}
+				if pending && semPID != curPID {
+					peerSem, peerPID = curSem, semPID
+				}
+			}
+			resumed, err := s.interrupt(ds, peerSem, curVia, cancelLabel, peerSem != nil)
+			if err != nil {
+				return err
+			}
+			if peerSem != nil && !resumed {
+				queue = append(queue, peerPID)
+			}
+		}
+	}
+	s.log.Debug("process cancellation succeeded",
+		slog.Any("pid", pid),
+		slog.Int("cascade", len(cancelled)-1),
+	)
+	return nil
+}
+
+// failed reduction attempts of a proc
+type retry struct {
+	attempts int
+	// next attempt isn't made before
+	at time.Time
+}
+
+// doubles with every attempt up to limit
+func (r retry) delay(base, limit time.Duration) time.Duration {
+	d := base
+	for i := 1; i < r.attempts && d < limit; i++ {
+		d *= 2
+	}
+	return min(d, limit)
+}
+
+// claims and reduces proc in a single transaction,
+// so that proc is reduced once and its effects are not lost;
+// reports error if reduction failed, but proc stays and can be retried
+func (s *service) reduceOne(ctx context.Context, proc step.ProcRoot) error {
+	err := s.operator.Explicit(ctx, func(ds data.Source) error {
+		err := s.steps.Delete(ds, proc.ID)
+		if err != nil {
+			s.log.Error("process claiming failed",
 				slog.Any("reason", err),
+				slog.Any("proc", proc),
 			)
 			return err
 		}
-		b, ok := cfg.LookupCh(bID)
-		if !ok {
-			err = chnl.ErrMissingInCfg(bID)
-			s.log.Error("transition taking failed",
-				slog.Any("reason", err),
-			)
+		return s.takeProc(ds, proc)
+	})
+	if err == nil {
+		s.wakeUp()
+		return nil
+	}
+	if errors.Is(err, step.ErrMissing) {
+		s.log.Debug("process cancelled before reduction", slog.Any("proc", proc))
+		return nil
+	}
+	if errors.Is(err, chnl.ErrConcurrentTake) {
+		// proc stays in the repo and is picked up again
+		s.log.Debug("process reduction postponed", slog.Any("proc", proc))
+		return nil
+	}
+	if ctx.Err() != nil {
+		// interrupted by shutdown, proc is picked up after restart
+		return nil
+	}
+	if !errors.Is(err, step.ErrTypeMismatch) && !errors.Is(err, state.ErrTypeMismatch) {
+		// connection loss, failed commit and alike
+		return err
+	}
+	// ill-typed proc won't ever be reduced and must not block the engine
+	dropErr := s.operator.Explicit(ctx, func(ds data.Source) error {
+		return s.steps.Delete(ds, proc.ID)
+	})
+	if dropErr != nil {
+		s.log.Error("process dropping failed",
+			slog.Any("reason", dropErr),
+			slog.Any("proc", proc),
+		)
+		return dropErr
+	}
+	s.log.Error("process dropped",
+		slog.Any("reason", err),
+		slog.Any("proc", proc),
+	)
+	return nil
+}
+
+func (s *service) takeProc(
+	ds data.Source,
+	proc step.ProcRoot,
+) (err error) {
+	s.log.Debug("transition taking started", slog.Any("proc", proc))
+	pe, err := s.chnls.SelectByID(ds, proc.PID)
+	if err != nil {
+		s.log.Error("providable endpoint selection failed",
+			slog.Any("reason", err),
+			slog.Any("id", proc.PID),
+		)
+		return err
+	}
+	ceIDs := step.CollectCtx(proc.PID, proc.Term)
+	ces, err := s.chnls.SelectCtx(ds, proc.PID, ceIDs)
+	if err != nil {
+		s.log.Error("consumable endpoints selection failed",
+			slog.Any("reason", err),
+			slog.Any("ids", ceIDs),
+		)
+		return err
+	}
+	stIDs := chnl.CollectCtx(append(ces, pe))
+	states, err := s.states.SelectEnv(ds, stIDs)
+	if err != nil {
+		s.log.Error("states selection failed",
+			slog.Any("reason", err),
+			slog.Any("ids", stIDs),
+		)
+		return err
+	}
+	roles := make(map[role.FQN]role.Root)
+	err = s.selectDefs(ds, roles, states)
+	if err != nil {
+		s.log.Error("defs selection failed",
+			slog.Any("reason", err),
+			slog.Any("pid", proc.PID),
+		)
+		return err
+	}
+	cfg := Configuration{
+		chnls:  convertToCfg(append(ces, pe)),
+		states: states,
+		defs:   convertToDefs(roles, states),
+	}
+	return s.takeProcWith(ds, proc, cfg)
+}
+
+// selects roles referenced by links until the closure is reached
+func (s *service) selectDefs(
+	ds data.Source,
+	roles map[role.FQN]role.Root,
+	states map[state.ID]state.Root,
+) error {
+	for {
+		var fqns []role.FQN
+		for _, fqn := range state.CollectEnv(maps.Values(states)) {
+			_, ok := roles[fqn]
+			if ok || slices.Contains(fqns, fqn) {
+				continue
+			}
+			fqns = append(fqns, fqn)
+		}
+		if len(fqns) == 0 {
+			return nil
+		}
+		newRoles, err := s.roles.SelectEnv(ds, fqns)
+		if err != nil {
 			return err
 		}
-		err = s.chnls.Transfer(proc.PID, srv.PID, []chnl.ID{b.ID})
+		stIDs := role.CollectEnv(maps.Values(newRoles))
+		newStates, err := s.states.SelectEnv(ds, stIDs)
 		if err != nil {
-			s.log.Error("channel transfer failed",
-				slog.Any("reason", err),
-				slog.Any("from", proc.PID),
-				slog.Any("to", srv.PID),
-				slog.Any("id", b.ID),
-			)
 			return err
 		}
-		s.log.Debug("transition taking succeeded")
-		recv.Cont = step.Subst(recv.Cont, recv.X, newVia.ID)
-		recv.Cont = step.Subst(recv.Cont, recv.Y, b.ID)
-		newProc := step.ProcRoot{
-			ID:   id.New(),
-			PID:  chnl.Subst(srv.PID, curVia.ID, newVia.ID),
-			Term: recv.Cont,
+		for fqn, r := range newRoles {
+			roles[fqn] = r
 		}
-		return s.takeProc(newProc)
-	case step.RecvSpec:
-		viaID, ok := term.X.(chnl.ID)
-		if !ok {
-			err := chnl.ErrNotAnID(term.X)
-			s.log.Error("transition taking failed",
-				slog.Any("reason", err),
-			)
-			return err
+		for stID, st := range newStates {
+			states[stID] = st
 		}
+	}
+}
+
+// appends reduction to journal, via is advanced if rendezvous took place
+func (s *service) record(ds data.Source, proc step.ProcRoot, cfg Configuration) error {
+	entry := step.Entry{
+		ID:      id.New(),
+		PID:     proc.PID,
+		Term:    proc.Term,
+		AK:      cfg.key,
+		TakenAt: time.Now(),
+	}
+	viaID, ok := proc.Term.Via().(chnl.ID)
+	if ok {
+		entry.PreVID = viaID
 		curVia, ok := cfg.LookupCh(viaID)
-		if !ok {
-			err = chnl.ErrMissingInCfg(viaID)
-			s.log.Error("transition taking failed",
-				slog.Any("reason", err),
-			)
-			return err
+		if ok {
+			entry.PreStateID = curVia.StateID
 		}
-		curSem, err := s.steps.SelectByVID(curVia.ID)
+		nextVia, ok, err := s.chnls.SelectNext(ds, viaID)
 		if err != nil {
-			s.log.Error("message selection failed",
+			s.log.Error("channel selection failed",
 				slog.Any("reason", err),
-				slog.Any("vid", curVia.ID),
+				slog.Any("pre", viaID),
 			)
 			return err
 		}
-		if curSem == nil {
-			newSrv := step.SrvRoot{
-				ID:   id.New(),
-				PID:  proc.PID,
-				VID:  curVia.ID,
-				Cont: term,
-			}
-			err = s.steps.Insert(newSrv)
-			if err != nil {
-				s.log.Error("service insertion failed",
-					slog.Any("reason", err),
-					slog.Any("srv", newSrv),
-				)
-				return err
-			}
-			s.log.Debug("transition taking half done", slog.Any("srv", newSrv))
-			return nil
+		if ok {
+			entry.VID = nextVia.ID
+			entry.StateID = nextVia.StateID
 		}
-		msg, ok := curSem.(step.MsgRoot)
+	}
+	err := s.steps.InsertEntry(ds, entry)
+	if err != nil {
+		s.log.Error("journal entry insertion failed",
+			slog.Any("reason", err),
+			slog.Any("pid", proc.PID),
+		)
+		return err
+	}
+	return nil
+}
+
+func (s *service) takeProcWith(
+	ds data.Source,
+	proc step.ProcRoot,
+	cfg Configuration,
+) (err error) {
+	// every reduction ends up in journal, spawn reduces continuation separately
+	defer func(taken step.ProcRoot) {
+		if err == nil {
+			err = s.record(ds, taken, cfg)
+		}
+	}(proc)
+	// concurrent takes must not both see the same pending step,
+	// take that has read cfg before the other one committed
+	// fails on advancing the same channel version once again
+	err = s.chnls.Lock(ds, maps.Keys(cfg.chnls))
+	if err != nil {
+		s.log.Error("channels locking failed",
+			slog.Any("reason", err),
+			slog.Any("pid", proc.PID),
+		)
+		return err
+	}
+	switch term := proc.Term.(type) {
+	case step.CloseSpec:
+		viaID, ok := term.A.(chnl.ID)
 		if !ok {
-			err = step.ErrRootTypeMismatch(curSem, step.MsgRoot{})
+			err := chnl.ErrNotAnID(term.A)
 			s.log.Error("transition taking failed",
 				slog.Any("reason", err),
-				// TODO log some ID
 			)
 			return err
 		}
-		send, ok := msg.Val.(step.SendSpec)
+		curVia, ok := cfg.LookupCh(viaID)
 		if !ok {
-			err = fmt.Errorf("val type mismatch: want %T, got %T", send, msg.Val)
+			err := chnl.ErrMissingInCfg(viaID)
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+			)
+			return err
+		}
+		curSem, err := s.steps.SelectByVID(ds, viaID)
+		if err != nil {
+			s.log.Error("service selection failed",
+				slog.Any("reason", err),
+				slog.Any("vid", curVia.ID),
+			)
+			return err
+		}
+		if curSem == nil {
+			queued, err := s.enqueue(ds, proc, cfg, curVia, term)
+			if err != nil {
+				s.log.Error("message enqueuing failed",
+					slog.Any("reason", err),
+					slog.Any("vid", curVia.ID),
+				)
+				return err
+			}
+			if queued {
+				return nil
+			}
+			newMsg := step.MsgRoot{
+				ID:       id.New(),
+				PID:      proc.PID,
+				VID:      curVia.ID,
+				Val:      term,
+				Deadline: proc.Deadline,
+			}
+			err = s.steps.Insert(ds, newMsg)
+			if err != nil {
+				s.log.Error("message insertion failed",
+					slog.Any("reason", err),
+					slog.Any("msg", newMsg),
+				)
+				return err
+			}
+			s.log.Debug("transition taking half done", slog.Any("msg", newMsg))
+			return nil
+		}
+		srv, ok := curSem.(step.SrvRoot)
+		if !ok {
+			err = step.ErrRootTypeUnexpected(curSem)
 			s.log.Error("transition taking failed",
 				slog.Any("reason", err),
-				slog.Any("val", msg.Val),
 			)
 			return err
 		}
-		curSt, ok := cfg.LookupSt(curVia.ID)
+		wait, ok := srv.Cont.(step.WaitSpec)
 		if !ok {
-			err = chnl.ErrMissingInCfg(curVia.ID)
+			err = step.ErrContTypeMismatch(srv.Cont, wait)
 			s.log.Error("transition taking failed",
 				slog.Any("reason", err),
+				slog.Any("cont", srv.Cont),
 			)
 			return err
 		}
-		nextID := curSt.(state.Prod).Next()
-		newVia := chnl.Root{
+		// consume and close channel
+		finVia := chnl.Root{
 			ID:      id.New(),
 			Key:     curVia.Key,
 			PreID:   &curVia.ID,
-			StateID: &nextID,
+			StateID: nil,
 		}
-		err = s.chnls.Insert(newVia)
+		err = s.chnls.Insert(ds, finVia)
 		if err != nil {
 			s.log.Error("channel insertion failed",
 				slog.Any("reason", err),
-				slog.Any("via", newVia),
+				slog.Any("via", finVia),
 			)
 			return err
 		}
-		bID, ok := send.B.(chnl.ID)
+		newProc := step.ProcRoot{
+			ID:   id.New(),
+			PID:  srv.PID,
+			Term: wait.Cont,
+		}
+		s.log.Debug("transition taking succeeded")
+		return s.schedule(ds, newProc)
+	case step.WaitSpec:
+		viaID, ok := term.X.(chnl.ID)
 		if !ok {
-			err := chnl.ErrNotAnID(send.B)
+			err := chnl.ErrNotAnID(term.X)
 			s.log.Error("transition taking failed",
 				slog.Any("reason", err),
 			)
 			return err
 		}
-		b, ok := cfg.LookupCh(bID)
+		curVia, ok := cfg.LookupCh(viaID)
 		if !ok {
-			err = chnl.ErrMissingInCfg(bID)
+			err = chnl.ErrMissingInCfg(viaID)
 			s.log.Error("transition taking failed",
 				slog.Any("reason", err),
 			)
 			return err
 		}
-		err = s.chnls.Transfer(msg.PID, proc.PID, []chnl.ID{b.ID})
+		curSem, err := s.steps.SelectByVID(ds, curVia.ID)
 		if err != nil {
-			s.log.Error("channel transfer failed",
+			s.log.Error("message selection failed",
 				slog.Any("reason", err),
-				slog.Any("from", msg.PID),
-				slog.Any("to", proc.PID),
-				slog.Any("id", b.ID),
+				slog.Any("vid", curVia.ID),
 			)
 			return err
 		}
-		s.log.Debug("transition taking succeeded")
-		term.Cont = step.Subst(term.Cont, term.X, newVia.ID)
-		term.Cont = step.Subst(term.Cont, term.Y, b.ID)
-		newProc := step.ProcRoot{
-			ID:   id.New(),
-			PID:  chnl.Subst(proc.PID, curVia.ID, newVia.ID),
-			Term: term.Cont,
+		if curSem == nil {
+			newSrv := step.SrvRoot{
+				ID:       id.New(),
+				PID:      proc.PID,
+				VID:      curVia.ID,
+				Cont:     term,
+				Deadline: proc.Deadline,
+			}
+			err = s.steps.Insert(ds, newSrv)
+			if err != nil {
+				s.log.Error("service insertion failed",
+					slog.Any("reason", err),
+					slog.Any("srv", newSrv),
+				)
+				return err
+			}
+			s.log.Debug("transition taking half done", slog.Any("srv", newSrv))
+			return nil
 		}
-		return s.takeProc(newProc)
-	case step.LabSpec:
+		msg, ok := curSem.(step.MsgRoot)
+		if !ok {
+			err = step.ErrRootTypeMismatch(curSem, step.MsgRoot{})
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+				// TODO log some ID
+			)
+			return err
+		}
+		var newProc step.ProcRoot
+		switch val := msg.Val.(type) {
+		case step.CloseSpec:
+			// consume and close channel
+			finVia := chnl.Root{
+				ID:      id.New(),
+				Key:     curVia.Key,
+				PreID:   &curVia.ID,
+				StateID: nil,
+			}
+			finVia, err = s.advance(ds, msg, finVia)
+			if err != nil {
+				s.log.Error("channel advancing failed",
+					slog.Any("reason", err),
+					slog.Any("via", finVia),
+				)
+				return err
+			}
+			newProc = step.ProcRoot{
+				ID:   id.New(),
+				PID:  proc.PID,
+				Term: term.Cont,
+			}
+		case step.FwdSpec:
+			d, ok := val.D.(chnl.ID)
+			if !ok {
+				err = chnl.ErrNotAnID(val.D)
+				s.log.Error("transition taking failed",
+					slog.Any("reason", err),
+				)
+				return err
+			}
+			err := s.chnls.Transfer(ds, msg.PID, proc.PID, []chnl.ID{d})
+			if err != nil {
+				s.log.Error("channel transfer failed",
+					slog.Any("reason", err),
+					slog.Any("from", msg.PID),
+					slog.Any("to", proc.PID),
+					slog.Any("id", d),
+				)
+				return err
+			}
+			newProc = step.ProcRoot{
+				ID:   id.New(),
+				PID:  proc.PID,
+				Term: step.Subst(term, val.C, d),
+			}
+		default:
+			panic(step.ErrValTypeUnexpected(msg.Val))
+		}
+		s.log.Debug("transition taking succeeded")
+		return s.schedule(ds, newProc)
+	case step.SendSpec:
 		viaID, ok := term.A.(chnl.ID)
 		if !ok {
 			err := chnl.ErrNotAnID(term.A)
@@ -851,7 +2097,7 @@ func (s *service) takeProcWith(
 			)
 			return err
 		}
-		curSem, err := s.steps.SelectByVID(curVia.ID)
+		curSem, err := s.steps.SelectByVID(ds, curVia.ID)
 		if err != nil {
 			s.log.Error("service selection failed",
 				slog.Any("reason", err),
@@ -860,13 +2106,25 @@ func (s *service) takeProcWith(
 			return err
 		}
 		if curSem == nil {
+			queued, err := s.enqueue(ds, proc, cfg, curVia, term)
+			if err != nil {
+				s.log.Error("message enqueuing failed",
+					slog.Any("reason", err),
+					slog.Any("vid", curVia.ID),
+				)
+				return err
+			}
+			if queued {
+				return nil
+			}
 			newMsg := step.MsgRoot{
-				ID:  id.New(),
-				PID: proc.PID,
-				VID: curVia.ID,
-				Val: term,
+				ID:       id.New(),
+				PID:      proc.PID,
+				VID:      curVia.ID,
+				Val:      term,
+				Deadline: proc.Deadline,
 			}
-			err = s.steps.Insert(newMsg)
+			err = s.steps.Insert(ds, newMsg)
 			if err != nil {
 				s.log.Error("message insertion failed",
 					slog.Any("reason", err),
@@ -886,31 +2144,30 @@ func (s *service) takeProcWith(
 			)
 			return err
 		}
-		cont, ok := srv.Cont.(step.CaseSpec)
+		recv, ok := srv.Cont.(step.RecvSpec)
 		if !ok {
-			err = fmt.Errorf("unexpected cont type: want %T, got %T", step.CaseSpec{}, srv.Cont)
+			err = step.ErrContTypeMismatch(srv.Cont, recv)
 			s.log.Error("transition taking failed",
 				slog.Any("reason", err),
 				slog.Any("cont", srv.Cont),
 			)
 			return err
 		}
-		curSt, ok := cfg.LookupSt(curVia.ID)
-		if !ok {
-			err = chnl.ErrMissingInCfg(curVia.ID)
+		curSt, err := s.lookupSt(ds, cfg, curVia.ID)
+		if err != nil {
 			s.log.Error("transition taking failed",
 				slog.Any("reason", err),
 			)
 			return err
 		}
-		nextID := curSt.(state.Sum).Next(term.L)
+		nextID := curSt.(state.Prod).Next()
 		newVia := chnl.Root{
 			ID:      id.New(),
 			Key:     curVia.Key,
 			PreID:   &curVia.ID,
 			StateID: &nextID,
 		}
-		err = s.chnls.Insert(newVia)
+		err = s.chnls.Insert(ds, newVia)
 		if err != nil {
 			s.log.Error("channel insertion failed",
 				slog.Any("reason", err),
@@ -918,14 +2175,42 @@ func (s *service) takeProcWith(
 			)
 			return err
 		}
+		bID, ok := term.B.(chnl.ID)
+		if !ok {
+			err := chnl.ErrNotAnID(term.B)
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+			)
+			return err
+		}
+		b, ok := cfg.LookupCh(bID)
+		if !ok {
+			err = chnl.ErrMissingInCfg(bID)
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+			)
+			return err
+		}
+		err = s.chnls.Transfer(ds, proc.PID, srv.PID, []chnl.ID{b.ID})
+		if err != nil {
+			s.log.Error("channel transfer failed",
+				slog.Any("reason", err),
+				slog.Any("from", proc.PID),
+				slog.Any("to", srv.PID),
+				slog.Any("id", b.ID),
+			)
+			return err
+		}
 		s.log.Debug("transition taking succeeded")
+		recv.Cont = step.Subst(recv.Cont, recv.X, newVia.ID)
+		recv.Cont = step.Subst(recv.Cont, recv.Y, b.ID)
 		newProc := step.ProcRoot{
 			ID:   id.New(),
 			PID:  chnl.Subst(srv.PID, curVia.ID, newVia.ID),
-			Term: step.Subst(cont.Conts[term.L], cont.X, newVia.ID),
+			Term: recv.Cont,
 		}
-		return s.takeProc(newProc)
-	case step.CaseSpec:
+		return s.schedule(ds, newProc)
+	case step.RecvSpec:
 		viaID, ok := term.X.(chnl.ID)
 		if !ok {
 			err := chnl.ErrNotAnID(term.X)
@@ -942,7 +2227,7 @@ func (s *service) takeProcWith(
 			)
 			return err
 		}
-		curSem, err := s.steps.SelectByVID(curVia.ID)
+		curSem, err := s.steps.SelectByVID(ds, curVia.ID)
 		if err != nil {
 			s.log.Error("message selection failed",
 				slog.Any("reason", err),
@@ -952,12 +2237,13 @@ func (s *service) takeProcWith(
 		}
 		if curSem == nil {
 			newSrv := step.SrvRoot{
-				ID:   id.New(),
-				PID:  proc.PID,
-				VID:  curVia.ID,
-				Cont: term,
+				ID:       id.New(),
+				PID:      proc.PID,
+				VID:      curVia.ID,
+				Cont:     term,
+				Deadline: proc.Deadline,
 			}
-			err = s.steps.Insert(newSrv)
+			err = s.steps.Insert(ds, newSrv)
 			if err != nil {
 				s.log.Error("service insertion failed",
 					slog.Any("reason", err),
@@ -977,55 +2263,283 @@ func (s *service) takeProcWith(
 			)
 			return err
 		}
-		lab, ok := msg.Val.(step.LabSpec)
+		send, ok := msg.Val.(step.SendSpec)
 		if !ok {
-			err = fmt.Errorf("val type mismatch: want %T, got %T", lab, msg.Val)
+			err = step.ErrValTypeMismatch(msg.Val, send)
 			s.log.Error("transition taking failed",
 				slog.Any("reason", err),
 				slog.Any("val", msg.Val),
 			)
 			return err
 		}
-		curSt, ok := cfg.LookupSt(curVia.ID)
-		if !ok {
-			err = chnl.ErrMissingInCfg(curVia.ID)
+		curSt, err := s.lookupSt(ds, cfg, curVia.ID)
+		if err != nil {
 			s.log.Error("transition taking failed",
 				slog.Any("reason", err),
 			)
 			return err
 		}
-		nextID := curSt.(state.Sum).Next(lab.L)
+		nextID := curSt.(state.Prod).Next()
 		newVia := chnl.Root{
 			ID:      id.New(),
 			Key:     curVia.Key,
 			PreID:   &curVia.ID,
 			StateID: &nextID,
 		}
-		err = s.chnls.Insert(newVia)
+		newVia, err = s.advance(ds, msg, newVia)
 		if err != nil {
-			s.log.Error("channel insertion failed",
+			s.log.Error("channel advancing failed",
 				slog.Any("reason", err),
 				slog.Any("via", newVia),
 			)
 			return err
 		}
-		s.log.Debug("transition taking succeeded")
-		newProc := step.ProcRoot{
-			ID:   id.New(),
-			PID:  chnl.Subst(proc.PID, curVia.ID, newVia.ID),
-			Term: step.Subst(term.Conts[lab.L], term.X, newVia.ID),
+		bID, ok := send.B.(chnl.ID)
+		if !ok {
+			err := chnl.ErrNotAnID(send.B)
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+			)
+			return err
 		}
-		return s.takeProc(newProc)
-	case step.SpawnSpec:
-		newPE, err := s.Involve(PartSpec{Sig: term.Sig, Owner: proc.PID, TEs: term.CEs})
-		if err != nil {
+		b, ok := cfg.LookupCh(bID)
+		if !ok {
+			err = chnl.ErrMissingInCfg(bID)
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+			)
 			return err
 		}
-		err = s.chnls.Transfer(id.Empty(), proc.PID, []chnl.ID{newPE.ID})
+		err = s.chnls.Transfer(ds, holderOf(msg), proc.PID, []chnl.ID{b.ID})
 		if err != nil {
 			s.log.Error("channel transfer failed",
 				slog.Any("reason", err),
-				slog.Any("from", id.Empty()),
+				slog.Any("from", holderOf(msg)),
+				slog.Any("to", proc.PID),
+				slog.Any("id", b.ID),
+			)
+			return err
+		}
+		s.log.Debug("transition taking succeeded")
+		term.Cont = step.Subst(term.Cont, term.X, newVia.ID)
+		term.Cont = step.Subst(term.Cont, term.Y, b.ID)
+		newProc := step.ProcRoot{
+			ID:   id.New(),
+			PID:  chnl.Subst(proc.PID, curVia.ID, newVia.ID),
+			Term: term.Cont,
+		}
+		return s.schedule(ds, newProc)
+	case step.LabSpec:
+		viaID, ok := term.A.(chnl.ID)
+		if !ok {
+			err := chnl.ErrNotAnID(term.A)
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+			)
+			return err
+		}
+		curVia, ok := cfg.LookupCh(viaID)
+		if !ok {
+			err = chnl.ErrMissingInCfg(viaID)
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+			)
+			return err
+		}
+		curSem, err := s.steps.SelectByVID(ds, curVia.ID)
+		if err != nil {
+			s.log.Error("service selection failed",
+				slog.Any("reason", err),
+				slog.Any("vid", curVia.ID),
+			)
+			return err
+		}
+		if curSem == nil {
+			queued, err := s.enqueue(ds, proc, cfg, curVia, term)
+			if err != nil {
+				s.log.Error("message enqueuing failed",
+					slog.Any("reason", err),
+					slog.Any("vid", curVia.ID),
+				)
+				return err
+			}
+			if queued {
+				return nil
+			}
+			newMsg := step.MsgRoot{
+				ID:       id.New(),
+				PID:      proc.PID,
+				VID:      curVia.ID,
+				Val:      term,
+				Deadline: proc.Deadline,
+			}
+			err = s.steps.Insert(ds, newMsg)
+			if err != nil {
+				s.log.Error("message insertion failed",
+					slog.Any("reason", err),
+					slog.Any("msg", newMsg),
+				)
+				return err
+			}
+			s.log.Debug("transition taking half done", slog.Any("msg", newMsg))
+			return nil
+		}
+		srv, ok := curSem.(step.SrvRoot)
+		if !ok {
+			err = step.ErrRootTypeMismatch(curSem, step.SrvRoot{})
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+				// TODO log some ID
+			)
+			return err
+		}
+		cont, ok := srv.Cont.(step.CaseSpec)
+		if !ok {
+			err = fmt.Errorf("unexpected cont type: want %T, got %T", step.CaseSpec{}, srv.Cont)
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+				slog.Any("cont", srv.Cont),
+			)
+			return err
+		}
+		curSt, err := s.lookupSt(ds, cfg, curVia.ID)
+		if err != nil {
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+			)
+			return err
+		}
+		nextID := curSt.(state.Sum).Next(term.L)
+		newVia := chnl.Root{
+			ID:      id.New(),
+			Key:     curVia.Key,
+			PreID:   &curVia.ID,
+			StateID: &nextID,
+		}
+		err = s.chnls.Insert(ds, newVia)
+		if err != nil {
+			s.log.Error("channel insertion failed",
+				slog.Any("reason", err),
+				slog.Any("via", newVia),
+			)
+			return err
+		}
+		s.log.Debug("transition taking succeeded")
+		newProc := step.ProcRoot{
+			ID:   id.New(),
+			PID:  chnl.Subst(srv.PID, curVia.ID, newVia.ID),
+			Term: step.Subst(cont.Conts[term.L], cont.X, newVia.ID),
+		}
+		return s.schedule(ds, newProc)
+	case step.CaseSpec:
+		viaID, ok := term.X.(chnl.ID)
+		if !ok {
+			err := chnl.ErrNotAnID(term.X)
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+			)
+			return err
+		}
+		curVia, ok := cfg.LookupCh(viaID)
+		if !ok {
+			err = chnl.ErrMissingInCfg(viaID)
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+			)
+			return err
+		}
+		curSem, err := s.steps.SelectByVID(ds, curVia.ID)
+		if err != nil {
+			s.log.Error("message selection failed",
+				slog.Any("reason", err),
+				slog.Any("vid", curVia.ID),
+			)
+			return err
+		}
+		if curSem == nil {
+			newSrv := step.SrvRoot{
+				ID:       id.New(),
+				PID:      proc.PID,
+				VID:      curVia.ID,
+				Cont:     term,
+				Deadline: proc.Deadline,
+			}
+			err = s.steps.Insert(ds, newSrv)
+			if err != nil {
+				s.log.Error("service insertion failed",
+					slog.Any("reason", err),
+					slog.Any("srv", newSrv),
+				)
+				return err
+			}
+			s.log.Debug("transition taking half done", slog.Any("srv", newSrv))
+			return nil
+		}
+		msg, ok := curSem.(step.MsgRoot)
+		if !ok {
+			err = step.ErrRootTypeMismatch(curSem, step.MsgRoot{})
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+				// TODO log some ID
+			)
+			return err
+		}
+		lab, ok := msg.Val.(step.LabSpec)
+		if !ok {
+			err = step.ErrValTypeMismatch(msg.Val, lab)
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+				slog.Any("val", msg.Val),
+			)
+			return err
+		}
+		curSt, err := s.lookupSt(ds, cfg, curVia.ID)
+		if err != nil {
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+			)
+			return err
+		}
+		nextID := curSt.(state.Sum).Next(lab.L)
+		newVia := chnl.Root{
+			ID:      id.New(),
+			Key:     curVia.Key,
+			PreID:   &curVia.ID,
+			StateID: &nextID,
+		}
+		newVia, err = s.advance(ds, msg, newVia)
+		if err != nil {
+			s.log.Error("channel advancing failed",
+				slog.Any("reason", err),
+				slog.Any("via", newVia),
+			)
+			return err
+		}
+		s.log.Debug("transition taking succeeded")
+		newProc := step.ProcRoot{
+			ID:   id.New(),
+			PID:  chnl.Subst(proc.PID, curVia.ID, newVia.ID),
+			Term: step.Subst(term.Conts[lab.L], term.X, newVia.ID),
+		}
+		return s.schedule(ds, newProc)
+	case step.SpawnSpec:
+		ceIDs, err := convertToIDs(term.CEs)
+		if err != nil {
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+			)
+			return err
+		}
+		newPart, err := s.involve(ds, PartSpec{Sig: term.Sig, Owner: proc.PID, TEs: ceIDs})
+		if err != nil {
+			return err
+		}
+		newPE := newPart.PE
+		err = s.chnls.Transfer(ds, id.Empty(), proc.PID, []chnl.ID{newPE.ID})
+		if err != nil {
+			s.log.Error("channel transfer failed",
+				slog.Any("reason", err),
+				slog.Any("from", id.Empty()),
 				slog.Any("to", proc.PID),
 				slog.Any("id", newPE.ID),
 			)
@@ -1033,9 +2547,40 @@ func (s *service) takeProcWith(
 		}
 		s.log.Debug("transition taking succeeded")
 		cfg.Add(newPE)
-		cfg.Remove(term.CEs...)
+		cfg.Remove(ceIDs...)
 		proc.Term = step.Subst(term.Cont, term.PE, newPE.ID)
-		return s.takeProcWith(proc, cfg)
+		return s.takeProcWith(ds, proc, cfg)
+	case step.LinkSpec:
+		ceIDs, err := convertToIDs(term.CEs)
+		if err != nil {
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+			)
+			return err
+		}
+		decl, err := s.sigs.SelectByID(ds, term.Sig)
+		if err != nil {
+			s.log.Error("signature selection failed",
+				slog.Any("reason", err),
+				slog.Any("id", term.Sig),
+			)
+			return err
+		}
+		if decl.Body == nil {
+			err := sig.ErrBodyMissing(decl.ID)
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+			)
+			return err
+		}
+		newProc := step.ProcRoot{
+			ID:       id.New(),
+			PID:      proc.PID,
+			Term:     instantiateBody(decl, proc.PID, ceIDs),
+			Deadline: deadlineOf(decl),
+		}
+		s.log.Debug("transition taking succeeded")
+		return s.schedule(ds, newProc)
 	case step.FwdSpec:
 		viaID, ok := term.C.(chnl.ID)
 		if !ok {
@@ -1053,15 +2598,14 @@ func (s *service) takeProcWith(
 			)
 			return err
 		}
-		curSt, ok := cfg.LookupSt(curVia.ID)
-		if !ok {
-			err = chnl.ErrMissingInCfg(curVia.ID)
+		curSt, err := cfg.LookupSt(curVia.ID)
+		if err != nil {
 			s.log.Error("transition taking failed",
 				slog.Any("reason", err),
 			)
 			return err
 		}
-		curSem, err := s.steps.SelectByVID(curVia.ID)
+		curSem, err := s.steps.SelectByVID(ds, curVia.ID)
 		if err != nil {
 			s.log.Error("step selection failed",
 				slog.Any("reason", err),
@@ -1085,7 +2629,7 @@ func (s *service) takeProcWith(
 					s.log.Error("transition taking failed", slog.Any("reason", err))
 					return err
 				}
-				err := s.chnls.Transfer(proc.PID, sem.PID, []chnl.ID{c.ID})
+				err := s.chnls.Transfer(ds, proc.PID, sem.PID, []chnl.ID{c.ID})
 				if err != nil {
 					s.log.Error("channel transfer failed",
 						slog.Any("reason", err),
@@ -1101,7 +2645,7 @@ func (s *service) takeProcWith(
 					Term: step.Subst(sem.Cont, term.D, c.ID),
 				}
 				s.log.Debug("transition taking succeeded")
-				return s.takeProc(newProc)
+				return s.schedule(ds, newProc)
 			case step.MsgRoot:
 				dID, ok := term.C.(chnl.ID)
 				if !ok {
@@ -1115,7 +2659,7 @@ func (s *service) takeProcWith(
 					s.log.Error("transition taking failed", slog.Any("reason", err))
 					return err
 				}
-				err := s.chnls.Transfer(proc.PID, sem.PID, []chnl.ID{d.ID})
+				err := s.chnls.Transfer(ds, proc.PID, sem.PID, []chnl.ID{d.ID})
 				if err != nil {
 					s.log.Error("channel transfer failed",
 						slog.Any("reason", err),
@@ -1131,15 +2675,16 @@ func (s *service) takeProcWith(
 					Term: step.Subst(sem.Val, term.C, d.ID),
 				}
 				s.log.Debug("transition taking succeeded")
-				return s.takeProc(newProc)
+				return s.schedule(ds, newProc)
 			case nil:
 				newMsg := step.MsgRoot{
-					ID:  id.New(),
-					PID: proc.PID,
-					VID: curVia.ID,
-					Val: term,
+					ID:       id.New(),
+					PID:      proc.PID,
+					VID:      curVia.ID,
+					Val:      term,
+					Deadline: proc.Deadline,
 				}
-				err := s.steps.Insert(newMsg)
+				err := s.steps.Insert(ds, newMsg)
 				if err != nil {
 					s.log.Error("message insertion failed",
 						slog.Any("reason", err),
@@ -1167,7 +2712,7 @@ func (s *service) takeProcWith(
 					s.log.Error("transition taking failed", slog.Any("reason", err))
 					return err
 				}
-				err := s.chnls.Transfer(proc.PID, sem.PID, []chnl.ID{d.ID})
+				err := s.chnls.Transfer(ds, proc.PID, sem.PID, []chnl.ID{d.ID})
 				if err != nil {
 					s.log.Error("channel transfer failed",
 						slog.Any("reason", err),
@@ -1183,7 +2728,7 @@ func (s *service) takeProcWith(
 					Term: step.Subst(sem.Cont, term.C, d.ID),
 				}
 				s.log.Debug("transition taking succeeded")
-				return s.takeProc(newProc)
+				return s.schedule(ds, newProc)
 			case step.MsgRoot:
 				cID, ok := term.C.(chnl.ID)
 				if !ok {
@@ -1197,7 +2742,7 @@ func (s *service) takeProcWith(
 					s.log.Error("transition taking failed", slog.Any("reason", err))
 					return err
 				}
-				err := s.chnls.Transfer(proc.PID, sem.PID, []chnl.ID{c.ID})
+				err := s.chnls.Transfer(ds, proc.PID, sem.PID, []chnl.ID{c.ID})
 				if err != nil {
 					s.log.Error("channel transfer failed",
 						slog.Any("reason", err),
@@ -1213,15 +2758,16 @@ func (s *service) takeProcWith(
 					Term: step.Subst(sem.Val, term.D, c.ID),
 				}
 				s.log.Debug("transition taking succeeded")
-				return s.takeProc(newProc)
+				return s.schedule(ds, newProc)
 			case nil:
 				newSrv := step.SrvRoot{
-					ID:   id.New(),
-					PID:  proc.PID,
-					VID:  curVia.ID,
-					Cont: term,
+					ID:       id.New(),
+					PID:      proc.PID,
+					VID:      curVia.ID,
+					Cont:     term,
+					Deadline: proc.Deadline,
 				}
-				err = s.steps.Insert(newSrv)
+				err = s.steps.Insert(ds, newSrv)
 				if err != nil {
 					s.log.Error("service insertion failed",
 						slog.Any("reason", err),
@@ -1237,51 +2783,780 @@ func (s *service) takeProcWith(
 		default:
 			panic(state.ErrPolarityUnexpected(curSt))
 		}
-	default:
-		panic(step.ErrTermTypeUnexpected(proc.Term))
+	case step.AcqSpec:
+		viaID, ok := term.X.(chnl.ID)
+		if !ok {
+			err := chnl.ErrNotAnID(term.X)
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+			)
+			return err
+		}
+		curVia, ok := cfg.LookupCh(viaID)
+		if !ok {
+			err = chnl.ErrMissingInCfg(viaID)
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+			)
+			return err
+		}
+		curSem, err := s.steps.SelectByVID(ds, curVia.ID)
+		if err != nil {
+			s.log.Error("step selection failed",
+				slog.Any("reason", err),
+				slog.Any("vid", curVia.ID),
+			)
+			return err
+		}
+		if curSem != nil {
+			srv, ok := curSem.(step.SrvRoot)
+			if !ok {
+				err = step.ErrRootTypeMismatch(curSem, step.SrvRoot{})
+				s.log.Error("transition taking failed",
+					slog.Any("reason", err),
+				)
+				return err
+			}
+			acc, ok := srv.Cont.(step.AccSpec)
+			if ok {
+				return s.takeAcquire(ds, cfg, curVia, srv.ID, term, proc.PID, acc, srv.PID)
+			}
+			_, ok = srv.Cont.(step.AcqSpec)
+			if !ok {
+				err = step.ErrContTypeMismatch(srv.Cont, acc)
+				s.log.Error("transition taking failed",
+					slog.Any("reason", err),
+					slog.Any("cont", srv.Cont),
+				)
+				return err
+			}
+			// other clients are waiting already, so stand in line behind them
+			newSrv := step.SrvRoot{
+				ID:       id.New(),
+				PID:      proc.PID,
+				VID:      curVia.ID,
+				Cont:     term,
+				Deadline: proc.Deadline,
+			}
+			err = s.steps.EnqueueAcquirer(ds, newSrv)
+			if err != nil {
+				s.log.Error("acquirer enqueueing failed",
+					slog.Any("reason", err),
+					slog.Any("srv", newSrv),
+				)
+				return err
+			}
+			s.log.Debug("transition taking queued", slog.Any("srv", newSrv))
+			return nil
+		}
+		newSrv := step.SrvRoot{
+			ID:       id.New(),
+			PID:      proc.PID,
+			VID:      curVia.ID,
+			Cont:     term,
+			Deadline: proc.Deadline,
+		}
+		err = s.steps.Insert(ds, newSrv)
+		if err != nil {
+			s.log.Error("service insertion failed",
+				slog.Any("reason", err),
+				slog.Any("srv", newSrv),
+			)
+			return err
+		}
+		s.log.Debug("transition taking half done", slog.Any("srv", newSrv))
+		return nil
+	case step.AccSpec:
+		viaID, ok := term.X.(chnl.ID)
+		if !ok {
+			err := chnl.ErrNotAnID(term.X)
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+			)
+			return err
+		}
+		curVia, ok := cfg.LookupCh(viaID)
+		if !ok {
+			err = chnl.ErrMissingInCfg(viaID)
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+			)
+			return err
+		}
+		curSem, err := s.steps.SelectByVID(ds, curVia.ID)
+		if err != nil {
+			s.log.Error("step selection failed",
+				slog.Any("reason", err),
+				slog.Any("vid", curVia.ID),
+			)
+			return err
+		}
+		if curSem == nil {
+			newSrv := step.SrvRoot{
+				ID:       id.New(),
+				PID:      proc.PID,
+				VID:      curVia.ID,
+				Cont:     term,
+				Deadline: proc.Deadline,
+			}
+			err = s.steps.Insert(ds, newSrv)
+			if err != nil {
+				s.log.Error("service insertion failed",
+					slog.Any("reason", err),
+					slog.Any("srv", newSrv),
+				)
+				return err
+			}
+			s.log.Debug("transition taking half done", slog.Any("srv", newSrv))
+			return nil
+		}
+		srv, ok := curSem.(step.SrvRoot)
+		if !ok {
+			err = step.ErrRootTypeMismatch(curSem, step.SrvRoot{})
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+			)
+			return err
+		}
+		acq, ok := srv.Cont.(step.AcqSpec)
+		if !ok {
+			err = step.ErrContTypeMismatch(srv.Cont, acq)
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+				slog.Any("cont", srv.Cont),
+			)
+			return err
+		}
+		return s.takeAcquire(ds, cfg, curVia, srv.ID, acq, srv.PID, term, proc.PID)
+	case step.RelSpec:
+		viaID, ok := term.X.(chnl.ID)
+		if !ok {
+			err := chnl.ErrNotAnID(term.X)
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+			)
+			return err
+		}
+		curVia, ok := cfg.LookupCh(viaID)
+		if !ok {
+			err = chnl.ErrMissingInCfg(viaID)
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+			)
+			return err
+		}
+		curSem, err := s.steps.SelectByVID(ds, curVia.ID)
+		if err != nil {
+			s.log.Error("step selection failed",
+				slog.Any("reason", err),
+				slog.Any("vid", curVia.ID),
+			)
+			return err
+		}
+		if curSem == nil {
+			newSrv := step.SrvRoot{
+				ID:       id.New(),
+				PID:      proc.PID,
+				VID:      curVia.ID,
+				Cont:     term,
+				Deadline: proc.Deadline,
+			}
+			err = s.steps.Insert(ds, newSrv)
+			if err != nil {
+				s.log.Error("service insertion failed",
+					slog.Any("reason", err),
+					slog.Any("srv", newSrv),
+				)
+				return err
+			}
+			s.log.Debug("transition taking half done", slog.Any("srv", newSrv))
+			return nil
+		}
+		msg, ok := curSem.(step.MsgRoot)
+		if !ok {
+			err = step.ErrRootTypeMismatch(curSem, step.MsgRoot{})
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+			)
+			return err
+		}
+		det, ok := msg.Val.(step.DetSpec)
+		if !ok {
+			err = step.ErrValTypeMismatch(msg.Val, det)
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+				slog.Any("val", msg.Val),
+			)
+			return err
+		}
+		return s.takeRelease(ds, curVia, term, proc.PID, det)
+	case step.DetSpec:
+		viaID, ok := term.X.(chnl.ID)
+		if !ok {
+			err := chnl.ErrNotAnID(term.X)
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+			)
+			return err
+		}
+		curVia, ok := cfg.LookupCh(viaID)
+		if !ok {
+			err = chnl.ErrMissingInCfg(viaID)
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+			)
+			return err
+		}
+		curSem, err := s.steps.SelectByVID(ds, curVia.ID)
+		if err != nil {
+			s.log.Error("step selection failed",
+				slog.Any("reason", err),
+				slog.Any("vid", curVia.ID),
+			)
+			return err
+		}
+		if curSem == nil {
+			newMsg := step.MsgRoot{
+				ID:       id.New(),
+				PID:      proc.PID,
+				VID:      curVia.ID,
+				Val:      term,
+				Deadline: proc.Deadline,
+			}
+			err = s.steps.Insert(ds, newMsg)
+			if err != nil {
+				s.log.Error("message insertion failed",
+					slog.Any("reason", err),
+					slog.Any("msg", newMsg),
+				)
+				return err
+			}
+			s.log.Debug("transition taking half done", slog.Any("msg", newMsg))
+			return nil
+		}
+		srv, ok := curSem.(step.SrvRoot)
+		if !ok {
+			err = step.ErrRootTypeMismatch(curSem, step.SrvRoot{})
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+			)
+			return err
+		}
+		rel, ok := srv.Cont.(step.RelSpec)
+		if !ok {
+			err = step.ErrContTypeMismatch(srv.Cont, rel)
+			s.log.Error("transition taking failed",
+				slog.Any("reason", err),
+				slog.Any("cont", srv.Cont),
+			)
+			return err
+		}
+		return s.takeRelease(ds, curVia, rel, srv.PID, term)
+	default:
+		panic(step.ErrTermTypeUnexpected(proc.Term))
+	}
+}
+
+// lookupSt unfolds state of via, states instantiated from parametric roles
+// exist in memory only, so they're stored before successors refer to them
+func (s *service) lookupSt(ds data.Source, cfg Configuration, vid chnl.ID) (state.Root, error) {
+	curSt, err := cfg.LookupSt(vid)
+	if err != nil {
+		return nil, err
+	}
+	curVia, _ := cfg.LookupCh(vid)
+	if curSt.Ident() == *curVia.StateID {
+		return curSt, nil
+	}
+	err = s.states.Insert(ds, curSt)
+	if err != nil {
+		s.log.Error("state insertion failed",
+			slog.Any("reason", err),
+			slog.Any("id", curSt.Ident()),
+		)
+		return nil, err
+	}
+	return curSt, nil
+}
+
+// enqueue sends val ahead of receiver, reports false if queueing isn't possible
+// and val has to await rendezvous
+func (s *service) enqueue(
+	ds data.Source,
+	proc step.ProcRoot,
+	cfg Configuration,
+	curVia chnl.Root,
+	val step.Value,
+) (bool, error) {
+	if s.queueBound == 0 {
+		return false, nil
+	}
+	curSt, err := s.lookupSt(ds, cfg, curVia.ID)
+	if err != nil {
+		return false, err
+	}
+	// only providers of positive states run ahead of clients
+	if curSt.Pol() != pol.Pos {
+		return false, nil
+	}
+	queueLen, err := s.steps.SelectQueueLen(ds, curVia.ID)
+	if err != nil {
+		return false, err
+	}
+	if queueLen >= s.queueBound {
+		s.log.Debug("message queue is full", slog.Any("vid", curVia.ID), slog.Int("len", queueLen))
+		return false, nil
+	}
+	// continuation channel is allocated eagerly, so that sender goes on with it
+	newVia := chnl.Root{
+		ID:    id.New(),
+		Key:   curVia.Key,
+		PreID: &curVia.ID,
+	}
+	switch val := val.(type) {
+	case step.CloseSpec:
+		newVia.StateID = nil
+	case step.SendSpec:
+		nextID := curSt.(state.Prod).Next()
+		newVia.StateID = &nextID
+	case step.LabSpec:
+		nextID := curSt.(state.Sum).Next(val.L)
+		newVia.StateID = &nextID
+	default:
+		panic(step.ErrValTypeUnexpected(val))
+	}
+	// queued msgs don't expire, their senders aren't blocked
+	newMsg := step.MsgRoot{
+		ID:      id.New(),
+		PID:     proc.PID,
+		VID:     curVia.ID,
+		NextVID: newVia.ID,
+		Val:     val,
+	}
+	err = s.steps.Insert(ds, newMsg)
+	if err != nil {
+		return false, err
+	}
+	err = s.chnls.Insert(ds, newVia)
+	if err != nil {
+		return false, err
+	}
+	// sent channel leaves sender right away and is held by msg till receipt
+	send, ok := val.(step.SendSpec)
+	if ok {
+		bID, ok := send.B.(chnl.ID)
+		if !ok {
+			return false, chnl.ErrNotAnID(send.B)
+		}
+		b, ok := cfg.LookupCh(bID)
+		if !ok {
+			return false, chnl.ErrMissingInCfg(bID)
+		}
+		err = s.chnls.Transfer(ds, proc.PID, newMsg.ID, []chnl.ID{b.ID})
+		if err != nil {
+			return false, err
+		}
+	}
+	s.log.Debug("transition taking enqueued", slog.Any("msg", newMsg))
+	return true, nil
+}
+
+// queued msg holds sent channels till receipt,
+// otherwise they stay with sender till rendezvous
+func holderOf(msg step.MsgRoot) chnl.ID {
+	if msg.NextVID.IsEmpty() {
+		return msg.PID
+	}
+	return msg.ID
+}
+
+// advance takes successor of via on msg receipt,
+// queued msg brings successor allocated by sender
+func (s *service) advance(ds data.Source, msg step.MsgRoot, newVia chnl.Root) (chnl.Root, error) {
+	if msg.NextVID.IsEmpty() {
+		return newVia, s.chnls.Insert(ds, newVia)
+	}
+	err := s.steps.Delete(ds, msg.ID)
+	if err != nil {
+		return chnl.Root{}, err
+	}
+	return s.chnls.SelectByID(ds, msg.NextVID)
+}
+
+// pairs an acquiring client with an accepting provider
+// on a fresh linear channel, the shared one stays intact
+func (s *service) takeAcquire(
+	ds data.Source,
+	cfg Configuration,
+	curVia chnl.Root,
+	semID step.ID,
+	acq step.AcqSpec,
+	acqPID chnl.ID,
+	acc step.AccSpec,
+	accPID chnl.ID,
+) error {
+	curSt, err := s.lookupSt(ds, cfg, curVia.ID)
+	if err != nil {
+		s.log.Error("transition taking failed",
+			slog.Any("reason", err),
+		)
+		return err
+	}
+	upSt, ok := curSt.(state.UpRoot)
+	if !ok {
+		err := state.ErrRootTypeMismatch(curSt, upSt)
+		s.log.Error("transition taking failed",
+			slog.Any("reason", err),
+			slog.Any("via", curVia.ID),
+		)
+		return err
+	}
+	// shared channel can be acquired again
+	err = s.steps.Delete(ds, semID)
+	if err != nil {
+		s.log.Error("step deletion failed",
+			slog.Any("reason", err),
+			slog.Any("id", semID),
+		)
+		return err
+	}
+	// the longest waiting client is next to be served
+	nextSem, err := s.steps.DequeueAcquirer(ds, curVia.ID)
+	if err != nil {
+		s.log.Error("acquirer dequeueing failed",
+			slog.Any("reason", err),
+			slog.Any("vid", curVia.ID),
+		)
+		return err
+	}
+	if nextSem != nil {
+		err = s.steps.Insert(ds, nextSem)
+		if err != nil {
+			s.log.Error("service insertion failed",
+				slog.Any("reason", err),
+				slog.Any("srv", nextSem),
+			)
+			return err
+		}
+	}
+	nextID := upSt.A.Ident()
+	newVia := chnl.Root{
+		ID:      id.New(),
+		Key:     curVia.Key,
+		StateID: &nextID,
+	}
+	err = s.chnls.Insert(ds, newVia)
+	if err != nil {
+		s.log.Error("channel insertion failed",
+			slog.Any("reason", err),
+			slog.Any("via", newVia),
+		)
+		return err
+	}
+	s.log.Debug("transition taking succeeded")
+	accProc := step.ProcRoot{
+		ID:   id.New(),
+		PID:  chnl.Subst(accPID, curVia.ID, newVia.ID),
+		Term: step.Subst(acc.Cont, acc.Y, newVia.ID),
+	}
+	err = s.schedule(ds, accProc)
+	if err != nil {
+		return err
+	}
+	acqProc := step.ProcRoot{
+		ID:   id.New(),
+		PID:  acqPID,
+		Term: step.Subst(acq.Cont, acq.Y, newVia.ID),
+	}
+	return s.schedule(ds, acqProc)
+}
+
+// closes the linear channel, the provider is back to shared
+//
+// detach has no continuation: provider's agent has to take
+// accept on the shared channel again to serve the next client
+func (s *service) takeRelease(
+	ds data.Source,
+	curVia chnl.Root,
+	rel step.RelSpec,
+	relPID chnl.ID,
+	det step.DetSpec,
+) error {
+	if rel.Y != det.Y {
+		err := fmt.Errorf("shared channel mismatch: want %v, got %v", det.Y, rel.Y)
+		s.log.Error("transition taking failed",
+			slog.Any("reason", err),
+			slog.Any("via", curVia.ID),
+		)
+		return err
+	}
+	// consume and close channel
+	finVia := chnl.Root{
+		ID:      id.New(),
+		Key:     curVia.Key,
+		PreID:   &curVia.ID,
+		StateID: nil,
+	}
+	err := s.chnls.Insert(ds, finVia)
+	if err != nil {
+		s.log.Error("channel insertion failed",
+			slog.Any("reason", err),
+			slog.Any("via", finVia),
+		)
+		return err
+	}
+	s.log.Debug("transition taking succeeded")
+	newProc := step.ProcRoot{
+		ID:   id.New(),
+		PID:  relPID,
+		Term: rel.Cont,
+	}
+	return s.schedule(ds, newProc)
+}
+
+type repo interface {
+	Insert(data.Source, Root) error
+	SelectAll(data.Source) ([]Ref, error)
+	SelectByID(data.Source, ID) (Root, error)
+	SelectChildren(data.Source, ID) ([]Ref, error)
+	SelectSigs(data.Source, ID) ([]sig.Ref, error)
+	InsertPart(data.Source, ID, chnl.ID) error
+	// selects all versions of process channels involved into deal
+	SelectMembers(data.Source, ID) ([]chnl.ID, error)
+}
+
+// Point-in-time Reconstruction
+type SnapSpec struct {
+	Deal ID
+	// Journal Entry ID to reconstruct at, takes precedence over time
+	Pos step.ID
+	// reconstruct at, with precision of a second, latest if zero
+	At time.Time
+}
+
+// ids generated at or before cut are taken into account
+func (s SnapSpec) cut() id.ADT {
+	if !s.Pos.IsEmpty() {
+		return s.Pos
+	}
+	if !s.At.IsZero() {
+		return id.Bound(s.At)
+	}
+	return id.Bound(time.Now())
+}
+
+// Snapshot is deal configuration as of journal position
+type Snapshot struct {
+	// last folded Journal Entry ID
+	Pos step.ID
+	// open channels
+	Chnls []chnl.Root
+	// states of open channels, roots on server side
+	States []state.Ref
+	// half done reductions awaiting counterparts
+	Pending []step.Entry
+}
+
+type EventSpec struct {
+	Deal ID
+	// Channel ID, any version
+	VID chnl.ID
+}
+
+type ObligSpec struct {
+	Deal ID
+	// Channel ID, any version
+	VID chnl.ID
+}
+
+// Obligation is step expected from process at channel end
+type Obligation struct {
+	// Process ID, aka root of its channel history
+	PID chnl.ID
+	// Channel ID, the latest version
+	VID  chnl.ID
+	Kind step.TermKind
+	// labels allowed to send or to be received
+	Labels []core.Label
+}
+
+// History page
+type HistorySpec struct {
+	Deal ID
+	// Journal Entry ID to start after, empty for the first page
+	After step.ID
+	Limit int
+}
+
+// Kinship Relation
+type KinshipSpec struct {
+	ParentID ID
+	ChildIDs []ID
+}
+
+type KinshipRoot struct {
+	Parent   Ref
+	Children []Ref
+}
+
+type kinshipRepo interface {
+	Insert(data.Source, KinshipRoot) error
+}
+
+// Participation aka lightweight Spawn
+type PartSpec struct {
+	Deal  id.ADT
+	Sig   sig.ID
+	Owner chnl.ID
+	// Transferable Endpoints
+	TEs []chnl.ID
+}
+
+type PartRoot struct {
+	// Providable Endpoint
+	PE chnl.Root
+	// Agent Access Key, issued for sig without body only
+	AK ak.ADT
+}
+
+// Access Key Rotation
+type KeySpec struct {
+	Deal ID
+	// Process ID
+	PID chnl.ID
+	// key of process itself or of its client
+	Key ak.ADT
+}
+
+type KeyRoot struct {
+	PID chnl.ID
+	AK  ak.ADT
+}
+
+type keyRepo interface {
+	Insert(data.Source, KeyRoot) error
+	Update(data.Source, KeyRoot) error
+	SelectByPID(data.Source, chnl.ID) (KeyRoot, error)
+}
+
+// Transition
+type TranSpec struct {
+	Deal id.ADT
+	// Process ID
+	PID chnl.ID
+	// Agent Access Key
+	Key  ak.ADT
+	Term step.Term
+	// retries with the same key take transition at most once, optional
+	IK step.IK
+	// steps left pending expire at deadline, optional
+	Deadline time.Time
+}
+
+// Cancellation
+type CancelSpec struct {
+	Deal ID
+	// Process ID
+	PID chnl.ID
+	// Access Key of process or of its client
+	Key ak.ADT
+}
+
+var ErrNotMember = errors.New("process isn't deal member")
+
+func ErrMemberMissing(did ID, pid chnl.ID) error {
+	return fmt.Errorf("%w: %v in %v", ErrNotMember, pid, did)
+}
+
+// peers waiting in case with such branch take it on cancellation
+const cancelLabel = core.Label("cancel")
+
+// Wait is a process blocked on a pending step
+type Wait struct {
+	// Waiting Process ID
+	PID chnl.ID
+	// Pending Step ID
+	SID step.ID
+	// Via Channel ID
+	VID chnl.ID
+	// Awaited Process ID
+	ForPID chnl.ID
+}
+
+// Deadlock is a cycle of processes waiting for each other
+type Deadlock struct {
+	Waits []Wait
+}
+
+// CheckBody type checks sig body against sig endpoints,
+// where endpoint keys serve as channel placeholders
+func (s *service) CheckBody(ds data.Source, decl sig.Root) error {
+	s.log.Debug("body checking started", slog.Any("sig", decl.ID))
+	sigIDs := slices.DeleteFunc(step.CollectEnv(decl.Body), func(sigID sig.ID) bool {
+		return sigID == decl.ID
+	})
+	sigs, err := s.sigs.SelectEnv(ds, sigIDs)
+	if err != nil {
+		s.log.Error("signatures selection failed",
+			slog.Any("reason", err),
+			slog.Any("ids", sigIDs),
+		)
+		return err
+	}
+	// recursive bodies refer to sig itself
+	sigs[decl.ID] = decl
+	roleFQNs := sig.CollectEnv(maps.Values(sigs))
+	roles, err := s.roles.SelectEnv(ds, roleFQNs)
+	if err != nil {
+		s.log.Error("roles selection failed",
+			slog.Any("reason", err),
+			slog.Any("fqns", roleFQNs),
+		)
+		return err
+	}
+	for _, fqn := range roleFQNs {
+		_, ok := roles[fqn]
+		if !ok {
+			err := state.ErrMissingInDefs(fqn)
+			s.log.Error("body checking failed", slog.Any("reason", err))
+			return err
+		}
+	}
+	envIDs := role.CollectEnv(maps.Values(roles))
+	states, err := s.states.SelectEnv(ds, envIDs)
+	if err != nil {
+		s.log.Error("states selection failed",
+			slog.Any("reason", err),
+			slog.Any("env", envIDs),
+		)
+		return err
+	}
+	err = s.selectDefs(ds, roles, states)
+	if err != nil {
+		s.log.Error("defs selection failed",
+			slog.Any("reason", err),
+			slog.Any("sig", decl.ID),
+		)
+		return err
+	}
+	defs := convertToDefs(roles, states)
+	env := Environment{sigs, roles, states, defs}
+	ctx := state.Context{
+		Shared: make(map[ph.ADT]state.Root),
+		Linear: make(map[ph.ADT]state.Root, len(decl.CEs)),
+	}
+	pe := state.EP{Z: sym.New(decl.PE.Key), C: env.LookupPE(decl.ID).C}
+	for i, ce := range env.LookupCEs(decl.ID) {
+		z := sym.New(decl.CEs[i].Key)
+		_, ok := ctx.Linear[z]
+		if ok || z == pe.Z {
+			err := chnl.ErrDuplicatedInCtx(z)
+			s.log.Error("body checking failed", slog.Any("reason", err))
+			return err
+		}
+		ctx.Linear[z] = ce.C
 	}
-}
-
-type repo interface {
-	Insert(Root) error
-	SelectAll() ([]Ref, error)
-	SelectByID(ID) (Root, error)
-	SelectChildren(ID) ([]Ref, error)
-	SelectSigs(ID) ([]sig.Ref, error)
-}
-
-// Kinship Relation
-type KinshipSpec struct {
-	ParentID ID
-	ChildIDs []ID
-}
-
-type KinshipRoot struct {
-	Parent   Ref
-	Children []Ref
-}
-
-type kinshipRepo interface {
-	Insert(KinshipRoot) error
-}
-
-// Participation aka lightweight Spawn
-type PartSpec struct {
-	Deal  id.ADT
-	Sig   sig.ID
-	Owner chnl.ID
-	// Transferable Endpoints
-	TEs []chnl.ID
-}
-
-// Transition
-type TranSpec struct {
-	Deal id.ADT
-	// Process ID
-	PID chnl.ID
-	// Agent Access Key
-	Key  ak.ADT
-	Term step.Term
+	return s.checkState(env, ctx, pe, decl.Body)
 }
 
 func (s *service) checkState(
@@ -1303,16 +3578,22 @@ func (s *service) checkProvider(
 	pe state.EP,
 	t step.Term,
 ) error {
+	curSt, err := state.Unfold(env.defs, pe.C)
+	if err != nil {
+		s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+		return err
+	}
+	pe.C = curSt
 	switch term := t.(type) {
 	case step.CloseSpec:
 		// check ctx
 		if len(ctx.Linear) > 0 {
-			err := fmt.Errorf("context mismatch: want 0 items, got %v items", len(ctx.Linear))
+			err := chnl.ErrLeakedInCtx(maps.Keys(ctx.Linear))
 			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
 			return err
 		}
 		// check via
-		return state.CheckRoot(pe.C, state.OneRoot{})
+		return state.CheckRoot(env.defs, pe.C, state.OneRoot{})
 	case step.WaitSpec:
 		err := step.ErrTermTypeMismatch(t, step.CloseSpec{})
 		s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
@@ -1332,7 +3613,7 @@ func (s *service) checkProvider(
 			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
 			return err
 		}
-		err := state.CheckRoot(gotB, wantSt.B)
+		err := state.Subtype(env.defs, gotB, wantSt.B)
 		if err != nil {
 			return err
 		}
@@ -1355,7 +3636,7 @@ func (s *service) checkProvider(
 			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
 			return err
 		}
-		err := state.CheckRoot(gotY, wantSt.Y)
+		err := state.Subtype(env.defs, gotY, wantSt.Y)
 		if err != nil {
 			return err
 		}
@@ -1394,38 +3675,151 @@ func (s *service) checkProvider(
 			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
 			return err
 		}
-		for wantL, wantChoice := range wantSt.Choices {
+		branchCtxs := make([]state.Context, 0, len(wantSt.Choices))
+		for _, wantL := range sortedLabels(wantSt.Choices) {
 			gotCont, ok := term.Conts[wantL]
 			if !ok {
 				err := fmt.Errorf("label mismatch: want %q, got nothing", wantL)
 				s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
 				return err
 			}
-			pe.C = wantChoice
-			err := s.checkState(env, ctx, pe, gotCont)
+			branchCtx := ctx.Clone()
+			pe.C = wantSt.Choices[wantL]
+			err := s.checkState(env, branchCtx, pe, gotCont)
 			if err != nil {
 				return err
 			}
+			branchCtxs = append(branchCtxs, branchCtx)
 		}
-		return nil
+		return s.joinBranches(env, ctx, branchCtxs, t)
 	case step.FwdSpec:
-		if len(ctx.Linear) != 1 {
-			err := fmt.Errorf("context mismatch: want 1 item, got %v items", len(ctx.Linear))
-			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
-			return err
-		}
 		gotD, ok := ctx.Linear[term.D]
 		if !ok {
 			err := chnl.ErrMissingInCtx(term.D)
 			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
 			return err
 		}
+		if len(ctx.Linear) > 1 {
+			leaked := slices.DeleteFunc(maps.Keys(ctx.Linear), func(z ph.ADT) bool { return z == term.D })
+			err := chnl.ErrLeakedInCtx(leaked)
+			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+			return err
+		}
+		gotD, err := state.Unfold(env.defs, gotD)
+		if err != nil {
+			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+			return err
+		}
 		if gotD.Pol() != pe.C.Pol() {
 			err := state.ErrPolarityMismatch(gotD, pe.C)
 			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
 			return err
 		}
-		return state.CheckRoot(gotD, pe.C)
+		return state.Subtype(env.defs, gotD, pe.C)
+	case step.LinkSpec:
+		if !env.Contains(term.Sig) {
+			err := sig.ErrRootMissingInEnv(term.Sig)
+			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+			return err
+		}
+		wantCEs := env.LookupCEs(term.Sig)
+		if len(term.CEs) != len(wantCEs) {
+			err := fmt.Errorf("context mismatch: want %v items, got %v items", len(wantCEs), len(term.CEs))
+			s.log.Error("type checking failed",
+				slog.Any("reason", err),
+				slog.Any("via", t.Via()),
+				slog.Any("want", wantCEs),
+				slog.Any("got", term.CEs),
+			)
+			return err
+		}
+		for i, gotCE := range term.CEs {
+			if slices.Index(term.CEs, gotCE) != i {
+				err := chnl.ErrDuplicatedInCtx(gotCE)
+				s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+				return err
+			}
+			gotSt, ok := ctx.Linear[gotCE]
+			if !ok {
+				err := chnl.ErrMissingInCtx(gotCE)
+				s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+				return err
+			}
+			err := state.Subtype(env.defs, gotSt, wantCEs[i].C)
+			if err != nil {
+				s.log.Error("type checking failed",
+					slog.Any("reason", err),
+					slog.Any("via", t.Via()),
+					slog.Any("want", wantCEs[i]),
+					slog.Any("got", gotCE),
+				)
+				return err
+			}
+			delete(ctx.Linear, gotCE)
+		}
+		// check ctx
+		if len(ctx.Linear) > 0 {
+			err := chnl.ErrLeakedInCtx(maps.Keys(ctx.Linear))
+			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+			return err
+		}
+		// check via
+		return state.Subtype(env.defs, env.LookupPE(term.Sig).C, pe.C)
+	case step.AccSpec:
+		// check via
+		wantSt, ok := pe.C.(state.UpRoot)
+		if !ok {
+			err := state.ErrRootTypeMismatch(pe.C, wantSt)
+			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+			return err
+		}
+		// check cont
+		_, ok = ctx.Linear[term.Y]
+		if ok {
+			err := chnl.ErrDuplicatedInCtx(term.Y)
+			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+			return err
+		}
+		ctx.Shared[pe.Z] = pe.C
+		pe = state.EP{Z: term.Y, C: wantSt.A}
+		return s.checkState(env, ctx, pe, term.Cont)
+	case step.AcqSpec:
+		err := step.ErrTermTypeMismatch(t, step.AccSpec{})
+		s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+		return err
+	case step.DetSpec:
+		// check ctx
+		if len(ctx.Linear) > 0 {
+			err := chnl.ErrLeakedInCtx(maps.Keys(ctx.Linear))
+			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+			return err
+		}
+		// check via
+		wantSt, ok := pe.C.(state.DownRoot)
+		if !ok {
+			err := state.ErrRootTypeMismatch(pe.C, wantSt)
+			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+			return err
+		}
+		// check shared
+		gotY, ok := ctx.Shared[term.Y]
+		if !ok {
+			err := chnl.ErrMissingInCtx(term.Y)
+			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+			return err
+		}
+		// equi-synchronizing: detach to the accepted state
+		err := state.CheckRoot(env.defs, wantSt.A, gotY)
+		if err != nil {
+			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+			return err
+		}
+		// no cont to check
+		return nil
+	case step.RelSpec:
+		err := step.ErrTermTypeMismatch(t, step.DetSpec{})
+		s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+		return err
 	default:
 		panic(step.ErrTermTypeUnexpected(t))
 	}
@@ -1437,6 +3831,24 @@ func (s *service) checkClient(
 	pe state.EP,
 	t step.Term,
 ) error {
+	viaSt, ok := ctx.Linear[t.Via()]
+	if ok {
+		curSt, err := state.Unfold(env.defs, viaSt)
+		if err != nil {
+			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+			return err
+		}
+		ctx.Linear[t.Via()] = curSt
+	}
+	viaSt, ok = ctx.Shared[t.Via()]
+	if ok {
+		curSt, err := state.Unfold(env.defs, viaSt)
+		if err != nil {
+			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+			return err
+		}
+		ctx.Shared[t.Via()] = curSt
+	}
 	switch got := t.(type) {
 	case step.CloseSpec:
 		err := step.ErrTermTypeMismatch(t, step.WaitSpec{})
@@ -1480,7 +3892,7 @@ func (s *service) checkClient(
 			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
 			return err
 		}
-		err := state.CheckRoot(gotB, wantSt.Y)
+		err := state.Subtype(env.defs, gotB, wantSt.Y)
 		if err != nil {
 			return err
 		}
@@ -1509,7 +3921,7 @@ func (s *service) checkClient(
 			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
 			return err
 		}
-		err := state.CheckRoot(gotY, wantSt.B)
+		err := state.Subtype(env.defs, gotY, wantSt.B)
 		if err != nil {
 			return err
 		}
@@ -1560,20 +3972,23 @@ func (s *service) checkClient(
 			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
 			return err
 		}
-		for wantL, wantChoice := range wantSt.Choices {
+		branchCtxs := make([]state.Context, 0, len(wantSt.Choices))
+		for _, wantL := range sortedLabels(wantSt.Choices) {
 			gotCont, ok := got.Conts[wantL]
 			if !ok {
 				err := fmt.Errorf("label mismatch: want %q, got nothing", wantL)
 				s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
 				return err
 			}
-			ctx.Linear[got.X] = wantChoice
-			err := s.checkState(env, ctx, pe, gotCont)
+			branchCtx := ctx.Clone()
+			branchCtx.Linear[got.X] = wantSt.Choices[wantL]
+			err := s.checkState(env, branchCtx, pe, gotCont)
 			if err != nil {
 				return err
 			}
+			branchCtxs = append(branchCtxs, branchCtx)
 		}
-		return nil
+		return s.joinBranches(env, ctx, branchCtxs, t)
 	case step.SpawnSpec:
 		if !env.Contains(got.Sig) {
 			err := sig.ErrRootMissingInEnv(got.Sig)
@@ -1591,12 +4006,27 @@ func (s *service) checkClient(
 			)
 			return err
 		}
-		if len(got.CEs) == 0 {
-			return nil
+		for i, gotCE := range got.CEs {
+			if slices.Index(got.CEs, gotCE) != i {
+				err := chnl.ErrDuplicatedInCtx(gotCE)
+				s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+				return err
+			}
+		}
+		_, ok := ctx.Linear[got.PE]
+		if ok {
+			err := chnl.ErrDuplicatedInCtx(got.PE)
+			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+			return err
 		}
 		for i, gotCE := range got.CEs {
-			gotSt := ctx.Linear[gotCE]
-			err := state.CheckRoot(gotSt, wantCEs[i].C)
+			gotSt, ok := ctx.Linear[gotCE]
+			if !ok {
+				err := chnl.ErrMissingInCtx(gotCE)
+				s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+				return err
+			}
+			err := state.Subtype(env.defs, gotSt, wantCEs[i].C)
 			if err != nil {
 				s.log.Error("type checking failed",
 					slog.Any("reason", err),
@@ -1610,11 +4040,231 @@ func (s *service) checkClient(
 		}
 		ctx.Linear[got.PE] = env.LookupPE(got.Sig).C
 		return s.checkState(env, ctx, pe, got.Cont)
+	case step.AcqSpec:
+		// check via
+		gotX, ok := ctx.Shared[got.X]
+		if !ok {
+			err := chnl.ErrMissingInCtx(got.X)
+			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+			return err
+		}
+		wantSt, ok := gotX.(state.UpRoot)
+		if !ok {
+			err := state.ErrRootTypeMismatch(gotX, wantSt)
+			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+			return err
+		}
+		// check cont
+		_, ok = ctx.Linear[got.Y]
+		if ok {
+			err := chnl.ErrDuplicatedInCtx(got.Y)
+			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+			return err
+		}
+		ctx.Linear[got.Y] = wantSt.A
+		return s.checkState(env, ctx, pe, got.Cont)
+	case step.AccSpec:
+		err := step.ErrTermTypeMismatch(t, step.AcqSpec{})
+		s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+		return err
+	case step.RelSpec:
+		// check via
+		gotX, ok := ctx.Linear[got.X]
+		if !ok {
+			err := chnl.ErrMissingInCtx(got.X)
+			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+			return err
+		}
+		wantSt, ok := gotX.(state.DownRoot)
+		if !ok {
+			err := state.ErrRootTypeMismatch(gotX, wantSt)
+			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+			return err
+		}
+		// check shared
+		gotY, ok := ctx.Shared[got.Y]
+		if !ok {
+			err := chnl.ErrMissingInCtx(got.Y)
+			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+			return err
+		}
+		// equi-synchronizing: release to the acquired state
+		err := state.CheckRoot(env.defs, wantSt.A, gotY)
+		if err != nil {
+			s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+			return err
+		}
+		// check cont
+		delete(ctx.Linear, got.X)
+		return s.checkState(env, ctx, pe, got.Cont)
+	case step.DetSpec:
+		err := step.ErrTermTypeMismatch(t, step.RelSpec{})
+		s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+		return err
 	default:
 		panic(step.ErrTermTypeUnexpected(t))
 	}
 }
 
+// every branch must consume the same linear channels,
+// so that the rest of the process sees a single ctx
+// branches must leave the same channels in the same states
+func (s *service) joinBranches(env Environment, ctx state.Context, branchCtxs []state.Context, t step.Term) error {
+	if len(branchCtxs) == 0 {
+		return nil
+	}
+	want := branchCtxs[0]
+	for _, got := range branchCtxs[1:] {
+		for z, wantSt := range want.Linear {
+			gotSt, ok := got.Linear[z]
+			if !ok {
+				err := chnl.ErrBranchMismatch(z)
+				s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+				return err
+			}
+			err := state.CheckRoot(env.defs, gotSt, wantSt)
+			if err != nil {
+				err = fmt.Errorf("%w: %w", chnl.ErrBranchMismatch(z), err)
+				s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+				return err
+			}
+		}
+		for z := range got.Linear {
+			_, ok := want.Linear[z]
+			if !ok {
+				err := chnl.ErrBranchMismatch(z)
+				s.log.Error("type checking failed", slog.Any("reason", err), slog.Any("via", t.Via()))
+				return err
+			}
+		}
+	}
+	clear(ctx.Linear)
+	maps.Copy(ctx.Linear, want.Linear)
+	clear(ctx.Shared)
+	maps.Copy(ctx.Shared, want.Shared)
+	return nil
+}
+
+func sortedLabels(choices map[core.Label]state.Root) []core.Label {
+	labels := maps.Keys(choices)
+	slices.Sort(labels)
+	return labels
+}
+
+// pending steps of body procs expire by sig timeout, if any
+func deadlineOf(decl sig.Root) time.Time {
+	if decl.Timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(decl.Timeout)
+}
+
+// substitutes sig endpoint keys with actual channels
+func instantiateBody(decl sig.Root, pid chnl.ID, ces []chnl.ID) step.Term {
+	body := step.Subst(decl.Body, sym.New(decl.PE.Key), pid)
+	for i, ce := range decl.CEs {
+		body = step.Subst(body, sym.New(ce.Key), ces[i])
+	}
+	return body
+}
+
+func viaOf(sem step.Root) chnl.ID {
+	_, _, vid := pendingOf(sem)
+	return vid
+}
+
+func pendingOf(sem step.Root) (step.ID, chnl.ID, chnl.ID) {
+	switch sem := sem.(type) {
+	case step.MsgRoot:
+		return sem.ID, sem.PID, sem.VID
+	case step.SrvRoot:
+		return sem.ID, sem.PID, sem.VID
+	default:
+		panic(step.ErrRootTypeUnexpected(sem))
+	}
+}
+
+// pending step awaits the process at the other end of via
+func collectWaits(sems []step.Root, ends map[chnl.ID]chnl.Ends) []Wait {
+	waits := make([]Wait, 0, len(sems))
+	for _, sem := range sems {
+		var w Wait
+		switch sem := sem.(type) {
+		case step.MsgRoot:
+			w = Wait{PID: sem.PID, SID: sem.ID, VID: sem.VID}
+		case step.SrvRoot:
+			w = Wait{PID: sem.PID, SID: sem.ID, VID: sem.VID}
+		default:
+			panic(step.ErrRootTypeUnexpected(sem))
+		}
+		e := ends[w.VID]
+		if w.PID == e.ProviderID {
+			w.ForPID = e.ClientID
+		} else {
+			w.ForPID = e.ProviderID
+		}
+		if w.ForPID.IsEmpty() {
+			// awaits external agent
+			continue
+		}
+		waits = append(waits, w)
+	}
+	return waits
+}
+
+// finds cycles in wait-for graph, where process waits for one process at most
+func detectDeadlocks(waits []Wait) []Deadlock {
+	waitsFor := make(map[chnl.ID]Wait, len(waits))
+	for _, w := range waits {
+		_, ok := waitsFor[w.PID]
+		if ok {
+			continue
+		}
+		waitsFor[w.PID] = w
+	}
+	const (
+		unseen = iota
+		onPath
+		done
+	)
+	marks := make(map[chnl.ID]int, len(waitsFor))
+	deadlocks := []Deadlock{}
+	for _, start := range waits {
+		var path []Wait
+		pid := start.PID
+		for {
+			w, ok := waitsFor[pid]
+			if !ok || marks[pid] == done {
+				break
+			}
+			if marks[pid] == onPath {
+				i := slices.IndexFunc(path, func(p Wait) bool { return p.PID == pid })
+				deadlocks = append(deadlocks, Deadlock{Waits: slices.Clone(path[i:])})
+				break
+			}
+			marks[pid] = onPath
+			path = append(path, w)
+			pid = w.ForPID
+		}
+		for _, w := range path {
+			marks[w.PID] = done
+		}
+	}
+	return deadlocks
+}
+
+func convertToIDs(phs []ph.ADT) ([]chnl.ID, error) {
+	ids := make([]chnl.ID, 0, len(phs))
+	for _, z := range phs {
+		ce, ok := z.(chnl.ID)
+		if !ok {
+			return nil, chnl.ErrNotAnID(z)
+		}
+		ids = append(ids, ce)
+	}
+	return ids, nil
+}
+
 func convertToCfg(chnls []chnl.Root) map[chnl.ID]chnl.Root {
 	cfg := make(map[chnl.ID]chnl.Root, len(chnls))
 	for _, ch := range chnls {
@@ -1623,10 +4273,33 @@ func convertToCfg(chnls []chnl.Root) map[chnl.ID]chnl.Root {
 	return cfg
 }
 
-func convertToCtx(chnls []chnl.Root, states map[state.ID]state.Root) state.Context {
+func convertToDefs(roles map[role.FQN]role.Root, states map[state.ID]state.Root) state.Env {
+	defs := make(state.Env, len(roles))
+	for fqn, r := range roles {
+		defs[fqn] = states[r.StateID]
+	}
+	return defs
+}
+
+func convertToCtx(chnls []chnl.Root, states map[state.ID]state.Root, defs state.Env) state.Context {
+	shared := make(map[ph.ADT]state.Root)
 	linear := make(map[ph.ADT]state.Root, len(chnls))
 	for _, ch := range chnls {
-		linear[ch.ID] = states[*ch.StateID]
+		// closed or consumed ones aren't in context
+		if ch.StateID == nil {
+			continue
+		}
+		st := states[*ch.StateID]
+		// shared endpoints are the ones at the up shift
+		curSt, err := state.Unfold(defs, st)
+		if err == nil {
+			_, ok := curSt.(state.UpRoot)
+			if ok {
+				shared[ch.ID] = st
+				continue
+			}
+		}
+		linear[ch.ID] = st
 	}
-	return state.Context{Linear: linear}
+	return state.Context{Shared: shared, Linear: linear}
 }
@@ -0,0 +1,1251 @@
+package deal
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"smecalculus/rolevod/lib/ak"
+	"smecalculus/rolevod/lib/core"
+	"smecalculus/rolevod/lib/data"
+	"smecalculus/rolevod/lib/id"
+	"smecalculus/rolevod/lib/ph"
+	"smecalculus/rolevod/lib/sym"
+
+	"smecalculus/rolevod/internal/chnl"
+	"smecalculus/rolevod/internal/state"
+	"smecalculus/rolevod/internal/step"
+
+	"smecalculus/rolevod/app/role"
+	"smecalculus/rolevod/app/sig"
+)
+
+func TestDetectDeadlocks(t *testing.T) {
+
+	t.Run("Cycle", func(t *testing.T) {
+		// given
+		p1, p2, p3 := id.New(), id.New(), id.New()
+		// and
+		w1 := Wait{PID: p1, SID: id.New(), VID: id.New(), ForPID: p2}
+		w2 := Wait{PID: p2, SID: id.New(), VID: id.New(), ForPID: p3}
+		w3 := Wait{PID: p3, SID: id.New(), VID: id.New(), ForPID: p1}
+		// when
+		deadlocks := detectDeadlocks([]Wait{w1, w2, w3})
+		// then
+		want := []Deadlock{{Waits: []Wait{w1, w2, w3}}}
+		if !reflect.DeepEqual(deadlocks, want) {
+			t.Errorf("unexpected deadlocks: want %v, got %v", want, deadlocks)
+		}
+	})
+
+	t.Run("Chain", func(t *testing.T) {
+		// given
+		p1, p2, p3 := id.New(), id.New(), id.New()
+		// and
+		w1 := Wait{PID: p1, SID: id.New(), VID: id.New(), ForPID: p2}
+		w2 := Wait{PID: p2, SID: id.New(), VID: id.New(), ForPID: p3}
+		// when
+		deadlocks := detectDeadlocks([]Wait{w1, w2})
+		// then
+		if len(deadlocks) != 0 {
+			t.Errorf("unexpected deadlocks: %v", deadlocks)
+		}
+	})
+
+	t.Run("ChainIntoCycle", func(t *testing.T) {
+		// given
+		p1, p2, p3 := id.New(), id.New(), id.New()
+		// and
+		w1 := Wait{PID: p1, SID: id.New(), VID: id.New(), ForPID: p2}
+		w2 := Wait{PID: p2, SID: id.New(), VID: id.New(), ForPID: p3}
+		w3 := Wait{PID: p3, SID: id.New(), VID: id.New(), ForPID: p2}
+		// when
+		deadlocks := detectDeadlocks([]Wait{w1, w2, w3})
+		// then
+		want := []Deadlock{{Waits: []Wait{w2, w3}}}
+		if !reflect.DeepEqual(deadlocks, want) {
+			t.Errorf("unexpected deadlocks: want %v, got %v", want, deadlocks)
+		}
+	})
+}
+
+func TestRetrieveDeadlocks(t *testing.T) {
+	// given
+	did, member := id.New(), id.New()
+	steps := &stepRepoFake{}
+	s := &service{
+		deals:    &dealRepoFake{members: map[ID][]chnl.ID{did: {member}}},
+		steps:    steps,
+		chnls:    &chnlRepoFake{},
+		operator: operatorFake{},
+		log:      slog.Default(),
+	}
+	// when
+	_, err := s.RetrieveDeadlocks(did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// then
+	want := []chnl.ID{member}
+	if !reflect.DeepEqual(steps.pendingPIDs, want) {
+		t.Errorf("unexpected pids: want %v, got %v", want, steps.pendingPIDs)
+	}
+}
+
+func TestCollectWaits(t *testing.T) {
+	// given
+	provider, client := id.New(), id.New()
+	// and
+	via := id.New()
+	ends := map[chnl.ID]chnl.Ends{via: {ProviderID: provider, ClientID: client}}
+	// and
+	srv := step.SrvRoot{ID: id.New(), PID: client, VID: via}
+	msg := step.MsgRoot{ID: id.New(), PID: provider, VID: via}
+	// when
+	waits := collectWaits([]step.Root{srv, msg}, ends)
+	// then
+	want := []Wait{
+		{PID: client, SID: srv.ID, VID: via, ForPID: provider},
+		{PID: provider, SID: msg.ID, VID: via, ForPID: client},
+	}
+	if !reflect.DeepEqual(waits, want) {
+		t.Errorf("unexpected waits: want %v, got %v", want, waits)
+	}
+}
+
+func TestCheckKey(t *testing.T) {
+
+	t.Run("Match", func(t *testing.T) {
+		// given
+		key := ak.New()
+		// when
+		err := checkKey(key, key)
+		// then
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		// when
+		err := checkKey(ak.New(), ak.New())
+		// then
+		if !errors.Is(err, ak.ErrUnexpected) {
+			t.Errorf("unexpected error: want %v, got %v", ak.ErrUnexpected, err)
+		}
+	})
+
+	t.Run("NotIssued", func(t *testing.T) {
+		// when
+		err := checkKey(ak.ADT{}, ak.ADT{})
+		// then
+		if !errors.Is(err, ak.ErrUnexpected) {
+			t.Errorf("unexpected error: want %v, got %v", ak.ErrUnexpected, err)
+		}
+	})
+}
+
+func TestReconstruct(t *testing.T) {
+	// given
+	st0, st1 := id.New(), id.New()
+	v0 := chnl.Root{ID: id.New(), StateID: &st0}
+	e1 := step.Entry{ID: id.New(), PID: id.New(), PreVID: v0.ID, PreStateID: &st0}
+	v1 := chnl.Root{ID: id.New(), PreID: &v0.ID, StateID: &st1}
+	e2 := step.Entry{ID: id.New(), PID: id.New(), PreVID: v0.ID, VID: v1.ID, PreStateID: &st0, StateID: &st1}
+	// and
+	lineage := []chnl.Root{v0, v1}
+	entries := []step.Entry{e1, e2}
+
+	t.Run("HalfDone", func(t *testing.T) {
+		// when
+		snap, err := reconstruct(lineage, entries, e1.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// then
+		want := Snapshot{Pos: e1.ID, Chnls: []chnl.Root{v0}, Pending: []step.Entry{e1}}
+		if !reflect.DeepEqual(snap, want) {
+			t.Errorf("unexpected snapshot: want %+v, got %+v", want, snap)
+		}
+	})
+
+	t.Run("Rendezvous", func(t *testing.T) {
+		// when
+		snap, err := reconstruct(lineage, entries, e2.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// then
+		want := Snapshot{Pos: e2.ID, Chnls: []chnl.Root{v1}}
+		if !reflect.DeepEqual(snap, want) {
+			t.Errorf("unexpected snapshot: want %+v, got %+v", want, snap)
+		}
+	})
+
+	t.Run("Diverged", func(t *testing.T) {
+		// given
+		e3 := step.Entry{ID: id.New(), PID: id.New(), PreVID: v1.ID, VID: id.New()}
+		// when
+		_, err := reconstruct(lineage, append(entries, e3), e3.ID)
+		// then
+		if err == nil {
+			t.Error("divergence not detected")
+		}
+	})
+}
+
+func TestDeriveDuties(t *testing.T) {
+
+	t.Run("One", func(t *testing.T) {
+		// when
+		provider, client, labels, err := deriveDuties(state.OneRoot{ID: id.New()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// then
+		if provider != step.Close || client != step.Wait || labels != nil {
+			t.Errorf("unexpected duties: %v, %v, %v", provider, client, labels)
+		}
+	})
+
+	t.Run("With", func(t *testing.T) {
+		// given
+		st := state.WithRoot{
+			ID: id.New(),
+			Choices: map[core.Label]state.Root{
+				"b": state.OneRoot{ID: id.New()},
+				"a": state.OneRoot{ID: id.New()},
+			},
+		}
+		// when
+		provider, client, labels, err := deriveDuties(st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// then
+		if provider != step.Case || client != step.Lab {
+			t.Errorf("unexpected duties: %v, %v", provider, client)
+		}
+		// and
+		want := []core.Label{"a", "b"}
+		if !reflect.DeepEqual(labels, want) {
+			t.Errorf("unexpected labels: want %v, got %v", want, labels)
+		}
+	})
+
+	t.Run("Tensor", func(t *testing.T) {
+		// given
+		st := state.TensorRoot{ID: id.New(), B: state.OneRoot{ID: id.New()}, C: state.OneRoot{ID: id.New()}}
+		// when
+		provider, client, _, err := deriveDuties(st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// then
+		if provider != step.Send || client != step.Recv {
+			t.Errorf("unexpected duties: %v, %v", provider, client)
+		}
+	})
+}
+
+func TestTrack(t *testing.T) {
+	// given
+	vid := id.New()
+	awaited := chnl.Event{Kind: chnl.Awaited, VID: vid, SID: id.New()}
+	closed := chnl.Event{Kind: chnl.Closed, VID: vid, NextID: id.New()}
+	// and
+	payloads := make(chan []byte, 2)
+	for _, ev := range []chnl.Event{awaited, closed} {
+		payload, err := chnl.DataFromEvent(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payloads <- payload
+	}
+	// and
+	s := &service{log: slog.Default()}
+	evs := make(chan chnl.Event)
+	// when
+	go s.track(context.Background(), vid, payloads, func() {}, []chnl.Event{awaited}, evs)
+	// then
+	var got []chnl.Event
+	for ev := range evs {
+		got = append(got, ev)
+	}
+	want := []chnl.Event{awaited, closed}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected events: want %v, got %v", want, got)
+	}
+}
+
+func TestTrackForeignDeal(t *testing.T) {
+	// given
+	did, pid, vid := id.New(), id.New(), id.New()
+	// and
+	subs := &subscriberFake{}
+	s := &service{
+		deals:    &dealRepoFake{members: map[ID][]chnl.ID{did: {pid}}},
+		chnls:    &chnlRepoFake{ends: map[chnl.ID]chnl.Ends{vid: {ProviderID: pid}}},
+		operator: operatorFake{},
+		subs:     subs,
+		log:      slog.Default(),
+	}
+	// when
+	_, err := s.Track(context.Background(), EventSpec{Deal: id.New(), VID: vid})
+	// then
+	if !errors.Is(err, ErrNotMember) {
+		t.Errorf("unexpected error: want %v, got %v", ErrNotMember, err)
+	}
+	// and
+	if len(subs.topics) != 0 {
+		t.Errorf("unexpected subscriptions: %v", subs.topics)
+	}
+}
+
+func TestTrackEvicted(t *testing.T) {
+	// given
+	vid := id.New()
+	nextVia := chnl.Root{ID: id.New(), PreID: &vid}
+	// and
+	evicted := make(chan []byte)
+	close(evicted)
+	subs := &subscriberFake{}
+	s := &service{
+		chnls:    &chnlRepoFake{next: map[chnl.ID]chnl.Root{vid: nextVia}},
+		steps:    &stepRepoFake{},
+		operator: operatorFake{},
+		subs:     subs,
+		log:      slog.Default(),
+	}
+	evs := make(chan chnl.Event)
+	// when
+	go s.track(context.Background(), vid, evicted, func() {}, nil, evs)
+	// then
+	var got []chnl.Event
+	for ev := range evs {
+		got = append(got, ev)
+	}
+	want := []chnl.Event{{Kind: chnl.Closed, VID: vid, NextID: nextVia.ID}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected events: want %v, got %v", want, got)
+	}
+	// and
+	wantTopics := []string{chnl.Topic(vid)}
+	if !reflect.DeepEqual(subs.topics, wantTopics) {
+		t.Errorf("unexpected subscriptions: want %v, got %v", wantTopics, subs.topics)
+	}
+}
+
+func TestLookupSt(t *testing.T) {
+	// given
+	stID := id.New()
+	ch := chnl.Root{ID: id.New(), StateID: &stID}
+	cfg := Configuration{
+		chnls:  map[chnl.ID]chnl.Root{ch.ID: ch},
+		states: map[state.ID]state.Root{stID: state.LinkRoot{ID: stID, Role: "missing-role"}},
+		defs:   state.Env{},
+	}
+	// when
+	_, err := cfg.LookupSt(ch.ID)
+	// then
+	if err == nil || err.Error() != state.ErrMissingInDefs("missing-role").Error() {
+		t.Errorf("unexpected error: want %v, got %v", state.ErrMissingInDefs("missing-role"), err)
+	}
+}
+
+func TestCheckState(t *testing.T) {
+	// given
+	s := &service{log: slog.Default()}
+	env := Environment{defs: state.Env{}}
+	p, x, y := sym.New("p"), sym.New("x"), sym.New("y")
+	one := state.OneRoot{ID: id.New()}
+
+	t.Run("LeakedInCtx", func(t *testing.T) {
+		// given
+		ctx := state.Context{
+			Shared: map[ph.ADT]state.Root{},
+			Linear: map[ph.ADT]state.Root{x: one},
+		}
+		pe := state.EP{Z: p, C: one}
+		// when
+		err := s.checkState(env, ctx, pe, step.CloseSpec{A: p})
+		// then
+		want := chnl.ErrLeakedInCtx([]ph.ADT{x})
+		if err == nil || err.Error() != want.Error() {
+			t.Errorf("unexpected error: want %v, got %v", want, err)
+		}
+	})
+
+	t.Run("DuplicatedInCtx", func(t *testing.T) {
+		// given
+		ctx := state.Context{
+			Shared: map[ph.ADT]state.Root{},
+			Linear: map[ph.ADT]state.Root{x: one},
+		}
+		pe := state.EP{Z: p, C: state.UpRoot{ID: id.New(), A: one}}
+		// when
+		err := s.checkState(env, ctx, pe, step.AccSpec{X: p, Y: x, Cont: step.CloseSpec{A: x}})
+		// then
+		want := chnl.ErrDuplicatedInCtx(x)
+		if err == nil || err.Error() != want.Error() {
+			t.Errorf("unexpected error: want %v, got %v", want, err)
+		}
+	})
+
+	t.Run("BranchMismatch", func(t *testing.T) {
+		// given
+		ctx := state.Context{
+			Shared: map[ph.ADT]state.Root{},
+			Linear: map[ph.ADT]state.Root{x: one, y: one},
+		}
+		pe := state.EP{
+			Z: p,
+			C: state.WithRoot{
+				ID: id.New(),
+				Choices: map[core.Label]state.Root{
+					"a": state.TensorRoot{ID: id.New(), B: one, C: one},
+					"b": state.TensorRoot{ID: id.New(), B: one, C: one},
+				},
+			},
+		}
+		// when
+		err := s.checkState(env, ctx, pe, step.CaseSpec{
+			X: p,
+			Conts: map[core.Label]step.Term{
+				"a": step.SendSpec{A: p, B: x},
+				"b": step.SendSpec{A: p, B: y},
+			},
+		})
+		// then
+		if err == nil {
+			t.Error("unexpected success: want branch mismatch")
+		}
+	})
+
+	t.Run("BranchStateMismatch", func(t *testing.T) {
+		// given
+		lolli := state.LolliRoot{ID: id.New(), Y: one, Z: one}
+		ctx := state.Context{
+			Shared: map[ph.ADT]state.Root{},
+			Linear: map[ph.ADT]state.Root{x: lolli, y: one},
+		}
+		pe := state.EP{
+			Z: p,
+			C: state.WithRoot{
+				ID: id.New(),
+				Choices: map[core.Label]state.Root{
+					"a": one,
+					"b": state.TensorRoot{ID: id.New(), B: one, C: one},
+				},
+			},
+		}
+		// when
+		err := s.checkState(env, ctx, pe, step.CaseSpec{
+			X: p,
+			Conts: map[core.Label]step.Term{
+				"a": step.SendSpec{A: x, B: y},
+				"b": step.SendSpec{A: p, B: y},
+			},
+		})
+		// then
+		want := chnl.ErrBranchMismatch(x)
+		if err == nil || !strings.HasPrefix(err.Error(), want.Error()) {
+			t.Errorf("unexpected error: want %v, got %v", want, err)
+		}
+	})
+}
+
+func TestRetryDelay(t *testing.T) {
+	// given
+	base, limit := time.Second, 10*time.Second
+	// and
+	want := map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 5: limit, 50: limit}
+	for attempts, wantDelay := range want {
+		// when
+		got := retry{attempts: attempts}.delay(base, limit)
+		// then
+		if got != wantDelay {
+			t.Errorf("unexpected delay after %v attempts: want %v, got %v", attempts, wantDelay, got)
+		}
+	}
+}
+
+func TestDeadlineOf(t *testing.T) {
+
+	t.Run("Timeout", func(t *testing.T) {
+		// given
+		decl := sig.Root{Timeout: time.Minute}
+		before := time.Now()
+		// when
+		deadline := deadlineOf(decl)
+		// then
+		if deadline.Before(before.Add(time.Minute)) || deadline.After(time.Now().Add(time.Minute)) {
+			t.Errorf("unexpected deadline: want %v from now, got %v", decl.Timeout, deadline)
+		}
+	})
+
+	t.Run("NoTimeout", func(t *testing.T) {
+		// when
+		deadline := deadlineOf(sig.Root{})
+		// then
+		if !deadline.IsZero() {
+			t.Errorf("unexpected deadline: want zero, got %v", deadline)
+		}
+	})
+}
+
+func TestCancel(t *testing.T) {
+	// given
+	p1, p2, p3 := id.New(), id.New(), id.New()
+	st1 := state.OneRoot{ID: id.New()}
+	st0 := state.WithRoot{ID: id.New(), Choices: map[core.Label]state.Root{cancelLabel: st1}}
+	// and
+	c1 := chnl.Root{ID: id.New(), StateID: &st0.ID}
+	c2 := chnl.Root{ID: id.New(), StateID: &st0.ID}
+	c3 := chnl.Root{ID: id.New(), StateID: &st1.ID}
+	// and
+	x := id.New()
+	sem1 := step.SrvRoot{
+		ID:   id.New(),
+		PID:  p2,
+		VID:  c1.ID,
+		Cont: step.CaseSpec{X: x, Conts: map[core.Label]step.Term{cancelLabel: step.CloseSpec{A: x}}},
+	}
+	sem2 := step.SrvRoot{
+		ID:   id.New(),
+		PID:  p3,
+		VID:  c2.ID,
+		Cont: step.WaitSpec{X: x, Cont: step.CloseSpec{A: x}},
+	}
+	// and
+	steps := &stepRepoFake{sems: map[chnl.ID]step.Root{c1.ID: sem1, c2.ID: sem2}}
+	chnls := &chnlRepoFake{owned: map[chnl.ID][]chnl.Root{p1: {c1, c2}, p3: {c3}}}
+	states := &stateRepoFake{states: map[state.ID]state.Root{st0.ID: st0, st1.ID: st1}}
+	s := &service{steps: steps, chnls: chnls, states: states, log: slog.Default()}
+	// when
+	err := s.cancel(nil, p1, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// then
+	wantCancelled := map[chnl.ID]bool{p1: true, p3: true}
+	if !reflect.DeepEqual(steps.cancelled, wantCancelled) {
+		t.Errorf("unexpected cancellations: want %v, got %v", wantCancelled, steps.cancelled)
+	}
+	// and
+	if len(steps.sems) != 0 {
+		t.Errorf("unexpected pending steps: %v", steps.sems)
+	}
+	// and
+	if len(chnls.inserted) != 3 {
+		t.Fatalf("unexpected channels: want 3, got %v", chnls.inserted)
+	}
+	resumed, closed2, closed3 := chnls.inserted[0], chnls.inserted[1], chnls.inserted[2]
+	if *resumed.PreID != c1.ID || resumed.StateID == nil || *resumed.StateID != st1.ID {
+		t.Errorf("unexpected resumed channel: %+v", resumed)
+	}
+	if *closed2.PreID != c2.ID || closed2.StateID != nil {
+		t.Errorf("unexpected closed channel: %+v", closed2)
+	}
+	if *closed3.PreID != c3.ID || closed3.StateID != nil {
+		t.Errorf("unexpected closed channel: %+v", closed3)
+	}
+	// and
+	if len(steps.procs) != 1 {
+		t.Fatalf("unexpected procs: want 1, got %v", steps.procs)
+	}
+	wantTerm := step.CloseSpec{A: resumed.ID}
+	if steps.procs[0].PID != p2 || !reflect.DeepEqual(steps.procs[0].Term, wantTerm) {
+		t.Errorf("unexpected proc: want %v of %v, got %+v", wantTerm, p2, steps.procs[0])
+	}
+}
+
+func TestCancelRecursivePeer(t *testing.T) {
+	// given
+	p1, p2 := id.New(), id.New()
+	counter := sym.New("counter")
+	link := state.ConvertSpecToRoot(state.LinkSpec{Role: counter})
+	one := state.ConvertSpecToRoot(state.OneSpec{})
+	def := state.ConvertSpecToRoot(state.WithSpec{
+		Choices: map[core.Label]state.Spec{
+			cancelLabel: state.OneSpec{},
+			"next":      state.LinkSpec{Role: counter},
+		},
+	})
+	// and
+	linkID := link.Ident()
+	c1 := chnl.Root{ID: id.New(), StateID: &linkID}
+	x := id.New()
+	sem := step.SrvRoot{
+		ID:   id.New(),
+		PID:  p2,
+		VID:  c1.ID,
+		Cont: step.CaseSpec{X: x, Conts: map[core.Label]step.Term{cancelLabel: step.CloseSpec{A: x}}},
+	}
+	// and
+	steps := &stepRepoFake{sems: map[chnl.ID]step.Root{c1.ID: sem}}
+	chnls := &chnlRepoFake{owned: map[chnl.ID][]chnl.Root{p1: {c1}}}
+	s := &service{
+		steps: steps,
+		chnls: chnls,
+		roles: &roleRepoFake{roles: map[role.FQN]role.Root{counter: {StateID: def.Ident()}}},
+		states: &stateRepoFake{states: map[state.ID]state.Root{
+			linkID:      link,
+			def.Ident(): def,
+			one.Ident(): one,
+		}},
+		log: slog.Default(),
+	}
+	// when
+	err := s.cancel(nil, p1, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// then
+	wantCancelled := map[chnl.ID]bool{p1: true}
+	if !reflect.DeepEqual(steps.cancelled, wantCancelled) {
+		t.Errorf("unexpected cancellations: want %v, got %v", wantCancelled, steps.cancelled)
+	}
+	// and
+	if len(steps.procs) != 1 || steps.procs[0].PID != p2 {
+		t.Errorf("peer isn't resumed: %v", steps.procs)
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	// given
+	label := cancelLabel
+	counter := sym.New("counter")
+	link := state.ConvertSpecToRoot(state.LinkSpec{Role: counter})
+	one := state.ConvertSpecToRoot(state.OneSpec{})
+	// and
+	x := id.New()
+	cont := step.CaseSpec{X: x, Conts: map[core.Label]step.Term{label: step.CloseSpec{A: x}}}
+	// and
+	newService := func(def state.Root) (*service, *chnlRepoFake, *stepRepoFake) {
+		chnls := &chnlRepoFake{}
+		steps := &stepRepoFake{}
+		s := &service{
+			chnls: chnls,
+			steps: steps,
+			roles: &roleRepoFake{roles: map[role.FQN]role.Root{counter: {StateID: def.Ident()}}},
+			states: &stateRepoFake{states: map[state.ID]state.Root{
+				link.Ident(): link,
+				def.Ident():  def,
+				one.Ident():  one,
+			}},
+			log: slog.Default(),
+		}
+		return s, chnls, steps
+	}
+
+	t.Run("RecursiveRole", func(t *testing.T) {
+		// given
+		def := state.ConvertSpecToRoot(state.WithSpec{
+			Choices: map[core.Label]state.Spec{
+				label:  state.OneSpec{},
+				"next": state.LinkSpec{Role: counter},
+			},
+		})
+		s, chnls, steps := newService(def)
+		// and
+		stID := link.Ident()
+		via := chnl.Root{ID: id.New(), StateID: &stID}
+		srv := step.SrvRoot{ID: id.New(), PID: id.New(), VID: via.ID, Cont: cont}
+		// when
+		resumed, err := s.interrupt(nil, srv, via, label, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// then
+		if !resumed || len(steps.procs) != 1 {
+			t.Errorf("proc isn't resumed: %v", steps.procs)
+		}
+		if len(chnls.inserted) != 1 || *chnls.inserted[0].StateID != one.Ident() {
+			t.Errorf("unexpected channels: %v", chnls.inserted)
+		}
+	})
+
+	t.Run("MissingLabel", func(t *testing.T) {
+		// given
+		def := state.ConvertSpecToRoot(state.WithSpec{
+			Choices: map[core.Label]state.Spec{"next": state.LinkSpec{Role: counter}},
+		})
+		s, chnls, steps := newService(def)
+		// and
+		stID := link.Ident()
+		via := chnl.Root{ID: id.New(), StateID: &stID}
+		srv := step.SrvRoot{ID: id.New(), PID: id.New(), VID: via.ID, Cont: cont}
+		// when
+		resumed, err := s.interrupt(nil, srv, via, label, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// then
+		if resumed || len(steps.procs) != 0 {
+			t.Errorf("proc is resumed: %v", steps.procs)
+		}
+		if len(chnls.inserted) != 1 || chnls.inserted[0].StateID != nil {
+			t.Errorf("via isn't closed: %v", chnls.inserted)
+		}
+	})
+
+	t.Run("NotChoice", func(t *testing.T) {
+		// given
+		s, chnls, _ := newService(one)
+		// and
+		stID := one.Ident()
+		via := chnl.Root{ID: id.New(), StateID: &stID}
+		srv := step.SrvRoot{ID: id.New(), PID: id.New(), VID: via.ID, Cont: cont}
+		// when
+		resumed, err := s.interrupt(nil, srv, via, label, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// then
+		if resumed {
+			t.Error("proc is resumed")
+		}
+		if len(chnls.inserted) != 1 || chnls.inserted[0].StateID != nil {
+			t.Errorf("via isn't closed: %v", chnls.inserted)
+		}
+	})
+}
+
+func TestCheckCancel(t *testing.T) {
+	// given
+	did, pid, cid := id.New(), id.New(), id.New()
+	pidKey, cidKey := ak.New(), ak.New()
+	// and
+	s := &service{
+		deals: &dealRepoFake{members: map[ID][]chnl.ID{did: {pid}}},
+		chnls: &chnlRepoFake{ends: map[chnl.ID]chnl.Ends{pid: {ProviderID: pid, ClientID: cid}}},
+		keys:  &keyRepoFake{keys: map[chnl.ID]ak.ADT{pid: pidKey, cid: cidKey}},
+		log:   slog.Default(),
+	}
+
+	t.Run("OwnKey", func(t *testing.T) {
+		// when
+		err := s.checkCancel(nil, CancelSpec{Deal: did, PID: pid, Key: pidKey})
+		// then
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ClientKey", func(t *testing.T) {
+		// when
+		err := s.checkCancel(nil, CancelSpec{Deal: did, PID: pid, Key: cidKey})
+		// then
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ForeignKey", func(t *testing.T) {
+		// when
+		err := s.checkCancel(nil, CancelSpec{Deal: did, PID: pid, Key: ak.New()})
+		// then
+		if !errors.Is(err, ak.ErrUnexpected) {
+			t.Errorf("unexpected error: want %v, got %v", ak.ErrUnexpected, err)
+		}
+	})
+
+	t.Run("ForeignDeal", func(t *testing.T) {
+		// when
+		err := s.checkCancel(nil, CancelSpec{Deal: id.New(), PID: pid, Key: pidKey})
+		// then
+		if !errors.Is(err, ErrNotMember) {
+			t.Errorf("unexpected error: want %v, got %v", ErrNotMember, err)
+		}
+	})
+}
+
+func TestRetrieveObligations(t *testing.T) {
+	// given
+	did, pid, vid := id.New(), id.New(), id.New()
+	// and
+	s := &service{
+		deals: &dealRepoFake{members: map[ID][]chnl.ID{did: {pid}}},
+		chnls: &chnlRepoFake{ends: map[chnl.ID]chnl.Ends{vid: {ProviderID: pid}}},
+		log:   slog.Default(),
+	}
+
+	t.Run("OwnDeal", func(t *testing.T) {
+		// when
+		obligs, err := s.retrieveObligations(nil, ObligSpec{Deal: did, VID: vid})
+		// then
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(obligs) != 0 {
+			t.Errorf("unexpected obligations for closed channel: %v", obligs)
+		}
+	})
+
+	t.Run("ForeignDeal", func(t *testing.T) {
+		// when
+		_, err := s.retrieveObligations(nil, ObligSpec{Deal: id.New(), VID: vid})
+		// then
+		if !errors.Is(err, ErrNotMember) {
+			t.Errorf("unexpected error: want %v, got %v", ErrNotMember, err)
+		}
+	})
+}
+
+func TestTakeCancelled(t *testing.T) {
+	// given
+	pid, key := id.New(), ak.New()
+	steps := &stepRepoFake{cancelled: map[chnl.ID]bool{pid: true}}
+	keys := &keyRepoFake{keys: map[chnl.ID]ak.ADT{pid: key}}
+	s := &service{steps: steps, keys: keys, log: slog.Default()}
+	// when
+	err := s.take(nil, TranSpec{PID: pid, Key: key})
+	// then
+	if !errors.Is(err, step.ErrCancelled) {
+		t.Errorf("unexpected error: want %v, got %v", step.ErrCancelled, err)
+	}
+}
+
+func TestTakeRetried(t *testing.T) {
+	// given
+	pid, key := id.New(), ak.New()
+	spec := TranSpec{PID: pid, Key: key, Term: step.CloseSpec{A: pid}, IK: "ik"}
+	digest, err := digestOf(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// and
+	receipt := step.Receipt{IK: spec.IK, PID: pid, Digest: digest}
+	steps := &stepRepoFake{receipts: map[step.IK]step.Receipt{spec.IK: receipt}}
+	keys := &keyRepoFake{keys: map[chnl.ID]ak.ADT{pid: key}}
+	s := &service{steps: steps, keys: keys, log: slog.Default()}
+
+	t.Run("SameRequest", func(t *testing.T) {
+		// when
+		err := s.take(nil, spec)
+		// then
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("OtherRequest", func(t *testing.T) {
+		// given
+		otherSpec := spec
+		otherSpec.Term = step.CloseSpec{A: id.New()}
+		// when
+		err := s.take(nil, otherSpec)
+		// then
+		if !errors.Is(err, step.ErrKeyReused) {
+			t.Errorf("unexpected error: want %v, got %v", step.ErrKeyReused, err)
+		}
+	})
+
+	t.Run("OtherProc", func(t *testing.T) {
+		// given
+		otherPID := id.New()
+		keys.keys[otherPID] = key
+		otherSpec := spec
+		otherSpec.PID = otherPID
+		// when
+		err := s.take(nil, otherSpec)
+		// then
+		if !errors.Is(err, step.ErrKeyReused) {
+			t.Errorf("unexpected error: want %v, got %v", step.ErrKeyReused, err)
+		}
+	})
+
+	t.Run("ForeignKey", func(t *testing.T) {
+		// given
+		foreignSpec := spec
+		foreignSpec.Key = ak.New()
+		// when
+		err := s.take(nil, foreignSpec)
+		// then
+		if !errors.Is(err, ak.ErrUnexpected) {
+			t.Errorf("unexpected error: want %v, got %v", ak.ErrUnexpected, err)
+		}
+	})
+}
+
+func TestDigestOf(t *testing.T) {
+	// given
+	pid := id.New()
+	spec := TranSpec{PID: pid, Term: step.CloseSpec{A: pid}, IK: "ik1"}
+	// and
+	retried := spec
+	retried.IK = "ik2"
+	retried.Key = ak.New()
+	// and
+	deferred := spec
+	deferred.Deadline = time.Now()
+	// when
+	specDigest, err := digestOf(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retriedDigest, err := digestOf(retried)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deferredDigest, err := digestOf(deferred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// then
+	if retriedDigest != specDigest {
+		t.Errorf("unexpected digest: want %v, got %v", specDigest, retriedDigest)
+	}
+	if deferredDigest == specDigest {
+		t.Errorf("digests collided: %v", specDigest)
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	// given
+	sender := id.New()
+	stB := state.OneRoot{ID: id.New()}
+	stA := state.TensorRoot{ID: id.New(), B: stB, C: state.OneRoot{ID: id.New()}}
+	// and
+	a := chnl.Root{ID: id.New(), StateID: &stA.ID}
+	b := chnl.Root{ID: id.New(), StateID: &stB.ID}
+	cfg := Configuration{
+		chnls:  map[chnl.ID]chnl.Root{a.ID: a, b.ID: b},
+		states: map[state.ID]state.Root{stA.ID: stA, stB.ID: stB},
+	}
+	// and
+	steps := &stepRepoFake{}
+	chnls := &chnlRepoFake{}
+	s := &service{steps: steps, chnls: chnls, queueBound: 1, log: slog.Default()}
+	// when
+	queued, err := s.enqueue(nil, step.ProcRoot{PID: sender}, cfg, a, step.SendSpec{A: a.ID, B: b.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// then
+	if !queued || len(steps.msgs) != 1 {
+		t.Fatalf("unexpected msgs: want 1 queued, got %v", steps.msgs)
+	}
+	msg := steps.msgs[0]
+	if msg.NextVID.IsEmpty() {
+		t.Errorf("successor isn't allocated: %+v", msg)
+	}
+	// and
+	wantTransfers := []transfer{{From: sender, To: msg.ID, IDs: []chnl.ID{b.ID}}}
+	if !reflect.DeepEqual(chnls.transfers, wantTransfers) {
+		t.Errorf("unexpected transfers: want %v, got %v", wantTransfers, chnls.transfers)
+	}
+	// and
+	if holderOf(msg) != msg.ID {
+		t.Errorf("unexpected holder: want %v, got %v", msg.ID, holderOf(msg))
+	}
+}
+
+func TestLookupStParametric(t *testing.T) {
+	// given
+	label := core.Label("l")
+	cell := sym.New("cell")
+	cellDef := state.ConvertDefToRoot([]string{"a"}, state.PlusSpec{
+		Choices: map[core.Label]state.Spec{
+			label: state.PlusSpec{
+				Choices: map[core.Label]state.Spec{label: state.VarSpec{Name: "a"}},
+			},
+		},
+	})
+	box := state.ConvertSpecToRoot(state.LinkSpec{Role: cell, Args: []state.Spec{state.OneSpec{}}})
+	// and
+	boxID := box.Ident()
+	via := chnl.Root{ID: id.New(), StateID: &boxID}
+	cfg := Configuration{
+		chnls:  map[chnl.ID]chnl.Root{via.ID: via},
+		states: map[state.ID]state.Root{boxID: box},
+		defs:   state.Env{cell: cellDef},
+	}
+	// and
+	states := &stateRepoFake{states: map[state.ID]state.Root{}}
+	s := &service{states: states, log: slog.Default()}
+	// when
+	curSt, err := s.lookupSt(nil, cfg, via.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// then
+	nextID := curSt.(state.Sum).Next(label)
+	if states.states[curSt.Ident()] == nil || states.states[nextID] == nil {
+		t.Errorf("instantiated states aren't stored: %v", states.states)
+	}
+}
+
+func TestCheckTEs(t *testing.T) {
+	// given
+	inner, outer := sym.New("inner"), sym.New("outer")
+	innerSt := state.ConvertSpecToRoot(state.OneSpec{})
+	outerSt := state.ConvertSpecToRoot(state.LinkSpec{Role: inner})
+	// and
+	innerID := innerSt.Ident()
+	te := chnl.Root{ID: id.New(), StateID: &innerID}
+	wantSig := sig.Root{CEs: []chnl.Spec{{Key: "ce", Link: outer}}}
+	// and
+	s := &service{
+		chnls: &chnlRepoFake{byID: map[chnl.ID]chnl.Root{te.ID: te}},
+		roles: &roleRepoFake{roles: map[role.FQN]role.Root{
+			inner: {StateID: innerSt.Ident()},
+			outer: {StateID: outerSt.Ident()},
+		}},
+		states: &stateRepoFake{states: map[state.ID]state.Root{
+			innerSt.Ident(): innerSt,
+			outerSt.Ident(): outerSt,
+		}},
+		log: slog.Default(),
+	}
+	// when
+	err := s.checkTEs(nil, wantSig, []chnl.ID{te.ID})
+	// then
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestConvertToCtx(t *testing.T) {
+	// given
+	st := state.ConvertSpecToRoot(state.OneSpec{})
+	stID := st.Ident()
+	open := chnl.Root{ID: id.New(), StateID: &stID}
+	closed := chnl.Root{ID: id.New()}
+	// when
+	ctx := convertToCtx([]chnl.Root{open, closed}, map[state.ID]state.Root{stID: st}, nil)
+	// then
+	want := state.Context{
+		Shared: map[ph.ADT]state.Root{},
+		Linear: map[ph.ADT]state.Root{open.ID: st},
+	}
+	if !reflect.DeepEqual(ctx, want) {
+		t.Errorf("unexpected ctx: want %v, got %v", want, ctx)
+	}
+}
+
+type roleRepoFake struct {
+	role.Repo
+	roles map[role.FQN]role.Root
+}
+
+func (r *roleRepoFake) SelectEnv(_ data.Source, fqns []role.FQN) (map[role.FQN]role.Root, error) {
+	env := make(map[role.FQN]role.Root, len(fqns))
+	for _, fqn := range fqns {
+		env[fqn] = r.roles[fqn]
+	}
+	return env, nil
+}
+
+type dealRepoFake struct {
+	repo
+	members map[ID][]chnl.ID
+}
+
+func (r *dealRepoFake) SelectMembers(_ data.Source, did ID) ([]chnl.ID, error) {
+	return r.members[did], nil
+}
+
+type keyRepoFake struct {
+	keyRepo
+	keys map[chnl.ID]ak.ADT
+}
+
+func (r *keyRepoFake) SelectByPID(_ data.Source, pid chnl.ID) (KeyRoot, error) {
+	return KeyRoot{PID: pid, AK: r.keys[pid]}, nil
+}
+
+type operatorFake struct{}
+
+func (operatorFake) Explicit(_ context.Context, fn func(data.Source) error) error {
+	return fn(nil)
+}
+
+func (operatorFake) Implicit(_ context.Context, fn func(data.Source) error) error {
+	return fn(nil)
+}
+
+type subscriberFake struct {
+	topics []string
+}
+
+func (s *subscriberFake) Subscribe(topic string) (<-chan []byte, func()) {
+	s.topics = append(s.topics, topic)
+	return make(chan []byte), func() {}
+}
+
+type transfer struct {
+	From chnl.ID
+	To   chnl.ID
+	IDs  []chnl.ID
+}
+
+type chnlRepoFake struct {
+	chnl.Repo
+	owned     map[chnl.ID][]chnl.Root
+	ends      map[chnl.ID]chnl.Ends
+	next      map[chnl.ID]chnl.Root
+	byID      map[chnl.ID]chnl.Root
+	inserted  []chnl.Root
+	transfers []transfer
+}
+
+func (r *chnlRepoFake) SelectByIDs(_ data.Source, ids []chnl.ID) ([]chnl.Root, error) {
+	roots := make([]chnl.Root, 0, len(ids))
+	for _, cid := range ids {
+		roots = append(roots, r.byID[cid])
+	}
+	return roots, nil
+}
+
+func (r *chnlRepoFake) Transfer(_ data.Source, from chnl.ID, to chnl.ID, ids []chnl.ID) error {
+	r.transfers = append(r.transfers, transfer{from, to, ids})
+	return nil
+}
+
+func (r *chnlRepoFake) SelectByID(_ data.Source, cid chnl.ID) (chnl.Root, error) {
+	return chnl.Root{ID: cid}, nil
+}
+
+func (r *chnlRepoFake) SelectNext(_ data.Source, cid chnl.ID) (chnl.Root, bool, error) {
+	next, ok := r.next[cid]
+	return next, ok, nil
+}
+
+func (r *chnlRepoFake) Insert(_ data.Source, root chnl.Root) error {
+	r.inserted = append(r.inserted, root)
+	return nil
+}
+
+func (r *chnlRepoFake) SelectOwned(_ data.Source, pid chnl.ID) ([]chnl.Root, error) {
+	return r.owned[pid], nil
+}
+
+func (r *chnlRepoFake) SelectEnds(data.Source, []chnl.ID) (map[chnl.ID]chnl.Ends, error) {
+	return r.ends, nil
+}
+
+func (r *chnlRepoFake) Lock(data.Source, []chnl.ID) error {
+	return nil
+}
+
+type stateRepoFake struct {
+	state.Repo
+	states map[state.ID]state.Root
+}
+
+// stores the whole tree, as the real repo does
+func (r *stateRepoFake) Insert(_ data.Source, root state.Root) error {
+	r.states[root.Ident()] = root
+	switch root := root.(type) {
+	case state.PlusRoot:
+		for _, choice := range root.Choices {
+			r.Insert(nil, choice)
+		}
+	case state.WithRoot:
+		for _, choice := range root.Choices {
+			r.Insert(nil, choice)
+		}
+	case state.TensorRoot:
+		r.Insert(nil, root.B)
+		r.Insert(nil, root.C)
+	case state.LolliRoot:
+		r.Insert(nil, root.Y)
+		r.Insert(nil, root.Z)
+	}
+	return nil
+}
+
+func (r *stateRepoFake) SelectEnv(_ data.Source, ids []state.ID) (map[state.ID]state.Root, error) {
+	env := make(map[state.ID]state.Root, len(ids))
+	for _, sid := range ids {
+		env[sid] = r.states[sid]
+	}
+	return env, nil
+}
+
+func (r *stateRepoFake) SelectByID(_ data.Source, sid state.ID) (state.Root, error) {
+	return r.states[sid], nil
+}
+
+// pending steps are keyed by via
+type stepRepoFake struct {
+	step.Repo
+	sems      map[chnl.ID]step.Root
+	cancelled map[chnl.ID]bool
+	receipts  map[step.IK]step.Receipt
+	procs     []step.ProcRoot
+	msgs      []step.MsgRoot
+	// pids pending steps were selected for
+	pendingPIDs []chnl.ID
+}
+
+func (r *stepRepoFake) SelectPending(_ data.Source, pids []chnl.ID) ([]step.Root, error) {
+	r.pendingPIDs = pids
+	return nil, nil
+}
+
+func (r *stepRepoFake) Insert(_ data.Source, root step.Root) error {
+	switch root := root.(type) {
+	case step.ProcRoot:
+		r.procs = append(r.procs, root)
+	case step.MsgRoot:
+		r.msgs = append(r.msgs, root)
+	default:
+		panic(step.ErrRootTypeUnexpected(root))
+	}
+	return nil
+}
+
+func (r *stepRepoFake) SelectQueueLen(data.Source, chnl.ID) (int, error) {
+	return len(r.msgs), nil
+}
+
+func (r *stepRepoFake) SelectByPID(data.Source, chnl.ID) (step.Root, error) {
+	return nil, nil
+}
+
+func (r *stepRepoFake) SelectByVID(_ data.Source, vid chnl.ID) (step.Root, error) {
+	return r.sems[vid], nil
+}
+
+func (r *stepRepoFake) Interrupt(_ data.Source, sid step.ID, _ step.Cause, _ time.Time) (bool, error) {
+	for vid, sem := range r.sems {
+		semID, _, _ := pendingOf(sem)
+		if semID == sid {
+			delete(r.sems, vid)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *stepRepoFake) Cancel(_ data.Source, pid chnl.ID, _ time.Time) error {
+	if r.cancelled == nil {
+		r.cancelled = make(map[chnl.ID]bool)
+	}
+	r.cancelled[pid] = true
+	return nil
+}
+
+func (r *stepRepoFake) SelectCancelled(_ data.Source, pid chnl.ID) (bool, error) {
+	return r.cancelled[pid], nil
+}
+
+func (r *stepRepoFake) InsertReceipt(_ data.Source, root step.Receipt) (bool, error) {
+	_, ok := r.receipts[root.IK]
+	if ok {
+		return false, nil
+	}
+	r.receipts[root.IK] = root
+	return true, nil
+}
+
+func (r *stepRepoFake) SelectReceipt(_ data.Source, ik step.IK) (step.Receipt, error) {
+	return r.receipts[ik], nil
+}
@@ -36,3 +36,17 @@ var (
 	DataToKinshipRoot   func(kinshipRootData) (KinshipRoot, error)
 	DataFromKinshipRoot func(KinshipRoot) kinshipRootData
 )
+
+type keyRootData struct {
+	PID string `db:"pid"`
+	AK  string `db:"ak"`
+}
+
+// goverter:variables
+// goverter:output:format assign-variable
+// goverter:extend smecalculus/rolevod/lib/id:Convert.*
+// goverter:extend smecalculus/rolevod/lib/ak:Convert.*
+var (
+	DataToKeyRoot   func(keyRootData) (KeyRoot, error)
+	DataFromKeyRoot func(KeyRoot) keyRootData
+)
@@ -1,35 +1,31 @@
 package deal
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"log/slog"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 
 	"smecalculus/rolevod/app/sig"
+	"smecalculus/rolevod/lib/data"
 	"smecalculus/rolevod/lib/id"
+
+	"smecalculus/rolevod/internal/chnl"
 )
 
 // Adapter
 type repoPgx struct {
-	pool *pgxpool.Pool
-	log  *slog.Logger
+	log *slog.Logger
 }
 
-func newRepoPgx(p *pgxpool.Pool, l *slog.Logger) *repoPgx {
+func newRepoPgx(l *slog.Logger) *repoPgx {
 	name := slog.String("name", "dealRepoPgx")
-	return &repoPgx{p, l.With(name)}
+	return &repoPgx{l.With(name)}
 }
 
-func (r *repoPgx) Insert(root Root) error {
-	ctx := context.Background()
-	tx, err := r.pool.Begin(ctx)
-	if err != nil {
-		return err
-	}
+func (r *repoPgx) Insert(source data.Source, root Root) error {
+	ds := data.MustConform[data.SourcePgx](source)
 	dto := DataFromRoot(root)
 	query := `
 		INSERT INTO deals (
@@ -41,15 +37,15 @@ func (r *repoPgx) Insert(root Root) error {
 		"id":   dto.ID,
 		"name": dto.Name,
 	}
-	_, err = tx.Exec(ctx, query, args)
+	_, err := ds.Conn.Exec(ds.Ctx, query, args)
 	if err != nil {
 		r.log.Error("insert failed", slog.Any("reason", err), slog.Any("deal", args))
-		return errors.Join(err, tx.Rollback(ctx))
+		return err
 	}
-	return tx.Commit(ctx)
+	return nil
 }
 
-func (r *repoPgx) SelectAll() ([]Ref, error) {
+func (r *repoPgx) SelectAll(source data.Source) ([]Ref, error) {
 	roots := make([]Ref, 5)
 	for i := range 5 {
 		roots[i] = Ref{ID: id.New(), Name: fmt.Sprintf("DealRoot%v", i)}
@@ -57,11 +53,11 @@ func (r *repoPgx) SelectAll() ([]Ref, error) {
 	return roots, nil
 }
 
-func (r *repoPgx) SelectByID(id id.ADT) (Root, error) {
+func (r *repoPgx) SelectByID(source data.Source, id id.ADT) (Root, error) {
 	return Root{ID: id, Name: "DealRoot"}, nil
 }
 
-func (r *repoPgx) SelectChildren(id id.ADT) ([]Ref, error) {
+func (r *repoPgx) SelectChildren(source data.Source, id id.ADT) ([]Ref, error) {
 	query := `
 		SELECT
 			d.id,
@@ -70,8 +66,8 @@ func (r *repoPgx) SelectChildren(id id.ADT) ([]Ref, error) {
 		LEFT JOIN kinships k
 			ON d.id = k.child_id
 		WHERE k.parent_id = $1`
-	ctx := context.Background()
-	rows, err := r.pool.Query(ctx, query, id.String())
+	ds := data.MustConform[data.SourcePgx](source)
+	rows, err := ds.Conn.Query(ds.Ctx, query, id.String())
 	if err != nil {
 		return nil, err
 	}
@@ -83,22 +79,80 @@ func (r *repoPgx) SelectChildren(id id.ADT) ([]Ref, error) {
 	return DataToRefs(dtos)
 }
 
-func (r *repoPgx) SelectSigs(id id.ADT) ([]sig.Ref, error) {
+func (r *repoPgx) SelectSigs(source data.Source, id id.ADT) ([]sig.Ref, error) {
 	return []sig.Ref{}, nil
 }
 
+func (r *repoPgx) InsertPart(source data.Source, did ID, pid chnl.ID) error {
+	ds := data.MustConform[data.SourcePgx](source)
+	query := `
+		INSERT INTO parts (
+			deal_id, pid
+		) VALUES (
+			$1, $2
+		)`
+	_, err := ds.Conn.Exec(ds.Ctx, query, did.String(), pid.String())
+	if err != nil {
+		r.log.Error("insert failed", slog.Any("reason", err), slog.Any("deal", did), slog.Any("pid", pid))
+		return err
+	}
+	return nil
+}
+
+func (r *repoPgx) SelectMembers(source data.Source, did ID) ([]chnl.ID, error) {
+	ds := data.MustConform[data.SourcePgx](source)
+	// members are successors of involved channels and channels transferred to them
+	query := `
+		WITH RECURSIVE edges AS (
+			SELECT pre_id AS from_id, id AS to_id
+			FROM channels
+			WHERE pre_id IS NOT NULL
+			UNION ALL
+			SELECT to_id, pid
+			FROM clientships
+		), members AS (
+			SELECT pid AS id
+			FROM parts
+			WHERE deal_id = $1
+			UNION
+			SELECT e.to_id
+			FROM edges e, members m
+			WHERE e.from_id = m.id
+		)
+		SELECT id FROM members`
+	rows, err := ds.Conn.Query(ds.Ctx, query, did.String())
+	if err != nil {
+		r.log.Error("query execution failed", slog.Any("reason", err), slog.Any("deal", did))
+		return nil, err
+	}
+	defer rows.Close()
+	dtos, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		r.log.Error("rows collection failed", slog.Any("reason", err), slog.Any("deal", did))
+		return nil, err
+	}
+	pids := make([]chnl.ID, 0, len(dtos))
+	for _, dto := range dtos {
+		pid, err := id.ConvertFromString(dto)
+		if err != nil {
+			return nil, err
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
 // Adapter
 type kinshipRepoPgx struct {
-	pool *pgxpool.Pool
-	log  *slog.Logger
+	log *slog.Logger
 }
 
-func newKinshipRepoPgx(p *pgxpool.Pool, l *slog.Logger) *kinshipRepoPgx {
+func newKinshipRepoPgx(l *slog.Logger) *kinshipRepoPgx {
 	name := slog.String("name", "kinshipRepoPgx")
-	return &kinshipRepoPgx{p, l.With(name)}
+	return &kinshipRepoPgx{l.With(name)}
 }
 
-func (r *kinshipRepoPgx) Insert(root KinshipRoot) error {
+func (r *kinshipRepoPgx) Insert(source data.Source, root KinshipRoot) (err error) {
 	query := `
 		INSERT INTO kinships (
 			parent_id,
@@ -107,11 +161,7 @@ func (r *kinshipRepoPgx) Insert(root KinshipRoot) error {
 			@parent_id,
 			@child_id
 		)`
-	ctx := context.Background()
-	tx, err := r.pool.Begin(ctx)
-	if err != nil {
-		return err
-	}
+	ds := data.MustConform[data.SourcePgx](source)
 	batch := pgx.Batch{}
 	dto := DataFromKinshipRoot(root)
 	for _, child := range dto.Children {
@@ -121,7 +171,7 @@ func (r *kinshipRepoPgx) Insert(root KinshipRoot) error {
 		}
 		batch.Queue(query, args)
 	}
-	br := tx.SendBatch(ctx, &batch)
+	br := ds.Conn.SendBatch(ds.Ctx, &batch)
 	defer func() {
 		err = errors.Join(err, br.Close())
 	}()
@@ -132,14 +182,83 @@ func (r *kinshipRepoPgx) Insert(root KinshipRoot) error {
 				slog.Any("reason", err),
 				slog.Any("parent", dto.Parent),
 				slog.Any("child", child))
+			return err
 		}
 	}
+	return nil
+}
+
+// Adapter
+type keyRepoPgx struct {
+	log *slog.Logger
+}
+
+func newKeyRepoPgx(l *slog.Logger) *keyRepoPgx {
+	name := slog.String("name", "keyRepoPgx")
+	return &keyRepoPgx{l.With(name)}
+}
+
+func (r *keyRepoPgx) Insert(source data.Source, root KeyRoot) error {
+	ds := data.MustConform[data.SourcePgx](source)
+	dto := DataFromKeyRoot(root)
+	query := `
+		INSERT INTO access_keys (
+			pid, ak
+		) VALUES (
+			@pid, @ak
+		)`
+	args := pgx.NamedArgs{
+		"pid": dto.PID,
+		"ak":  dto.AK,
+	}
+	_, err := ds.Conn.Exec(ds.Ctx, query, args)
 	if err != nil {
-		return errors.Join(err, br.Close(), tx.Rollback(ctx))
+		r.log.Error("insert failed", slog.Any("reason", err), slog.Any("pid", dto.PID))
+		return err
+	}
+	return nil
+}
+
+func (r *keyRepoPgx) Update(source data.Source, root KeyRoot) error {
+	ds := data.MustConform[data.SourcePgx](source)
+	dto := DataFromKeyRoot(root)
+	query := `
+		UPDATE access_keys
+		SET ak = @ak
+		WHERE pid = @pid`
+	args := pgx.NamedArgs{
+		"pid": dto.PID,
+		"ak":  dto.AK,
+	}
+	_, err := ds.Conn.Exec(ds.Ctx, query, args)
+	if err != nil {
+		r.log.Error("update failed", slog.Any("reason", err), slog.Any("pid", dto.PID))
+		return err
+	}
+	return nil
+}
+
+func (r *keyRepoPgx) SelectByPID(source data.Source, pid chnl.ID) (KeyRoot, error) {
+	ds := data.MustConform[data.SourcePgx](source)
+	query := `
+		SELECT
+			pid, ak
+		FROM access_keys
+		WHERE pid = $1`
+	rows, err := ds.Conn.Query(ds.Ctx, query, pid.String())
+	if err != nil {
+		r.log.Error("query execution failed", slog.Any("reason", err), slog.Any("pid", pid))
+		return KeyRoot{}, err
+	}
+	defer rows.Close()
+	dto, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[keyRootData])
+	if errors.Is(err, pgx.ErrNoRows) {
+		// process isn't driven by agent, so no key issued
+		return KeyRoot{PID: pid}, nil
 	}
-	err = br.Close()
 	if err != nil {
-		return errors.Join(err, tx.Rollback(ctx))
+		r.log.Error("row collection failed", slog.Any("reason", err), slog.Any("pid", pid))
+		return KeyRoot{}, err
 	}
-	return tx.Commit(ctx)
+	return DataToKeyRoot(dto)
 }
@@ -3,39 +3,113 @@
 package deal
 
 import (
+	"context"
 	"embed"
+	"fmt"
 	"html/template"
 	"log/slog"
+	"time"
 
 	"github.com/Masterminds/sprig/v3"
 	"github.com/labstack/echo/v4"
 	"go.uber.org/fx"
 
+	"smecalculus/rolevod/lib/core"
+	"smecalculus/rolevod/lib/data"
 	"smecalculus/rolevod/lib/msg"
+
+	"smecalculus/rolevod/app/sig"
 )
 
 var Module = fx.Module("app/deal",
 	fx.Provide(
-		fx.Annotate(newService, fx.As(new(API))),
+		fx.Annotate(newService, fx.As(fx.Self()), fx.As(new(API)), fx.As(new(sig.Checker)), fx.As(new(Tracker))),
 	),
 	fx.Provide(
 		fx.Private,
+		newCfg,
 		fx.Annotate(newRenderer, fx.As(new(msg.Renderer))),
 		newHandlerEcho,
 		fx.Annotate(newRepoPgx, fx.As(new(repo))),
 		newKinshipHandlerEcho,
 		fx.Annotate(newKinshipRepoPgx, fx.As(new(kinshipRepo))),
+		fx.Annotate(newKeyRepoPgx, fx.As(new(keyRepo))),
 		newPartHandlerEcho,
 		newStepHandlerEcho,
+		newProcHandlerEcho,
+		newChnlHandlerEcho,
+		newEventHandlerEcho,
 	),
 	fx.Invoke(
+		cfgEngine,
 		cfgDealEcho,
 		cfgKinshipEcho,
 		cfgPartEcho,
 		cfgStepEcho,
+		cfgProcEcho,
+		cfgChnlEcho,
+		cfgEventEcho,
 	),
 )
 
+func newCfg(k core.Keeper) (*props, error) {
+	props := &props{
+		Workers:  1,
+		Interval: time.Second,
+		Backoff:  time.Minute,
+		Timeout:  timeoutProps{Action: abortAction, Label: "timeout"},
+		Queue:    queueProps{Bound: 16},
+	}
+	err := k.Load("reduction", props)
+	if err != nil {
+		return nil, err
+	}
+	if props.Timeout.Action != abortAction && props.Timeout.Action != injectAction {
+		return nil, fmt.Errorf("timeout action unexpected: %v", props.Timeout.Action)
+	}
+	if props.Backoff < props.Interval {
+		return nil, fmt.Errorf("backoff shorter than interval: %v", props.Backoff)
+	}
+	if props.Queue.Bound < 0 {
+		return nil, fmt.Errorf("queue bound negative: %v", props.Queue.Bound)
+	}
+	// each worker holds a connection for a whole reduction
+	pool := &poolProps{MaxConns: data.DefaultMaxConns}
+	err = k.Load("storage.protocol.postgres", pool)
+	if err != nil {
+		return nil, err
+	}
+	if props.Workers >= pool.MaxConns {
+		return nil, fmt.Errorf("workers leave no connections for api: %v of %v", props.Workers, pool.MaxConns)
+	}
+	return props, nil
+}
+
+func cfgEngine(s *service, p *props, lc fx.Lifecycle) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	lc.Append(
+		fx.Hook{
+			OnStart: func(context.Context) error {
+				go func() {
+					defer close(done)
+					s.reduce(ctx, p)
+				}()
+				return nil
+			},
+			OnStop: func(stopCtx context.Context) error {
+				cancel()
+				select {
+				case <-done:
+					return nil
+				case <-stopCtx.Done():
+					return stopCtx.Err()
+				}
+			},
+		},
+	)
+}
+
 //go:embed all:view
 var viesFs embed.FS
 
@@ -50,6 +124,9 @@ func newRenderer(l *slog.Logger) (*msg.RendererStdlib, error) {
 func cfgDealEcho(e *echo.Echo, h *handlerEcho) error {
 	e.POST("/api/v1/deals", h.ApiPostOne)
 	e.GET("/api/v1/deals/:id", h.ApiGetOne)
+	e.GET("/api/v1/deals/:id/deadlocks", h.ApiGetDeadlocks)
+	e.GET("/api/v1/deals/:id/history", h.ApiGetHistory)
+	e.GET("/api/v1/deals/:id/configuration", h.ApiGetConfiguration)
 	e.GET("/ssr/deals/:id", h.SsrGetOne)
 	return nil
 }
@@ -68,3 +145,19 @@ func cfgStepEcho(e *echo.Echo, h *stepHandlerEcho) error {
 	e.POST("/api/v1/deals/:id/steps", h.ApiPostOne)
 	return nil
 }
+
+func cfgChnlEcho(e *echo.Echo, h *chnlHandlerEcho) error {
+	e.GET("/api/v1/deals/:id/chnls/:cid/obligations", h.ApiGetObligations)
+	return nil
+}
+
+func cfgEventEcho(e *echo.Echo, h *eventHandlerEcho) error {
+	e.GET("/api/v1/deals/:id/chnls/:cid/events", h.ApiGetEvents)
+	return nil
+}
+
+func cfgProcEcho(e *echo.Echo, h *procHandlerEcho) error {
+	e.POST("/api/v1/deals/:id/procs/:pid/cancel", h.ApiPostCancel)
+	e.POST("/api/v1/deals/:id/procs/:pid/keys", h.ApiPostKey)
+	return nil
+}
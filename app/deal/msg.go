@@ -1,14 +1,19 @@
 package deal
 
 import (
+	"fmt"
+	"time"
+
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 
 	"smecalculus/rolevod/app/sig"
 	"smecalculus/rolevod/lib/ak"
 	"smecalculus/rolevod/lib/core"
 	"smecalculus/rolevod/lib/id"
+	"smecalculus/rolevod/lib/tm"
 
 	"smecalculus/rolevod/internal/chnl"
+	"smecalculus/rolevod/internal/state"
 	"smecalculus/rolevod/internal/step"
 )
 
@@ -110,11 +115,8 @@ func (dto PartSpecMsg) Validate() error {
 }
 
 type PartRootMsg struct {
-	ID     string        `json:"id"`
-	DealID string        `json:"deal_id"`
-	SigID  string        `json:"sig_id"`
-	PID    string        `json:"pid"`
-	TEs    []chnl.RefMsg `json:"tes"`
+	PE chnl.RootMsg `json:"pe"`
+	AK string       `json:"access_key"`
 }
 
 // goverter:variables
@@ -126,6 +128,8 @@ type PartRootMsg struct {
 var (
 	MsgFromPartSpec func(PartSpec) PartSpecMsg
 	MsgToPartSpec   func(PartSpecMsg) (PartSpec, error)
+	MsgFromPartRoot func(PartRoot) PartRootMsg
+	MsgToPartRoot   func(PartRootMsg) (PartRoot, error)
 )
 
 type TranSpecMsg struct {
@@ -133,6 +137,10 @@ type TranSpecMsg struct {
 	PID  string       `json:"pid"`
 	Key  string       `json:"key"`
 	Term step.TermMsg `json:"term"`
+	// RFC 3339, optional
+	Deadline string `json:"deadline,omitempty"`
+	// also accepted as Idempotency-Key header
+	IK string `json:"idempotency_key,omitempty"`
 }
 
 func (dto TranSpecMsg) Validate() error {
@@ -141,6 +149,8 @@ func (dto TranSpecMsg) Validate() error {
 		validation.Field(&dto.PID, id.Required...),
 		validation.Field(&dto.Key, ak.Required...),
 		validation.Field(&dto.Term, validation.Required),
+		validation.Field(&dto.Deadline, tm.TimeOptional...),
+		validation.Field(&dto.IK, core.NameOptional...),
 	)
 }
 
@@ -148,8 +158,268 @@ func (dto TranSpecMsg) Validate() error {
 // goverter:output:format assign-variable
 // goverter:extend smecalculus/rolevod/lib/id:Convert.*
 // goverter:extend smecalculus/rolevod/lib/ak:Convert.*
+// goverter:extend smecalculus/rolevod/lib/tm:Convert.*
 // goverter:extend smecalculus/rolevod/internal/step:Msg.*
 var (
 	MsgFromTranSpec func(TranSpec) TranSpecMsg
 	MsgToTranSpec   func(TranSpecMsg) (TranSpec, error)
 )
+
+type WaitMsg struct {
+	PID    string `json:"pid"`
+	SID    string `json:"sid"`
+	VID    string `json:"vid"`
+	ForPID string `json:"for_pid"`
+}
+
+type DeadlockMsg struct {
+	Waits []WaitMsg `json:"waits"`
+}
+
+// goverter:variables
+// goverter:output:format assign-variable
+// goverter:extend smecalculus/rolevod/lib/id:Convert.*
+var (
+	MsgFromDeadlocks func([]Deadlock) []DeadlockMsg
+	MsgToDeadlocks   func([]DeadlockMsg) ([]Deadlock, error)
+)
+
+type KeySpecMsg struct {
+	Deal string `json:"did" param:"id"`
+	PID  string `json:"pid" param:"pid"`
+	Key  string `json:"key"`
+}
+
+func (dto KeySpecMsg) Validate() error {
+	return validation.ValidateStruct(&dto,
+		validation.Field(&dto.Deal, id.Required...),
+		validation.Field(&dto.PID, id.Required...),
+		validation.Field(&dto.Key, ak.Required...),
+	)
+}
+
+type KeyRootMsg struct {
+	PID string `json:"pid"`
+	AK  string `json:"access_key"`
+}
+
+// goverter:variables
+// goverter:output:format assign-variable
+// goverter:extend smecalculus/rolevod/lib/id:Convert.*
+// goverter:extend smecalculus/rolevod/lib/ak:Convert.*
+var (
+	MsgFromKeySpec func(KeySpec) KeySpecMsg
+	MsgToKeySpec   func(KeySpecMsg) (KeySpec, error)
+	MsgFromKeyRoot func(KeyRoot) KeyRootMsg
+	MsgToKeyRoot   func(KeyRootMsg) (KeyRoot, error)
+)
+
+type CancelSpecMsg struct {
+	Deal string `json:"did" param:"id"`
+	PID  string `json:"pid" param:"pid"`
+	Key  string `json:"key"`
+}
+
+func (dto CancelSpecMsg) Validate() error {
+	return validation.ValidateStruct(&dto,
+		validation.Field(&dto.Deal, id.Required...),
+		validation.Field(&dto.PID, id.Required...),
+		validation.Field(&dto.Key, ak.Required...),
+	)
+}
+
+// goverter:variables
+// goverter:output:format assign-variable
+// goverter:extend smecalculus/rolevod/lib/id:Convert.*
+// goverter:extend smecalculus/rolevod/lib/ak:Convert.*
+var (
+	MsgFromCancelSpec func(CancelSpec) CancelSpecMsg
+	MsgToCancelSpec   func(CancelSpecMsg) (CancelSpec, error)
+)
+
+type HistorySpecMsg struct {
+	Deal  string `json:"deal_id" param:"id"`
+	After string `json:"after" query:"after"`
+	Limit int    `json:"limit" query:"limit"`
+}
+
+func (dto HistorySpecMsg) Validate() error {
+	return validation.ValidateStruct(&dto,
+		validation.Field(&dto.Deal, id.Required...),
+		validation.Field(&dto.After, id.Optional...),
+		validation.Field(&dto.Limit, validation.Min(0), validation.Max(historyLimitMax)),
+	)
+}
+
+type HistoryMsg struct {
+	Entries []step.EntryMsg `json:"entries"`
+	// cursor of the next page, empty if there is none
+	Next string `json:"next,omitempty"`
+}
+
+const (
+	historyLimitDefault = 50
+	historyLimitMax     = 500
+)
+
+// goverter:variables
+// goverter:output:format assign-variable
+// goverter:extend smecalculus/rolevod/lib/id:Convert.*
+var (
+	MsgFromHistorySpec func(HistorySpec) HistorySpecMsg
+	MsgToHistorySpec   func(HistorySpecMsg) (HistorySpec, error)
+)
+
+type SnapSpecMsg struct {
+	Deal string `json:"deal_id" param:"id"`
+	// Journal Entry ID or RFC 3339 time
+	At string `json:"at" query:"at"`
+}
+
+func (dto SnapSpecMsg) Validate() error {
+	return validation.ValidateStruct(&dto,
+		validation.Field(&dto.Deal, id.Required...),
+		validation.Field(&dto.At, validation.By(func(any) error {
+			_, _, err := convertFromAt(dto.At)
+			return err
+		})),
+	)
+}
+
+type SnapshotMsg struct {
+	Pos     string          `json:"pos"`
+	Chnls   []chnl.RootMsg  `json:"chnls"`
+	States  []state.RefMsg  `json:"states"`
+	Pending []step.EntryMsg `json:"pending"`
+}
+
+func MsgFromSnapSpec(spec SnapSpec) SnapSpecMsg {
+	dto := SnapSpecMsg{Deal: spec.Deal.String()}
+	if !spec.Pos.IsEmpty() {
+		dto.At = spec.Pos.String()
+	} else {
+		dto.At = tm.ConvertTimeToString(spec.At)
+	}
+	return dto
+}
+
+func MsgToSnapSpec(dto SnapSpecMsg) (SnapSpec, error) {
+	did, err := id.ConvertFromString(dto.Deal)
+	if err != nil {
+		return SnapSpec{}, err
+	}
+	pos, at, err := convertFromAt(dto.At)
+	if err != nil {
+		return SnapSpec{}, err
+	}
+	return SnapSpec{Deal: did, Pos: pos, At: at}, nil
+}
+
+// position takes precedence over time
+func convertFromAt(at string) (step.ID, time.Time, error) {
+	if at == "" {
+		return id.Empty(), time.Time{}, nil
+	}
+	pos, err := id.ConvertFromString(at)
+	if err == nil {
+		return pos, time.Time{}, nil
+	}
+	t, err := tm.ConvertTimeFromString(at)
+	if err != nil {
+		return id.Empty(), time.Time{}, fmt.Errorf("neither journal position nor time: %q", at)
+	}
+	return id.Empty(), t, nil
+}
+
+func MsgFromSnapshot(snap Snapshot) SnapshotMsg {
+	states := make([]state.RefMsg, 0, len(snap.States))
+	for _, st := range snap.States {
+		states = append(states, state.MsgFromRef(st))
+	}
+	return SnapshotMsg{
+		Pos:     snap.Pos.String(),
+		Chnls:   chnl.MsgFromRoots(snap.Chnls),
+		States:  states,
+		Pending: step.MsgFromEntries(snap.Pending),
+	}
+}
+
+func MsgToSnapshot(dto SnapshotMsg) (Snapshot, error) {
+	pos, err := id.ConvertFromString(dto.Pos)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	chnls, err := chnl.MsgToRoots(dto.Chnls)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	states := make([]state.Ref, 0, len(dto.States))
+	for _, st := range dto.States {
+		ref, err := state.MsgToRef(st)
+		if err != nil {
+			return Snapshot{}, err
+		}
+		states = append(states, ref)
+	}
+	pending, err := step.MsgToEntries(dto.Pending)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return Snapshot{Pos: pos, Chnls: chnls, States: states, Pending: pending}, nil
+}
+
+type EventSpecMsg struct {
+	Deal string `json:"deal_id" param:"id"`
+	VID  string `json:"vid" param:"cid"`
+	// long polling timeout in seconds
+	Timeout int `json:"timeout" query:"timeout"`
+}
+
+func (dto EventSpecMsg) Validate() error {
+	return validation.ValidateStruct(&dto,
+		validation.Field(&dto.Deal, id.Required...),
+		validation.Field(&dto.VID, id.Required...),
+		validation.Field(&dto.Timeout, validation.Min(0), validation.Max(pollTimeoutMax)),
+	)
+}
+
+const (
+	pollTimeoutDefault = 30
+	pollTimeoutMax     = 120
+)
+
+// goverter:variables
+// goverter:output:format assign-variable
+// goverter:extend smecalculus/rolevod/lib/id:Convert.*
+var (
+	MsgToEventSpec func(EventSpecMsg) (EventSpec, error)
+)
+
+type ObligSpecMsg struct {
+	Deal string `json:"deal_id" param:"id"`
+	VID  string `json:"vid" param:"cid"`
+}
+
+func (dto ObligSpecMsg) Validate() error {
+	return validation.ValidateStruct(&dto,
+		validation.Field(&dto.Deal, id.Required...),
+		validation.Field(&dto.VID, id.Required...),
+	)
+}
+
+type ObligationMsg struct {
+	PID    string        `json:"pid"`
+	VID    string        `json:"vid"`
+	Kind   step.TermKind `json:"kind"`
+	Labels []string      `json:"labels,omitempty"`
+}
+
+// goverter:variables
+// goverter:output:format assign-variable
+// goverter:extend smecalculus/rolevod/lib/id:Convert.*
+var (
+	MsgFromObligSpec   func(ObligSpec) ObligSpecMsg
+	MsgToObligSpec     func(ObligSpecMsg) (ObligSpec, error)
+	MsgFromObligations func([]Obligation) []ObligationMsg
+	MsgToObligations   func([]ObligationMsg) ([]Obligation, error)
+)
@@ -1,17 +1,27 @@
 package deal
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 
 	"smecalculus/rolevod/internal/chnl"
+	"smecalculus/rolevod/internal/step"
+	"smecalculus/rolevod/lib/ak"
 	"smecalculus/rolevod/lib/core"
 	"smecalculus/rolevod/lib/id"
 	"smecalculus/rolevod/lib/msg"
 )
 
+const headerIdempotencyKey = "Idempotency-Key"
+
 // Adapter
 type handlerEcho struct {
 	api API
@@ -79,6 +89,85 @@ func (h *handlerEcho) SsrGetOne(c echo.Context) error {
 	return c.HTMLBlob(http.StatusOK, html)
 }
 
+func (h *handlerEcho) ApiGetDeadlocks(c echo.Context) error {
+	var dto RefMsg
+	err := c.Bind(&dto)
+	if err != nil {
+		return err
+	}
+	err = dto.Validate()
+	if err != nil {
+		return err
+	}
+	id, err := id.ConvertFromString(dto.ID)
+	if err != nil {
+		return err
+	}
+	deadlocks, err := h.api.RetrieveDeadlocks(id)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, MsgFromDeadlocks(deadlocks))
+}
+
+func (h *handlerEcho) ApiGetHistory(c echo.Context) error {
+	var dto HistorySpecMsg
+	err := c.Bind(&dto)
+	if err != nil {
+		h.log.Error("dto binding failed", slog.Any("reason", err))
+		return err
+	}
+	err = dto.Validate()
+	if err != nil {
+		h.log.Error("dto validation failed", slog.Any("reason", err), slog.Any("dto", dto))
+		return err
+	}
+	if dto.After == "" {
+		dto.After = id.Empty().String()
+	}
+	if dto.Limit == 0 {
+		dto.Limit = historyLimitDefault
+	}
+	spec, err := MsgToHistorySpec(dto)
+	if err != nil {
+		h.log.Error("spec mapping failed", slog.Any("reason", err), slog.Any("dto", dto))
+		return err
+	}
+	entries, err := h.api.RetrieveHistory(spec)
+	if err != nil {
+		return err
+	}
+	res := HistoryMsg{Entries: step.MsgFromEntries(entries)}
+	if len(entries) == spec.Limit {
+		res.Next = entries[len(entries)-1].ID.String()
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h *handlerEcho) ApiGetConfiguration(c echo.Context) error {
+	var dto SnapSpecMsg
+	err := c.Bind(&dto)
+	if err != nil {
+		h.log.Error("dto binding failed", slog.Any("reason", err))
+		return err
+	}
+	err = dto.Validate()
+	if err != nil {
+		h.log.Error("dto validation failed", slog.Any("reason", err), slog.Any("dto", dto))
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	spec, err := MsgToSnapSpec(dto)
+	if err != nil {
+		h.log.Error("spec mapping failed", slog.Any("reason", err), slog.Any("dto", dto))
+		return err
+	}
+	snap, err := h.api.RetrieveSnapshot(spec)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, MsgFromSnapshot(snap))
+}
+
 // Adapter
 type kinshipHandlerEcho struct {
 	api API
@@ -141,11 +230,147 @@ func (h *partHandlerEcho) ApiPostOne(c echo.Context) error {
 		h.log.Error("spec mapping failed", slog.Any("reason", err), slog.Any("spec", dto))
 		return err
 	}
-	pe, err := h.api.Involve(spec)
+	part, err := h.api.Involve(spec)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, MsgFromPartRoot(part))
+}
+
+// Adapter
+type chnlHandlerEcho struct {
+	api API
+	ssr msg.Renderer
+	log *slog.Logger
+}
+
+func newChnlHandlerEcho(a API, r msg.Renderer, l *slog.Logger) *chnlHandlerEcho {
+	name := slog.String("name", "chnlHandlerEcho")
+	return &chnlHandlerEcho{a, r, l.With(name)}
+}
+
+func (h *chnlHandlerEcho) ApiGetObligations(c echo.Context) error {
+	var dto ObligSpecMsg
+	err := c.Bind(&dto)
+	if err != nil {
+		h.log.Error("dto binding failed", slog.Any("reason", err))
+		return err
+	}
+	err = dto.Validate()
+	if err != nil {
+		h.log.Error("dto validation failed", slog.Any("reason", err), slog.Any("dto", dto))
+		return err
+	}
+	spec, err := MsgToObligSpec(dto)
+	if err != nil {
+		h.log.Error("spec mapping failed", slog.Any("reason", err), slog.Any("dto", dto))
+		return err
+	}
+	obligs, err := h.api.RetrieveObligations(spec)
+	if errors.Is(err, ErrNotMember) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, MsgFromObligations(obligs))
+}
+
+// Adapter
+type eventHandlerEcho struct {
+	trk Tracker
+	log *slog.Logger
+}
+
+func newEventHandlerEcho(t Tracker, l *slog.Logger) *eventHandlerEcho {
+	name := slog.String("name", "eventHandlerEcho")
+	return &eventHandlerEcho{t, l.With(name)}
+}
+
+// ApiGetEvents streams events if client accepts them, long polls otherwise
+func (h *eventHandlerEcho) ApiGetEvents(c echo.Context) error {
+	var dto EventSpecMsg
+	err := c.Bind(&dto)
+	if err != nil {
+		h.log.Error("dto binding failed", slog.Any("reason", err))
+		return err
+	}
+	err = dto.Validate()
+	if err != nil {
+		h.log.Error("dto validation failed", slog.Any("reason", err), slog.Any("dto", dto))
+		return err
+	}
+	spec, err := MsgToEventSpec(dto)
+	if err != nil {
+		h.log.Error("spec mapping failed", slog.Any("reason", err), slog.Any("dto", dto))
+		return err
+	}
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
+	evs, err := h.trk.Track(ctx, spec)
+	if errors.Is(err, ErrNotMember) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
 	if err != nil {
 		return err
 	}
-	return c.JSON(http.StatusCreated, chnl.MsgFromRoot(pe))
+	if strings.Contains(c.Request().Header.Get(echo.HeaderAccept), mimeEventStream) {
+		return h.stream(c, evs)
+	}
+	if dto.Timeout == 0 {
+		dto.Timeout = pollTimeoutDefault
+	}
+	timer := time.NewTimer(time.Duration(dto.Timeout) * time.Second)
+	defer timer.Stop()
+	select {
+	case ev, ok := <-evs:
+		if !ok {
+			return c.NoContent(http.StatusNoContent)
+		}
+		return c.JSON(http.StatusOK, chnl.MsgFromEvent(ev))
+	case <-timer.C:
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+const (
+	mimeEventStream = "text/event-stream"
+	// keeps intermediaries from dropping idle streams
+	heartbeatInterval = 15 * time.Second
+)
+
+func (h *eventHandlerEcho) stream(c echo.Context, evs <-chan chnl.Event) error {
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, mimeEventStream)
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.WriteHeader(http.StatusOK)
+	resp.Flush()
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev, ok := <-evs:
+			if !ok {
+				return nil
+			}
+			dto := chnl.MsgFromEvent(ev)
+			payload, err := json.Marshal(dto)
+			if err != nil {
+				h.log.Error("event marshalling failed", slog.Any("reason", err), slog.Any("dto", dto))
+				return nil
+			}
+			_, err = fmt.Fprintf(resp, "event: %v\ndata: %s\n\n", dto.Kind, payload)
+			if err != nil {
+				return nil
+			}
+		case <-ticker.C:
+			_, err := fmt.Fprint(resp, ": heartbeat\n\n")
+			if err != nil {
+				return nil
+			}
+		}
+		resp.Flush()
+	}
 }
 
 // Adapter
@@ -167,6 +392,9 @@ func (h *stepHandlerEcho) ApiPostOne(c echo.Context) error {
 		h.log.Error("dto binding failed", slog.Any("reason", err))
 		return err
 	}
+	if dto.IK == "" {
+		dto.IK = c.Request().Header.Get(headerIdempotencyKey)
+	}
 	ctx := c.Request().Context()
 	h.log.Log(ctx, core.LevelTrace, "transition posting started", slog.Any("dto", dto))
 	err = dto.Validate()
@@ -180,8 +408,92 @@ func (h *stepHandlerEcho) ApiPostOne(c echo.Context) error {
 		return err
 	}
 	err = h.api.Take(spec)
+	if errors.Is(err, ak.ErrUnexpected) {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	if errors.Is(err, chnl.ErrConcurrentTake) {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	if errors.Is(err, step.ErrKeyReused) {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	if errors.Is(err, step.ErrCancelled) {
+		return echo.NewHTTPError(http.StatusGone, err.Error())
+	}
 	if err != nil {
 		return err
 	}
 	return c.NoContent(http.StatusCreated)
 }
+
+// Adapter
+type procHandlerEcho struct {
+	api API
+	ssr msg.Renderer
+	log *slog.Logger
+}
+
+func newProcHandlerEcho(a API, r msg.Renderer, l *slog.Logger) *procHandlerEcho {
+	name := slog.String("name", "procHandlerEcho")
+	return &procHandlerEcho{a, r, l.With(name)}
+}
+
+func (h *procHandlerEcho) ApiPostCancel(c echo.Context) error {
+	var dto CancelSpecMsg
+	err := c.Bind(&dto)
+	if err != nil {
+		h.log.Error("dto binding failed", slog.Any("reason", err))
+		return err
+	}
+	err = dto.Validate()
+	if err != nil {
+		h.log.Error("dto validation failed", slog.Any("reason", err), slog.Any("dto", dto))
+		return err
+	}
+	spec, err := MsgToCancelSpec(dto)
+	if err != nil {
+		h.log.Error("spec mapping failed", slog.Any("reason", err), slog.Any("dto", dto))
+		return err
+	}
+	err = h.api.Cancel(spec)
+	if errors.Is(err, ak.ErrUnexpected) {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	if errors.Is(err, ErrNotMember) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if errors.Is(err, chnl.ErrConcurrentTake) {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *procHandlerEcho) ApiPostKey(c echo.Context) error {
+	var dto KeySpecMsg
+	err := c.Bind(&dto)
+	if err != nil {
+		h.log.Error("dto binding failed", slog.Any("reason", err))
+		return err
+	}
+	err = dto.Validate()
+	if err != nil {
+		h.log.Error("dto validation failed", slog.Any("reason", err), slog.Any("pid", dto.PID))
+		return err
+	}
+	spec, err := MsgToKeySpec(dto)
+	if err != nil {
+		h.log.Error("spec mapping failed", slog.Any("reason", err), slog.Any("pid", dto.PID))
+		return err
+	}
+	key, err := h.api.Rotate(spec)
+	if errors.Is(err, ak.ErrUnexpected) {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, MsgFromKeyRoot(KeyRoot{PID: spec.PID, AK: key}))
+}
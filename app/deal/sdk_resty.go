@@ -2,12 +2,14 @@ package deal
 
 import (
 	"fmt"
+	"strconv"
 
 	"github.com/go-resty/resty/v2"
 
+	"smecalculus/rolevod/lib/ak"
 	"smecalculus/rolevod/lib/id"
 
-	"smecalculus/rolevod/internal/chnl"
+	"smecalculus/rolevod/internal/step"
 )
 
 func NewAPI() API {
@@ -75,21 +77,21 @@ func (c *clientResty) Establish(spec KinshipSpec) error {
 	return nil
 }
 
-func (c *clientResty) Involve(spec PartSpec) (chnl.Root, error) {
+func (c *clientResty) Involve(spec PartSpec) (PartRoot, error) {
 	req := MsgFromPartSpec(spec)
-	var res chnl.RootMsg
+	var res PartRootMsg
 	resp, err := c.resty.R().
 		SetResult(&res).
 		SetBody(&req).
 		SetPathParam("id", req.Deal).
 		Post("/deals/{id}/parts")
 	if err != nil {
-		return chnl.Root{}, err
+		return PartRoot{}, err
 	}
 	if resp.IsError() {
-		return chnl.Root{}, fmt.Errorf("received: %v", string(resp.Body()))
+		return PartRoot{}, fmt.Errorf("received: %v", string(resp.Body()))
 	}
-	return chnl.MsgToRoot(res)
+	return MsgToPartRoot(res)
 }
 
 func (c *clientResty) Take(spec TranSpec) error {
@@ -106,3 +108,107 @@ func (c *clientResty) Take(spec TranSpec) error {
 	}
 	return nil
 }
+
+func (c *clientResty) RetrieveDeadlocks(id id.ADT) ([]Deadlock, error) {
+	var res []DeadlockMsg
+	resp, err := c.resty.R().
+		SetResult(&res).
+		SetPathParam("id", id.String()).
+		Get("/deals/{id}/deadlocks")
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsError() {
+		return nil, fmt.Errorf("received: %v", string(resp.Body()))
+	}
+	return MsgToDeadlocks(res)
+}
+
+func (c *clientResty) Cancel(spec CancelSpec) error {
+	req := MsgFromCancelSpec(spec)
+	resp, err := c.resty.R().
+		SetPathParam("id", req.Deal).
+		SetPathParam("pid", req.PID).
+		Post("/deals/{id}/procs/{pid}/cancel")
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return fmt.Errorf("received: %v", string(resp.Body()))
+	}
+	return nil
+}
+
+func (c *clientResty) Rotate(spec KeySpec) (ak.ADT, error) {
+	req := MsgFromKeySpec(spec)
+	var res KeyRootMsg
+	resp, err := c.resty.R().
+		SetResult(&res).
+		SetBody(&req).
+		SetPathParam("id", req.Deal).
+		SetPathParam("pid", req.PID).
+		Post("/deals/{id}/procs/{pid}/keys")
+	if err != nil {
+		return ak.ADT{}, err
+	}
+	if resp.IsError() {
+		return ak.ADT{}, fmt.Errorf("received: %v", string(resp.Body()))
+	}
+	root, err := MsgToKeyRoot(res)
+	if err != nil {
+		return ak.ADT{}, err
+	}
+	return root.AK, nil
+}
+
+func (c *clientResty) RetrieveHistory(spec HistorySpec) ([]step.Entry, error) {
+	req := MsgFromHistorySpec(spec)
+	var res HistoryMsg
+	resp, err := c.resty.R().
+		SetResult(&res).
+		SetPathParam("id", req.Deal).
+		SetQueryParam("after", req.After).
+		SetQueryParam("limit", strconv.Itoa(req.Limit)).
+		Get("/deals/{id}/history")
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsError() {
+		return nil, fmt.Errorf("received: %v", string(resp.Body()))
+	}
+	return step.MsgToEntries(res.Entries)
+}
+
+func (c *clientResty) RetrieveSnapshot(spec SnapSpec) (Snapshot, error) {
+	req := MsgFromSnapSpec(spec)
+	var res SnapshotMsg
+	resp, err := c.resty.R().
+		SetResult(&res).
+		SetPathParam("id", req.Deal).
+		SetQueryParam("at", req.At).
+		Get("/deals/{id}/configuration")
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if resp.IsError() {
+		return Snapshot{}, fmt.Errorf("received: %v", string(resp.Body()))
+	}
+	return MsgToSnapshot(res)
+}
+
+func (c *clientResty) RetrieveObligations(spec ObligSpec) ([]Obligation, error) {
+	req := MsgFromObligSpec(spec)
+	var res []ObligationMsg
+	resp, err := c.resty.R().
+		SetResult(&res).
+		SetPathParam("id", req.Deal).
+		SetPathParam("cid", req.VID).
+		Get("/deals/{id}/chnls/{cid}/obligations")
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsError() {
+		return nil, fmt.Errorf("received: %v", string(resp.Body()))
+	}
+	return MsgToObligations(res)
+}
@@ -1,9 +1,11 @@
 package pool
 
 import (
+	"context"
 	"log/slog"
 
 	"smecalculus/rolevod/app/sig"
+	"smecalculus/rolevod/lib/data"
 	"smecalculus/rolevod/lib/id"
 	"smecalculus/rolevod/lib/rev"
 )
@@ -50,13 +52,14 @@ func newAPI() API {
 }
 
 type service struct {
-	pools repo
-	log   *slog.Logger
+	pools    repo
+	operator data.Operator
+	log      *slog.Logger
 }
 
-func newService(pools repo, l *slog.Logger) *service {
+func newService(pools repo, operator data.Operator, l *slog.Logger) *service {
 	name := slog.String("name", "poolService")
-	return &service{pools, l.With(name)}
+	return &service{pools, operator, l.With(name)}
 }
 
 func (s *service) Create(spec Spec) (Root, error) {
@@ -66,30 +69,42 @@ func (s *service) Create(spec Spec) (Root, error) {
 		Title: spec.Title,
 		SupID: spec.SupID,
 	}
-	err := s.pools.Insert(root)
+	ctx := context.Background()
+	err := s.operator.Explicit(ctx, func(ds data.Source) error {
+		return s.pools.Insert(ds, root)
+	})
 	if err != nil {
 		return root, err
 	}
 	return root, nil
 }
 
-func (s *service) Retrieve(rid id.ADT) (Snap, error) {
-	snap, err := s.pools.SelectByID(rid)
+func (s *service) Retrieve(rid id.ADT) (snap Snap, err error) {
+	ctx := context.Background()
+	err = s.operator.Implicit(ctx, func(ds data.Source) error {
+		snap, err = s.pools.SelectByID(ds, rid)
+		return err
+	})
 	if err != nil {
 		return Snap{}, err
 	}
 	return snap, nil
 }
 
-func (s *service) RetreiveRefs() ([]Ref, error) {
-	return s.pools.SelectAll()
+func (s *service) RetreiveRefs() (refs []Ref, err error) {
+	ctx := context.Background()
+	err = s.operator.Implicit(ctx, func(ds data.Source) error {
+		refs, err = s.pools.SelectAll(ds)
+		return err
+	})
+	return refs, err
 }
 
 // Port
 type repo interface {
-	Insert(Root) error
-	SelectByID(id.ADT) (Snap, error)
-	SelectAll() ([]Ref, error)
+	Insert(data.Source, Root) error
+	SelectByID(data.Source, id.ADT) (Snap, error)
+	SelectAll(data.Source) ([]Ref, error)
 }
 
 // goverter:variables
@@ -1,34 +1,27 @@
 package pool
 
 import (
-	"context"
-	"errors"
 	"log/slog"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 
 	"smecalculus/rolevod/lib/core"
+	"smecalculus/rolevod/lib/data"
 	"smecalculus/rolevod/lib/id"
 )
 
 // Adapter
 type repoPgx struct {
-	pool *pgxpool.Pool
-	log  *slog.Logger
+	log *slog.Logger
 }
 
-func newRepoPgx(p *pgxpool.Pool, l *slog.Logger) *repoPgx {
+func newRepoPgx(l *slog.Logger) *repoPgx {
 	name := slog.String("name", "poolRepoPgx")
-	return &repoPgx{p, l.With(name)}
+	return &repoPgx{l.With(name)}
 }
 
-func (r *repoPgx) Insert(root Root) error {
-	ctx := context.Background()
-	tx, err := r.pool.Begin(ctx)
-	if err != nil {
-		return err
-	}
+func (r *repoPgx) Insert(source data.Source, root Root) error {
+	ds := data.MustConform[data.SourcePgx](source)
 	dto := DataFromRoot(root)
 	insertRoot := `
 		insert into pool_roots (
@@ -42,18 +35,18 @@ func (r *repoPgx) Insert(root Root) error {
 		"title":   dto.Title,
 		"sup_id":  dto.SupID,
 	}
-	_, err = tx.Exec(ctx, insertRoot, rootArgs)
+	_, err := ds.Conn.Exec(ds.Ctx, insertRoot, rootArgs)
 	if err != nil {
 		r.log.Error("query execution failed", slog.Any("reason", err))
-		return errors.Join(err, tx.Rollback(ctx))
+		return err
 	}
-	r.log.Log(ctx, core.LevelTrace, "entity insertion succeeded", slog.Any("dto", dto))
-	return tx.Commit(ctx)
+	r.log.Log(ds.Ctx, core.LevelTrace, "entity insertion succeeded", slog.Any("dto", dto))
+	return nil
 }
 
-func (r *repoPgx) SelectByID(rid id.ADT) (Snap, error) {
-	ctx := context.Background()
-	rows, err := r.pool.Query(ctx, selectById, rid.String())
+func (r *repoPgx) SelectByID(source data.Source, rid id.ADT) (Snap, error) {
+	ds := data.MustConform[data.SourcePgx](source)
+	rows, err := ds.Conn.Query(ds.Ctx, selectById, rid.String())
 	if err != nil {
 		r.log.Error("query execution failed", slog.Any("reason", err))
 		return Snap{}, err
@@ -64,17 +57,17 @@ func (r *repoPgx) SelectByID(rid id.ADT) (Snap, error) {
 		r.log.Error("row collection failed", slog.Any("reason", err))
 		return Snap{}, err
 	}
-	r.log.Log(ctx, core.LevelTrace, "entity selection succeeded", slog.Any("dto", dto))
+	r.log.Log(ds.Ctx, core.LevelTrace, "entity selection succeeded", slog.Any("dto", dto))
 	return DataToSnap(dto)
 }
 
-func (r *repoPgx) SelectAll() ([]Ref, error) {
+func (r *repoPgx) SelectAll(source data.Source) ([]Ref, error) {
 	query := `
 		select
 			pool_id, rev, title
 		from pool_roots`
-	ctx := context.Background()
-	rows, err := r.pool.Query(ctx, query)
+	ds := data.MustConform[data.SourcePgx](source)
+	rows, err := ds.Conn.Query(ds.Ctx, query)
 	if err != nil {
 		r.log.Error("query execution failed", slog.Any("reason", err))
 		return nil, err
@@ -1,9 +1,13 @@
 package role
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 
+	"smecalculus/rolevod/lib/data"
 	"smecalculus/rolevod/lib/id"
 	"smecalculus/rolevod/lib/rev"
 	"smecalculus/rolevod/lib/sym"
@@ -19,8 +23,9 @@ type FQN = sym.ADT
 type Title = string
 
 type Spec struct {
-	FQN   sym.ADT
-	State state.Spec
+	FQN    sym.ADT
+	Params []string
+	State  state.Spec
 }
 
 type Ref struct {
@@ -30,19 +35,40 @@ type Ref struct {
 }
 
 type Snap struct {
+	ID     id.ADT
+	Rev    rev.ADT
+	Title  string
+	FQN    sym.ADT
+	Params []string
+	State  state.Spec
+	// Parts   []Ref
+	// breaking modification permission
+	Force bool
+	// modification outcome
+	Impact *Impact
+}
+
+// Impact is a consequence of a state modification
+type Impact struct {
+	// new state is neither subtype nor supertype of old one
+	Breaking bool
+	// signatures which reference the role
+	Sigs []SigRef
+}
+
+// sig.Ref without import cycle
+type SigRef struct {
 	ID    id.ADT
 	Rev   rev.ADT
 	Title string
-	FQN   sym.ADT
-	State state.Spec
-	// Parts   []Ref
 }
 
 // aka TpDef
 type Root struct {
-	ID    id.ADT
-	Rev   rev.ADT
-	Title string
+	ID     id.ADT
+	Rev    rev.ADT
+	Title  string
+	Params []string
 	// specification relation
 	StateID state.ID
 	// composition relation
@@ -57,13 +83,15 @@ type API interface {
 	RetrieveRoot(id.ADT) (Root, error)
 	RetrieveSnap(Root) (Snap, error)
 	RetreiveRefs() ([]Ref, error)
+	RetrieveDiff(id.ADT, rev.ADT, rev.ADT) ([]state.Change, error)
 }
 
 type service struct {
-	roles   Repo
-	states  state.Repo
-	aliases alias.Repo
-	log     *slog.Logger
+	roles    Repo
+	states   state.Repo
+	aliases  alias.Repo
+	operator data.Operator
+	log      *slog.Logger
 }
 
 // for compilation purposes
@@ -75,16 +103,93 @@ func newService(
 	roles Repo,
 	states state.Repo,
 	aliases alias.Repo,
+	operator data.Operator,
 	l *slog.Logger,
 ) *service {
 	name := slog.String("name", "roleService")
-	return &service{roles, states, aliases, l.With(name)}
+	return &service{roles, states, aliases, operator, l.With(name)}
+}
+
+func (s *service) Incept(fqn sym.ADT) (ref Ref, err error) {
+	ctx := context.Background()
+	err = s.operator.Explicit(ctx, func(ds data.Source) error {
+		ref, err = s.incept(ds, fqn)
+		return err
+	})
+	return ref, err
+}
+
+func (s *service) Create(spec Spec) (snap Snap, err error) {
+	ctx := context.Background()
+	err = s.operator.Explicit(ctx, func(ds data.Source) error {
+		snap, err = s.create(ds, spec)
+		return err
+	})
+	return snap, err
+}
+
+func (s *service) Modify(newSnap Snap) (snap Snap, err error) {
+	ctx := context.Background()
+	err = s.operator.Explicit(ctx, func(ds data.Source) error {
+		snap, err = s.modify(ds, newSnap)
+		return err
+	})
+	return snap, err
+}
+
+func (s *service) Retrieve(rid ID) (snap Snap, err error) {
+	ctx := context.Background()
+	err = s.operator.Implicit(ctx, func(ds data.Source) error {
+		snap, err = s.retrieve(ds, rid)
+		return err
+	})
+	return snap, err
+}
+
+func (s *service) RetrieveRoot(rid ID) (root Root, err error) {
+	ctx := context.Background()
+	err = s.operator.Implicit(ctx, func(ds data.Source) error {
+		root, err = s.roles.SelectByID(ds, rid)
+		return err
+	})
+	if err != nil {
+		s.log.Error("root selection failed", slog.Any("reason", err))
+		return Root{}, err
+	}
+	return root, nil
+}
+
+func (s *service) RetrieveSnap(root Root) (snap Snap, err error) {
+	ctx := context.Background()
+	err = s.operator.Implicit(ctx, func(ds data.Source) error {
+		snap, err = s.retrieveSnap(ds, root)
+		return err
+	})
+	return snap, err
 }
 
-func (s *service) Incept(fqn sym.ADT) (Ref, error) {
+func (s *service) RetreiveRefs() (refs []Ref, err error) {
+	ctx := context.Background()
+	err = s.operator.Implicit(ctx, func(ds data.Source) error {
+		refs, err = s.roles.SelectRefs(ds)
+		return err
+	})
+	return refs, err
+}
+
+func (s *service) RetrieveDiff(rid ID, from, to Rev) (changes []state.Change, err error) {
+	ctx := context.Background()
+	err = s.operator.Implicit(ctx, func(ds data.Source) error {
+		changes, err = s.retrieveDiff(ds, rid, from, to)
+		return err
+	})
+	return changes, err
+}
+
+func (s *service) incept(ds data.Source, fqn sym.ADT) (Ref, error) {
 	s.log.Debug("role inception started", slog.Any("fqn", fqn))
 	newAlias := alias.Root{Sym: fqn, ID: id.New(), Rev: rev.Initial()}
-	err := s.aliases.Insert(newAlias)
+	err := s.aliases.Insert(ds, newAlias)
 	if err != nil {
 		s.log.Error("alias insertion failed",
 			slog.Any("reason", err),
@@ -97,7 +202,7 @@ func (s *service) Incept(fqn sym.ADT) (Ref, error) {
 		Rev:   newAlias.Rev,
 		Title: newAlias.Sym.Name(),
 	}
-	err = s.roles.Insert(newRoot)
+	err = s.roles.Insert(ds, newRoot)
 	if err != nil {
 		s.log.Error("role insertion failed",
 			slog.Any("reason", err),
@@ -109,10 +214,18 @@ func (s *service) Incept(fqn sym.ADT) (Ref, error) {
 	return ConvertRootToRef(newRoot), nil
 }
 
-func (s *service) Create(spec Spec) (Snap, error) {
+func (s *service) create(ds data.Source, spec Spec) (Snap, error) {
 	s.log.Debug("role creation started", slog.Any("spec", spec))
+	err := s.checkSpec(ds, spec.FQN, spec.Params, spec.State)
+	if err != nil {
+		s.log.Error("role validation failed",
+			slog.Any("reason", err),
+			slog.Any("fqn", spec.FQN),
+		)
+		return Snap{}, err
+	}
 	newAlias := alias.Root{Sym: spec.FQN, ID: id.New(), Rev: rev.Initial()}
-	err := s.aliases.Insert(newAlias)
+	err = s.aliases.Insert(ds, newAlias)
 	if err != nil {
 		s.log.Error("alias insertion failed",
 			slog.Any("reason", err),
@@ -120,8 +233,8 @@ func (s *service) Create(spec Spec) (Snap, error) {
 		)
 		return Snap{}, err
 	}
-	newState := state.ConvertSpecToRoot(spec.State)
-	err = s.states.Insert(newState)
+	newState := state.ConvertDefToRoot(spec.Params, spec.State)
+	err = s.states.Insert(ds, newState)
 	if err != nil {
 		s.log.Error("state insertion failed",
 			slog.Any("reason", err),
@@ -133,9 +246,10 @@ func (s *service) Create(spec Spec) (Snap, error) {
 		ID:      newAlias.ID,
 		Rev:     newAlias.Rev,
 		Title:   newAlias.Sym.Name(),
+		Params:  spec.Params,
 		StateID: newState.Ident(),
 	}
-	err = s.roles.Insert(newRoot)
+	err = s.roles.Insert(ds, newRoot)
 	if err != nil {
 		s.log.Error("role insertion failed",
 			slog.Any("reason", err),
@@ -145,17 +259,18 @@ func (s *service) Create(spec Spec) (Snap, error) {
 	}
 	s.log.Debug("role creation succeeded", slog.Any("id", newRoot.ID))
 	return Snap{
-		ID:    newRoot.ID,
-		Rev:   newRoot.Rev,
-		Title: newRoot.Title,
-		FQN:   newAlias.Sym,
-		State: state.ConvertRootToSpec(newState),
+		ID:     newRoot.ID,
+		Rev:    newRoot.Rev,
+		Title:  newRoot.Title,
+		FQN:    newAlias.Sym,
+		Params: newRoot.Params,
+		State:  state.ConvertRootToSpec(newState),
 	}, nil
 }
 
-func (s *service) Modify(newSnap Snap) (Snap, error) {
+func (s *service) modify(ds data.Source, newSnap Snap) (Snap, error) {
 	s.log.Debug("role modification started", slog.Any("snap", newSnap))
-	curRoot, err := s.roles.SelectByID(newSnap.ID)
+	curRoot, err := s.roles.SelectByID(ds, newSnap.ID)
 	if err != nil {
 		s.log.Error("root selection failed",
 			slog.Any("reason", err),
@@ -173,7 +288,7 @@ func (s *service) Modify(newSnap Snap) (Snap, error) {
 	} else {
 		newSnap.Rev = rev.Next(newSnap.Rev)
 	}
-	curSnap, err := s.RetrieveSnap(curRoot)
+	curSnap, err := s.retrieveSnap(ds, curRoot)
 	if err != nil {
 		s.log.Error("snapshot retrieval failed",
 			slog.Any("reason", err),
@@ -182,9 +297,42 @@ func (s *service) Modify(newSnap Snap) (Snap, error) {
 		return Snap{}, err
 	}
 	diff := state.CheckSpec(newSnap.State, curSnap.State)
-	if diff != nil {
-		newState := state.ConvertSpecToRoot(newSnap.State)
-		err := s.states.Insert(newState)
+	if diff != nil || !slices.Equal(newSnap.Params, curSnap.Params) {
+		curAlias, err := s.aliases.SelectByID(ds, curRoot.ID)
+		if err != nil {
+			s.log.Error("alias selection failed",
+				slog.Any("reason", err),
+				slog.Any("id", curRoot.ID),
+			)
+			return Snap{}, err
+		}
+		err = s.checkSpec(ds, curAlias.Sym, newSnap.Params, newSnap.State)
+		if err != nil {
+			s.log.Error("role validation failed",
+				slog.Any("reason", err),
+				slog.Any("fqn", curAlias.Sym),
+			)
+			return Snap{}, err
+		}
+		impact, err := s.checkImpact(ds, curAlias.Sym, curSnap, newSnap)
+		if err != nil {
+			s.log.Error("impact checking failed",
+				slog.Any("reason", err),
+				slog.Any("fqn", curAlias.Sym),
+			)
+			return Snap{}, err
+		}
+		if impact.Breaking && !newSnap.Force {
+			err := errBreakingModification(curAlias.Sym, impact.Sigs)
+			s.log.Error("role modification failed",
+				slog.Any("reason", err),
+				slog.Any("id", curRoot.ID),
+			)
+			return Snap{}, err
+		}
+		newSnap.Impact = &impact
+		newState := state.ConvertDefToRoot(newSnap.Params, newSnap.State)
+		err = s.states.Insert(ds, newState)
 		if err != nil {
 			s.log.Error("state insertion failed",
 				slog.Any("reason", err),
@@ -192,11 +340,12 @@ func (s *service) Modify(newSnap Snap) (Snap, error) {
 			)
 			return Snap{}, err
 		}
+		curRoot.Params = newSnap.Params
 		curRoot.StateID = newState.Ident()
 		curRoot.Rev = newSnap.Rev
 	}
 	if curRoot.Rev == newSnap.Rev {
-		err := s.roles.Update(curRoot)
+		err := s.roles.Update(ds, curRoot)
 		if err != nil {
 			s.log.Error("root update failed",
 				slog.Any("reason", err),
@@ -209,40 +358,141 @@ func (s *service) Modify(newSnap Snap) (Snap, error) {
 	return newSnap, nil
 }
 
-func (s *service) Retrieve(rid ID) (Snap, error) {
-	root, err := s.roles.SelectByID(rid)
+func (s *service) retrieve(ds data.Source, rid ID) (Snap, error) {
+	root, err := s.roles.SelectByID(ds, rid)
 	if err != nil {
 		s.log.Error("root selection failed", slog.Any("reason", err))
 		return Snap{}, err
 	}
-	return s.RetrieveSnap(root)
+	return s.retrieveSnap(ds, root)
 }
 
-func (s *service) RetrieveRoot(rid ID) (Root, error) {
-	root, err := s.roles.SelectByID(rid)
+func (s *service) retrieveSnap(ds data.Source, root Root) (Snap, error) {
+	curState, err := s.states.SelectByID(ds, root.StateID)
 	if err != nil {
-		s.log.Error("root selection failed", slog.Any("reason", err))
-		return Root{}, err
+		s.log.Error("state selection failed", slog.Any("reason", err))
+		return Snap{}, err
 	}
-	return root, nil
+	return Snap{
+		ID:     root.ID,
+		Rev:    root.Rev,
+		Title:  root.Title,
+		Params: root.Params,
+		State:  state.ConvertRootToSpec(curState),
+	}, nil
 }
 
-func (s *service) RetrieveSnap(root Root) (Snap, error) {
-	curState, err := s.states.SelectByID(root.StateID)
+func (s *service) retrieveDiff(ds data.Source, rid ID, from, to Rev) ([]state.Change, error) {
+	fromRoot, err := s.roles.SelectByRev(ds, rid, from)
 	if err != nil {
-		s.log.Error("state selection failed", slog.Any("reason", err))
-		return Snap{}, err
+		s.log.Error("root selection failed",
+			slog.Any("reason", err),
+			slog.Any("id", rid),
+			slog.Any("rev", from),
+		)
+		return nil, err
 	}
-	return Snap{
-		ID:    root.ID,
-		Rev:   root.Rev,
-		Title: root.Title,
-		State: state.ConvertRootToSpec(curState),
+	toRoot, err := s.roles.SelectByRev(ds, rid, to)
+	if err != nil {
+		s.log.Error("root selection failed",
+			slog.Any("reason", err),
+			slog.Any("id", rid),
+			slog.Any("rev", to),
+		)
+		return nil, err
+	}
+	if fromRoot.StateID == toRoot.StateID {
+		return []state.Change{}, nil
+	}
+	states, err := s.states.SelectEnv(ds, []state.ID{fromRoot.StateID, toRoot.StateID})
+	if err != nil {
+		s.log.Error("states selection failed",
+			slog.Any("reason", err),
+			slog.Any("id", rid),
+		)
+		return nil, err
+	}
+	fromSpec := state.ConvertRootToSpec(states[fromRoot.StateID])
+	toSpec := state.ConvertRootToSpec(states[toRoot.StateID])
+	return state.Diff(fromSpec, toSpec), nil
+}
+
+// aka checkTpDef
+func (s *service) checkSpec(ds data.Source, fqn sym.ADT, params []string, spec state.Spec) error {
+	defs, arity, err := s.selectDefs(ds, fqn, state.CollectLinks(spec))
+	if err != nil {
+		s.log.Error("defs selection failed",
+			slog.Any("reason", err),
+			slog.Any("fqn", fqn),
+		)
+		return err
+	}
+	return state.Validate(defs, arity, fqn, params, spec)
+}
+
+// classifies state modification and collects dependant signatures
+func (s *service) checkImpact(ds data.Source, fqn sym.ADT, oldSnap, newSnap Snap) (Impact, error) {
+	fqns := append(state.CollectLinks(oldSnap.State), state.CollectLinks(newSnap.State)...)
+	defs, _, err := s.selectDefs(ds, fqn, fqns)
+	if err != nil {
+		return Impact{}, err
+	}
+	sigs, err := s.roles.SelectSigRefs(ds, fqn)
+	if err != nil {
+		return Impact{}, err
+	}
+	oldRoot := state.ConvertDefToRoot(oldSnap.Params, oldSnap.State)
+	newRoot := state.ConvertDefToRoot(newSnap.Params, newSnap.State)
+	narrowed := state.Subtype(defs, newRoot, oldRoot)
+	widened := state.Subtype(defs, oldRoot, newRoot)
+	return Impact{
+		Breaking: len(oldSnap.Params) != len(newSnap.Params) || narrowed != nil && widened != nil,
+		Sigs:     sigs,
 	}, nil
 }
 
-func (s *service) RetreiveRefs() ([]Ref, error) {
-	return s.roles.SelectRefs()
+// selects roles referenced by links and the chains of links behind them
+func (s *service) selectDefs(ds data.Source, fqn sym.ADT, fqns []sym.ADT) (state.Env, state.Arity, error) {
+	defs := make(state.Env, len(fqns))
+	arity := make(state.Arity, len(fqns))
+	for {
+		var newFQNs []sym.ADT
+		for _, f := range fqns {
+			_, ok := defs[f]
+			if ok || f == fqn || slices.Contains(newFQNs, f) {
+				continue
+			}
+			newFQNs = append(newFQNs, f)
+		}
+		if len(newFQNs) == 0 {
+			return defs, arity, nil
+		}
+		roles, err := s.roles.SelectEnv(ds, newFQNs)
+		if err != nil {
+			return nil, nil, err
+		}
+		var stIDs []state.ID
+		for _, r := range roles {
+			if r.StateID.IsEmpty() {
+				continue
+			}
+			stIDs = append(stIDs, r.StateID)
+		}
+		states, err := s.states.SelectEnv(ds, stIDs)
+		if err != nil {
+			return nil, nil, err
+		}
+		fqns = nil
+		for f, r := range roles {
+			st := states[r.StateID]
+			defs[f] = st
+			arity[f] = len(r.Params)
+			link, ok := st.(state.LinkRoot)
+			if ok {
+				fqns = append(fqns, link.Role)
+			}
+		}
+	}
 }
 
 func CollectEnv(roles []Root) []id.ADT {
@@ -254,16 +504,18 @@ func CollectEnv(roles []Root) []id.ADT {
 }
 
 type Repo interface {
-	Insert(Root) error
-	Update(Root) error
-	SelectRefs() ([]Ref, error)
-	SelectByID(id.ADT) (Root, error)
-	SelectByIDs([]id.ADT) ([]Root, error)
-	SelectByFQN(sym.ADT) (Root, error)
-	SelectByFQNs([]sym.ADT) ([]Root, error)
+	Insert(data.Source, Root) error
+	Update(data.Source, Root) error
+	SelectRefs(data.Source) ([]Ref, error)
+	SelectByID(data.Source, id.ADT) (Root, error)
+	SelectByRev(data.Source, id.ADT, rev.ADT) (Root, error)
+	SelectByIDs(data.Source, []id.ADT) ([]Root, error)
+	SelectByFQN(data.Source, sym.ADT) (Root, error)
+	SelectByFQNs(data.Source, []sym.ADT) ([]Root, error)
 	// SelectByRef(Ref) (Snap, error)
-	SelectParts(id.ADT) ([]Ref, error)
-	SelectEnv([]sym.ADT) (map[sym.ADT]Root, error)
+	SelectParts(data.Source, id.ADT) ([]Ref, error)
+	SelectEnv(data.Source, []sym.ADT) (map[sym.ADT]Root, error)
+	SelectSigRefs(data.Source, sym.ADT) ([]SigRef, error)
 }
 
 // goverter:variables
@@ -279,6 +531,12 @@ func errConcurrentModification(got rev.ADT, want rev.ADT) error {
 	return fmt.Errorf("entity concurrent modification: want revision %v, got revision %v", want, got)
 }
 
+var ErrBreakingModification = errors.New("role breaking modification")
+
+func errBreakingModification(fqn sym.ADT, sigs []SigRef) error {
+	return fmt.Errorf("%w: %v referenced by %v signatures", ErrBreakingModification, fqn, len(sigs))
+}
+
 func errOptimisticUpdate(got rev.ADT) error {
 	return fmt.Errorf("entity concurrent modification: got revision %v", got)
 }
@@ -1,9 +1,11 @@
 package role
 
 import (
+	"context"
 	"log/slog"
 	"testing"
 
+	"smecalculus/rolevod/lib/data"
 	"smecalculus/rolevod/lib/id"
 	"smecalculus/rolevod/lib/sym"
 
@@ -12,65 +14,85 @@ import (
 )
 
 func TestKinshipEstalish(t *testing.T) {
-	newService(&roleRepoStub{}, &stateRepoStub{}, &aliasRepoStub{}, slog.Default())
+	newService(&roleRepoStub{}, &stateRepoStub{}, &aliasRepoStub{}, &operatorStub{}, slog.Default())
 }
 
 type roleRepoStub struct {
 }
 
-func (r *roleRepoStub) Insert(root Root) error {
+func (r *roleRepoStub) Insert(source data.Source, root Root) error {
 	return nil
 }
-func (r *roleRepoStub) Update(root Root) error {
+func (r *roleRepoStub) Update(source data.Source, root Root) error {
 	return nil
 }
-func (r *roleRepoStub) SelectRefs() ([]Ref, error) {
+func (r *roleRepoStub) SelectRefs(source data.Source) ([]Ref, error) {
 	return []Ref{}, nil
 }
-func (r *roleRepoStub) SelectByID(id id.ADT) (Root, error) {
+func (r *roleRepoStub) SelectByID(source data.Source, id id.ADT) (Root, error) {
 	return Root{}, nil
 }
-func (r *roleRepoStub) SelectByIDs(ids []id.ADT) ([]Root, error) {
+func (r *roleRepoStub) SelectByRev(source data.Source, id id.ADT, rv Rev) (Root, error) {
+	return Root{}, nil
+}
+func (r *roleRepoStub) SelectByIDs(source data.Source, ids []id.ADT) ([]Root, error) {
 	return []Root{}, nil
 }
-func (r *roleRepoStub) SelectByRef(ref Ref) (Snap, error) {
+func (r *roleRepoStub) SelectByRef(source data.Source, ref Ref) (Snap, error) {
 	return Snap{}, nil
 }
-func (r *roleRepoStub) SelectByFQN(fqn sym.ADT) (Root, error) {
+func (r *roleRepoStub) SelectByFQN(source data.Source, fqn sym.ADT) (Root, error) {
 	return Root{}, nil
 }
-func (r *roleRepoStub) SelectByFQNs(fqns []sym.ADT) ([]Root, error) {
+func (r *roleRepoStub) SelectByFQNs(source data.Source, fqns []sym.ADT) ([]Root, error) {
 	return []Root{}, nil
 }
-func (r *roleRepoStub) SelectEnv(fqns []sym.ADT) (map[sym.ADT]Root, error) {
+func (r *roleRepoStub) SelectEnv(source data.Source, fqns []sym.ADT) (map[sym.ADT]Root, error) {
 	return nil, nil
 }
-func (r *roleRepoStub) SelectParts(id id.ADT) ([]Ref, error) {
+func (r *roleRepoStub) SelectSigRefs(source data.Source, fqn sym.ADT) ([]SigRef, error) {
+	return []SigRef{}, nil
+}
+func (r *roleRepoStub) SelectParts(source data.Source, id id.ADT) ([]Ref, error) {
 	return []Ref{}, nil
 }
 
 type stateRepoStub struct {
 }
 
-func (r *stateRepoStub) Insert(root state.Root) error {
+func (r *stateRepoStub) Insert(source data.Source, root state.Root) error {
 	return nil
 }
-func (r *stateRepoStub) SelectAll() ([]state.Ref, error) {
+func (r *stateRepoStub) SelectAll(source data.Source) ([]state.Ref, error) {
 	return []state.Ref{}, nil
 }
-func (r *stateRepoStub) SelectByID(sid id.ADT) (state.Root, error) {
+func (r *stateRepoStub) SelectByID(source data.Source, sid id.ADT) (state.Root, error) {
 	return nil, nil
 }
-func (r *stateRepoStub) SelectEnv(ids []id.ADT) (map[state.ID]state.Root, error) {
+func (r *stateRepoStub) SelectEnv(source data.Source, ids []id.ADT) (map[state.ID]state.Root, error) {
 	return nil, nil
 }
-func (r *stateRepoStub) SelectByIDs(ids []id.ADT) ([]state.Root, error) {
+func (r *stateRepoStub) SelectByIDs(source data.Source, ids []id.ADT) ([]state.Root, error) {
 	return nil, nil
 }
 
 type aliasRepoStub struct {
 }
 
-func (r *aliasRepoStub) Insert(ar alias.Root) error {
+func (r *aliasRepoStub) Insert(source data.Source, ar alias.Root) error {
 	return nil
 }
+func (r *aliasRepoStub) SelectByID(source data.Source, id id.ADT) (alias.Root, error) {
+	return alias.Root{}, nil
+}
+
+type operatorStub struct {
+}
+
+func (o *operatorStub) Explicit(ctx context.Context, fn func(data.Source) error) error {
+	return fn(nil)
+}
+
+func (o *operatorStub) Implicit(ctx context.Context, fn func(data.Source) error) error {
+	return fn(nil)
+}
@@ -10,10 +10,17 @@ type refData struct {
 	Title string `db:"title"`
 }
 
+type sigRefData struct {
+	ID    string `db:"sig_id"`
+	Rev   int64  `db:"rev"`
+	Title string `db:"title"`
+}
+
 type rootData struct {
 	ID      string         `db:"role_id"`
 	Rev     int64          `db:"rev"`
 	Title   string         `db:"title"`
+	Params  []string       `db:"params"`
 	StateID string         `db:"state_id"`
 	WholeID sql.NullString `db:"whole_id"`
 }
@@ -32,4 +39,5 @@ var (
 	DataFromRoot  func(Root) (rootData, error)
 	DataToRoots   func([]rootData) ([]Root, error)
 	DataFromRoots func([]Root) ([]rootData, error)
+	DataToSigRefs func([]sigRefData) ([]SigRef, error)
 )
@@ -1,28 +1,27 @@
 package role
 
 import (
-	"context"
 	"errors"
 	"log/slog"
 	"math"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 
 	"smecalculus/rolevod/lib/core"
+	"smecalculus/rolevod/lib/data"
 	"smecalculus/rolevod/lib/id"
+	"smecalculus/rolevod/lib/rev"
 	"smecalculus/rolevod/lib/sym"
 )
 
 // Adapter
 type repoPgx struct {
-	pool *pgxpool.Pool
-	log  *slog.Logger
+	log *slog.Logger
 }
 
-func newRepoPgx(p *pgxpool.Pool, l *slog.Logger) *repoPgx {
+func newRepoPgx(l *slog.Logger) *repoPgx {
 	name := slog.String("name", "roleRepoPgx")
-	return &repoPgx{p, l.With(name)}
+	return &repoPgx{l.With(name)}
 }
 
 // for compilation purposes
@@ -30,13 +29,9 @@ func newRepo() Repo {
 	return &repoPgx{}
 }
 
-func (r *repoPgx) Insert(root Root) error {
-	ctx := context.Background()
-	r.log.Log(ctx, core.LevelTrace, "root insertion started", slog.Any("role_id", root.ID))
-	tx, err := r.pool.Begin(ctx)
-	if err != nil {
-		return err
-	}
+func (r *repoPgx) Insert(source data.Source, root Root) error {
+	ds := data.MustConform[data.SourcePgx](source)
+	r.log.Log(ds.Ctx, core.LevelTrace, "root insertion started", slog.Any("role_id", root.ID))
 	dto, err := DataFromRoot(root)
 	if err != nil {
 		r.log.Error("dto mapping failed", slog.Any("reason", err))
@@ -53,39 +48,36 @@ func (r *repoPgx) Insert(root Root) error {
 		"rev":     dto.Rev,
 		"title":   dto.Title,
 	}
-	_, err = tx.Exec(ctx, insertRoot, rootArgs)
+	_, err = ds.Conn.Exec(ds.Ctx, insertRoot, rootArgs)
 	if err != nil {
 		r.log.Error("query execution failed", slog.Any("reason", err))
-		return errors.Join(err, tx.Rollback(ctx))
+		return err
 	}
 	insertState := `
 		insert into role_states (
-			role_id, state_id, rev_from, rev_to
+			role_id, state_id, params, rev_from, rev_to
 		) values (
-			@role_id, @state_id, @rev_from, @rev_to
+			@role_id, @state_id, @params, @rev_from, @rev_to
 		)`
 	stateArgs := pgx.NamedArgs{
 		"role_id":  dto.ID,
+		"params":   dto.Params,
 		"rev_from": dto.Rev,
 		"rev_to":   math.MaxInt64,
 		"state_id": dto.StateID,
 	}
-	_, err = tx.Exec(ctx, insertState, stateArgs)
+	_, err = ds.Conn.Exec(ds.Ctx, insertState, stateArgs)
 	if err != nil {
 		r.log.Error("query execution failed", slog.Any("reason", err))
-		return errors.Join(err, tx.Rollback(ctx))
+		return err
 	}
-	r.log.Log(ctx, core.LevelTrace, "root insertion succeeded", slog.Any("role_id", root.ID))
-	return tx.Commit(ctx)
+	r.log.Log(ds.Ctx, core.LevelTrace, "root insertion succeeded", slog.Any("role_id", root.ID))
+	return nil
 }
 
-func (r *repoPgx) Update(root Root) error {
-	ctx := context.Background()
-	r.log.Log(ctx, core.LevelTrace, "root update started", slog.Any("role_id", root.ID))
-	tx, err := r.pool.Begin(ctx)
-	if err != nil {
-		return err
-	}
+func (r *repoPgx) Update(source data.Source, root Root) error {
+	ds := data.MustConform[data.SourcePgx](source)
+	r.log.Log(ds.Ctx, core.LevelTrace, "root update started", slog.Any("role_id", root.ID))
 	dto, err := DataFromRoot(root)
 	if err != nil {
 		r.log.Error("dto mapping failed", slog.Any("reason", err))
@@ -93,49 +85,58 @@ func (r *repoPgx) Update(root Root) error {
 	}
 	rootQuery := `
 		update role_roots
-		set rev = @rev,
-			state_id = @state_id
+		set rev = @rev
 		where role_id = @role_id
 			and rev = @rev - 1`
-	snapQuery := `
-		insert into role_snaps (
-			role_id, rev, title, state_id, whole_id
+	closeQuery := `
+		update role_states
+		set rev_to = @rev
+		where role_id = @role_id
+			and rev_to = @rev_to`
+	stateQuery := `
+		insert into role_states (
+			role_id, state_id, params, rev_from, rev_to
 		) values (
-			@role_id, @rev, @title, @state_id, @whole_id
+			@role_id, @state_id, @params, @rev, @rev_to
 		)`
 	args := pgx.NamedArgs{
 		"role_id":  dto.ID,
+		"params":   dto.Params,
 		"rev":      dto.Rev,
-		"title":    dto.Title,
+		"rev_to":   math.MaxInt64,
 		"state_id": dto.StateID,
-		"whole_id": dto.WholeID,
 	}
-	ct, err := tx.Exec(ctx, rootQuery, args)
+	ct, err := ds.Conn.Exec(ds.Ctx, rootQuery, args)
 	if err != nil {
 		r.log.Error("query execution failed", slog.Any("reason", err))
-		return errors.Join(err, tx.Rollback(ctx))
+		return err
 	}
 	if ct.RowsAffected() != 1 {
 		err := errOptimisticUpdate(root.Rev - 1)
 		r.log.Error("root update failed", slog.Any("reason", err))
-		return errors.Join(err, tx.Rollback(ctx))
+		return err
+	}
+	_, err = ds.Conn.Exec(ds.Ctx, closeQuery, args)
+	if err != nil {
+		r.log.Error("query execution failed", slog.Any("reason", err))
+		return err
 	}
-	_, err = tx.Exec(ctx, snapQuery, args)
+	_, err = ds.Conn.Exec(ds.Ctx, stateQuery, args)
 	if err != nil {
 		r.log.Error("query execution failed", slog.Any("reason", err))
-		return errors.Join(err, tx.Rollback(ctx))
+		return err
 	}
-	r.log.Log(ctx, core.LevelTrace, "root update succeeded", slog.Any("role_id", root.ID))
-	return tx.Commit(ctx)
+	r.log.Log(ds.Ctx, core.LevelTrace, "root update succeeded", slog.Any("role_id", root.ID))
+	return nil
 }
 
-func (r *repoPgx) SelectRefs() ([]Ref, error) {
+func (r *repoPgx) SelectRefs(source data.Source) ([]Ref, error) {
 	query := `
 		SELECT
 			role_id, rev, title
 		FROM role_roots`
-	ctx := context.Background()
-	rows, err := r.pool.Query(ctx, query)
+	ds := data.MustConform[data.SourcePgx](source)
+	rows, err := ds.Conn.Query(ds.Ctx, query)
 	if err != nil {
 		r.log.Error("query execution failed", slog.Any("reason", err))
 		return nil, err
@@ -149,9 +150,9 @@ func (r *repoPgx) SelectRefs() ([]Ref, error) {
 	return DataToRefs(dtos)
 }
 
-func (r *repoPgx) SelectByID(rid ID) (Root, error) {
-	ctx := context.Background()
-	rows, err := r.pool.Query(ctx, selectById, rid.String())
+func (r *repoPgx) SelectByID(source data.Source, rid ID) (Root, error) {
+	ds := data.MustConform[data.SourcePgx](source)
+	rows, err := ds.Conn.Query(ds.Ctx, selectById, rid.String())
 	if err != nil {
 		r.log.Error("query execution failed", slog.Any("reason", err))
 		return Root{}, err
@@ -162,13 +163,13 @@ func (r *repoPgx) SelectByID(rid ID) (Root, error) {
 		r.log.Error("row collection failed", slog.Any("reason", err))
 		return Root{}, err
 	}
-	r.log.Log(ctx, core.LevelTrace, "selection succeeded", slog.Any("role_id", rid))
+	r.log.Log(ds.Ctx, core.LevelTrace, "selection succeeded", slog.Any("role_id", rid))
 	return DataToRoot(dto)
 }
 
-func (r *repoPgx) SelectByFQN(fqn sym.ADT) (Root, error) {
-	ctx := context.Background()
-	rows, err := r.pool.Query(ctx, selectByFQN, sym.ConvertToString(fqn))
+func (r *repoPgx) SelectByRev(source data.Source, rid ID, rv Rev) (Root, error) {
+	ds := data.MustConform[data.SourcePgx](source)
+	rows, err := ds.Conn.Query(ds.Ctx, selectByRev, rid.String(), rev.ConvertToInt(rv))
 	if err != nil {
 		r.log.Error("query execution failed", slog.Any("reason", err))
 		return Root{}, err
@@ -179,32 +180,40 @@ func (r *repoPgx) SelectByFQN(fqn sym.ADT) (Root, error) {
 		r.log.Error("row collection failed", slog.Any("reason", err))
 		return Root{}, err
 	}
-	r.log.Log(ctx, core.LevelTrace, "role selection succeeded", slog.Any("fqn", fqn))
+	r.log.Log(ds.Ctx, core.LevelTrace, "selection succeeded", slog.Any("role_id", rid), slog.Any("rev", rv))
 	return DataToRoot(dto)
 }
 
-func (r *repoPgx) SelectByIDs(ids []ID) ([]Root, error) {
-	if len(ids) == 0 {
-		return []Root{}, nil
+func (r *repoPgx) SelectByFQN(source data.Source, fqn sym.ADT) (Root, error) {
+	ds := data.MustConform[data.SourcePgx](source)
+	rows, err := ds.Conn.Query(ds.Ctx, selectByFQN, sym.ConvertToString(fqn))
+	if err != nil {
+		r.log.Error("query execution failed", slog.Any("reason", err))
+		return Root{}, err
 	}
-	query := `
-		select
-			role_id, rev, title, state_id, whole_id
-		from role_roots
-		where role_id = $1`
-	ctx := context.Background()
-	tx, err := r.pool.Begin(ctx)
+	defer rows.Close()
+	dto, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[rootData])
 	if err != nil {
-		return nil, err
+		r.log.Error("row collection failed", slog.Any("reason", err))
+		return Root{}, err
+	}
+	r.log.Log(ds.Ctx, core.LevelTrace, "role selection succeeded", slog.Any("fqn", fqn))
+	return DataToRoot(dto)
+}
+
+func (r *repoPgx) SelectByIDs(source data.Source, ids []ID) (_ []Root, err error) {
+	if len(ids) == 0 {
+		return []Root{}, nil
 	}
+	ds := data.MustConform[data.SourcePgx](source)
 	batch := pgx.Batch{}
 	for _, rid := range ids {
 		if rid.IsEmpty() {
 			return nil, id.ErrEmpty
 		}
-		batch.Queue(query, rid.String())
+		batch.Queue(selectById, rid.String())
 	}
-	br := tx.SendBatch(ctx, &batch)
+	br := ds.Conn.SendBatch(ds.Ctx, &batch)
 	defer func() {
 		err = errors.Join(err, br.Close())
 	}()
@@ -216,6 +225,7 @@ func (r *repoPgx) SelectByIDs(ids []ID) ([]Root, error) {
 				slog.Any("reason", err),
 				slog.Any("role_id", rid),
 			)
+			return nil, err
 		}
 		dto, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[rootData])
 		if err != nil {
@@ -223,50 +233,70 @@ func (r *repoPgx) SelectByIDs(ids []ID) ([]Root, error) {
 				slog.Any("reason", err),
 				slog.Any("role_id", rid),
 			)
+			return nil, err
 		}
 		dtos = append(dtos, dto)
 	}
-	if err != nil {
-		return nil, errors.Join(err, br.Close(), tx.Rollback(ctx))
-	}
-	err = br.Close()
-	if err != nil {
-		return nil, errors.Join(err, tx.Rollback(ctx))
-	}
-	r.log.Log(ctx, core.LevelTrace, "roots selection succeeded", slog.Any("dtos", dtos))
-	err = tx.Commit(ctx)
-	if err != nil {
-		return nil, errors.Join(err, br.Close(), tx.Rollback(ctx))
-	}
+	r.log.Log(ds.Ctx, core.LevelTrace, "roots selection succeeded", slog.Any("dtos", dtos))
 	return DataToRoots(dtos)
 }
 
-func (r *repoPgx) SelectEnv(fqns []sym.ADT) (map[sym.ADT]Root, error) {
-	roots, err := r.SelectByFQNs(fqns)
-	if err != nil {
-		return nil, err
+// roles missing by fqn are left out of env
+func (r *repoPgx) SelectEnv(source data.Source, fqns []sym.ADT) (map[sym.ADT]Root, error) {
+	env := make(map[sym.ADT]Root, len(fqns))
+	if len(fqns) == 0 {
+		return env, nil
 	}
-	env := make(map[sym.ADT]Root, len(roots))
-	for i, root := range roots {
-		env[fqns[i]] = root
+	ds := data.MustConform[data.SourcePgx](source)
+	batch := pgx.Batch{}
+	for _, fqn := range fqns {
+		batch.Queue(selectByFQN, sym.ConvertToString(fqn))
 	}
-	return env, nil
+	br := ds.Conn.SendBatch(ds.Ctx, &batch)
+	for _, fqn := range fqns {
+		rows, err := br.Query()
+		if err != nil {
+			r.log.Error("query execution failed",
+				slog.Any("reason", err),
+				slog.Any("fqn", fqn),
+			)
+			return nil, errors.Join(err, br.Close())
+		}
+		dtos, err := pgx.CollectRows(rows, pgx.RowToStructByName[rootData])
+		if err != nil {
+			r.log.Error("row collection failed",
+				slog.Any("reason", err),
+				slog.Any("fqn", fqn),
+			)
+			return nil, errors.Join(err, br.Close())
+		}
+		if len(dtos) == 0 {
+			continue
+		}
+		root, err := DataToRoot(dtos[0])
+		if err != nil {
+			r.log.Error("dto mapping failed",
+				slog.Any("reason", err),
+				slog.Any("fqn", fqn),
+			)
+			return nil, errors.Join(err, br.Close())
+		}
+		env[fqn] = root
+	}
+	r.log.Log(ds.Ctx, core.LevelTrace, "env selection succeeded", slog.Any("fqns", fqns))
+	return env, br.Close()
 }
 
-func (r *repoPgx) SelectByFQNs(fqns []sym.ADT) ([]Root, error) {
+func (r *repoPgx) SelectByFQNs(source data.Source, fqns []sym.ADT) (_ []Root, err error) {
 	if len(fqns) == 0 {
 		return []Root{}, nil
 	}
-	ctx := context.Background()
-	tx, err := r.pool.Begin(ctx)
-	if err != nil {
-		return nil, err
-	}
+	ds := data.MustConform[data.SourcePgx](source)
 	batch := pgx.Batch{}
 	for _, fqn := range fqns {
 		batch.Queue(selectByFQN, sym.ConvertToString(fqn))
 	}
-	br := tx.SendBatch(ctx, &batch)
+	br := ds.Conn.SendBatch(ds.Ctx, &batch)
 	defer func() {
 		err = errors.Join(err, br.Close())
 	}()
@@ -278,6 +308,7 @@ func (r *repoPgx) SelectByFQNs(fqns []sym.ADT) ([]Root, error) {
 				slog.Any("reason", err),
 				slog.Any("fqn", fqn),
 			)
+			return nil, err
 		}
 		dto, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[rootData])
 		if err != nil {
@@ -285,25 +316,48 @@ func (r *repoPgx) SelectByFQNs(fqns []sym.ADT) ([]Root, error) {
 				slog.Any("reason", err),
 				slog.Any("fqn", fqn),
 			)
+			return nil, err
 		}
 		dtos = append(dtos, dto)
 	}
+	r.log.Log(ds.Ctx, core.LevelTrace, "roots selection succeeded", slog.Any("dtos", dtos))
+	return DataToRoots(dtos)
+}
+
+func (r *repoPgx) SelectSigRefs(source data.Source, fqn sym.ADT) ([]SigRef, error) {
+	query := `
+		select
+			sr.sig_id, sr.rev, sr.title
+		from sig_roots sr
+		where exists (
+				select 1 from sig_pes sp
+				where sp.sig_id = sr.sig_id
+					and sp.role_fqn = $1
+					and sp.rev_to > sr.rev
+			)
+			or exists (
+				select 1 from sig_ces sc
+				where sc.sig_id = sr.sig_id
+					and sc.role_fqn = $1
+					and sc.rev_to > sr.rev
+			)`
+	ds := data.MustConform[data.SourcePgx](source)
+	rows, err := ds.Conn.Query(ds.Ctx, query, sym.ConvertToString(fqn))
 	if err != nil {
-		return nil, errors.Join(err, br.Close(), tx.Rollback(ctx))
-	}
-	err = br.Close()
-	if err != nil {
-		return nil, errors.Join(err, tx.Rollback(ctx))
+		r.log.Error("query execution failed", slog.Any("reason", err))
+		return nil, err
 	}
-	r.log.Log(ctx, core.LevelTrace, "roots selection succeeded", slog.Any("dtos", dtos))
-	err = tx.Commit(ctx)
+	defer rows.Close()
+	dtos, err := pgx.CollectRows(rows, pgx.RowToStructByName[sigRefData])
 	if err != nil {
-		return nil, errors.Join(err, br.Close(), tx.Rollback(ctx))
+		r.log.Error("row collection failed", slog.Any("reason", err))
+		return nil, err
 	}
-	return DataToRoots(dtos)
+	r.log.Log(ds.Ctx, core.LevelTrace, "sig refs selection succeeded", slog.Any("fqn", fqn))
+	return DataToSigRefs(dtos)
 }
 
-func (r *repoPgx) SelectParts(rid id.ADT) ([]Ref, error) {
+func (r *repoPgx) SelectParts(source data.Source, rid id.ADT) ([]Ref, error) {
 	query := `
 		SELECT
 			r.id,
@@ -313,8 +367,8 @@ func (r *repoPgx) SelectParts(rid id.ADT) ([]Ref, error) {
 		LEFT JOIN kinships k
 			ON r.id = k.child_id
 		WHERE k.parent_id = $1`
-	ctx := context.Background()
-	rows, err := r.pool.Query(ctx, query, rid.String())
+	ds := data.MustConform[data.SourcePgx](source)
+	rows, err := ds.Conn.Query(ds.Ctx, query, rid.String())
 	if err != nil {
 		return nil, err
 	}
@@ -332,6 +386,7 @@ const (
 			rr.role_id,
 			rr.rev,
 			rr.title,
+			rs.params,
 			rs.state_id,
 			null as whole_id
 		from role_roots rr
@@ -350,6 +405,7 @@ const (
 			rr.role_id,
 			rr.rev,
 			rr.title,
+			rs.params,
 			rs.state_id,
 			null as whole_id
 		from role_roots rr
@@ -358,4 +414,20 @@ const (
 			and rs.rev_from >= rr.rev
 			and rs.rev_to > rr.rev
 		where rr.role_id = $1`
+
+	selectByRev = `
+		select
+			rr.role_id,
+			$2::bigint as rev,
+			rr.title,
+			rs.params,
+			rs.state_id,
+			null as whole_id
+		from role_roots rr
+		left join role_states rs
+			on rs.role_id = rr.role_id
+			and rs.rev_from <= $2
+			and rs.rev_to > $2
+		where rr.role_id = $1
+			and rr.rev >= $2`
 )
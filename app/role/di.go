@@ -46,6 +46,7 @@ func cfgApiEcho(e *echo.Echo, h *handlerEcho) error {
 	e.POST("/api/v1/roles", h.PostOne)
 	e.GET("/api/v1/roles/:id", h.GetOne)
 	e.PATCH("/api/v1/roles/:id", h.PatchOne)
+	e.GET("/api/v1/roles/:id/diff", h.GetDiff)
 	return nil
 }
 
@@ -3,6 +3,7 @@ package role
 import (
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 
+	"smecalculus/rolevod/lib/core"
 	"smecalculus/rolevod/lib/id"
 	"smecalculus/rolevod/lib/rev"
 	"smecalculus/rolevod/lib/sym"
@@ -11,13 +12,15 @@ import (
 )
 
 type SpecMsg struct {
-	FQN   string        `json:"fqn"`
-	State state.SpecMsg `json:"state"`
+	FQN    string        `json:"fqn"`
+	Params []string      `json:"params,omitempty"`
+	State  state.SpecMsg `json:"state"`
 }
 
 func (dto SpecMsg) Validate() error {
 	return validation.ValidateStruct(&dto,
 		validation.Field(&dto.FQN, sym.Required...),
+		validation.Field(&dto.Params, validation.Each(core.NameRequired...)),
 		validation.Field(&dto.State, validation.Required),
 	)
 }
@@ -45,26 +48,56 @@ func (dto RefMsg) Validate() error {
 	)
 }
 
+type DiffMsg struct {
+	ID   string `param:"id"`
+	From int64  `query:"from"`
+	To   int64  `query:"to"`
+}
+
+func (dto DiffMsg) Validate() error {
+	return validation.ValidateStruct(&dto,
+		validation.Field(&dto.ID, id.Required...),
+		validation.Field(&dto.From, rev.Required...),
+		validation.Field(&dto.To, rev.Required...),
+	)
+}
+
 type SnapMsg struct {
-	ID    string        `json:"id" param:"id"`
-	Rev   int64         `json:"rev" query:"rev"`
-	Title string        `json:"title"`
-	FQN   string        `json:"fqn"`
-	State state.SpecMsg `json:"state"`
+	ID     string        `json:"id" param:"id"`
+	Rev    int64         `json:"rev" query:"rev"`
+	Title  string        `json:"title"`
+	FQN    string        `json:"fqn"`
+	Params []string      `json:"params,omitempty"`
+	State  state.SpecMsg `json:"state"`
+	Force  bool          `json:"-" query:"force"`
+	Impact *ImpactMsg    `json:"impact,omitempty"`
 }
 
 func (dto SnapMsg) Validate() error {
 	return validation.ValidateStruct(&dto,
 		validation.Field(&dto.ID, id.Required...),
 		validation.Field(&dto.Rev, rev.Optional...),
+		validation.Field(&dto.Params, validation.Each(core.NameRequired...)),
 		validation.Field(&dto.State, validation.Required),
 	)
 }
 
+type ImpactMsg struct {
+	Breaking bool        `json:"breaking"`
+	Sigs     []SigRefMsg `json:"sigs"`
+}
+
+type SigRefMsg struct {
+	ID    string `json:"id"`
+	Rev   int64  `json:"rev"`
+	Title string `json:"title"`
+}
+
 type RootMsg struct {
 	ID      string        `json:"id" param:"id"`
 	Rev     int64         `json:"rev"`
 	Title   string        `json:"title"`
+	Params  []string      `json:"params,omitempty"`
 	StateID string        `json:"state_id"`
 	State   state.SpecMsg `json:"state"`
 	Parts   []RefMsg      `json:"parts"`
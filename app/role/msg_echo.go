@@ -1,13 +1,19 @@
 package role
 
 import (
+	"errors"
+	"io"
 	"log/slog"
 	"net/http"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 
 	"smecalculus/rolevod/lib/core"
 	"smecalculus/rolevod/lib/id"
+	"smecalculus/rolevod/lib/rev"
+
+	"smecalculus/rolevod/internal/state"
 )
 
 // Adapter
@@ -23,7 +29,18 @@ func newHandlerEcho(a API, l *slog.Logger) *handlerEcho {
 
 func (h *handlerEcho) PostOne(c echo.Context) error {
 	var dto SpecMsg
-	err := c.Bind(&dto)
+	var err error
+	if isStateText(c.Request().Header.Get(echo.HeaderContentType)) {
+		err = echo.QueryParamsBinder(c).
+			String("fqn", &dto.FQN).
+			Strings("param", &dto.Params).
+			BindError()
+		if err == nil {
+			err = bindStateText(c, &dto.State)
+		}
+	} else {
+		err = c.Bind(&dto)
+	}
 	if err != nil {
 		h.log.Error("dto binding failed")
 		return err
@@ -43,6 +60,10 @@ func (h *handlerEcho) PostOne(c echo.Context) error {
 	snap, err := h.api.Create(spec)
 	if err != nil {
 		h.log.Error("role creation failed")
+		var problems state.Problems
+		if errors.As(err, &problems) {
+			return c.JSON(http.StatusUnprocessableEntity, state.MsgFromProblems(problems))
+		}
 		return err
 	}
 	h.log.Log(ctx, core.LevelTrace, "role posting succeeded", slog.Any("id", snap.ID))
@@ -71,12 +92,60 @@ func (h *handlerEcho) GetOne(c echo.Context) error {
 		h.log.Error("root retrieval failed")
 		return err
 	}
+	if isStateText(c.Request().Header.Get(echo.HeaderAccept)) {
+		return c.Blob(http.StatusOK, state.TextMIME, []byte(state.TextFromSpec(snap.State)))
+	}
 	return c.JSON(http.StatusOK, MsgFromSnap(snap))
 }
 
+func (h *handlerEcho) GetDiff(c echo.Context) error {
+	var dto DiffMsg
+	err := c.Bind(&dto)
+	if err != nil {
+		h.log.Error("dto binding failed")
+		return err
+	}
+	err = dto.Validate()
+	if err != nil {
+		h.log.Error("dto validation failed")
+		return err
+	}
+	rid, err := id.ConvertFromString(dto.ID)
+	if err != nil {
+		h.log.Error("dto mapping failed")
+		return err
+	}
+	changes, err := h.api.RetrieveDiff(rid, rev.ConvertFromInt(dto.From), rev.ConvertFromInt(dto.To))
+	if err != nil {
+		h.log.Error("diff retrieval failed")
+		return err
+	}
+	return c.JSON(http.StatusOK, state.MsgFromChanges(changes))
+}
+
 func (h *handlerEcho) PatchOne(c echo.Context) error {
 	var dto SnapMsg
-	err := c.Bind(&dto)
+	var err error
+	if isStateText(c.Request().Header.Get(echo.HeaderContentType)) {
+		err = (&echo.DefaultBinder{}).BindPathParams(c, &dto)
+		if err == nil {
+			err = echo.QueryParamsBinder(c).
+				Int64("rev", &dto.Rev).
+				Strings("param", &dto.Params).
+				BindError()
+		}
+		if err == nil {
+			err = bindStateText(c, &dto.State)
+		}
+	} else {
+		err = c.Bind(&dto)
+	}
+	if err != nil {
+		h.log.Error("dto binding failed")
+		return err
+	}
+	// query params are bound for GET and DELETE only
+	err = echo.QueryParamsBinder(c).Bool("force", &dto.Force).BindError()
 	if err != nil {
 		h.log.Error("dto binding failed")
 		return err
@@ -96,8 +165,32 @@ func (h *handlerEcho) PatchOne(c echo.Context) error {
 	resSnap, err := h.api.Modify(reqSnap)
 	if err != nil {
 		h.log.Error("role modification failed")
+		var problems state.Problems
+		if errors.As(err, &problems) {
+			return c.JSON(http.StatusUnprocessableEntity, state.MsgFromProblems(problems))
+		}
+		if errors.Is(err, ErrBreakingModification) {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
 		return err
 	}
 	h.log.Log(ctx, core.LevelTrace, "role patching succeeded", slog.Any("ref", ConvertSnapToRef(resSnap)))
 	return c.JSON(http.StatusOK, MsgFromSnap(resSnap))
 }
+
+func isStateText(header string) bool {
+	return strings.Contains(header, state.TextMIME)
+}
+
+func bindStateText(c echo.Context, dto *state.SpecMsg) error {
+	text, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return err
+	}
+	spec, err := state.TextToSpec(string(text))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	*dto = state.MsgFromSpec(spec)
+	return nil
+}
@@ -6,6 +6,9 @@ import (
 	"github.com/go-resty/resty/v2"
 
 	"smecalculus/rolevod/lib/id"
+	"smecalculus/rolevod/lib/rev"
+
+	"smecalculus/rolevod/internal/state"
 )
 
 // Adapter
@@ -72,3 +75,7 @@ func (c *clientResty) RetrieveSnap(entity Root) (Snap, error) {
 func (c *clientResty) RetreiveRefs() ([]Ref, error) {
 	return []Ref{}, nil
 }
+
+func (c *clientResty) RetrieveDiff(rid id.ADT, from, to rev.ADT) ([]state.Change, error) {
+	return []state.Change{}, nil
+}
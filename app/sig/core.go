@@ -1,15 +1,20 @@
 package sig
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"time"
 
+	"smecalculus/rolevod/lib/data"
 	"smecalculus/rolevod/lib/id"
 	"smecalculus/rolevod/lib/rev"
 	"smecalculus/rolevod/lib/sym"
 
 	"smecalculus/rolevod/internal/alias"
 	"smecalculus/rolevod/internal/chnl"
+	"smecalculus/rolevod/internal/step"
 
 	"smecalculus/rolevod/app/role"
 )
@@ -25,6 +30,10 @@ type Spec struct {
 	PE chnl.Spec
 	// Consumable Endpoints
 	CEs []chnl.Spec
+	// Process Definition, optional
+	Body step.Term
+	// Pending steps timeout, optional
+	Timeout time.Duration
 }
 
 type Ref struct {
@@ -39,15 +48,22 @@ type Snap struct {
 	Title string
 	CEs   []chnl.Spec
 	PE    chnl.Spec
+	Body  step.Term
+	// optional
+	Timeout time.Duration
 }
 
-// aka ExpDec or ExpDecDef without expression
+// aka ExpDec or ExpDecDef
 type Root struct {
 	ID    id.ADT
 	Rev   rev.ADT
 	Title string
 	CEs   []chnl.Spec
 	PE    chnl.Spec
+	// Endpoint keys are placeholders in body
+	Body step.Term
+	// optional
+	Timeout time.Duration
 }
 
 type API interface {
@@ -57,15 +73,22 @@ type API interface {
 	RetreiveRefs() ([]Ref, error)
 }
 
+// Checker type checks bodies, implemented by deal
+type Checker interface {
+	CheckBody(data.Source, Root) error
+}
+
 type service struct {
-	sigs    Repo
-	aliases alias.Repo
-	log     *slog.Logger
+	sigs     Repo
+	aliases  alias.Repo
+	checker  Checker
+	operator data.Operator
+	log      *slog.Logger
 }
 
-func newService(sigs Repo, aliases alias.Repo, l *slog.Logger) *service {
+func newService(sigs Repo, aliases alias.Repo, checker Checker, operator data.Operator, l *slog.Logger) *service {
 	name := slog.String("name", "sigService")
-	return &service{sigs, aliases, l.With(name)}
+	return &service{sigs, aliases, checker, operator, l.With(name)}
 }
 
 // for compilation purposes
@@ -73,10 +96,19 @@ func newAPI() API {
 	return &service{}
 }
 
-func (s *service) Incept(fqn sym.ADT) (Ref, error) {
+func (s *service) Incept(fqn sym.ADT) (ref Ref, err error) {
+	ctx := context.Background()
+	err = s.operator.Explicit(ctx, func(ds data.Source) error {
+		ref, err = s.incept(ds, fqn)
+		return err
+	})
+	return ref, err
+}
+
+func (s *service) incept(ds data.Source, fqn sym.ADT) (Ref, error) {
 	s.log.Debug("signature inception started", slog.Any("fqn", fqn))
 	newAlias := alias.Root{Sym: fqn, ID: id.New(), Rev: rev.Initial()}
-	err := s.aliases.Insert(newAlias)
+	err := s.aliases.Insert(ds, newAlias)
 	if err != nil {
 		s.log.Error("alias insertion failed",
 			slog.Any("reason", err),
@@ -89,7 +121,7 @@ func (s *service) Incept(fqn sym.ADT) (Ref, error) {
 		Rev:   newAlias.Rev,
 		Title: newAlias.Sym.Name(),
 	}
-	err = s.sigs.Insert(newRoot)
+	err = s.sigs.Insert(ds, newRoot)
 	if err != nil {
 		s.log.Error("signature insertion failed",
 			slog.Any("reason", err),
@@ -101,16 +133,37 @@ func (s *service) Incept(fqn sym.ADT) (Ref, error) {
 	return ConvertRootToRef(newRoot), nil
 }
 
-func (s *service) Create(spec Spec) (Root, error) {
+func (s *service) Create(spec Spec) (root Root, err error) {
+	ctx := context.Background()
+	err = s.operator.Explicit(ctx, func(ds data.Source) error {
+		root, err = s.create(ds, spec)
+		return err
+	})
+	return root, err
+}
+
+func (s *service) create(ds data.Source, spec Spec) (Root, error) {
 	s.log.Debug("signature creation started", slog.Any("spec", spec))
 	root := Root{
-		ID:    id.New(),
-		Rev:   rev.Initial(),
-		Title: spec.FQN.Name(),
-		PE:    spec.PE,
-		CEs:   spec.CEs,
+		ID:      id.New(),
+		Rev:     rev.Initial(),
+		Title:   spec.FQN.Name(),
+		PE:      spec.PE,
+		CEs:     spec.CEs,
+		Body:    spec.Body,
+		Timeout: spec.Timeout,
 	}
-	err := s.sigs.Insert(root)
+	if root.Body != nil {
+		err := s.checker.CheckBody(ds, root)
+		if err != nil {
+			s.log.Error("body checking failed",
+				slog.Any("reason", err),
+				slog.Any("sig", root),
+			)
+			return root, errBodyInvalid(err)
+		}
+	}
+	err := s.sigs.Insert(ds, root)
 	if err != nil {
 		s.log.Error("signature insertion failed",
 			slog.Any("reason", err),
@@ -122,24 +175,33 @@ func (s *service) Create(spec Spec) (Root, error) {
 	return root, nil
 }
 
-func (s *service) Retrieve(rid ID) (Root, error) {
-	root, err := s.sigs.SelectByID(rid)
+func (s *service) Retrieve(rid ID) (root Root, err error) {
+	ctx := context.Background()
+	err = s.operator.Implicit(ctx, func(ds data.Source) error {
+		root, err = s.sigs.SelectByID(ds, rid)
+		return err
+	})
 	if err != nil {
 		return Root{}, err
 	}
 	return root, nil
 }
 
-func (s *service) RetreiveRefs() ([]Ref, error) {
-	return s.sigs.SelectAll()
+func (s *service) RetreiveRefs() (refs []Ref, err error) {
+	ctx := context.Background()
+	err = s.operator.Implicit(ctx, func(ds data.Source) error {
+		refs, err = s.sigs.SelectAll(ds)
+		return err
+	})
+	return refs, err
 }
 
 type Repo interface {
-	Insert(Root) error
-	SelectAll() ([]Ref, error)
-	SelectByID(ID) (Root, error)
-	SelectByIDs([]ID) ([]Root, error)
-	SelectEnv([]ID) (map[ID]Root, error)
+	Insert(data.Source, Root) error
+	SelectAll(data.Source) ([]Ref, error)
+	SelectByID(data.Source, ID) (Root, error)
+	SelectByIDs(data.Source, []ID) ([]Root, error)
+	SelectEnv(data.Source, []ID) (map[ID]Root, error)
 }
 
 func CollectEnv(sigs []Root) []role.FQN {
@@ -160,6 +222,16 @@ var (
 	ConvertRootToRef func(Root) Ref
 )
 
+var ErrBodyInvalid = errors.New("signature body invalid")
+
+func errBodyInvalid(reason error) error {
+	return fmt.Errorf("%w: %v", ErrBodyInvalid, reason)
+}
+
+func ErrBodyMissing(rid ID) error {
+	return fmt.Errorf("signature body missing: %v", rid)
+}
+
 func ErrRootMissingInEnv(rid ID) error {
 	return fmt.Errorf("root missing in env: %v", rid)
 }
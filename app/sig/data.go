@@ -2,6 +2,7 @@ package sig
 
 import (
 	"smecalculus/rolevod/internal/chnl"
+	"smecalculus/rolevod/internal/step"
 )
 
 type refData struct {
@@ -16,12 +17,17 @@ type rootData struct {
 	Title string          `db:"title"`
 	CEs   []chnl.SpecData `db:"ces"`
 	PE    chnl.SpecData   `db:"pe"`
+	Body  *step.TermData  `db:"body"`
+	// in milliseconds
+	Timeout int64 `db:"timeout"`
 }
 
 // goverter:variables
 // goverter:output:format assign-variable
 // goverter:extend smecalculus/rolevod/lib/id:Convert.*
+// goverter:extend smecalculus/rolevod/lib/tm:Convert.*
 // goverter:extend smecalculus/rolevod/internal/state:Data.*
+// goverter:extend smecalculus/rolevod/internal/step:Data.*
 var (
 	DataToRef     func(refData) (Ref, error)
 	DataFromRef   func(Ref) refData
@@ -1,27 +1,25 @@
 package sig
 
 import (
-	"context"
 	"errors"
 	"log/slog"
 	"math"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 
 	"smecalculus/rolevod/lib/core"
+	"smecalculus/rolevod/lib/data"
 	"smecalculus/rolevod/lib/id"
 )
 
 // Adapter
 type repoPgx struct {
-	pool *pgxpool.Pool
-	log  *slog.Logger
+	log *slog.Logger
 }
 
-func newRepoPgx(p *pgxpool.Pool, l *slog.Logger) *repoPgx {
+func newRepoPgx(l *slog.Logger) *repoPgx {
 	name := slog.String("name", "sigRepoPgx")
-	return &repoPgx{p, l.With(name)}
+	return &repoPgx{l.With(name)}
 }
 
 // for compilation purposes
@@ -29,31 +27,29 @@ func newRepo() Repo {
 	return &repoPgx{}
 }
 
-func (r *repoPgx) Insert(root Root) error {
-	ctx := context.Background()
-	tx, err := r.pool.Begin(ctx)
-	if err != nil {
-		return err
-	}
+func (r *repoPgx) Insert(source data.Source, root Root) (err error) {
+	ds := data.MustConform[data.SourcePgx](source)
 	dto, err := DataFromRoot(root)
 	if err != nil {
 		return err
 	}
 	insertRoot := `
 		insert into sig_roots (
-			sig_id, rev, title
+			sig_id, rev, title, body, timeout
 		) VALUES (
-			@sig_id, @rev, @title
+			@sig_id, @rev, @title, @body, @timeout
 		)`
 	rootArgs := pgx.NamedArgs{
-		"sig_id": dto.ID,
-		"rev":    dto.Rev,
-		"title":  dto.Title,
+		"sig_id":  dto.ID,
+		"rev":     dto.Rev,
+		"title":   dto.Title,
+		"body":    dto.Body,
+		"timeout": dto.Timeout,
 	}
-	_, err = tx.Exec(ctx, insertRoot, rootArgs)
+	_, err = ds.Conn.Exec(ds.Ctx, insertRoot, rootArgs)
 	if err != nil {
 		r.log.Error("query execution failed", slog.Any("reason", err))
-		return errors.Join(err, tx.Rollback(ctx))
+		return err
 	}
 	insertPE := `
 		insert into sig_pes (
@@ -68,10 +64,10 @@ func (r *repoPgx) Insert(root Root) error {
 		"chnl_key": dto.PE.Key,
 		"role_fqn": dto.PE.Link,
 	}
-	_, err = tx.Exec(ctx, insertPE, peArgs)
+	_, err = ds.Conn.Exec(ds.Ctx, insertPE, peArgs)
 	if err != nil {
 		r.log.Error("query execution failed", slog.Any("reason", err))
-		return errors.Join(err, tx.Rollback(ctx))
+		return err
 	}
 	insertCE := `
 		insert into sig_ces (
@@ -90,7 +86,7 @@ func (r *repoPgx) Insert(root Root) error {
 		}
 		batch.Queue(insertCE, args)
 	}
-	br := tx.SendBatch(ctx, &batch)
+	br := ds.Conn.SendBatch(ds.Ctx, &batch)
 	defer func() {
 		err = errors.Join(err, br.Close())
 	}()
@@ -98,21 +94,15 @@ func (r *repoPgx) Insert(root Root) error {
 		_, err = br.Exec()
 		if err != nil {
 			r.log.Error("query execution failed", slog.Any("reason", err), slog.Any("ce", ce))
+			return err
 		}
 	}
-	if err != nil {
-		return errors.Join(err, br.Close(), tx.Rollback(ctx))
-	}
-	err = br.Close()
-	if err != nil {
-		return errors.Join(err, tx.Rollback(ctx))
-	}
-	return tx.Commit(ctx)
+	return nil
 }
 
-func (r *repoPgx) SelectByID(rid id.ADT) (Root, error) {
-	ctx := context.Background()
-	rows, err := r.pool.Query(ctx, selectById, rid.String())
+func (r *repoPgx) SelectByID(source data.Source, rid id.ADT) (Root, error) {
+	ds := data.MustConform[data.SourcePgx](source)
+	rows, err := ds.Conn.Query(ds.Ctx, selectById, rid.String())
 	if err != nil {
 		r.log.Error("query execution failed", slog.Any("reason", err))
 		return Root{}, err
@@ -123,12 +113,12 @@ func (r *repoPgx) SelectByID(rid id.ADT) (Root, error) {
 		r.log.Error("row collection failed", slog.Any("reason", err))
 		return Root{}, err
 	}
-	r.log.Log(ctx, core.LevelTrace, "signature selection succeeded", slog.Any("dto", dto))
+	r.log.Log(ds.Ctx, core.LevelTrace, "signature selection succeeded", slog.Any("dto", dto))
 	return DataToRoot(dto)
 }
 
-func (r *repoPgx) SelectEnv(ids []ID) (map[ID]Root, error) {
-	sigs, err := r.SelectByIDs(ids)
+func (r *repoPgx) SelectEnv(source data.Source, ids []ID) (map[ID]Root, error) {
+	sigs, err := r.SelectByIDs(source, ids)
 	if err != nil {
 		return nil, err
 	}
@@ -139,15 +129,11 @@ func (r *repoPgx) SelectEnv(ids []ID) (map[ID]Root, error) {
 	return env, nil
 }
 
-func (r *repoPgx) SelectByIDs(ids []ID) ([]Root, error) {
+func (r *repoPgx) SelectByIDs(source data.Source, ids []ID) (_ []Root, err error) {
 	if len(ids) == 0 {
 		return []Root{}, nil
 	}
-	ctx := context.Background()
-	tx, err := r.pool.Begin(ctx)
-	if err != nil {
-		return nil, err
-	}
+	ds := data.MustConform[data.SourcePgx](source)
 	batch := pgx.Batch{}
 	for _, rid := range ids {
 		if rid.IsEmpty() {
@@ -155,7 +141,7 @@ func (r *repoPgx) SelectByIDs(ids []ID) ([]Root, error) {
 		}
 		batch.Queue(selectById, rid.String())
 	}
-	br := tx.SendBatch(ctx, &batch)
+	br := ds.Conn.SendBatch(ds.Ctx, &batch)
 	defer func() {
 		err = errors.Join(err, br.Close())
 	}()
@@ -167,6 +153,7 @@ func (r *repoPgx) SelectByIDs(ids []ID) ([]Root, error) {
 				slog.Any("reason", err),
 				slog.Any("id", rid),
 			)
+			return nil, err
 		}
 		dto, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[rootData])
 		if err != nil {
@@ -174,31 +161,21 @@ func (r *repoPgx) SelectByIDs(ids []ID) ([]Root, error) {
 				slog.Any("reason", err),
 				slog.Any("id", rid),
 			)
+			return nil, err
 		}
 		dtos = append(dtos, dto)
 	}
-	if err != nil {
-		return nil, errors.Join(err, br.Close(), tx.Rollback(ctx))
-	}
-	err = br.Close()
-	if err != nil {
-		return nil, errors.Join(err, tx.Rollback(ctx))
-	}
-	r.log.Log(ctx, core.LevelTrace, "signatures selection succeeded", slog.Any("dtos", dtos))
-	err = tx.Commit(ctx)
-	if err != nil {
-		return nil, errors.Join(err, br.Close(), tx.Rollback(ctx))
-	}
+	r.log.Log(ds.Ctx, core.LevelTrace, "signatures selection succeeded", slog.Any("dtos", dtos))
 	return DataToRoots(dtos)
 }
 
-func (r *repoPgx) SelectAll() ([]Ref, error) {
+func (r *repoPgx) SelectAll(source data.Source) ([]Ref, error) {
 	query := `
 		select
 			sig_id, rev, title
 		from sig_roots`
-	ctx := context.Background()
-	rows, err := r.pool.Query(ctx, query)
+	ds := data.MustConform[data.SourcePgx](source)
+	rows, err := ds.Conn.Query(ds.Ctx, query)
 	if err != nil {
 		r.log.Error("query execution failed", slog.Any("reason", err))
 		return nil, err
@@ -218,6 +195,8 @@ const (
 			sr.sig_id,
 			sr.rev,
 			(array_agg(sr.title))[1] as title,
+			(array_agg(sr.body))[1] as body,
+			coalesce((array_agg(sr.timeout))[1], 0) as timeout,
 			(jsonb_agg(to_jsonb((select ep from (select sp.chnl_key, sp.role_fqn) ep))))[0] as pe,
 			jsonb_agg(to_jsonb((select ep from (select sc.chnl_key, sc.role_fqn) ep))) filter (where sc.sig_id is not null) as ces
 		from sig_roots sr
@@ -6,14 +6,19 @@ import (
 	"smecalculus/rolevod/lib/core"
 	"smecalculus/rolevod/lib/id"
 	"smecalculus/rolevod/lib/sym"
+	"smecalculus/rolevod/lib/tm"
 
 	"smecalculus/rolevod/internal/chnl"
+	"smecalculus/rolevod/internal/step"
 )
 
 type SpecMsg struct {
-	FQN string         `json:"fqn"`
-	PE  chnl.SpecMsg   `json:"pe"`
-	CEs []chnl.SpecMsg `json:"ces"`
+	FQN  string         `json:"fqn"`
+	PE   chnl.SpecMsg   `json:"pe"`
+	CEs  []chnl.SpecMsg `json:"ces"`
+	Body *step.TermMsg  `json:"body,omitempty"`
+	// e.g. 90s, optional
+	Timeout string `json:"timeout,omitempty"`
 }
 
 func (dto SpecMsg) Validate() error {
@@ -21,6 +26,8 @@ func (dto SpecMsg) Validate() error {
 		validation.Field(&dto.FQN, sym.Required...),
 		validation.Field(&dto.PE, validation.Required),
 		validation.Field(&dto.CEs, core.CtxOptional...),
+		validation.Field(&dto.Body),
+		validation.Field(&dto.Timeout, tm.DurationOptional...),
 	)
 }
 
@@ -52,6 +59,9 @@ type RootMsg struct {
 	Title string         `json:"title"`
 	PE    chnl.SpecMsg   `json:"pe"`
 	CEs   []chnl.SpecMsg `json:"ces"`
+	Body  *step.TermMsg  `json:"body,omitempty"`
+	// optional
+	Timeout string `json:"timeout,omitempty"`
 }
 
 type SnapMsg struct {
@@ -60,13 +70,18 @@ type SnapMsg struct {
 	Title string         `json:"title"`
 	PE    chnl.SpecMsg   `json:"pe"`
 	CEs   []chnl.SpecMsg `json:"ces"`
+	Body  *step.TermMsg  `json:"body,omitempty"`
+	// optional
+	Timeout string `json:"timeout,omitempty"`
 }
 
 // goverter:variables
 // goverter:output:format assign-variable
 // goverter:extend smecalculus/rolevod/lib/id:Convert.*
+// goverter:extend smecalculus/rolevod/lib/tm:Convert.*
 // goverter:extend smecalculus/rolevod/app/role:Msg.*
 // goverter:extend smecalculus/rolevod/internal/state:Msg.*
+// goverter:extend smecalculus/rolevod/internal/step:Msg.*
 var (
 	MsgToID      func(string) (id.ADT, error)
 	MsgFromID    func(id.ADT) string
@@ -1,6 +1,7 @@
 package sig
 
 import (
+	"errors"
 	"log/slog"
 	"net/http"
 
@@ -40,6 +41,9 @@ func (h *handlerEcho) PostOne(c echo.Context) error {
 		return err
 	}
 	root, err := h.api.Create(spec)
+	if errors.Is(err, ErrBodyInvalid) {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
 	if err != nil {
 		return err
 	}
@@ -7,6 +7,7 @@ CREATE TABLE role_roots (
 CREATE TABLE role_states (
 	role_id varchar(36),
 	state_id varchar(36),
+	params text[],
 	rev_from bigint,
 	rev_to bigint
 );
@@ -21,7 +22,9 @@ CREATE TABLE role_subs (
 CREATE TABLE sig_roots (
 	sig_id varchar(36),
 	rev bigint,
-	title text
+	title text,
+	body jsonb,
+	timeout bigint
 );
 
 CREATE TABLE sig_pes (
@@ -81,16 +84,16 @@ CREATE TABLE deals (
 );
 
 CREATE TABLE states (
-	id varchar(36),
+	id varchar(36) PRIMARY KEY,
 	kind smallint,
-	from_id varchar(36),
+	to_ids varchar(36)[],
 	spec jsonb
 );
 
 CREATE TABLE channels (
 	id varchar(36),
 	name varchar(64),
-	pre_id varchar(36),
+	pre_id varchar(36) UNIQUE,
 	state_id varchar(36)
 );
 
@@ -99,7 +102,60 @@ CREATE TABLE steps (
 	kind smallint,
 	pid varchar(36),
 	vid varchar(36),
-	spec jsonb
+	next_vid varchar(36),
+	spec jsonb,
+	deadline timestamptz,
+	interrupted_at timestamptz,
+	cause smallint
+);
+
+CREATE TABLE acquirers (
+	pos bigint GENERATED ALWAYS AS IDENTITY,
+	id varchar(36),
+	kind smallint,
+	pid varchar(36),
+	vid varchar(36),
+	next_vid varchar(36),
+	spec jsonb,
+	deadline timestamptz
+);
+
+CREATE INDEX acquirers_vid_idx ON acquirers (vid, pos);
+
+CREATE TABLE parts (
+	deal_id varchar(36),
+	pid varchar(36)
+);
+
+CREATE TABLE journal (
+	id varchar(36) PRIMARY KEY,
+	pid varchar(36),
+	term jsonb,
+	pre_vid varchar(36),
+	vid varchar(36),
+	pre_state_id varchar(36),
+	state_id varchar(36),
+	ak varchar(20),
+	taken_at timestamptz
+);
+
+CREATE INDEX journal_pid_idx ON journal (pid);
+
+CREATE TABLE receipts (
+	ik varchar(64) PRIMARY KEY,
+	pid varchar(36),
+	digest varchar(36),
+	taken_at timestamptz
+);
+
+CREATE TABLE cancellations (
+	pid varchar(36) PRIMARY KEY,
+	cancelled_at timestamptz
+);
+
+CREATE TABLE access_keys (
+	pid varchar(36) PRIMARY KEY,
+	ak varchar(20)
 );
 
 CREATE TABLE producers (
@@ -1,9 +1,9 @@
 package alias
 
 type rootData struct {
-	ID  string
-	Rev int64
-	Sym string
+	ID  string `db:"id"`
+	Rev int64  `db:"rev"`
+	Sym string `db:"sym"`
 }
 
 // goverter:variables
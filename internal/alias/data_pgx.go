@@ -1,32 +1,27 @@
 package alias
 
 import (
-	"context"
-	"errors"
 	"log/slog"
 	"math"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
+
+	"smecalculus/rolevod/lib/data"
+	"smecalculus/rolevod/lib/id"
 )
 
 // Adapter
 type repoPgx struct {
-	pool *pgxpool.Pool
-	log  *slog.Logger
+	log *slog.Logger
 }
 
-func newRepoPgx(p *pgxpool.Pool, l *slog.Logger) *repoPgx {
+func newRepoPgx(l *slog.Logger) *repoPgx {
 	name := slog.String("name", "aliasRepoPgx")
-	return &repoPgx{p, l.With(name)}
+	return &repoPgx{l.With(name)}
 }
 
-func (r *repoPgx) Insert(root Root) error {
-	ctx := context.Background()
-	tx, err := r.pool.Begin(ctx)
-	if err != nil {
-		return err
-	}
+func (r *repoPgx) Insert(source data.Source, root Root) error {
+	ds := data.MustConform[data.SourcePgx](source)
 	dto, err := DataFromRoot(root)
 	if err != nil {
 		return err
@@ -43,9 +38,32 @@ func (r *repoPgx) Insert(root Root) error {
 		"rev_to":   math.MaxInt64,
 		"sym":      dto.Sym,
 	}
-	_, err = tx.Exec(ctx, query, args)
+	_, err = ds.Conn.Exec(ds.Ctx, query, args)
+	if err != nil {
+		r.log.Error("query execution failed", slog.Any("reason", err))
+		return err
+	}
+	return nil
+}
+
+func (r *repoPgx) SelectByID(source data.Source, rid id.ADT) (Root, error) {
+	ds := data.MustConform[data.SourcePgx](source)
+	query := `
+		select
+			id, rev_from as rev, sym
+		from aliases
+		where id = $1
+			and rev_to = $2`
+	rows, err := ds.Conn.Query(ds.Ctx, query, rid.String(), math.MaxInt64)
+	if err != nil {
+		r.log.Error("query execution failed", slog.Any("reason", err))
+		return Root{}, err
+	}
+	defer rows.Close()
+	dto, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[rootData])
 	if err != nil {
-		return errors.Join(err, tx.Rollback(ctx))
+		r.log.Error("row collection failed", slog.Any("reason", err))
+		return Root{}, err
 	}
-	return tx.Commit(ctx)
+	return DataToRoot(dto)
 }
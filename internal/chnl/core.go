@@ -1,8 +1,10 @@
 package chnl
 
 import (
+	"errors"
 	"fmt"
 
+	"smecalculus/rolevod/lib/data"
 	"smecalculus/rolevod/lib/id"
 	"smecalculus/rolevod/lib/ph"
 	"smecalculus/rolevod/lib/sym"
@@ -35,15 +37,58 @@ type Root struct {
 	StateID *state.ID
 }
 
+// Ends are processes at both sides of a channel
+type Ends struct {
+	// Provider Process ID, aka root of channel history
+	ProviderID id.ADT
+	// Client Process ID, empty if channel is not owned
+	ClientID id.ADT
+}
+
+type EventKind int
+
+const (
+	// message sent via channel awaits receipt
+	Arrived = EventKind(iota + 1)
+	// service awaits message via channel
+	Awaited
+	// channel has got successor
+	Advanced
+	// channel has got closing successor
+	Closed
+)
+
+// Event is what happened to channel, delivered after commit
+type Event struct {
+	Kind EventKind
+	VID  ID
+	// Successor Channel ID, empty unless advanced or closed
+	NextID ID
+	// Step ID, empty unless arrived or awaited
+	SID id.ADT
+}
+
+// Topic addresses events of channel
+func Topic(vid ID) string {
+	return "chnl:" + vid.String()
+}
+
 type Repo interface {
-	Insert(Root) error
-	InsertCtx([]Root) ([]Root, error)
-	SelectAll() ([]Ref, error)
-	SelectByID(id.ADT) (Root, error)
-	SelectByIDs([]id.ADT) ([]Root, error)
-	SelectCtx(id.ADT, []id.ADT) ([]Root, error)
-	SelectCfg([]id.ADT) (map[id.ADT]Root, error)
-	Transfer(from id.ADT, to id.ADT, pids []id.ADT) error
+	Insert(data.Source, Root) error
+	InsertCtx(data.Source, []Root) ([]Root, error)
+	SelectAll(data.Source) ([]Ref, error)
+	SelectByID(data.Source, id.ADT) (Root, error)
+	SelectByIDs(data.Source, []id.ADT) ([]Root, error)
+	// selects latest successor of channel, reports false if there is none
+	SelectNext(data.Source, id.ADT) (Root, bool, error)
+	SelectCtx(data.Source, id.ADT, []id.ADT) ([]Root, error)
+	SelectCfg(data.Source, []id.ADT) (map[id.ADT]Root, error)
+	Transfer(source data.Source, from id.ADT, to id.ADT, pids []id.ADT) error
+	SelectEnds(data.Source, []id.ADT) (map[id.ADT]Ends, error)
+	// selects open channels provided by process or owned as client
+	SelectOwned(source data.Source, pid id.ADT) ([]Root, error)
+	// Lock serializes transitions on channels till the end of unit of work
+	Lock(data.Source, []id.ADT) error
 }
 
 func CollectCtx(roots []Root) []state.ID {
@@ -84,6 +129,18 @@ func ErrMissingInCtx(want ph.ADT) error {
 	return fmt.Errorf("channel missing in ctx: %v", want)
 }
 
+func ErrLeakedInCtx(got []ph.ADT) error {
+	return fmt.Errorf("channels leaked in ctx: %v", got)
+}
+
+func ErrDuplicatedInCtx(got ph.ADT) error {
+	return fmt.Errorf("channel duplicated in ctx: %v", got)
+}
+
+func ErrBranchMismatch(got ph.ADT) error {
+	return fmt.Errorf("channel consumed in some branches only: %v", got)
+}
+
 func ErrAlreadyClosed(got ID) error {
 	return fmt.Errorf("channel already closed: %v", got)
 }
@@ -91,3 +148,10 @@ func ErrAlreadyClosed(got ID) error {
 func ErrNotAnID(got ph.ADT) error {
 	return fmt.Errorf("not a channel id: %v", got)
 }
+
+// ErrConcurrentTake is retryable
+var ErrConcurrentTake = errors.New("channel taken concurrently")
+
+func errConcurrentTake(got []ID) error {
+	return fmt.Errorf("%w: %v", ErrConcurrentTake, got)
+}
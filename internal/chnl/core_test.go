@@ -0,0 +1,42 @@
+package chnl
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"smecalculus/rolevod/lib/id"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestConvertAdvanceErr(t *testing.T) {
+
+	t.Run("SecondSuccessor", func(t *testing.T) {
+		// given
+		preID := id.New()
+		pgErr := &pgconn.PgError{Code: uniqueViolation}
+		// when
+		err := convertAdvanceErr(pgErr, preID)
+		// then
+		if !errors.Is(err, ErrConcurrentTake) {
+			t.Errorf("unexpected error: want %v, got %v", ErrConcurrentTake, err)
+		}
+	})
+
+	t.Run("Other", func(t *testing.T) {
+		// given
+		pgErr := &pgconn.PgError{Code: "08006"}
+		// when
+		err := convertAdvanceErr(pgErr, id.New())
+		// then
+		if err != pgErr {
+			t.Errorf("unexpected error: want %v, got %v", pgErr, err)
+		}
+	})
+}
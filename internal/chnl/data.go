@@ -2,6 +2,9 @@ package chnl
 
 import (
 	"database/sql"
+	"encoding/json"
+
+	"smecalculus/rolevod/lib/id"
 )
 
 type SpecData struct {
@@ -21,6 +24,55 @@ type rootData struct {
 	StateID sql.NullString `db:"state_id"`
 }
 
+type endsData struct {
+	ProviderID sql.NullString `db:"provider_id"`
+	ClientID   sql.NullString `db:"client_id"`
+}
+
+type eventData struct {
+	K      EventKind `json:"k"`
+	VID    string    `json:"vid"`
+	NextID string    `json:"next_id,omitempty"`
+	SID    string    `json:"sid,omitempty"`
+}
+
+func DataFromEvent(ev Event) ([]byte, error) {
+	dto := eventData{K: ev.Kind, VID: ev.VID.String()}
+	if !ev.NextID.IsEmpty() {
+		dto.NextID = ev.NextID.String()
+	}
+	if !ev.SID.IsEmpty() {
+		dto.SID = ev.SID.String()
+	}
+	return json.Marshal(dto)
+}
+
+func DataToEvent(payload []byte) (Event, error) {
+	var dto eventData
+	err := json.Unmarshal(payload, &dto)
+	if err != nil {
+		return Event{}, err
+	}
+	vid, err := id.ConvertFromString(dto.VID)
+	if err != nil {
+		return Event{}, err
+	}
+	ev := Event{Kind: dto.K, VID: vid}
+	if dto.NextID != "" {
+		ev.NextID, err = id.ConvertFromString(dto.NextID)
+		if err != nil {
+			return Event{}, err
+		}
+	}
+	if dto.SID != "" {
+		ev.SID, err = id.ConvertFromString(dto.SID)
+		if err != nil {
+			return Event{}, err
+		}
+	}
+	return ev, nil
+}
+
 // goverter:variables
 // goverter:output:format assign-variable
 // goverter:extend smecalculus/rolevod/lib/id:Convert.*
@@ -40,4 +92,5 @@ var (
 	DataFromRoot  func(Root) (rootData, error)
 	DataToRoots   func([]rootData) ([]Root, error)
 	DataFromRoots func([]Root) ([]rootData, error)
+	DataToEnds    func(endsData) (Ends, error)
 )
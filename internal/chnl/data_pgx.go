@@ -1,27 +1,26 @@
 package chnl
 
 import (
-	"context"
 	"database/sql"
 	"errors"
 	"log/slog"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/pgconn"
 
 	"smecalculus/rolevod/lib/core"
+	"smecalculus/rolevod/lib/data"
 	"smecalculus/rolevod/lib/id"
 )
 
 // Adapter
 type repoPgx struct {
-	pool *pgxpool.Pool
-	log  *slog.Logger
+	log *slog.Logger
 }
 
-func newRepoPgx(p *pgxpool.Pool, l *slog.Logger) *repoPgx {
+func newRepoPgx(l *slog.Logger) *repoPgx {
 	name := slog.String("name", "chnlRepoPgx")
-	return &repoPgx{p, l.With(name)}
+	return &repoPgx{l.With(name)}
 }
 
 // for compilation purposes
@@ -29,12 +28,8 @@ func newRepo() Repo {
 	return &repoPgx{}
 }
 
-func (r *repoPgx) Insert(root Root) error {
-	ctx := context.Background()
-	tx, err := r.pool.Begin(ctx)
-	if err != nil {
-		return err
-	}
+func (r *repoPgx) Insert(source data.Source, root Root) error {
+	ds := data.MustConform[data.SourcePgx](source)
 	dto, err := DataFromRoot(root)
 	if err != nil {
 		return err
@@ -51,14 +46,51 @@ func (r *repoPgx) Insert(root Root) error {
 		"pre_id":   dto.PreID,
 		"state_id": dto.StateID,
 	}
-	_, err = tx.Exec(ctx, query, args)
+	_, err = ds.Conn.Exec(ds.Ctx, query, args)
 	if err != nil {
-		return errors.Join(err, tx.Rollback(ctx))
+		r.log.Error("query execution failed", slog.Any("reason", err))
+		if root.PreID != nil {
+			return convertAdvanceErr(err, *root.PreID)
+		}
+		return err
 	}
-	return tx.Commit(ctx)
+	if root.PreID == nil {
+		return nil
+	}
+	ev := Event{Kind: Advanced, VID: *root.PreID, NextID: root.ID}
+	if root.StateID == nil {
+		ev.Kind = Closed
+	}
+	return r.notify(ds, ev)
+}
+
+// version has at most one successor, so the second one
+// comes from the take that read configuration before the first
+func convertAdvanceErr(err error, preID ID) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return errConcurrentTake([]ID{preID})
+	}
+	return err
 }
 
-func (r *repoPgx) InsertCtx(roots []Root) (rs []Root, err error) {
+const uniqueViolation = "23505"
+
+func (r *repoPgx) notify(ds data.SourcePgx, ev Event) error {
+	payload, err := DataFromEvent(ev)
+	if err != nil {
+		return err
+	}
+	err = ds.Notify(Topic(ev.VID), payload)
+	if err != nil {
+		r.log.Error("notification failed", slog.Any("reason", err), slog.Any("event", ev))
+		return err
+	}
+	return nil
+}
+
+func (r *repoPgx) InsertCtx(source data.Source, roots []Root) (_ []Root, err error) {
+	ds := data.MustConform[data.SourcePgx](source)
 	query := `
 		INSERT INTO channels (
 			id, name, pre_id, state_id
@@ -68,11 +100,6 @@ func (r *repoPgx) InsertCtx(roots []Root) (rs []Root, err error) {
 		FROM channels
 		WHERE id = @id
 		RETURNING *`
-	ctx := context.Background()
-	tx, err := r.pool.Begin(ctx)
-	if err != nil {
-		return nil, err
-	}
 	batch := pgx.Batch{}
 	reqs, err := DataFromRoots(roots)
 	if err != nil {
@@ -90,18 +117,19 @@ func (r *repoPgx) InsertCtx(roots []Root) (rs []Root, err error) {
 		}
 		batch.Queue(query, args)
 	}
-	br := tx.SendBatch(ctx, &batch)
+	br := ds.Conn.SendBatch(ds.Ctx, &batch)
 	defer func() {
 		err = errors.Join(err, br.Close())
 	}()
 	var resps []rootData
-	for _, req := range reqs {
+	for i, req := range reqs {
 		rows, err := br.Query()
 		if err != nil {
 			r.log.Error("query execution failed",
 				slog.Any("reason", err),
 				slog.Any("req", req),
 			)
+			return nil, convertAdvanceErr(err, *roots[i].PreID)
 		}
 		resp, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[rootData])
 		if err != nil {
@@ -109,37 +137,37 @@ func (r *repoPgx) InsertCtx(roots []Root) (rs []Root, err error) {
 				slog.Any("reason", err),
 				slog.Any("req", req),
 			)
+			return nil, convertAdvanceErr(err, *roots[i].PreID)
 		}
 		resps = append(resps, resp)
 	}
+	r.log.Log(ds.Ctx, core.LevelTrace, "ctx insertion succeeded", slog.Any("resps", resps))
+	roots, err = DataToRoots(resps)
 	if err != nil {
-		return nil, errors.Join(err, br.Close(), tx.Rollback(ctx))
-	}
-	err = br.Close()
-	if err != nil {
-		return nil, errors.Join(err, tx.Rollback(ctx))
+		return nil, err
 	}
-	r.log.Log(ctx, core.LevelTrace, "ctx insertion succeeded", slog.Any("resps", resps))
-	err = tx.Commit(ctx)
-	if err != nil {
-		return nil, errors.Join(err, br.Close(), tx.Rollback(ctx))
+	for _, root := range roots {
+		err = r.notify(ds, Event{Kind: Advanced, VID: *root.PreID, NextID: root.ID})
+		if err != nil {
+			return nil, err
+		}
 	}
-	return DataToRoots(resps)
+	return roots, nil
 }
 
-func (r *repoPgx) SelectAll() ([]Ref, error) {
+func (r *repoPgx) SelectAll(source data.Source) ([]Ref, error) {
 	roots := make([]Ref, 5)
 	return roots, nil
 }
 
-func (r *repoPgx) SelectByID(rid ID) (Root, error) {
+func (r *repoPgx) SelectByID(source data.Source, rid ID) (Root, error) {
+	ds := data.MustConform[data.SourcePgx](source)
 	query := `
 		SELECT
 			id, name, pre_id, state_id
 		FROM channels
 		WHERE id = $1`
-	ctx := context.Background()
-	rows, err := r.pool.Query(ctx, query, rid.String())
+	rows, err := ds.Conn.Query(ds.Ctx, query, rid.String())
 	if err != nil {
 		r.log.Error("query execution failed", slog.Any("reason", err), slog.Any("id", rid))
 		return Root{}, err
@@ -150,14 +178,44 @@ func (r *repoPgx) SelectByID(rid ID) (Root, error) {
 		r.log.Error("row collection failed", slog.Any("reason", err), slog.Any("id", rid))
 		return Root{}, err
 	}
-	r.log.Log(ctx, core.LevelTrace, "channel selection succeeded", slog.Any("dto", dto))
+	r.log.Log(ds.Ctx, core.LevelTrace, "channel selection succeeded", slog.Any("dto", dto))
 	return DataToRoot(dto)
 }
 
-func (r *repoPgx) SelectCtx(pid ID, ids []ID) ([]Root, error) {
+func (r *repoPgx) SelectNext(source data.Source, rid ID) (Root, bool, error) {
+	ds := data.MustConform[data.SourcePgx](source)
+	// successor is unique, acquired versions of shared channels have no predecessor
+	query := `
+		SELECT
+			id, name, pre_id, state_id
+		FROM channels
+		WHERE pre_id = $1`
+	rows, err := ds.Conn.Query(ds.Ctx, query, rid.String())
+	if err != nil {
+		r.log.Error("query execution failed", slog.Any("reason", err), slog.Any("id", rid))
+		return Root{}, false, err
+	}
+	defer rows.Close()
+	dto, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[rootData])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Root{}, false, nil
+	}
+	if err != nil {
+		r.log.Error("row collection failed", slog.Any("reason", err), slog.Any("id", rid))
+		return Root{}, false, err
+	}
+	root, err := DataToRoot(dto)
+	if err != nil {
+		return Root{}, false, err
+	}
+	return root, true, nil
+}
+
+func (r *repoPgx) SelectCtx(source data.Source, pid ID, ids []ID) (_ []Root, err error) {
 	if len(ids) == 0 {
 		return []Root{}, nil
 	}
+	ds := data.MustConform[data.SourcePgx](source)
 	query := `
 		WITH RECURSIVE history AS (
 			SELECT seed.*
@@ -167,6 +225,13 @@ func (r *repoPgx) SelectCtx(pid ID, ids []ID) ([]Root, error) {
 			SELECT output.*
 			FROM channels output, history input
 			WHERE output.pre_id = input.id
+				-- consumers stay at the head of message queue
+				AND NOT EXISTS (
+					SELECT 1
+					FROM steps
+					WHERE vid = input.id
+						AND next_vid IS NOT NULL
+				)
 		)
 		SELECT h.id, h.name, h.pre_id, h.state_id
 		FROM history h
@@ -181,11 +246,6 @@ func (r *repoPgx) SelectCtx(pid ID, ids []ID) ([]Root, error) {
 			where pid = h.id
 			and from_id = $2
 		)`
-	ctx := context.Background()
-	tx, err := r.pool.Begin(ctx)
-	if err != nil {
-		return nil, err
-	}
 	batch := pgx.Batch{}
 	for _, rid := range ids {
 		if rid.IsEmpty() {
@@ -193,7 +253,7 @@ func (r *repoPgx) SelectCtx(pid ID, ids []ID) ([]Root, error) {
 		}
 		batch.Queue(query, rid.String(), pid.String())
 	}
-	br := tx.SendBatch(ctx, &batch)
+	br := ds.Conn.SendBatch(ds.Ctx, &batch)
 	defer func() {
 		err = errors.Join(err, br.Close())
 	}()
@@ -205,7 +265,7 @@ func (r *repoPgx) SelectCtx(pid ID, ids []ID) ([]Root, error) {
 				slog.Any("reason", err),
 				slog.Any("id", rid),
 			)
-			return nil, errors.Join(err, br.Close(), tx.Rollback(ctx))
+			return nil, err
 		}
 		dto, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[rootData])
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -216,24 +276,16 @@ func (r *repoPgx) SelectCtx(pid ID, ids []ID) ([]Root, error) {
 				slog.Any("reason", err),
 				slog.Any("id", rid),
 			)
-			return nil, errors.Join(err, br.Close(), tx.Rollback(ctx))
+			return nil, err
 		}
 		dtos = append(dtos, dto)
 	}
-	err = br.Close()
-	if err != nil {
-		return nil, errors.Join(err, tx.Rollback(ctx))
-	}
-	err = tx.Commit(ctx)
-	if err != nil {
-		return nil, errors.Join(err, tx.Rollback(ctx))
-	}
-	r.log.Log(ctx, core.LevelTrace, "ctx selection succeeded", slog.Any("dtos", dtos))
+	r.log.Log(ds.Ctx, core.LevelTrace, "ctx selection succeeded", slog.Any("dtos", dtos))
 	return DataToRoots(dtos)
 }
 
-func (r *repoPgx) SelectCfg(ids []ID) (map[ID]Root, error) {
-	chnls, err := r.SelectByIDs(ids)
+func (r *repoPgx) SelectCfg(source data.Source, ids []ID) (map[ID]Root, error) {
+	chnls, err := r.SelectByIDs(source, ids)
 	if err != nil {
 		return nil, err
 	}
@@ -244,20 +296,16 @@ func (r *repoPgx) SelectCfg(ids []ID) (map[ID]Root, error) {
 	return cfg, nil
 }
 
-func (r *repoPgx) SelectByIDs(ids []ID) (rs []Root, err error) {
+func (r *repoPgx) SelectByIDs(source data.Source, ids []ID) (_ []Root, err error) {
 	if len(ids) == 0 {
 		return []Root{}, nil
 	}
+	ds := data.MustConform[data.SourcePgx](source)
 	query := `
 		SELECT
-			id, name, pre_id, state_id, state
+			id, name, pre_id, state_id
 		FROM channels
 		WHERE id = $1`
-	ctx := context.Background()
-	tx, err := r.pool.Begin(ctx)
-	if err != nil {
-		return nil, err
-	}
 	batch := pgx.Batch{}
 	for _, rid := range ids {
 		if rid.IsEmpty() {
@@ -265,7 +313,7 @@ func (r *repoPgx) SelectByIDs(ids []ID) (rs []Root, err error) {
 		}
 		batch.Queue(query, rid.String())
 	}
-	br := tx.SendBatch(ctx, &batch)
+	br := ds.Conn.SendBatch(ds.Ctx, &batch)
 	defer func() {
 		err = errors.Join(err, br.Close())
 	}()
@@ -277,6 +325,7 @@ func (r *repoPgx) SelectByIDs(ids []ID) (rs []Root, err error) {
 				slog.Any("reason", err),
 				slog.Any("id", rid),
 			)
+			return nil, err
 		}
 		dto, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[rootData])
 		if err != nil {
@@ -284,36 +333,143 @@ func (r *repoPgx) SelectByIDs(ids []ID) (rs []Root, err error) {
 				slog.Any("reason", err),
 				slog.Any("id", rid),
 			)
+			return nil, err
 		}
 		dtos = append(dtos, dto)
 	}
-	if err != nil {
-		return nil, errors.Join(err, br.Close(), tx.Rollback(ctx))
+	r.log.Log(ds.Ctx, core.LevelTrace, "channels selection succeeded", slog.Any("dtos", dtos))
+	return DataToRoots(dtos)
+}
+
+func (r *repoPgx) SelectEnds(source data.Source, ids []ID) (_ map[ID]Ends, err error) {
+	if len(ids) == 0 {
+		return map[ID]Ends{}, nil
+	}
+	ds := data.MustConform[data.SourcePgx](source)
+	// client is the last one in the chain of transfers
+	query := `
+		WITH RECURSIVE history AS (
+			SELECT seed.id, seed.pre_id
+			FROM channels seed
+			WHERE id = $1
+			UNION ALL
+			SELECT input.id, input.pre_id
+			FROM channels input, history output
+			WHERE input.id = output.pre_id
+		)
+		SELECT
+			(SELECT id FROM history WHERE pre_id IS NULL) AS provider_id,
+			(SELECT cs.to_id
+				FROM clientships cs
+				WHERE cs.pid IN (SELECT id FROM history)
+					AND NOT EXISTS (
+						SELECT 1
+						FROM clientships next
+						WHERE next.pid IN (SELECT id FROM history)
+							AND next.from_id = cs.to_id
+					)
+				LIMIT 1) AS client_id`
+	batch := pgx.Batch{}
+	for _, rid := range ids {
+		if rid.IsEmpty() {
+			return nil, id.ErrEmpty
+		}
+		batch.Queue(query, rid.String())
 	}
-	err = br.Close()
+	br := ds.Conn.SendBatch(ds.Ctx, &batch)
+	defer func() {
+		err = errors.Join(err, br.Close())
+	}()
+	ends := make(map[ID]Ends, len(ids))
+	for _, rid := range ids {
+		rows, err := br.Query()
+		if err != nil {
+			r.log.Error("query execution failed",
+				slog.Any("reason", err),
+				slog.Any("id", rid),
+			)
+			return nil, err
+		}
+		dto, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[endsData])
+		if err != nil {
+			r.log.Error("row collection failed",
+				slog.Any("reason", err),
+				slog.Any("id", rid),
+			)
+			return nil, err
+		}
+		ends[rid], err = DataToEnds(dto)
+		if err != nil {
+			r.log.Error("dto mapping failed",
+				slog.Any("reason", err),
+				slog.Any("id", rid),
+			)
+			return nil, err
+		}
+	}
+	r.log.Log(ds.Ctx, core.LevelTrace, "ends selection succeeded", slog.Any("ends", ends))
+	return ends, nil
+}
+
+func (r *repoPgx) SelectOwned(source data.Source, pid ID) ([]Root, error) {
+	ds := data.MustConform[data.SourcePgx](source)
+	// ownership is recorded by clientships,
+	// latest versions are leaves of channel history
+	query := `
+		WITH RECURSIVE owned AS (
+			SELECT id
+			FROM channels
+			WHERE id = $1
+			UNION
+			SELECT cs.pid
+			FROM clientships cs
+			WHERE cs.to_id = $1
+				AND NOT EXISTS (
+					SELECT 1
+					FROM clientships next
+					WHERE next.pid = cs.pid
+						AND next.from_id = $1
+				)
+		), history AS (
+			SELECT seed.*
+			FROM channels seed
+			WHERE id IN (SELECT id FROM owned)
+			UNION ALL
+			SELECT output.*
+			FROM channels output, history input
+			WHERE output.pre_id = input.id
+		)
+		SELECT DISTINCT h.id, h.name, h.pre_id, h.state_id
+		FROM history h
+		WHERE h.state_id IS NOT NULL
+			AND NOT EXISTS (
+				SELECT 1
+				FROM channels
+				WHERE pre_id = h.id
+			)`
+	rows, err := ds.Conn.Query(ds.Ctx, query, pid.String())
 	if err != nil {
-		return nil, errors.Join(err, tx.Rollback(ctx))
+		r.log.Error("query execution failed", slog.Any("reason", err), slog.Any("pid", pid))
+		return nil, err
 	}
-	r.log.Log(ctx, core.LevelTrace, "channels selection succeeded", slog.Any("dtos", dtos))
-	err = tx.Commit(ctx)
+	defer rows.Close()
+	dtos, err := pgx.CollectRows(rows, pgx.RowToStructByName[rootData])
 	if err != nil {
-		return nil, errors.Join(err, br.Close(), tx.Rollback(ctx))
+		r.log.Error("rows collection failed", slog.Any("reason", err), slog.Any("pid", pid))
+		return nil, err
 	}
+	r.log.Log(ds.Ctx, core.LevelTrace, "owned channels selection succeeded", slog.Any("dtos", dtos))
 	return DataToRoots(dtos)
 }
 
-func (r *repoPgx) Transfer(from ID, to ID, pids []ID) (err error) {
+func (r *repoPgx) Transfer(source data.Source, from ID, to ID, pids []ID) (err error) {
+	ds := data.MustConform[data.SourcePgx](source)
 	query := `
 		INSERT INTO clientships (
 			from_id, to_id, pid
 		) VALUES (
 			@from_id, @to_id, @pid
 		)`
-	ctx := context.Background()
-	tx, err := r.pool.Begin(ctx)
-	if err != nil {
-		return err
-	}
 	batch := pgx.Batch{}
 	for _, pid := range pids {
 		args := pgx.NamedArgs{
@@ -323,7 +479,7 @@ func (r *repoPgx) Transfer(from ID, to ID, pids []ID) (err error) {
 		}
 		batch.Queue(query, args)
 	}
-	br := tx.SendBatch(ctx, &batch)
+	br := ds.Conn.SendBatch(ds.Ctx, &batch)
 	defer func() {
 		err = errors.Join(err, br.Close())
 	}()
@@ -334,19 +490,49 @@ func (r *repoPgx) Transfer(from ID, to ID, pids []ID) (err error) {
 				slog.Any("reason", err),
 				slog.Any("id", pid),
 			)
+			return err
 		}
 	}
-	if err != nil {
-		return errors.Join(err, br.Close(), tx.Rollback(ctx))
+	r.log.Log(ds.Ctx, core.LevelTrace, "context transfer succeeded")
+	return nil
+}
+
+func (r *repoPgx) Lock(source data.Source, ids []ID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	ds := data.MustConform[data.SourcePgx](source)
+	// locks are released on commit or rollback,
+	// try flavor doesn't wait, so lock order doesn't matter
+	query := `
+		SELECT id
+		FROM unnest($1::varchar[]) AS id
+		WHERE NOT pg_try_advisory_xact_lock(hashtextextended(id, 0))`
+	args := make([]string, 0, len(ids))
+	for _, rid := range ids {
+		args = append(args, rid.String())
 	}
-	err = br.Close()
+	rows, err := ds.Conn.Query(ds.Ctx, query, args)
 	if err != nil {
-		return errors.Join(err, tx.Rollback(ctx))
+		r.log.Error("query execution failed", slog.Any("reason", err), slog.Any("ids", ids))
+		return err
 	}
-	err = tx.Commit(ctx)
+	dtos, err := pgx.CollectRows(rows, pgx.RowTo[string])
 	if err != nil {
-		return errors.Join(err, tx.Rollback(ctx))
+		r.log.Error("rows collection failed", slog.Any("reason", err), slog.Any("ids", ids))
+		return err
+	}
+	if len(dtos) > 0 {
+		taken := make([]ID, 0, len(dtos))
+		for _, dto := range dtos {
+			rid, err := id.ConvertFromString(dto)
+			if err != nil {
+				return err
+			}
+			taken = append(taken, rid)
+		}
+		return errConcurrentTake(taken)
 	}
-	r.log.Log(ctx, core.LevelTrace, "context transfer succeeded")
+	r.log.Log(ds.Ctx, core.LevelTrace, "channels locking succeeded", slog.Any("ids", ids))
 	return nil
 }
@@ -39,18 +39,55 @@ type RootMsg struct {
 	StateID *string `json:"state_id"`
 }
 
+type EventMsg struct {
+	Kind   string `json:"kind"`
+	VID    string `json:"vid"`
+	NextID string `json:"next_id,omitempty"`
+	SID    string `json:"sid,omitempty"`
+}
+
+const (
+	ArrivedKind  = "arrived"
+	AwaitedKind  = "awaited"
+	AdvancedKind = "advanced"
+	ClosedKind   = "closed"
+)
+
+func MsgFromEvent(ev Event) EventMsg {
+	dto := EventMsg{VID: ev.VID.String()}
+	switch ev.Kind {
+	case Arrived:
+		dto.Kind = ArrivedKind
+	case Awaited:
+		dto.Kind = AwaitedKind
+	case Advanced:
+		dto.Kind = AdvancedKind
+	case Closed:
+		dto.Kind = ClosedKind
+	}
+	if !ev.NextID.IsEmpty() {
+		dto.NextID = ev.NextID.String()
+	}
+	if !ev.SID.IsEmpty() {
+		dto.SID = ev.SID.String()
+	}
+	return dto
+}
+
 // goverter:variables
 // goverter:output:format assign-variable
 // goverter:extend smecalculus/rolevod/lib/id:Convert.*
 // goverter:extend smecalculus/rolevod/lib/ak:Convert.*
 // goverter:extend smecalculus/rolevod/internal/state:Msg.*
 var (
-	MsgToSpec   func(SpecMsg) (Spec, error)
-	MsgFromSpec func(Spec) SpecMsg
-	MsgToRef    func(RefMsg) (Ref, error)
-	MsgFromRef  func(Ref) RefMsg
-	MsgToRoot   func(RootMsg) (Root, error)
-	MsgFromRoot func(Root) RootMsg
+	MsgToSpec    func(SpecMsg) (Spec, error)
+	MsgFromSpec  func(Spec) SpecMsg
+	MsgToRef     func(RefMsg) (Ref, error)
+	MsgFromRef   func(Ref) RefMsg
+	MsgToRoot    func(RootMsg) (Root, error)
+	MsgFromRoot  func(Root) RootMsg
+	MsgToRoots   func([]RootMsg) ([]Root, error)
+	MsgFromRoots func([]Root) []RootMsg
 )
 
 func MsgFromRefMap(refs map[Key]ID) []RefMsg {
@@ -1,9 +1,16 @@
 package state
 
 import (
+	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/maps"
 
 	"smecalculus/rolevod/lib/core"
+	"smecalculus/rolevod/lib/data"
 	"smecalculus/rolevod/lib/id"
 	"smecalculus/rolevod/lib/ph"
 	"smecalculus/rolevod/lib/pol"
@@ -24,10 +31,18 @@ func (OneSpec) spec() {}
 // aka TpName
 type LinkSpec struct {
 	Role sym.ADT
+	Args []Spec
 }
 
 func (LinkSpec) spec() {}
 
+// aka TpVar
+type VarSpec struct {
+	Name string
+}
+
+func (VarSpec) spec() {}
+
 type TensorSpec struct {
 	B Spec
 	C Spec
@@ -56,12 +71,14 @@ type WithSpec struct {
 
 func (WithSpec) spec() {}
 
+// aka Up
 type UpSpec struct {
 	A Spec
 }
 
 func (UpSpec) spec() {}
 
+// aka Down
 type DownSpec struct {
 	A Spec
 }
@@ -84,6 +101,12 @@ type LinkRef struct {
 
 func (r LinkRef) Ident() id.ADT { return r.ID }
 
+type VarRef struct {
+	ID id.ADT
+}
+
+func (r VarRef) Ident() id.ADT { return r.ID }
+
 type PlusRef struct {
 	ID id.ADT
 }
@@ -148,6 +171,7 @@ func (r OneRoot) Pol() pol.ADT { return pol.Pos }
 type LinkRoot struct {
 	ID   id.ADT
 	Role sym.ADT
+	Args []Root
 }
 
 func (LinkRoot) spec() {}
@@ -156,6 +180,20 @@ func (r LinkRoot) Ident() id.ADT { return r.ID }
 
 func (LinkRoot) Pol() pol.ADT { return pol.Zero }
 
+// aka TpVar
+type VarRoot struct {
+	ID   id.ADT
+	Name string
+	// position in role params
+	Index int
+}
+
+func (VarRoot) spec() {}
+
+func (r VarRoot) Ident() id.ADT { return r.ID }
+
+func (VarRoot) Pol() pol.ADT { return pol.Zero }
+
 // aka Internal Choice
 type PlusRoot struct {
 	ID      id.ADT
@@ -235,61 +273,182 @@ func (r DownRoot) Ident() id.ADT { return r.ID }
 func (r DownRoot) Pol() pol.ADT { return pol.Zero }
 
 type Context struct {
+	Shared map[ph.ADT]Root
 	Linear map[ph.ADT]Root
 }
 
+// Clone copies ctx, so that branches consume channels independently
+func (ctx Context) Clone() Context {
+	return Context{Shared: maps.Clone(ctx.Shared), Linear: maps.Clone(ctx.Linear)}
+}
+
+// aka TpDefs
+type Env map[sym.ADT]Root
+
+// Arity is number of params by role
+type Arity map[sym.ADT]int
+
 // Endpoint aka ChanTp
 type EP struct {
 	Z ph.ADT
 	C Root
 }
 
+// Problem is a well-formedness violation at the path into a spec
+type Problem struct {
+	Path string
+	Desc string
+}
+
+type Problems []Problem
+
+func (ps Problems) Error() string {
+	descs := make([]string, len(ps))
+	for i, p := range ps {
+		descs[i] = fmt.Sprintf("%v: %v", p.Path, p.Desc)
+	}
+	return fmt.Sprintf("spec ill-formed: %v", strings.Join(descs, "; "))
+}
+
+// Change is a difference at the path between two specs
+type Change struct {
+	Path string
+	Kind ChangeKind
+	Old  Spec
+	New  Spec
+}
+
+type ChangeKind string
+
+const (
+	LabelAdded   = ChangeKind("label_added")
+	LabelRemoved = ChangeKind("label_removed")
+	KindChanged  = ChangeKind("kind_changed")
+	RoleChanged  = ChangeKind("role_changed")
+	VarChanged   = ChangeKind("var_changed")
+)
+
 type Repo interface {
-	Insert(Root) error
-	SelectAll() ([]Ref, error)
-	SelectByID(id.ADT) (Root, error)
-	SelectByIDs([]id.ADT) ([]Root, error)
-	SelectEnv([]id.ADT) (map[id.ADT]Root, error)
+	Insert(data.Source, Root) error
+	SelectAll(data.Source) ([]Ref, error)
+	SelectByID(data.Source, id.ADT) (Root, error)
+	SelectByIDs(data.Source, []id.ADT) ([]Root, error)
+	SelectEnv(data.Source, []id.ADT) (map[id.ADT]Root, error)
 }
 
 func ConvertSpecToRoot(s Spec) Root {
+	return convertSpecRec(nil, s)
+}
+
+// ConvertDefToRoot substitutes params with their positions
+func ConvertDefToRoot(params []string, s Spec) Root {
+	return convertSpecRec(params, s)
+}
+
+func convertSpecRec(params []string, s Spec) Root {
 	if s == nil {
 		return nil
 	}
 	switch spec := s.(type) {
 	case OneSpec:
-		return OneRoot{ID: id.New()}
+		return newOneRoot()
 	case LinkSpec:
-		return LinkRoot{ID: id.New(), Role: spec.Role}
-	case TensorSpec:
-		return TensorRoot{
-			ID: id.New(),
-			B:  ConvertSpecToRoot(spec.B),
-			C:  ConvertSpecToRoot(spec.C),
+		var args []Root
+		for _, arg := range spec.Args {
+			args = append(args, convertSpecRec(params, arg))
 		}
+		return newLinkRoot(spec.Role, args)
+	case VarSpec:
+		return newVarRoot(spec.Name, slices.Index(params, spec.Name))
+	case TensorSpec:
+		return newTensorRoot(convertSpecRec(params, spec.B), convertSpecRec(params, spec.C))
 	case LolliSpec:
-		return LolliRoot{
-			ID: id.New(),
-			Y:  ConvertSpecToRoot(spec.Y),
-			Z:  ConvertSpecToRoot(spec.Z),
-		}
+		return newLolliRoot(convertSpecRec(params, spec.Y), convertSpecRec(params, spec.Z))
 	case WithSpec:
 		choices := make(map[core.Label]Root, len(spec.Choices))
 		for lab, st := range spec.Choices {
-			choices[lab] = ConvertSpecToRoot(st)
+			choices[lab] = convertSpecRec(params, st)
 		}
-		return WithRoot{ID: id.New(), Choices: choices}
+		return newWithRoot(choices)
 	case PlusSpec:
 		choices := make(map[core.Label]Root, len(spec.Choices))
 		for lab, st := range spec.Choices {
-			choices[lab] = ConvertSpecToRoot(st)
+			choices[lab] = convertSpecRec(params, st)
 		}
-		return PlusRoot{ID: id.New(), Choices: choices}
+		return newPlusRoot(choices)
+	case UpSpec:
+		return newUpRoot(convertSpecRec(params, spec.A))
+	case DownSpec:
+		return newDownRoot(convertSpecRec(params, spec.A))
 	default:
 		panic(ErrSpecTypeUnexpected(spec))
 	}
 }
 
+// Roots are identified by their structure,
+// so equal states share ids and storage.
+
+func newOneRoot() OneRoot {
+	return OneRoot{ID: digest("one")}
+}
+
+func newLinkRoot(role sym.ADT, args []Root) LinkRoot {
+	parts := []string{sym.ConvertToString(role)}
+	for _, arg := range args {
+		parts = append(parts, identOf(arg))
+	}
+	return LinkRoot{ID: digest("link", parts...), Role: role, Args: args}
+}
+
+func newVarRoot(name string, index int) VarRoot {
+	return VarRoot{ID: digest("var", name, strconv.Itoa(index)), Name: name, Index: index}
+}
+
+func newTensorRoot(b, c Root) TensorRoot {
+	return TensorRoot{ID: digest("tensor", identOf(b), identOf(c)), B: b, C: c}
+}
+
+func newLolliRoot(y, z Root) LolliRoot {
+	return LolliRoot{ID: digest("lolli", identOf(y), identOf(z)), Y: y, Z: z}
+}
+
+func newPlusRoot(choices map[core.Label]Root) PlusRoot {
+	return PlusRoot{ID: digest("plus", choiceParts(choices)...), Choices: choices}
+}
+
+func newWithRoot(choices map[core.Label]Root) WithRoot {
+	return WithRoot{ID: digest("with", choiceParts(choices)...), Choices: choices}
+}
+
+func newUpRoot(a Root) UpRoot {
+	return UpRoot{ID: digest("up", identOf(a)), A: a}
+}
+
+func newDownRoot(a Root) DownRoot {
+	return DownRoot{ID: digest("down", identOf(a)), A: a}
+}
+
+func choiceParts(choices map[core.Label]Root) []string {
+	labels := maps.Keys(choices)
+	slices.Sort(labels)
+	parts := make([]string, 0, len(labels)*2)
+	for _, l := range labels {
+		parts = append(parts, string(l), identOf(choices[l]))
+	}
+	return parts
+}
+
+func identOf(r Root) string {
+	if r == nil {
+		return ""
+	}
+	return r.Ident().String()
+}
+
+func digest(k string, parts ...string) id.ADT {
+	return id.FromDigest([]byte(k + "\x00" + strings.Join(parts, "\x00")))
+}
+
 func ConvertRootToSpec(r Root) Spec {
 	if r == nil {
 		return nil
@@ -298,7 +457,13 @@ func ConvertRootToSpec(r Root) Spec {
 	case OneRoot:
 		return OneSpec{}
 	case LinkRoot:
-		return LinkSpec{Role: root.Role}
+		var args []Spec
+		for _, arg := range root.Args {
+			args = append(args, ConvertRootToSpec(arg))
+		}
+		return LinkSpec{Role: root.Role, Args: args}
+	case VarRoot:
+		return VarSpec{Name: root.Name}
 	case TensorRoot:
 		return TensorSpec{
 			B: ConvertRootToSpec(root.B),
@@ -321,6 +486,10 @@ func ConvertRootToSpec(r Root) Spec {
 			choices[lab] = ConvertRootToSpec(st)
 		}
 		return PlusSpec{Choices: choices}
+	case UpRoot:
+		return UpSpec{A: ConvertRootToSpec(root.A)}
+	case DownRoot:
+		return DownSpec{A: ConvertRootToSpec(root.A)}
 	default:
 		panic(ErrRootTypeUnexpected(root))
 	}
@@ -334,8 +503,37 @@ func CheckRef(got, want ID) error {
 }
 
 // aka eqtp
+//
+// Links are compared by role name, without unfolding.
 func CheckSpec(got, want Spec) error {
 	switch wantSt := want.(type) {
+	case LinkSpec:
+		gotSt, ok := got.(LinkSpec)
+		if !ok {
+			return ErrSpecTypeMismatch(got, want)
+		}
+		if gotSt.Role != wantSt.Role {
+			return fmt.Errorf("link mismatch: want %q, got %q", wantSt.Role, gotSt.Role)
+		}
+		if len(gotSt.Args) != len(wantSt.Args) {
+			return errArgsMismatch(len(wantSt.Args), len(gotSt.Args))
+		}
+		for i := range wantSt.Args {
+			err := CheckSpec(gotSt.Args[i], wantSt.Args[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case VarSpec:
+		gotSt, ok := got.(VarSpec)
+		if !ok {
+			return ErrSpecTypeMismatch(got, want)
+		}
+		if gotSt.Name != wantSt.Name {
+			return fmt.Errorf("var mismatch: want %q, got %q", wantSt.Name, gotSt.Name)
+		}
+		return nil
 	case OneSpec:
 		_, ok := got.(OneSpec)
 		if !ok {
@@ -400,14 +598,65 @@ func CheckSpec(got, want Spec) error {
 			}
 		}
 		return nil
+	case UpSpec:
+		gotSt, ok := got.(UpSpec)
+		if !ok {
+			return ErrSpecTypeMismatch(got, want)
+		}
+		return CheckSpec(gotSt.A, wantSt.A)
+	case DownSpec:
+		gotSt, ok := got.(DownSpec)
+		if !ok {
+			return ErrSpecTypeMismatch(got, want)
+		}
+		return CheckSpec(gotSt.A, wantSt.A)
 	default:
 		panic(ErrSpecTypeUnexpected(want))
 	}
 }
 
 // aka eqtp
-func CheckRoot(got, want Root) error {
+func CheckRoot(env Env, got, want Root) error {
+	return checkRootRec(env, map[[2]string]bool{}, got, want)
+}
+
+// coinductive: a pair of links seen before is assumed to be equal
+func checkRootRec(env Env, seen map[[2]string]bool, got, want Root) error {
+	// structurally equal
+	if got.Ident() == want.Ident() {
+		return nil
+	}
+	gotLink, gotOK := got.(LinkRoot)
+	wantLink, wantOK := want.(LinkRoot)
+	if gotOK && wantOK && gotLink.Role == wantLink.Role && checkArgs(env, seen, gotLink.Args, wantLink.Args) == nil {
+		return nil
+	}
+	if gotOK || wantOK {
+		pair := [2]string{rootKey(got), rootKey(want)}
+		if seen[pair] {
+			return nil
+		}
+		seen[pair] = true
+		gotSt, err := Unfold(env, got)
+		if err != nil {
+			return err
+		}
+		wantSt, err := Unfold(env, want)
+		if err != nil {
+			return err
+		}
+		return checkRootRec(env, seen, gotSt, wantSt)
+	}
 	switch wantSt := want.(type) {
+	case VarRoot:
+		gotSt, ok := got.(VarRoot)
+		if !ok {
+			return ErrRootTypeMismatch(got, want)
+		}
+		if gotSt.Index != wantSt.Index {
+			return fmt.Errorf("var mismatch: want %q, got %q", wantSt.Name, gotSt.Name)
+		}
+		return nil
 	case OneRoot:
 		_, ok := got.(OneRoot)
 		if !ok {
@@ -419,21 +668,21 @@ func CheckRoot(got, want Root) error {
 		if !ok {
 			return ErrRootTypeMismatch(got, want)
 		}
-		err := CheckRoot(gotSt.B, wantSt.B)
+		err := checkRootRec(env, seen, gotSt.B, wantSt.B)
 		if err != nil {
 			return err
 		}
-		return CheckRoot(gotSt.C, wantSt.C)
+		return checkRootRec(env, seen, gotSt.C, wantSt.C)
 	case LolliRoot:
 		gotSt, ok := got.(LolliRoot)
 		if !ok {
 			return ErrRootTypeMismatch(got, want)
 		}
-		err := CheckRoot(gotSt.Y, wantSt.Y)
+		err := checkRootRec(env, seen, gotSt.Y, wantSt.Y)
 		if err != nil {
 			return err
 		}
-		return CheckRoot(gotSt.Z, wantSt.Z)
+		return checkRootRec(env, seen, gotSt.Z, wantSt.Z)
 	case PlusRoot:
 		gotSt, ok := got.(PlusRoot)
 		if !ok {
@@ -447,7 +696,7 @@ func CheckRoot(got, want Root) error {
 			if !ok {
 				return fmt.Errorf("label mismatch: want %q, got nothing", wantLab)
 			}
-			err := CheckRoot(gotChoice, wantChoice)
+			err := checkRootRec(env, seen, gotChoice, wantChoice)
 			if err != nil {
 				return err
 			}
@@ -466,17 +715,597 @@ func CheckRoot(got, want Root) error {
 			if !ok {
 				return fmt.Errorf("label mismatch: want %q, got nothing", wantLab)
 			}
-			err := CheckRoot(gotChoice, wantChoice)
+			err := checkRootRec(env, seen, gotChoice, wantChoice)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case UpRoot:
+		gotSt, ok := got.(UpRoot)
+		if !ok {
+			return ErrRootTypeMismatch(got, want)
+		}
+		return checkRootRec(env, seen, gotSt.A, wantSt.A)
+	case DownRoot:
+		gotSt, ok := got.(DownRoot)
+		if !ok {
+			return ErrRootTypeMismatch(got, want)
+		}
+		return checkRootRec(env, seen, gotSt.A, wantSt.A)
+	default:
+		panic(ErrRootTypeUnexpected(want))
+	}
+}
+
+// Subtype checks that got can be used wherever want is expected.
+// Internal choices may offer fewer labels and external choices may
+// accept more labels. Values of lollies are contravariant, everything
+// else is covariant.
+func Subtype(env Env, got, want Root) error {
+	return subtypeRec(env, map[[2]string]bool{}, got, want)
+}
+
+// coinductive: a pair of links seen before is assumed to be related
+func subtypeRec(env Env, seen map[[2]string]bool, got, want Root) error {
+	// structurally equal
+	if got.Ident() == want.Ident() {
+		return nil
+	}
+	gotLink, gotOK := got.(LinkRoot)
+	wantLink, wantOK := want.(LinkRoot)
+	if gotOK && wantOK && gotLink.Role == wantLink.Role && checkArgs(env, seen, gotLink.Args, wantLink.Args) == nil {
+		return nil
+	}
+	if gotOK || wantOK {
+		pair := [2]string{rootKey(got), rootKey(want)}
+		if seen[pair] {
+			return nil
+		}
+		seen[pair] = true
+		gotSt, err := Unfold(env, got)
+		if err != nil {
+			return err
+		}
+		wantSt, err := Unfold(env, want)
+		if err != nil {
+			return err
+		}
+		return subtypeRec(env, seen, gotSt, wantSt)
+	}
+	switch wantSt := want.(type) {
+	case VarRoot:
+		gotSt, ok := got.(VarRoot)
+		if !ok {
+			return ErrRootTypeMismatch(got, want)
+		}
+		if gotSt.Index != wantSt.Index {
+			return fmt.Errorf("var mismatch: want %q, got %q", wantSt.Name, gotSt.Name)
+		}
+		return nil
+	case OneRoot:
+		_, ok := got.(OneRoot)
+		if !ok {
+			return ErrRootTypeMismatch(got, want)
+		}
+		return nil
+	case TensorRoot:
+		gotSt, ok := got.(TensorRoot)
+		if !ok {
+			return ErrRootTypeMismatch(got, want)
+		}
+		err := subtypeRec(env, seen, gotSt.B, wantSt.B)
+		if err != nil {
+			return err
+		}
+		return subtypeRec(env, seen, gotSt.C, wantSt.C)
+	case LolliRoot:
+		gotSt, ok := got.(LolliRoot)
+		if !ok {
+			return ErrRootTypeMismatch(got, want)
+		}
+		// contravariant value
+		err := subtypeRec(env, seen, wantSt.Y, gotSt.Y)
+		if err != nil {
+			return err
+		}
+		return subtypeRec(env, seen, gotSt.Z, wantSt.Z)
+	case PlusRoot:
+		gotSt, ok := got.(PlusRoot)
+		if !ok {
+			return ErrRootTypeMismatch(got, want)
+		}
+		// width: got labels must be a subset of want labels
+		for gotLab, gotChoice := range gotSt.Choices {
+			wantChoice, ok := wantSt.Choices[gotLab]
+			if !ok {
+				return fmt.Errorf("label mismatch: want nothing, got %q", gotLab)
+			}
+			// depth
+			err := subtypeRec(env, seen, gotChoice, wantChoice)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case WithRoot:
+		gotSt, ok := got.(WithRoot)
+		if !ok {
+			return ErrRootTypeMismatch(got, want)
+		}
+		// width: want labels must be a subset of got labels
+		for wantLab, wantChoice := range wantSt.Choices {
+			gotChoice, ok := gotSt.Choices[wantLab]
+			if !ok {
+				return fmt.Errorf("label mismatch: want %q, got nothing", wantLab)
+			}
+			// depth
+			err := subtypeRec(env, seen, gotChoice, wantChoice)
 			if err != nil {
 				return err
 			}
 		}
 		return nil
+	case UpRoot:
+		gotSt, ok := got.(UpRoot)
+		if !ok {
+			return ErrRootTypeMismatch(got, want)
+		}
+		return subtypeRec(env, seen, gotSt.A, wantSt.A)
+	case DownRoot:
+		gotSt, ok := got.(DownRoot)
+		if !ok {
+			return ErrRootTypeMismatch(got, want)
+		}
+		return subtypeRec(env, seen, gotSt.A, wantSt.A)
 	default:
 		panic(ErrRootTypeUnexpected(want))
 	}
 }
 
+// aka ExpdTp
+func Unfold(env Env, r Root) (Root, error) {
+	seen := map[sym.ADT]bool{}
+	for {
+		link, ok := r.(LinkRoot)
+		if !ok {
+			return r, nil
+		}
+		if seen[link.Role] {
+			return nil, ErrNotContractive(link.Role)
+		}
+		seen[link.Role] = true
+		def, ok := env[link.Role]
+		if !ok {
+			return nil, ErrMissingInDefs(link.Role)
+		}
+		inst, err := Instantiate(def, link.Args)
+		if err != nil {
+			return nil, err
+		}
+		r = inst
+	}
+}
+
+// Instantiate substitutes args for params of def
+func Instantiate(def Root, args []Root) (Root, error) {
+	if def == nil {
+		return nil, nil
+	}
+	switch root := def.(type) {
+	case OneRoot:
+		return root, nil
+	case VarRoot:
+		if root.Index < 0 || root.Index >= len(args) {
+			return nil, ErrArgMissing(root.Name)
+		}
+		return args[root.Index], nil
+	case LinkRoot:
+		if len(root.Args) == 0 {
+			return root, nil
+		}
+		newArgs := make([]Root, len(root.Args))
+		for i, arg := range root.Args {
+			newArg, err := Instantiate(arg, args)
+			if err != nil {
+				return nil, err
+			}
+			newArgs[i] = newArg
+		}
+		return newLinkRoot(root.Role, newArgs), nil
+	case TensorRoot:
+		b, err := Instantiate(root.B, args)
+		if err != nil {
+			return nil, err
+		}
+		c, err := Instantiate(root.C, args)
+		if err != nil {
+			return nil, err
+		}
+		return newTensorRoot(b, c), nil
+	case LolliRoot:
+		y, err := Instantiate(root.Y, args)
+		if err != nil {
+			return nil, err
+		}
+		z, err := Instantiate(root.Z, args)
+		if err != nil {
+			return nil, err
+		}
+		return newLolliRoot(y, z), nil
+	case PlusRoot:
+		choices, err := instantiateChoices(root.Choices, args)
+		if err != nil {
+			return nil, err
+		}
+		return newPlusRoot(choices), nil
+	case WithRoot:
+		choices, err := instantiateChoices(root.Choices, args)
+		if err != nil {
+			return nil, err
+		}
+		return newWithRoot(choices), nil
+	case UpRoot:
+		a, err := Instantiate(root.A, args)
+		if err != nil {
+			return nil, err
+		}
+		return newUpRoot(a), nil
+	case DownRoot:
+		a, err := Instantiate(root.A, args)
+		if err != nil {
+			return nil, err
+		}
+		return newDownRoot(a), nil
+	default:
+		panic(ErrRootTypeUnexpected(def))
+	}
+}
+
+func instantiateChoices(choices map[core.Label]Root, args []Root) (map[core.Label]Root, error) {
+	newChoices := make(map[core.Label]Root, len(choices))
+	for lab, choice := range choices {
+		newChoice, err := Instantiate(choice, args)
+		if err != nil {
+			return nil, err
+		}
+		newChoices[lab] = newChoice
+	}
+	return newChoices, nil
+}
+
+// args are invariant
+func checkArgs(env Env, seen map[[2]string]bool, got, want []Root) error {
+	if len(got) != len(want) {
+		return errArgsMismatch(len(want), len(got))
+	}
+	for i := range want {
+		err := checkRootRec(env, seen, got[i], want[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// links are identified by role and args
+func rootKey(r Root) string {
+	link, ok := r.(LinkRoot)
+	if !ok {
+		return r.Ident().String()
+	}
+	keys := make([]string, len(link.Args))
+	for i, arg := range link.Args {
+		keys[i] = rootKey(arg)
+	}
+	return fmt.Sprintf("%v[%v]", link.Role, strings.Join(keys, ","))
+}
+
+func CollectEnv(roots []Root) []sym.ADT {
+	fqns := []sym.ADT{}
+	for _, r := range roots {
+		fqns = collectEnvRec(r, fqns)
+	}
+	return fqns
+}
+
+func collectEnvRec(r Root, fqns []sym.ADT) []sym.ADT {
+	switch root := r.(type) {
+	case LinkRoot:
+		for _, arg := range root.Args {
+			fqns = collectEnvRec(arg, fqns)
+		}
+		return append(fqns, root.Role)
+	case TensorRoot:
+		return collectEnvRec(root.C, collectEnvRec(root.B, fqns))
+	case LolliRoot:
+		return collectEnvRec(root.Z, collectEnvRec(root.Y, fqns))
+	case PlusRoot:
+		for _, choice := range root.Choices {
+			fqns = collectEnvRec(choice, fqns)
+		}
+		return fqns
+	case WithRoot:
+		for _, choice := range root.Choices {
+			fqns = collectEnvRec(choice, fqns)
+		}
+		return fqns
+	case UpRoot:
+		return collectEnvRec(root.A, fqns)
+	case DownRoot:
+		return collectEnvRec(root.A, fqns)
+	default:
+		return fqns
+	}
+}
+
+// CollectLinks collects roles referenced by spec links
+func CollectLinks(s Spec) []sym.ADT {
+	return collectLinksRec(s, []sym.ADT{})
+}
+
+func collectLinksRec(s Spec, fqns []sym.ADT) []sym.ADT {
+	switch spec := s.(type) {
+	case LinkSpec:
+		for _, arg := range spec.Args {
+			fqns = collectLinksRec(arg, fqns)
+		}
+		return append(fqns, spec.Role)
+	case TensorSpec:
+		return collectLinksRec(spec.C, collectLinksRec(spec.B, fqns))
+	case LolliSpec:
+		return collectLinksRec(spec.Z, collectLinksRec(spec.Y, fqns))
+	case PlusSpec:
+		for _, choice := range spec.Choices {
+			fqns = collectLinksRec(choice, fqns)
+		}
+		return fqns
+	case WithSpec:
+		for _, choice := range spec.Choices {
+			fqns = collectLinksRec(choice, fqns)
+		}
+		return fqns
+	case UpSpec:
+		return collectLinksRec(spec.A, fqns)
+	case DownSpec:
+		return collectLinksRec(spec.A, fqns)
+	default:
+		return fqns
+	}
+}
+
+// Validate checks that spec is a well-formed definition of fqn:
+// sub-specs and choices are present, links resolve through env,
+// links resolve through env with as many args as params in arity,
+// vars are bound by params and the definition is contractive.
+func Validate(env Env, arity Arity, fqn sym.ADT, params []string, s Spec) error {
+	problems := validateParams(params, nil)
+	problems = validateRec(env, arity, fqn, params, "state", s, problems)
+	if len(problems) == 0 {
+		err := checkContractive(env, fqn, s)
+		if err != nil {
+			problems = append(problems, Problem{"state", err.Error()})
+		}
+	}
+	if len(problems) > 0 {
+		return Problems(problems)
+	}
+	return nil
+}
+
+func validateParams(params []string, problems Problems) Problems {
+	for i, p := range params {
+		path := fmt.Sprintf("params[%v]", i)
+		if p == "" {
+			problems = append(problems, Problem{path, "param missing"})
+		}
+		if slices.Index(params, p) != i {
+			problems = append(problems, Problem{path, "param duplicated"})
+		}
+	}
+	return problems
+}
+
+func validateRec(env Env, arity Arity, fqn sym.ADT, params []string, path string, s Spec, problems Problems) Problems {
+	switch spec := s.(type) {
+	case nil:
+		return append(problems, Problem{path, "spec missing"})
+	case OneSpec:
+		return problems
+	case LinkSpec:
+		if spec.Role == "" {
+			return append(problems, Problem{path, "role missing"})
+		}
+		for i, arg := range spec.Args {
+			problems = validateRec(env, arity, fqn, params, fmt.Sprintf("%v.link.args[%v]", path, i), arg, problems)
+		}
+		if spec.Role == fqn {
+			// regular recursion keeps unfolding finite
+			if !slices.Equal(collectVars(spec.Args), params) {
+				return append(problems, Problem{path, "args must repeat params"})
+			}
+			return problems
+		}
+		def, ok := env[spec.Role]
+		if !ok || def == nil {
+			return append(problems, Problem{path, ErrMissingInDefs(spec.Role).Error()})
+		}
+		want, ok := arity[spec.Role]
+		if ok && want != len(spec.Args) {
+			return append(problems, Problem{path, errArgsMismatch(want, len(spec.Args)).Error()})
+		}
+		return problems
+	case VarSpec:
+		if !slices.Contains(params, spec.Name) {
+			return append(problems, Problem{path, ErrParamMissing(spec.Name).Error()})
+		}
+		return problems
+	case TensorSpec:
+		problems = validateRec(env, arity, fqn, params, path+".tensor.value", spec.B, problems)
+		return validateRec(env, arity, fqn, params, path+".tensor.cont", spec.C, problems)
+	case LolliSpec:
+		problems = validateRec(env, arity, fqn, params, path+".lolli.value", spec.Y, problems)
+		return validateRec(env, arity, fqn, params, path+".lolli.cont", spec.Z, problems)
+	case PlusSpec:
+		return validateChoices(env, arity, fqn, params, path+".plus", spec.Choices, problems)
+	case WithSpec:
+		return validateChoices(env, arity, fqn, params, path+".with", spec.Choices, problems)
+	case UpSpec:
+		return validateRec(env, arity, fqn, params, path+".up.cont", spec.A, problems)
+	case DownSpec:
+		return validateRec(env, arity, fqn, params, path+".down.cont", spec.A, problems)
+	default:
+		return append(problems, Problem{path, ErrSpecTypeUnexpected(s).Error()})
+	}
+}
+
+func validateChoices(env Env, arity Arity, fqn sym.ADT, params []string, path string, choices map[core.Label]Spec, problems Problems) Problems {
+	if len(choices) == 0 {
+		return append(problems, Problem{path + ".choices", "choices missing"})
+	}
+	labels := maps.Keys(choices)
+	slices.Sort(labels)
+	for _, l := range labels {
+		choicePath := fmt.Sprintf("%v.choices[%v]", path, l)
+		if l == "" {
+			problems = append(problems, Problem{choicePath, "label missing"})
+		}
+		problems = validateRec(env, arity, fqn, params, choicePath+".cont", choices[l], problems)
+	}
+	return problems
+}
+
+func collectVars(args []Spec) []string {
+	var names []string
+	for _, arg := range args {
+		v, ok := arg.(VarSpec)
+		if !ok {
+			return nil
+		}
+		names = append(names, v.Name)
+	}
+	return names
+}
+
+// a definition which is a bare chain of links back to itself
+// never reaches a constructor
+func checkContractive(env Env, fqn sym.ADT, s Spec) error {
+	link, ok := s.(LinkSpec)
+	if !ok {
+		return nil
+	}
+	seen := map[sym.ADT]bool{fqn: true}
+	for {
+		if seen[link.Role] {
+			return ErrNotContractive(fqn)
+		}
+		seen[link.Role] = true
+		def, ok := env[link.Role].(LinkRoot)
+		if !ok {
+			return nil
+		}
+		link = LinkSpec{Role: def.Role}
+	}
+}
+
+// Diff collects every change that turns old spec into new one
+func Diff(old, new Spec) []Change {
+	return diffRec("state", old, new, nil)
+}
+
+func diffRec(path string, old, new Spec, changes []Change) []Change {
+	switch o := old.(type) {
+	case OneSpec:
+		_, ok := new.(OneSpec)
+		if ok {
+			return changes
+		}
+	case LinkSpec:
+		n, ok := new.(LinkSpec)
+		if ok {
+			if o.Role != n.Role || len(o.Args) != len(n.Args) {
+				return append(changes, Change{path, RoleChanged, o, n})
+			}
+			for i := range o.Args {
+				changes = diffRec(fmt.Sprintf("%v.link.args[%v]", path, i), o.Args[i], n.Args[i], changes)
+			}
+			return changes
+		}
+	case VarSpec:
+		n, ok := new.(VarSpec)
+		if ok {
+			if o.Name != n.Name {
+				changes = append(changes, Change{path, VarChanged, o, n})
+			}
+			return changes
+		}
+	case TensorSpec:
+		n, ok := new.(TensorSpec)
+		if ok {
+			changes = diffRec(path+".tensor.value", o.B, n.B, changes)
+			return diffRec(path+".tensor.cont", o.C, n.C, changes)
+		}
+	case LolliSpec:
+		n, ok := new.(LolliSpec)
+		if ok {
+			changes = diffRec(path+".lolli.value", o.Y, n.Y, changes)
+			return diffRec(path+".lolli.cont", o.Z, n.Z, changes)
+		}
+	case PlusSpec:
+		n, ok := new.(PlusSpec)
+		if ok {
+			return diffChoices(path+".plus", o.Choices, n.Choices, changes)
+		}
+	case WithSpec:
+		n, ok := new.(WithSpec)
+		if ok {
+			return diffChoices(path+".with", o.Choices, n.Choices, changes)
+		}
+	case UpSpec:
+		n, ok := new.(UpSpec)
+		if ok {
+			return diffRec(path+".up.cont", o.A, n.A, changes)
+		}
+	case DownSpec:
+		n, ok := new.(DownSpec)
+		if ok {
+			return diffRec(path+".down.cont", o.A, n.A, changes)
+		}
+	case nil:
+		if new == nil {
+			return changes
+		}
+	default:
+		panic(ErrSpecTypeUnexpected(old))
+	}
+	return append(changes, Change{path, KindChanged, old, new})
+}
+
+func diffChoices(path string, old, new map[core.Label]Spec, changes []Change) []Change {
+	labels := maps.Keys(old)
+	for l := range new {
+		_, ok := old[l]
+		if !ok {
+			labels = append(labels, l)
+		}
+	}
+	slices.Sort(labels)
+	for _, l := range labels {
+		choicePath := fmt.Sprintf("%v.choices[%v]", path, l)
+		o, inOld := old[l]
+		n, inNew := new[l]
+		switch {
+		case !inNew:
+			changes = append(changes, Change{choicePath, LabelRemoved, o, nil})
+		case !inOld:
+			changes = append(changes, Change{choicePath, LabelAdded, nil, n})
+		default:
+			changes = diffRec(choicePath+".cont", o, n, changes)
+		}
+	}
+	return changes
+}
+
 func ErrSpecTypeUnexpected(got Spec) error {
 	return fmt.Errorf("spec type unexpected: %T", got)
 }
@@ -497,14 +1326,37 @@ func ErrMissingInCfg(want ID) error {
 	return fmt.Errorf("root missing in cfg: %v", want)
 }
 
+func ErrMissingInDefs(want sym.ADT) error {
+	return fmt.Errorf("role missing in defs: %v", want)
+}
+
+func ErrParamMissing(name string) error {
+	return fmt.Errorf("param missing for var: %v", name)
+}
+
+func ErrArgMissing(param string) error {
+	return fmt.Errorf("arg missing for param: %v", param)
+}
+
+func errArgsMismatch(want, got int) error {
+	return fmt.Errorf("args mismatch: want %v items, got %v items", want, got)
+}
+
+func ErrNotContractive(got sym.ADT) error {
+	return fmt.Errorf("role not contractive: %v", got)
+}
+
 func ErrRootTypeUnexpected(got Root) error {
 	return fmt.Errorf("root type unexpected: %T", got)
 }
 
+// ErrTypeMismatch marks errors that retrying won't fix
+var ErrTypeMismatch = errors.New("type mismatch")
+
 func ErrSpecTypeMismatch(got, want Spec) error {
-	return fmt.Errorf("spec type mismatch: want %T, got %T", want, got)
+	return fmt.Errorf("spec %w: want %T, got %T", ErrTypeMismatch, want, got)
 }
 
 func ErrRootTypeMismatch(got, want Root) error {
-	return fmt.Errorf("root type mismatch: want %T, got %T", want, got)
+	return fmt.Errorf("root %w: want %T, got %T", ErrTypeMismatch, want, got)
 }
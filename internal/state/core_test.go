@@ -0,0 +1,493 @@
+package state
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"smecalculus/rolevod/lib/core"
+	"smecalculus/rolevod/lib/id"
+	"smecalculus/rolevod/lib/ph"
+	"smecalculus/rolevod/lib/sym"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestCheckRoot(t *testing.T) {
+
+	t.Run("Recursive", func(t *testing.T) {
+		// given
+		queue := sym.New("queue")
+		// and
+		queueRoot := WithRoot{
+			ID: id.New(),
+			Choices: map[core.Label]Root{
+				"enq": LolliRoot{
+					ID: id.New(),
+					Y:  OneRoot{ID: id.New()},
+					Z:  LinkRoot{ID: id.New(), Role: queue},
+				},
+				"deq": PlusRoot{
+					ID: id.New(),
+					Choices: map[core.Label]Root{
+						"none": OneRoot{ID: id.New()},
+						"some": TensorRoot{
+							ID: id.New(),
+							B:  OneRoot{ID: id.New()},
+							C:  LinkRoot{ID: id.New(), Role: queue},
+						},
+					},
+				},
+			},
+		}
+		// and
+		env := Env{queue: queueRoot}
+		// when
+		err := CheckRoot(env, LinkRoot{ID: id.New(), Role: queue}, queueRoot)
+		// then
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("MutuallyRecursive", func(t *testing.T) {
+		// given
+		ping := sym.New("ping")
+		pong := sym.New("pong")
+		// and
+		env := Env{
+			ping: PlusRoot{
+				ID: id.New(),
+				Choices: map[core.Label]Root{
+					"ping": LinkRoot{ID: id.New(), Role: pong},
+				},
+			},
+			pong: PlusRoot{
+				ID: id.New(),
+				Choices: map[core.Label]Root{
+					"ping": LinkRoot{ID: id.New(), Role: ping},
+				},
+			},
+		}
+		// when
+		err := CheckRoot(env, LinkRoot{ID: id.New(), Role: ping}, LinkRoot{ID: id.New(), Role: pong})
+		// then
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Shared", func(t *testing.T) {
+		// given
+		counter := sym.New("counter")
+		// and
+		counterRoot := UpRoot{
+			ID: id.New(),
+			A: WithRoot{
+				ID: id.New(),
+				Choices: map[core.Label]Root{
+					"inc": DownRoot{
+						ID: id.New(),
+						A:  LinkRoot{ID: id.New(), Role: counter},
+					},
+				},
+			},
+		}
+		// and
+		env := Env{counter: counterRoot}
+		// when
+		err := CheckRoot(env, LinkRoot{ID: id.New(), Role: counter}, counterRoot)
+		// then
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		// and
+		err = CheckRoot(env, counterRoot.A, counterRoot)
+		if err == nil {
+			t.Errorf("unexpected success: want error for %+v", counterRoot.A)
+		}
+	})
+
+	t.Run("Parametric", func(t *testing.T) {
+		// given
+		list := sym.New("list")
+		// and
+		listSpec := PlusSpec{
+			Choices: map[core.Label]Spec{
+				"nil": OneSpec{},
+				"cons": TensorSpec{
+					B: VarSpec{Name: "a"},
+					C: LinkSpec{Role: list, Args: []Spec{VarSpec{Name: "a"}}},
+				},
+			},
+		}
+		// and
+		env := Env{list: ConvertDefToRoot([]string{"a"}, listSpec)}
+		// and
+		ones := LinkRoot{ID: id.New(), Role: list, Args: []Root{OneRoot{ID: id.New()}}}
+		pairs := LinkRoot{
+			ID:   id.New(),
+			Role: list,
+			Args: []Root{
+				TensorRoot{ID: id.New(), B: OneRoot{ID: id.New()}, C: OneRoot{ID: id.New()}},
+			},
+		}
+		// when
+		onesSt, err := Unfold(env, ones)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		err = CheckRoot(env, ones, onesSt)
+		// then
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		// and
+		err = CheckRoot(env, ones, pairs)
+		if err == nil {
+			t.Errorf("unexpected success: want error for %+v", pairs)
+		}
+	})
+
+	t.Run("LinkArgs", func(t *testing.T) {
+		// given
+		box := sym.New("box")
+		unit := sym.New("unit")
+		// and
+		env := Env{unit: OneRoot{ID: id.New()}}
+		// and
+		got := LinkRoot{ID: id.New(), Role: box, Args: []Root{LinkRoot{ID: id.New(), Role: unit}}}
+		want := LinkRoot{ID: id.New(), Role: box, Args: []Root{OneRoot{ID: id.New()}}}
+		// when
+		err := CheckRoot(env, got, want)
+		// then
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("NotContractive", func(t *testing.T) {
+		// given
+		a := sym.New("a")
+		b := sym.New("b")
+		// and
+		env := Env{
+			a: LinkRoot{ID: id.New(), Role: b},
+			b: LinkRoot{ID: id.New(), Role: a},
+		}
+		// when
+		err := CheckRoot(env, LinkRoot{ID: id.New(), Role: a}, OneRoot{ID: id.New()})
+		// then
+		if err == nil {
+			t.Errorf("unexpected success: want error for %q", a)
+		}
+	})
+}
+
+func TestSubtype(t *testing.T) {
+
+	t.Run("PlusWidth", func(t *testing.T) {
+		// given
+		narrow := PlusRoot{
+			ID: id.New(),
+			Choices: map[core.Label]Root{
+				"ok": OneRoot{ID: id.New()},
+			},
+		}
+		wide := PlusRoot{
+			ID: id.New(),
+			Choices: map[core.Label]Root{
+				"ok":  OneRoot{ID: id.New()},
+				"err": OneRoot{ID: id.New()},
+			},
+		}
+		// when
+		err := Subtype(Env{}, narrow, wide)
+		// then
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		// and
+		err = Subtype(Env{}, wide, narrow)
+		if err == nil {
+			t.Errorf("unexpected success: want error for %+v", wide)
+		}
+	})
+
+	t.Run("WithWidth", func(t *testing.T) {
+		// given
+		narrow := WithRoot{
+			ID: id.New(),
+			Choices: map[core.Label]Root{
+				"get": OneRoot{ID: id.New()},
+			},
+		}
+		wide := WithRoot{
+			ID: id.New(),
+			Choices: map[core.Label]Root{
+				"get": OneRoot{ID: id.New()},
+				"put": OneRoot{ID: id.New()},
+			},
+		}
+		// when
+		err := Subtype(Env{}, wide, narrow)
+		// then
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		// and
+		err = Subtype(Env{}, narrow, wide)
+		if err == nil {
+			t.Errorf("unexpected success: want error for %+v", narrow)
+		}
+	})
+
+	t.Run("LolliContravariance", func(t *testing.T) {
+		// given
+		narrow := PlusRoot{
+			ID: id.New(),
+			Choices: map[core.Label]Root{
+				"ok": OneRoot{ID: id.New()},
+			},
+		}
+		wide := PlusRoot{
+			ID: id.New(),
+			Choices: map[core.Label]Root{
+				"ok":  OneRoot{ID: id.New()},
+				"err": OneRoot{ID: id.New()},
+			},
+		}
+		// and
+		acceptsWide := LolliRoot{ID: id.New(), Y: wide, Z: OneRoot{ID: id.New()}}
+		acceptsNarrow := LolliRoot{ID: id.New(), Y: narrow, Z: OneRoot{ID: id.New()}}
+		// when
+		err := Subtype(Env{}, acceptsWide, acceptsNarrow)
+		// then
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		// and
+		err = Subtype(Env{}, acceptsNarrow, acceptsWide)
+		if err == nil {
+			t.Errorf("unexpected success: want error for %+v", acceptsNarrow)
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+
+	t.Run("Recursive", func(t *testing.T) {
+		// given
+		queue := sym.New("queue")
+		// and
+		queueSpec := WithSpec{
+			Choices: map[core.Label]Spec{
+				"enq": LolliSpec{Y: OneSpec{}, Z: LinkSpec{Role: queue}},
+				"deq": OneSpec{},
+			},
+		}
+		// when
+		err := Validate(Env{}, Arity{}, queue, nil, queueSpec)
+		// then
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("DanglingLink", func(t *testing.T) {
+		// given
+		a := sym.New("a")
+		b := sym.New("b")
+		// when
+		err := Validate(Env{}, Arity{}, a, nil, TensorSpec{B: LinkSpec{Role: b}, C: OneSpec{}})
+		// then
+		var problems Problems
+		if !errors.As(err, &problems) {
+			t.Fatalf("unexpected result: want problems, got %v", err)
+		}
+		// and
+		if len(problems) != 1 || problems[0].Path != "state.tensor.value" {
+			t.Errorf("unexpected problems: %+v", problems)
+		}
+	})
+
+	t.Run("ChoicesMissing", func(t *testing.T) {
+		// given
+		a := sym.New("a")
+		// when
+		err := Validate(Env{}, Arity{}, a, nil, PlusSpec{})
+		// then
+		var problems Problems
+		if !errors.As(err, &problems) {
+			t.Fatalf("unexpected result: want problems, got %v", err)
+		}
+		// and
+		if len(problems) != 1 || problems[0].Path != "state.plus.choices" {
+			t.Errorf("unexpected problems: %+v", problems)
+		}
+	})
+
+	t.Run("ParamMissing", func(t *testing.T) {
+		// given
+		a := sym.New("a")
+		// when
+		err := Validate(Env{}, Arity{}, a, []string{"x"}, TensorSpec{B: VarSpec{Name: "y"}, C: OneSpec{}})
+		// then
+		var problems Problems
+		if !errors.As(err, &problems) {
+			t.Fatalf("unexpected result: want problems, got %v", err)
+		}
+		// and
+		if len(problems) != 1 || problems[0].Path != "state.tensor.value" {
+			t.Errorf("unexpected problems: %+v", problems)
+		}
+	})
+
+	t.Run("ArityMismatch", func(t *testing.T) {
+		// given
+		a := sym.New("a")
+		b := sym.New("b")
+		// and
+		env := Env{b: OneRoot{ID: id.New()}}
+		arity := Arity{b: 1}
+		// when
+		err := Validate(env, arity, a, nil, LinkSpec{Role: b, Args: []Spec{OneSpec{}, OneSpec{}}})
+		// then
+		var problems Problems
+		if !errors.As(err, &problems) {
+			t.Fatalf("unexpected result: want problems, got %v", err)
+		}
+		// and
+		if len(problems) != 1 || problems[0].Path != "state" {
+			t.Errorf("unexpected problems: %+v", problems)
+		}
+	})
+
+	t.Run("NotContractive", func(t *testing.T) {
+		// given
+		a := sym.New("a")
+		b := sym.New("b")
+		// and
+		env := Env{b: LinkRoot{ID: id.New(), Role: a}}
+		// when
+		err := Validate(env, Arity{}, a, nil, LinkSpec{Role: b})
+		// then
+		if err == nil {
+			t.Errorf("unexpected success: want error for %q", a)
+		}
+	})
+}
+
+func TestDiff(t *testing.T) {
+
+	t.Run("Same", func(t *testing.T) {
+		// given
+		spec := PlusSpec{
+			Choices: map[core.Label]Spec{
+				"ok": TensorSpec{B: OneSpec{}, C: OneSpec{}},
+			},
+		}
+		// when
+		changes := Diff(spec, spec)
+		// then
+		if len(changes) != 0 {
+			t.Errorf("unexpected changes: %+v", changes)
+		}
+	})
+
+	t.Run("Changed", func(t *testing.T) {
+		// given
+		a := sym.New("a")
+		b := sym.New("b")
+		// and
+		old := WithSpec{
+			Choices: map[core.Label]Spec{
+				"get":  LinkSpec{Role: a},
+				"put":  OneSpec{},
+				"stop": OneSpec{},
+			},
+		}
+		new := WithSpec{
+			Choices: map[core.Label]Spec{
+				"get":  LinkSpec{Role: b},
+				"put":  LolliSpec{Y: OneSpec{}, Z: OneSpec{}},
+				"peek": OneSpec{},
+			},
+		}
+		// when
+		changes := Diff(old, new)
+		// then
+		want := []Change{
+			{"state.with.choices[get].cont", RoleChanged, LinkSpec{Role: a}, LinkSpec{Role: b}},
+			{"state.with.choices[peek]", LabelAdded, nil, OneSpec{}},
+			{"state.with.choices[put].cont", KindChanged, OneSpec{}, LolliSpec{Y: OneSpec{}, Z: OneSpec{}}},
+			{"state.with.choices[stop]", LabelRemoved, OneSpec{}, nil},
+		}
+		if !reflect.DeepEqual(changes, want) {
+			t.Errorf("unexpected changes: want %+v, got %+v", want, changes)
+		}
+	})
+}
+
+func TestConvertSpecToRoot(t *testing.T) {
+
+	t.Run("Structural", func(t *testing.T) {
+		// given
+		queue := sym.New("queue")
+		// and
+		spec := WithSpec{
+			Choices: map[core.Label]Spec{
+				"enq": LolliSpec{Y: OneSpec{}, Z: LinkSpec{Role: queue}},
+				"deq": TensorSpec{B: OneSpec{}, C: LinkSpec{Role: queue}},
+			},
+		}
+		// when
+		root1 := ConvertSpecToRoot(spec)
+		root2 := ConvertSpecToRoot(spec)
+		// then
+		if root1.Ident() != root2.Ident() {
+			t.Errorf("unexpected ids: want equal, got %v and %v", root1.Ident(), root2.Ident())
+		}
+		// and
+		with := root1.(WithRoot)
+		enq := with.Choices["enq"].(LolliRoot)
+		deq := with.Choices["deq"].(TensorRoot)
+		if enq.Y.Ident() != deq.B.Ident() || enq.Z.Ident() != deq.C.Ident() {
+			t.Errorf("unexpected ids: want shared sub-states, got %+v and %+v", enq, deq)
+		}
+		// and
+		if enq.Ident() == deq.Ident() {
+			t.Errorf("unexpected ids: want distinct constructors, got %v", enq.Ident())
+		}
+	})
+}
+
+func TestContextClone(t *testing.T) {
+
+	t.Run("Independent", func(t *testing.T) {
+		// given
+		x, y := id.New(), id.New()
+		ctx := Context{
+			Shared: map[ph.ADT]Root{},
+			Linear: map[ph.ADT]Root{x: OneRoot{ID: id.New()}},
+		}
+		// when
+		branch := ctx.Clone()
+		delete(branch.Linear, x)
+		branch.Linear[y] = OneRoot{ID: id.New()}
+		// then
+		_, ok := ctx.Linear[x]
+		if !ok {
+			t.Errorf("unexpected consumption: %v", x)
+		}
+		// and
+		_, ok = ctx.Linear[y]
+		if ok {
+			t.Errorf("unexpected addition: %v", y)
+		}
+	})
+}
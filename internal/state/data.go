@@ -1,7 +1,6 @@
 package state
 
 import (
-	"database/sql"
 	"fmt"
 
 	"smecalculus/rolevod/lib/core"
@@ -19,6 +18,9 @@ const (
 	lolli
 	plus
 	with
+	up
+	down
+	variable
 )
 
 type RefData struct {
@@ -32,18 +34,22 @@ type rootData struct {
 }
 
 type stateData struct {
-	ID     string         `db:"id"`
-	K      kind           `db:"kind"`
-	FromID sql.NullString `db:"from_id"`
-	Spec   specData       `db:"spec"`
+	ID    string   `db:"id"`
+	K     kind     `db:"kind"`
+	ToIDs []string `db:"to_ids"`
+	Spec  specData `db:"spec"`
 }
 
 type specData struct {
-	Link   string    `json:"link,omitempty"`
-	Tensor *prodData `json:"tensor,omitempty"`
-	Lolli  *prodData `json:"lolli,omitempty"`
-	Plus   []sumData `json:"plus,omitempty"`
-	With   []sumData `json:"with,omitempty"`
+	Link   string     `json:"link,omitempty"`
+	Args   []string   `json:"args,omitempty"`
+	Var    *varData   `json:"var,omitempty"`
+	Tensor *prodData  `json:"tensor,omitempty"`
+	Lolli  *prodData  `json:"lolli,omitempty"`
+	Plus   []sumData  `json:"plus,omitempty"`
+	With   []sumData  `json:"with,omitempty"`
+	Up     *shiftData `json:"up,omitempty"`
+	Down   *shiftData `json:"down,omitempty"`
 }
 
 type prodData struct {
@@ -56,6 +62,15 @@ type sumData struct {
 	Cont string `json:"to"`
 }
 
+type varData struct {
+	Name  string `json:"name"`
+	Index int    `json:"index"`
+}
+
+type shiftData struct {
+	Cont string `json:"to"`
+}
+
 // goverter:variables
 // goverter:output:format assign-variable
 // goverter:extend smecalculus/rolevod/lib/id:Convert.*
@@ -79,6 +94,8 @@ func DataFromRef(ref Ref) *RefData {
 		return &RefData{K: one, ID: rid}
 	case LinkRef, LinkRoot:
 		return &RefData{K: link, ID: rid}
+	case VarRef, VarRoot:
+		return &RefData{K: variable, ID: rid}
 	case TensorRef, TensorRoot:
 		return &RefData{K: tensor, ID: rid}
 	case LolliRef, LolliRoot:
@@ -87,6 +104,10 @@ func DataFromRef(ref Ref) *RefData {
 		return &RefData{K: plus, ID: rid}
 	case WithRef, WithRoot:
 		return &RefData{K: with, ID: rid}
+	case UpRef, UpRoot:
+		return &RefData{K: up, ID: rid}
+	case DownRef, DownRoot:
+		return &RefData{K: down, ID: rid}
 	default:
 		panic(ErrRefTypeUnexpected(ref))
 	}
@@ -105,6 +126,8 @@ func DataToRef(dto *RefData) (Ref, error) {
 		return OneRef{rid}, nil
 	case link:
 		return LinkRef{rid}, nil
+	case variable:
+		return VarRef{rid}, nil
 	case tensor:
 		return TensorRef{rid}, nil
 	case lolli:
@@ -113,6 +136,10 @@ func DataToRef(dto *RefData) (Ref, error) {
 		return PlusRef{rid}, nil
 	case with:
 		return WithRef{rid}, nil
+	case up:
+		return UpRef{rid}, nil
+	case down:
+		return DownRef{rid}, nil
 	default:
 		panic(errUnexpectedKind(dto.K))
 	}
@@ -134,7 +161,7 @@ func dataFromRoot(root Root) *rootData {
 		ID:     root.Ident().String(),
 		States: nil,
 	}
-	statesFromRoot("", root, dto)
+	statesFromRoot(root, dto)
 	return dto
 }
 
@@ -147,7 +174,17 @@ func statesToRoot(states map[string]stateData, st stateData) (Root, error) {
 	case one:
 		return OneRoot{ID: stID}, nil
 	case link:
-		return LinkRoot{ID: stID, Role: sym.CovertFromString(st.Spec.Link)}, nil
+		var args []Root
+		for _, argID := range st.Spec.Args {
+			arg, err := statesToRoot(states, states[argID])
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+		return LinkRoot{ID: stID, Role: sym.CovertFromString(st.Spec.Link), Args: args}, nil
+	case variable:
+		return VarRoot{ID: stID, Name: st.Spec.Var.Name, Index: st.Spec.Var.Index}, nil
 	case tensor:
 		b, err := statesToRoot(states, states[st.Spec.Tensor.Val])
 		if err != nil {
@@ -188,46 +225,73 @@ func statesToRoot(states map[string]stateData, st stateData) (Root, error) {
 			choices[core.Label(ch.Lab)] = choice
 		}
 		return WithRoot{ID: stID, Choices: choices}, nil
+	case up:
+		a, err := statesToRoot(states, states[st.Spec.Up.Cont])
+		if err != nil {
+			return nil, err
+		}
+		return UpRoot{ID: stID, A: a}, nil
+	case down:
+		a, err := statesToRoot(states, states[st.Spec.Down.Cont])
+		if err != nil {
+			return nil, err
+		}
+		return DownRoot{ID: stID, A: a}, nil
 	default:
 		panic(errUnexpectedKind(st.K))
 	}
 }
 
-func statesFromRoot(from string, r Root, dto *rootData) (string, error) {
-	var fromID sql.NullString
-	if len(from) > 0 {
-		fromID = sql.NullString{String: from, Valid: true}
-	}
+func statesFromRoot(r Root, dto *rootData) (string, error) {
 	stID := r.Ident().String()
 	switch root := r.(type) {
 	case OneRoot:
-		st := stateData{ID: stID, K: one, FromID: fromID}
+		st := stateData{ID: stID, K: one}
 		dto.States = append(dto.States, st)
 		return stID, nil
 	case LinkRoot:
+		var args []string
+		for _, arg := range root.Args {
+			argID, err := statesFromRoot(arg, dto)
+			if err != nil {
+				return "", err
+			}
+			args = append(args, argID)
+		}
 		st := stateData{
-			ID:     stID,
-			K:      link,
-			FromID: fromID,
+			ID:    stID,
+			K:     link,
+			ToIDs: args,
 			Spec: specData{
 				Link: sym.ConvertToString(root.Role),
+				Args: args,
+			},
+		}
+		dto.States = append(dto.States, st)
+		return stID, nil
+	case VarRoot:
+		st := stateData{
+			ID: stID,
+			K:  variable,
+			Spec: specData{
+				Var: &varData{root.Name, root.Index},
 			},
 		}
 		dto.States = append(dto.States, st)
 		return stID, nil
 	case TensorRoot:
-		val, err := statesFromRoot(stID, root.B, dto)
+		val, err := statesFromRoot(root.B, dto)
 		if err != nil {
 			return "", err
 		}
-		cont, err := statesFromRoot(stID, root.C, dto)
+		cont, err := statesFromRoot(root.C, dto)
 		if err != nil {
 			return "", err
 		}
 		st := stateData{
-			ID:     stID,
-			K:      tensor,
-			FromID: fromID,
+			ID:    stID,
+			K:     tensor,
+			ToIDs: []string{val, cont},
 			Spec: specData{
 				Tensor: &prodData{val, cont},
 			},
@@ -235,18 +299,18 @@ func statesFromRoot(from string, r Root, dto *rootData) (string, error) {
 		dto.States = append(dto.States, st)
 		return stID, nil
 	case LolliRoot:
-		val, err := statesFromRoot(stID, root.Y, dto)
+		val, err := statesFromRoot(root.Y, dto)
 		if err != nil {
 			return "", err
 		}
-		cont, err := statesFromRoot(stID, root.Z, dto)
+		cont, err := statesFromRoot(root.Z, dto)
 		if err != nil {
 			return "", err
 		}
 		st := stateData{
-			ID:     stID,
-			K:      lolli,
-			FromID: fromID,
+			ID:    stID,
+			K:     lolli,
+			ToIDs: []string{val, cont},
 			Spec: specData{
 				Lolli: &prodData{val, cont},
 			},
@@ -255,35 +319,65 @@ func statesFromRoot(from string, r Root, dto *rootData) (string, error) {
 		return stID, nil
 	case PlusRoot:
 		var choices []sumData
+		var conts []string
 		for label, choice := range root.Choices {
-			cont, err := statesFromRoot(stID, choice, dto)
+			cont, err := statesFromRoot(choice, dto)
 			if err != nil {
 				return "", err
 			}
 			choices = append(choices, sumData{string(label), cont})
+			conts = append(conts, cont)
 		}
 		st := stateData{
-			ID:     stID,
-			K:      plus,
-			FromID: fromID,
-			Spec:   specData{Plus: choices},
+			ID:    stID,
+			K:     plus,
+			ToIDs: conts,
+			Spec:  specData{Plus: choices},
 		}
 		dto.States = append(dto.States, st)
 		return stID, nil
 	case WithRoot:
 		var choices []sumData
+		var conts []string
 		for label, choice := range root.Choices {
-			cont, err := statesFromRoot(stID, choice, dto)
+			cont, err := statesFromRoot(choice, dto)
 			if err != nil {
 				return "", err
 			}
 			choices = append(choices, sumData{string(label), cont})
+			conts = append(conts, cont)
+		}
+		st := stateData{
+			ID:    stID,
+			K:     with,
+			ToIDs: conts,
+			Spec:  specData{With: choices},
+		}
+		dto.States = append(dto.States, st)
+		return stID, nil
+	case UpRoot:
+		cont, err := statesFromRoot(root.A, dto)
+		if err != nil {
+			return "", err
+		}
+		st := stateData{
+			ID:    stID,
+			K:     up,
+			ToIDs: []string{cont},
+			Spec:  specData{Up: &shiftData{cont}},
+		}
+		dto.States = append(dto.States, st)
+		return stID, nil
+	case DownRoot:
+		cont, err := statesFromRoot(root.A, dto)
+		if err != nil {
+			return "", err
 		}
 		st := stateData{
-			ID:     stID,
-			K:      with,
-			FromID: fromID,
-			Spec:   specData{With: choices},
+			ID:    stID,
+			K:     down,
+			ToIDs: []string{cont},
+			Spec:  specData{Down: &shiftData{cont}},
 		}
 		dto.States = append(dto.States, st)
 		return stID, nil
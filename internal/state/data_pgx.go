@@ -1,26 +1,24 @@
 package state
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"log/slog"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 
 	"smecalculus/rolevod/lib/core"
+	"smecalculus/rolevod/lib/data"
 )
 
 // Adapter
 type repoPgx struct {
-	pool *pgxpool.Pool
-	log  *slog.Logger
+	log *slog.Logger
 }
 
-func newRepoPgx(p *pgxpool.Pool, l *slog.Logger) *repoPgx {
+func newRepoPgx(l *slog.Logger) *repoPgx {
 	name := slog.String("name", "stateRepoPgx")
-	return &repoPgx{p, l.With(name)}
+	return &repoPgx{l.With(name)}
 }
 
 // for compilation purposes
@@ -28,30 +26,28 @@ func newRepo() Repo {
 	return &repoPgx{}
 }
 
-func (r *repoPgx) Insert(root Root) (err error) {
-	ctx := context.Background()
-	tx, err := r.pool.Begin(ctx)
-	if err != nil {
-		return err
-	}
+func (r *repoPgx) Insert(source data.Source, root Root) (err error) {
+	ds := data.MustConform[data.SourcePgx](source)
 	dto := dataFromRoot(root)
+	// equal states have equal ids and are stored once
 	query := `
 		INSERT INTO states (
-			id, kind, from_id, spec
+			id, kind, to_ids, spec
 		) VALUES (
-			@id, @kind, @from_id, @spec
-		)`
+			@id, @kind, @to_ids, @spec
+		)
+		ON CONFLICT (id) DO NOTHING`
 	batch := pgx.Batch{}
 	for _, st := range dto.States {
 		sa := pgx.NamedArgs{
-			"id":      st.ID,
-			"kind":    st.K,
-			"from_id": st.FromID,
-			"spec":    st.Spec,
+			"id":     st.ID,
+			"kind":   st.K,
+			"to_ids": st.ToIDs,
+			"spec":   st.Spec,
 		}
 		batch.Queue(query, sa)
 	}
-	br := tx.SendBatch(ctx, &batch)
+	br := ds.Conn.SendBatch(ds.Ctx, &batch)
 	defer func() {
 		err = errors.Join(err, br.Close())
 	}()
@@ -59,25 +55,19 @@ func (r *repoPgx) Insert(root Root) (err error) {
 		_, err = br.Exec()
 		if err != nil {
 			r.log.Error("query execution failed", slog.Any("reason", err), slog.Any("state", st))
+			return err
 		}
 	}
-	if err != nil {
-		return errors.Join(err, br.Close(), tx.Rollback(ctx))
-	}
-	err = br.Close()
-	if err != nil {
-		return errors.Join(err, tx.Rollback(ctx))
-	}
-	return tx.Commit(ctx)
+	return nil
 }
 
-func (r *repoPgx) SelectAll() ([]Ref, error) {
+func (r *repoPgx) SelectAll(source data.Source) ([]Ref, error) {
+	ds := data.MustConform[data.SourcePgx](source)
 	query := `
 		SELECT
 			kind, id
 		FROM states`
-	ctx := context.Background()
-	rows, err := r.pool.Query(ctx, query)
+	rows, err := ds.Conn.Query(ds.Ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -89,22 +79,9 @@ func (r *repoPgx) SelectAll() ([]Ref, error) {
 	return DataToRefs(dtos)
 }
 
-func (r *repoPgx) SelectByID(rid ID) (Root, error) {
-	query := `
-		WITH RECURSIVE top_states AS (
-			SELECT
-				rs.*
-			FROM states rs
-			WHERE id = $1
-			UNION ALL
-			SELECT
-				bs.*
-			FROM states bs, top_states ts
-			WHERE bs.from_id = ts.id
-		)
-		SELECT * FROM top_states`
-	ctx := context.Background()
-	rows, err := r.pool.Query(ctx, query, rid.String())
+func (r *repoPgx) SelectByID(source data.Source, rid ID) (Root, error) {
+	ds := data.MustConform[data.SourcePgx](source)
+	rows, err := ds.Conn.Query(ds.Ctx, selectByID, rid.String())
 	if err != nil {
 		r.log.Error("query execution failed", slog.Any("reason", err), slog.Any("root", rid))
 		return nil, err
@@ -120,7 +97,7 @@ func (r *repoPgx) SelectByID(rid ID) (Root, error) {
 		r.log.Error("state selection failed", slog.Any("reason", err), slog.Any("root", rid))
 		return nil, err
 	}
-	r.log.Log(ctx, core.LevelTrace, "state selection succeeded", slog.Any("dtos", dtos))
+	r.log.Log(ds.Ctx, core.LevelTrace, "state selection succeeded", slog.Any("dtos", dtos))
 	states := make(map[string]stateData, len(dtos))
 	for _, dto := range dtos {
 		states[dto.ID] = dto
@@ -128,8 +105,8 @@ func (r *repoPgx) SelectByID(rid ID) (Root, error) {
 	return statesToRoot(states, states[rid.String()])
 }
 
-func (r *repoPgx) SelectEnv(ids []ID) (map[ID]Root, error) {
-	states, err := r.SelectByIDs(ids)
+func (r *repoPgx) SelectEnv(source data.Source, ids []ID) (map[ID]Root, error) {
+	states, err := r.SelectByIDs(source, ids)
 	if err != nil {
 		return nil, err
 	}
@@ -140,17 +117,16 @@ func (r *repoPgx) SelectEnv(ids []ID) (map[ID]Root, error) {
 	return env, nil
 }
 
-func (r *repoPgx) SelectByIDs(ids []ID) (rs []Root, err error) {
-	ctx := context.Background()
-	tx, err := r.pool.Begin(ctx)
-	if err != nil {
-		return nil, err
+func (r *repoPgx) SelectByIDs(source data.Source, ids []ID) (_ []Root, err error) {
+	if len(ids) == 0 {
+		return []Root{}, nil
 	}
+	ds := data.MustConform[data.SourcePgx](source)
 	batch := pgx.Batch{}
 	for _, rid := range ids {
 		batch.Queue(selectByID, rid.String())
 	}
-	br := tx.SendBatch(ctx, &batch)
+	br := ds.Conn.SendBatch(ds.Ctx, &batch)
 	defer func() {
 		err = errors.Join(err, br.Close())
 	}()
@@ -162,6 +138,7 @@ func (r *repoPgx) SelectByIDs(ids []ID) (rs []Root, err error) {
 				slog.Any("reason", err),
 				slog.Any("id", rid),
 			)
+			return nil, err
 		}
 		dtos, err := pgx.CollectRows(rows, pgx.RowToStructByName[stateData])
 		if err != nil {
@@ -169,12 +146,14 @@ func (r *repoPgx) SelectByIDs(ids []ID) (rs []Root, err error) {
 				slog.Any("reason", err),
 				slog.Any("id", rid),
 			)
+			return nil, err
 		}
 		if len(dtos) == 0 {
 			err = ErrDoesNotExist(rid)
 			r.log.Error("state selection failed",
 				slog.Any("reason", err),
 			)
+			return nil, err
 		}
 		root, err := dataToRoot(&rootData{rid.String(), dtos})
 		if err != nil {
@@ -182,22 +161,12 @@ func (r *repoPgx) SelectByIDs(ids []ID) (rs []Root, err error) {
 				slog.Any("reason", err),
 				slog.Any("dtos", dtos),
 			)
+			return nil, err
 		}
 		roots = append(roots, root)
 	}
-	if err != nil {
-		return nil, errors.Join(err, br.Close(), tx.Rollback(ctx))
-	}
-	err = br.Close()
-	if err != nil {
-		return nil, errors.Join(err, tx.Rollback(ctx))
-	}
-	err = tx.Commit(ctx)
-	if err != nil {
-		return nil, errors.Join(err, br.Close(), tx.Rollback(ctx))
-	}
-	r.log.Log(ctx, core.LevelTrace, "states selection succeeded", slog.Any("roots", roots))
-	return roots, err
+	r.log.Log(ds.Ctx, core.LevelTrace, "states selection succeeded", slog.Any("roots", roots))
+	return roots, nil
 }
 
 const (
@@ -206,10 +175,10 @@ const (
 			SELECT root.*
 			FROM states root
 			WHERE id = $1
-			UNION ALL
+			UNION
 			SELECT child.*
 			FROM states child, state_tree parent
-			WHERE child.from_id = parent.id
+			WHERE child.id = ANY(parent.to_ids)
 		)
 		SELECT * FROM state_tree
 	`
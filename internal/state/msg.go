@@ -13,32 +13,50 @@ import (
 )
 
 type SpecMsg struct {
-	K      Kind     `json:"kind"`
-	Link   *LinkMsg `json:"link,omitempty"`
-	Tensor *ProdMsg `json:"tensor,omitempty"`
-	Lolli  *ProdMsg `json:"lolli,omitempty"`
-	Plus   *SumMsg  `json:"plus,omitempty"`
-	With   *SumMsg  `json:"with,omitempty"`
+	K      Kind      `json:"kind"`
+	Link   *LinkMsg  `json:"link,omitempty"`
+	Var    *VarMsg   `json:"var,omitempty"`
+	Tensor *ProdMsg  `json:"tensor,omitempty"`
+	Lolli  *ProdMsg  `json:"lolli,omitempty"`
+	Plus   *SumMsg   `json:"plus,omitempty"`
+	With   *SumMsg   `json:"with,omitempty"`
+	Up     *ShiftMsg `json:"up,omitempty"`
+	Down   *ShiftMsg `json:"down,omitempty"`
 }
 
 func (dto SpecMsg) Validate() error {
 	return validation.ValidateStruct(&dto,
 		validation.Field(&dto.K, kindRequired...),
 		validation.Field(&dto.Link, validation.Required.When(dto.K == Link), validation.Skip.When(dto.K != Link)),
+		validation.Field(&dto.Var, validation.Required.When(dto.K == Var), validation.Skip.When(dto.K != Var)),
 		validation.Field(&dto.Tensor, validation.Required.When(dto.K == Tensor), validation.Skip.When(dto.K != Tensor)),
 		validation.Field(&dto.Lolli, validation.Required.When(dto.K == Lolli), validation.Skip.When(dto.K != Lolli)),
 		validation.Field(&dto.Plus, validation.Required.When(dto.K == Plus), validation.Skip.When(dto.K != Plus)),
 		validation.Field(&dto.With, validation.Required.When(dto.K == With), validation.Skip.When(dto.K != With)),
+		validation.Field(&dto.Up, validation.Required.When(dto.K == Up), validation.Skip.When(dto.K != Up)),
+		validation.Field(&dto.Down, validation.Required.When(dto.K == Down), validation.Skip.When(dto.K != Down)),
 	)
 }
 
 type LinkMsg struct {
-	FQN string `json:"fqn"`
+	FQN  string    `json:"fqn"`
+	Args []SpecMsg `json:"args,omitempty"`
 }
 
 func (dto LinkMsg) Validate() error {
 	return validation.ValidateStruct(&dto,
 		validation.Field(&dto.FQN, sym.Required...),
+		validation.Field(&dto.Args, validation.Each(validation.Required)),
+	)
+}
+
+type VarMsg struct {
+	Name string `json:"name"`
+}
+
+func (dto VarMsg) Validate() error {
+	return validation.ValidateStruct(&dto,
+		validation.Field(&dto.Name, core.NameRequired...),
 	)
 }
 
@@ -68,6 +86,16 @@ func (dto SumMsg) Validate() error {
 	)
 }
 
+type ShiftMsg struct {
+	Cont SpecMsg `json:"cont"`
+}
+
+func (dto ShiftMsg) Validate() error {
+	return validation.ValidateStruct(&dto,
+		validation.Field(&dto.Cont, validation.Required),
+	)
+}
+
 type ChoiceMsg struct {
 	Label string  `json:"label"`
 	Cont  SpecMsg `json:"cont"`
@@ -92,20 +120,35 @@ func (dto RefMsg) Validate() error {
 	)
 }
 
+type ProblemMsg struct {
+	Path string `json:"path"`
+	Desc string `json:"desc"`
+}
+
+type ChangeMsg struct {
+	Path string   `json:"path"`
+	Kind string   `json:"kind"`
+	Old  *SpecMsg `json:"old,omitempty"`
+	New  *SpecMsg `json:"new,omitempty"`
+}
+
 type Kind string
 
 const (
 	One    = Kind("one")
 	Link   = Kind("link")
+	Var    = Kind("var")
 	Tensor = Kind("tensor")
 	Lolli  = Kind("lolli")
 	Plus   = Kind("plus")
 	With   = Kind("with")
+	Up     = Kind("up")
+	Down   = Kind("down")
 )
 
 var kindRequired = []validation.Rule{
 	validation.Required,
-	validation.In(One, Link, Tensor, Lolli, Plus, With),
+	validation.In(One, Link, Var, Tensor, Lolli, Plus, With, Up, Down),
 }
 
 // goverter:variables
@@ -122,9 +165,15 @@ func MsgFromSpec(s Spec) SpecMsg {
 	case OneSpec:
 		return SpecMsg{K: One}
 	case LinkSpec:
+		var args []SpecMsg
+		for _, arg := range spec.Args {
+			args = append(args, MsgFromSpec(arg))
+		}
 		return SpecMsg{
 			K:    Link,
-			Link: &LinkMsg{FQN: sym.ConvertToString(spec.Role)}}
+			Link: &LinkMsg{FQN: sym.ConvertToString(spec.Role), Args: args}}
+	case VarSpec:
+		return SpecMsg{K: Var, Var: &VarMsg{Name: spec.Name}}
 	case TensorSpec:
 		return SpecMsg{
 			K: Tensor,
@@ -153,6 +202,10 @@ func MsgFromSpec(s Spec) SpecMsg {
 			choices[i] = ChoiceMsg{Label: string(l), Cont: MsgFromSpec(spec.Choices[l])}
 		}
 		return SpecMsg{K: Plus, Plus: &SumMsg{Choices: choices}}
+	case UpSpec:
+		return SpecMsg{K: Up, Up: &ShiftMsg{Cont: MsgFromSpec(spec.A)}}
+	case DownSpec:
+		return SpecMsg{K: Down, Down: &ShiftMsg{Cont: MsgFromSpec(spec.A)}}
 	default:
 		panic(ErrSpecTypeUnexpected(s))
 	}
@@ -163,7 +216,17 @@ func MsgToSpec(dto SpecMsg) (Spec, error) {
 	case One:
 		return OneSpec{}, nil
 	case Link:
-		return LinkSpec{Role: sym.CovertFromString(dto.Link.FQN)}, nil
+		var args []Spec
+		for _, dto := range dto.Link.Args {
+			arg, err := MsgToSpec(dto)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+		return LinkSpec{Role: sym.CovertFromString(dto.Link.FQN), Args: args}, nil
+	case Var:
+		return VarSpec{Name: dto.Var.Name}, nil
 	case Tensor:
 		v, err := MsgToSpec(dto.Tensor.Value)
 		if err != nil {
@@ -204,6 +267,18 @@ func MsgToSpec(dto SpecMsg) (Spec, error) {
 			choices[core.Label(ch.Label)] = choice
 		}
 		return WithSpec{Choices: choices}, nil
+	case Up:
+		a, err := MsgToSpec(dto.Up.Cont)
+		if err != nil {
+			return nil, err
+		}
+		return UpSpec{A: a}, nil
+	case Down:
+		a, err := MsgToSpec(dto.Down.Cont)
+		if err != nil {
+			return nil, err
+		}
+		return DownSpec{A: a}, nil
 	default:
 		panic(errKindUnexpected(dto.K))
 	}
@@ -216,6 +291,8 @@ func MsgFromRef(r Ref) RefMsg {
 		return RefMsg{K: One, ID: ident}
 	case LinkRef, LinkRoot:
 		return RefMsg{K: Link, ID: ident}
+	case VarRef, VarRoot:
+		return RefMsg{K: Var, ID: ident}
 	case TensorRef, TensorRoot:
 		return RefMsg{K: Tensor, ID: ident}
 	case LolliRef, LolliRoot:
@@ -224,6 +301,10 @@ func MsgFromRef(r Ref) RefMsg {
 		return RefMsg{K: Plus, ID: ident}
 	case WithRef, WithRoot:
 		return RefMsg{K: With, ID: ident}
+	case UpRef, UpRoot:
+		return RefMsg{K: Up, ID: ident}
+	case DownRef, DownRoot:
+		return RefMsg{K: Down, ID: ident}
 	default:
 		panic(ErrRefTypeUnexpected(r))
 	}
@@ -239,6 +320,8 @@ func MsgToRef(dto RefMsg) (Ref, error) {
 		return OneRef{rid}, nil
 	case Link:
 		return LinkRef{rid}, nil
+	case Var:
+		return VarRef{rid}, nil
 	case Tensor:
 		return TensorRef{rid}, nil
 	case Lolli:
@@ -247,11 +330,39 @@ func MsgToRef(dto RefMsg) (Ref, error) {
 		return PlusRef{rid}, nil
 	case With:
 		return WithRef{rid}, nil
+	case Up:
+		return UpRef{rid}, nil
+	case Down:
+		return DownRef{rid}, nil
 	default:
 		panic(errKindUnexpected(dto.K))
 	}
 }
 
+func MsgFromProblems(ps Problems) []ProblemMsg {
+	dtos := make([]ProblemMsg, len(ps))
+	for i, p := range ps {
+		dtos[i] = ProblemMsg{Path: p.Path, Desc: p.Desc}
+	}
+	return dtos
+}
+
+func MsgFromChanges(cs []Change) []ChangeMsg {
+	dtos := make([]ChangeMsg, len(cs))
+	for i, c := range cs {
+		dtos[i] = ChangeMsg{Path: c.Path, Kind: string(c.Kind)}
+		if c.Old != nil {
+			old := MsgFromSpec(c.Old)
+			dtos[i].Old = &old
+		}
+		if c.New != nil {
+			new := MsgFromSpec(c.New)
+			dtos[i].New = &new
+		}
+	}
+	return dtos
+}
+
 func ErrPolarityUnexpected(got Root) error {
 	return fmt.Errorf("root polarity unexpected: %v", got.Pol())
 }
@@ -0,0 +1,318 @@
+package state
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"golang.org/x/exp/maps"
+
+	"smecalculus/rolevod/lib/core"
+	"smecalculus/rolevod/lib/sym"
+)
+
+// TextMIME is a content type of textual specs
+const TextMIME = "text/x-rolevod-state"
+
+// TextToSpec parses textual spec:
+//
+//	spec  = unary [("*" | "-o") spec]
+//	unary = "1" | "+{" choices "}" | "&{" choices "}"
+//	      | "up" unary | "down" unary | "'" name
+//	      | fqn ["[" spec {"," spec} "]"] | "(" spec ")"
+//	choices = label ":" spec {"," label ":" spec}
+//
+// Names may contain "-" but never "-o", so "a-o b" is a lolli.
+func TextToSpec(text string) (Spec, error) {
+	p := &textParser{text: text}
+	p.next()
+	s, err := p.spec()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != "" {
+		return nil, p.errUnexpected("end of text")
+	}
+	return s, nil
+}
+
+// TextFromSpec prints spec in a canonical form:
+// choices are sorted by label and products are right associative
+func TextFromSpec(s Spec) string {
+	var sb strings.Builder
+	printSpec(&sb, s)
+	return sb.String()
+}
+
+type textParser struct {
+	text string
+	pos  int
+	// current token and its position
+	tok    string
+	tokPos int
+}
+
+func (p *textParser) next() {
+	for p.pos < len(p.text) && unicode.IsSpace(rune(p.text[p.pos])) {
+		p.pos++
+	}
+	p.tokPos = p.pos
+	if p.pos == len(p.text) {
+		p.tok = ""
+		return
+	}
+	rest := p.text[p.pos:]
+	for _, op := range []string{"+{", "&{", "-o"} {
+		if strings.HasPrefix(rest, op) {
+			p.tok = op
+			p.pos += len(op)
+			return
+		}
+	}
+	if !isNameChar(rest[0]) {
+		p.tok = rest[:1]
+		p.pos++
+		return
+	}
+	end := 1
+	for end < len(rest) && (isNameChar(rest[end]) || rest[end] == '.' || rest[end] == '-') {
+		// lolli operator ends the name
+		if strings.HasPrefix(rest[end:], "-o") {
+			break
+		}
+		end++
+	}
+	p.tok = rest[:end]
+	p.pos += end
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+}
+
+func (p *textParser) expect(tok string) error {
+	if p.tok != tok {
+		return p.errUnexpected(fmt.Sprintf("%q", tok))
+	}
+	p.next()
+	return nil
+}
+
+func (p *textParser) name() (string, error) {
+	if p.tok == "" || !isNameChar(p.tok[0]) {
+		return "", p.errUnexpected("name")
+	}
+	name := p.tok
+	p.next()
+	return name, nil
+}
+
+func (p *textParser) spec() (Spec, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	switch p.tok {
+	case "*":
+		p.next()
+		right, err := p.spec()
+		if err != nil {
+			return nil, err
+		}
+		return TensorSpec{B: left, C: right}, nil
+	case "-o":
+		p.next()
+		right, err := p.spec()
+		if err != nil {
+			return nil, err
+		}
+		return LolliSpec{Y: left, Z: right}, nil
+	default:
+		return left, nil
+	}
+}
+
+func (p *textParser) unary() (Spec, error) {
+	switch p.tok {
+	case "1":
+		p.next()
+		return OneSpec{}, nil
+	case "+{":
+		p.next()
+		choices, err := p.choices()
+		if err != nil {
+			return nil, err
+		}
+		return PlusSpec{Choices: choices}, nil
+	case "&{":
+		p.next()
+		choices, err := p.choices()
+		if err != nil {
+			return nil, err
+		}
+		return WithSpec{Choices: choices}, nil
+	case "up":
+		p.next()
+		a, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return UpSpec{A: a}, nil
+	case "down":
+		p.next()
+		a, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return DownSpec{A: a}, nil
+	case "'":
+		p.next()
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		return VarSpec{Name: name}, nil
+	case "(":
+		p.next()
+		s, err := p.spec()
+		if err != nil {
+			return nil, err
+		}
+		return s, p.expect(")")
+	default:
+		return p.link()
+	}
+}
+
+func (p *textParser) link() (Spec, error) {
+	fqn, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	link := LinkSpec{Role: sym.CovertFromString(fqn)}
+	if p.tok != "[" {
+		return link, nil
+	}
+	p.next()
+	for {
+		arg, err := p.spec()
+		if err != nil {
+			return nil, err
+		}
+		link.Args = append(link.Args, arg)
+		if p.tok != "," {
+			break
+		}
+		p.next()
+	}
+	return link, p.expect("]")
+}
+
+func (p *textParser) choices() (map[core.Label]Spec, error) {
+	choices := map[core.Label]Spec{}
+	for {
+		label, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		_, ok := choices[core.Label(label)]
+		if ok {
+			return nil, fmt.Errorf("label duplicated at %v: %q", p.tokPos, label)
+		}
+		err = p.expect(":")
+		if err != nil {
+			return nil, err
+		}
+		choice, err := p.spec()
+		if err != nil {
+			return nil, err
+		}
+		choices[core.Label(label)] = choice
+		if p.tok != "," {
+			break
+		}
+		p.next()
+	}
+	return choices, p.expect("}")
+}
+
+func (p *textParser) errUnexpected(want string) error {
+	got := p.tok
+	if got == "" {
+		got = "end of text"
+	}
+	return fmt.Errorf("text unexpected at %v: want %v, got %q", p.tokPos, want, got)
+}
+
+func printSpec(sb *strings.Builder, s Spec) {
+	switch spec := s.(type) {
+	case OneSpec:
+		sb.WriteString("1")
+	case LinkSpec:
+		sb.WriteString(sym.ConvertToString(spec.Role))
+		if len(spec.Args) == 0 {
+			return
+		}
+		sb.WriteString("[")
+		for i, arg := range spec.Args {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			printSpec(sb, arg)
+		}
+		sb.WriteString("]")
+	case VarSpec:
+		sb.WriteString("'")
+		sb.WriteString(spec.Name)
+	case TensorSpec:
+		printOperand(sb, spec.B)
+		sb.WriteString(" * ")
+		printSpec(sb, spec.C)
+	case LolliSpec:
+		printOperand(sb, spec.Y)
+		sb.WriteString(" -o ")
+		printSpec(sb, spec.Z)
+	case PlusSpec:
+		sb.WriteString("+{")
+		printChoices(sb, spec.Choices)
+		sb.WriteString("}")
+	case WithSpec:
+		sb.WriteString("&{")
+		printChoices(sb, spec.Choices)
+		sb.WriteString("}")
+	case UpSpec:
+		sb.WriteString("up ")
+		printOperand(sb, spec.A)
+	case DownSpec:
+		sb.WriteString("down ")
+		printOperand(sb, spec.A)
+	default:
+		panic(ErrSpecTypeUnexpected(s))
+	}
+}
+
+// products on the left need parens
+func printOperand(sb *strings.Builder, s Spec) {
+	switch s.(type) {
+	case TensorSpec, LolliSpec:
+		sb.WriteString("(")
+		printSpec(sb, s)
+		sb.WriteString(")")
+	default:
+		printSpec(sb, s)
+	}
+}
+
+func printChoices(sb *strings.Builder, choices map[core.Label]Spec) {
+	labels := maps.Keys(choices)
+	slices.Sort(labels)
+	for i, l := range labels {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(string(l))
+		sb.WriteString(": ")
+		printSpec(sb, choices[l])
+	}
+}
@@ -0,0 +1,107 @@
+package state
+
+import (
+	"reflect"
+	"testing"
+
+	"smecalculus/rolevod/lib/core"
+	"smecalculus/rolevod/lib/sym"
+)
+
+func TestTextToSpec(t *testing.T) {
+
+	t.Run("Products", func(t *testing.T) {
+		// given
+		text := "+{ok: 1 * a.b, err: 1}"
+		// when
+		got, err := TextToSpec(text)
+		// then
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// and
+		want := PlusSpec{
+			Choices: map[core.Label]Spec{
+				"ok":  TensorSpec{B: OneSpec{}, C: LinkSpec{Role: sym.New("a.b")}},
+				"err": OneSpec{},
+			},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("unexpected spec: want %+v, got %+v", want, got)
+		}
+	})
+
+	t.Run("RightAssociative", func(t *testing.T) {
+		// given
+		text := "1 -o 1 * 1"
+		// when
+		got, err := TextToSpec(text)
+		// then
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// and
+		want := LolliSpec{Y: OneSpec{}, Z: TensorSpec{B: OneSpec{}, C: OneSpec{}}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("unexpected spec: want %+v, got %+v", want, got)
+		}
+	})
+
+	t.Run("LolliUnspaced", func(t *testing.T) {
+		// given
+		texts := map[string]Spec{
+			"1-o 1":     LolliSpec{Y: OneSpec{}, Z: OneSpec{}},
+			"A-o B":     LolliSpec{Y: LinkSpec{Role: sym.New("A")}, Z: LinkSpec{Role: sym.New("B")}},
+			"a-b-o a-b": LolliSpec{Y: LinkSpec{Role: sym.New("a-b")}, Z: LinkSpec{Role: sym.New("a-b")}},
+		}
+		for text, want := range texts {
+			// when
+			got, err := TextToSpec(text)
+			// then
+			if err != nil {
+				t.Fatalf("unexpected error for %q: %v", text, err)
+			}
+			// and
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("unexpected spec for %q: want %+v, got %+v", text, want, got)
+			}
+		}
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		for _, text := range []string{"", "+{ok 1}", "&{ok: 1", "list[1", "1 *", "1 1"} {
+			// when
+			_, err := TextToSpec(text)
+			// then
+			if err == nil {
+				t.Errorf("unexpected success: want error for %q", text)
+			}
+		}
+	})
+}
+
+func TestTextFromSpec(t *testing.T) {
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		// given
+		texts := []string{
+			"1",
+			"&{deq: +{none: 1, some: 'a * queue['a]}, enq: 'a -o queue['a]}",
+			"(1 * 1) -o 1",
+			"up &{inc: down counter}",
+			"pair[1, up (1 * 1)]",
+		}
+		for _, text := range texts {
+			// when
+			spec, err := TextToSpec(text)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// then
+			got := TextFromSpec(spec)
+			if got != text {
+				t.Errorf("unexpected text: want %q, got %q", text, got)
+			}
+		}
+	})
+}
@@ -1,15 +1,18 @@
 package step
 
 import (
+	"errors"
 	"fmt"
+	"time"
 
 	"smecalculus/rolevod/lib/ak"
 	"smecalculus/rolevod/lib/core"
+	"smecalculus/rolevod/lib/data"
 	"smecalculus/rolevod/lib/id"
 	"smecalculus/rolevod/lib/ph"
-	"smecalculus/rolevod/lib/sym"
 
 	"smecalculus/rolevod/internal/chnl"
+	"smecalculus/rolevod/internal/state"
 )
 
 type ID = id.ADT
@@ -45,6 +48,8 @@ type ProcRoot struct {
 	ID   ID
 	PID  chnl.ID
 	Term Term
+	// steps left pending by term expire at deadline, optional
+	Deadline time.Time
 }
 
 func (ProcRoot) step() {}
@@ -54,7 +59,12 @@ type MsgRoot struct {
 	ID  ID
 	PID chnl.ID
 	VID chnl.ID
-	Val Value
+	// Successor Channel ID allocated eagerly by asynchronous send,
+	// empty if msg awaits rendezvous
+	NextVID chnl.ID
+	Val     Value
+	// optional
+	Deadline time.Time
 }
 
 func (MsgRoot) step() {}
@@ -64,6 +74,8 @@ type SrvRoot struct {
 	PID  chnl.ID
 	VID  chnl.ID
 	Cont Continuation
+	// optional
+	Deadline time.Time
 }
 
 func (SrvRoot) step() {}
@@ -154,6 +166,52 @@ func (CaseSpec) cont() {}
 
 func (s CaseSpec) Via() ph.ADT { return s.X }
 
+// aka Acquire
+type AcqSpec struct {
+	X    ph.ADT // via (shared)
+	Y    ph.ADT // linear
+	Cont Term
+}
+
+func (AcqSpec) cont() {}
+
+func (s AcqSpec) Via() ph.ADT { return s.X }
+
+// aka Accept
+type AccSpec struct {
+	X    ph.ADT // via (shared)
+	Y    ph.ADT // linear
+	Cont Term
+}
+
+func (AccSpec) cont() {}
+
+func (s AccSpec) Via() ph.ADT { return s.X }
+
+// aka Release
+type RelSpec struct {
+	X    ph.ADT // via (linear)
+	Y    ph.ADT // shared
+	Cont Term
+}
+
+func (RelSpec) cont() {}
+
+func (s RelSpec) Via() ph.ADT { return s.X }
+
+// aka Detach
+//
+// Detach is a value, so provider's process ends here. To serve the next
+// client provider's agent takes AccSpec on the shared channel again.
+type DetSpec struct {
+	X ph.ADT // via (linear)
+	Y ph.ADT // shared
+}
+
+func (DetSpec) val() {}
+
+func (s DetSpec) Via() ph.ADT { return s.X }
+
 type CTASpec struct {
 	AK  ak.ADT
 	Sig id.ADT
@@ -165,9 +223,9 @@ func (s CTASpec) Via() ph.ADT { return s.Sig }
 
 // aka ExpName
 type LinkSpec struct {
-	PE  chnl.ID
-	CEs []chnl.ID
-	Sig sym.ADT
+	PE  ph.ADT
+	CEs []ph.ADT
+	Sig id.ADT
 }
 
 func (s LinkSpec) Via() ph.ADT { return s.PE }
@@ -185,19 +243,84 @@ func (s FwdSpec) Via() ph.ADT { return s.C }
 
 type SpawnSpec struct {
 	PE   ph.ADT
-	CEs  []chnl.ID
+	CEs  []ph.ADT
 	Cont Term
 	Sig  id.ADT
 }
 
 func (s SpawnSpec) Via() ph.ADT { return s.PE }
 
+// Cause of pending step interruption
+type Cause int
+
+const (
+	Expiry = Cause(iota + 1)
+	Cancellation
+)
+
+// Idempotency Key supplied by agent
+type IK = string
+
+// Receipt proves that transition was taken under idempotency key
+type Receipt struct {
+	IK  IK
+	PID chnl.ID
+	// digest of request taken under the key
+	Digest  id.ADT
+	TakenAt time.Time
+}
+
+// Entry is immutable record of reduction
+type Entry struct {
+	ID  ID
+	PID chnl.ID
+	// reduced term
+	Term Term
+	// Via Channel ID before reduction, empty if term has no via
+	PreVID chnl.ID
+	// Via Channel ID after reduction, empty if via isn't advanced yet
+	VID chnl.ID
+	// Via State ID before reduction
+	PreStateID *state.ID
+	// Via State ID after reduction, nil if via is closed or isn't advanced yet
+	StateID *state.ID
+	// Agent Access Key, empty if reduction is taken by engine
+	AK      ak.ADT
+	TakenAt time.Time
+}
+
 type Repo interface {
-	Insert(Root) error
-	SelectAll() ([]Ref, error)
-	SelectByID(ID) (Root, error)
-	SelectByPID(chnl.ID) (Root, error)
-	SelectByVID(chnl.ID) (Root, error)
+	Insert(data.Source, Root) error
+	SelectAll(data.Source) ([]Ref, error)
+	SelectByID(data.Source, ID) (Root, error)
+	SelectByPID(data.Source, chnl.ID) (Root, error)
+	SelectByVID(data.Source, chnl.ID) (Root, error)
+	// selects procs ready for reduction, i.e. not awaiting agents
+	SelectReady(source data.Source, limit int) ([]ProcRoot, error)
+	// selects msgs and srvs of processes awaiting counterparts, i.e. via has no successor
+	SelectPending(source data.Source, pids []chnl.ID) ([]Root, error)
+	// selects pending msgs and srvs with deadline passed
+	SelectExpired(source data.Source, now time.Time, limit int) ([]Root, error)
+	// marks pending step as interrupted, reports false if step isn't pending anymore
+	Interrupt(source data.Source, sid ID, cause Cause, now time.Time) (bool, error)
+	// marks process as cancelled and drops its procs
+	Cancel(source data.Source, pid chnl.ID, now time.Time) error
+	// reports whether process is cancelled
+	SelectCancelled(source data.Source, pid chnl.ID) (bool, error)
+	// counts msgs queued ahead of channel, i.e. sent asynchronously and not received yet
+	SelectQueueLen(source data.Source, vid chnl.ID) (int, error)
+	// appends acquirer to the queue of shared channel, behind the one already waiting
+	EnqueueAcquirer(data.Source, SrvRoot) error
+	// removes and returns the longest waiting acquirer, nil if nobody is queued
+	DequeueAcquirer(source data.Source, vid chnl.ID) (Root, error)
+	Delete(data.Source, ID) error
+	// inserts receipt, reports false if idempotency key is already taken
+	InsertReceipt(data.Source, Receipt) (bool, error)
+	SelectReceipt(data.Source, IK) (Receipt, error)
+	// appends entry to journal
+	InsertEntry(data.Source, Entry) error
+	// selects journal entries of processes in order of taking
+	SelectEntries(source data.Source, pids []chnl.ID, after ID, limit int) ([]Entry, error)
 }
 
 func CollectEnv(t Term) []id.ADT {
@@ -206,6 +329,8 @@ func CollectEnv(t Term) []id.ADT {
 
 func collectEnvRec(t Term, env []id.ADT) []id.ADT {
 	switch term := t.(type) {
+	case WaitSpec:
+		return collectEnvRec(term.Cont, env)
 	case RecvSpec:
 		return collectEnvRec(term.Cont, env)
 	case CaseSpec:
@@ -215,6 +340,14 @@ func collectEnvRec(t Term, env []id.ADT) []id.ADT {
 		return env
 	case SpawnSpec:
 		return collectEnvRec(term.Cont, append(env, term.Sig))
+	case LinkSpec:
+		return append(env, term.Sig)
+	case AcqSpec:
+		return collectEnvRec(term.Cont, env)
+	case AccSpec:
+		return collectEnvRec(term.Cont, env)
+	case RelSpec:
+		return collectEnvRec(term.Cont, env)
 	default:
 		return env
 	}
@@ -274,12 +407,42 @@ func collectCEsRec(pe chnl.ID, t Term, ces []chnl.ID) []chnl.ID {
 		}
 		return ces
 	case SpawnSpec:
-		return collectCEsRec(pe, term.Cont, append(ces, term.CEs...))
+		return collectCEsRec(pe, term.Cont, collectIDs(term.CEs, ces))
+	case LinkSpec:
+		return collectIDs(term.CEs, ces)
+	case AcqSpec:
+		x, ok := term.X.(chnl.ID)
+		if ok && x != pe {
+			ces = append(ces, x)
+		}
+		return collectCEsRec(pe, term.Cont, ces)
+	case AccSpec:
+		return collectCEsRec(pe, term.Cont, ces)
+	case RelSpec:
+		x, ok := term.X.(chnl.ID)
+		if ok && x != pe {
+			ces = append(ces, x)
+		}
+		y, ok := term.Y.(chnl.ID)
+		if ok && y != pe {
+			ces = append(ces, y)
+		}
+		return collectCEsRec(pe, term.Cont, ces)
 	default:
 		return ces
 	}
 }
 
+func collectIDs(phs []ph.ADT, ces []chnl.ID) []chnl.ID {
+	for _, z := range phs {
+		ce, ok := z.(chnl.ID)
+		if ok {
+			ces = append(ces, ce)
+		}
+	}
+	return ces
+}
+
 func Subst(t Term, ph ph.ADT, val chnl.ID) Term {
 	if t == nil {
 		return nil
@@ -304,21 +467,141 @@ func Subst(t Term, ph ph.ADT, val chnl.ID) Term {
 			term.B = val
 		}
 		return term
+	case RecvSpec:
+		if ph == term.X {
+			term.X = val
+		}
+		// value binds in cont
+		if ph == term.Y {
+			return term
+		}
+		term.Cont = Subst(term.Cont, ph, val)
+		return term
+	case LabSpec:
+		if ph == term.A {
+			term.A = val
+		}
+		return term
+	case CaseSpec:
+		if ph == term.X {
+			term.X = val
+		}
+		conts := make(map[core.Label]Term, len(term.Conts))
+		for l, cont := range term.Conts {
+			conts[l] = Subst(cont, ph, val)
+		}
+		term.Conts = conts
+		return term
+	case SpawnSpec:
+		term.CEs = substCEs(term.CEs, ph, val)
+		// provider endpoint binds in cont
+		if ph == term.PE {
+			return term
+		}
+		term.Cont = Subst(term.Cont, ph, val)
+		return term
+	case FwdSpec:
+		if ph == term.C {
+			term.C = val
+		}
+		if ph == term.D {
+			term.D = val
+		}
+		return term
+	case LinkSpec:
+		if ph == term.PE {
+			term.PE = val
+		}
+		term.CEs = substCEs(term.CEs, ph, val)
+		return term
+	case CTASpec:
+		return term
+	case AcqSpec:
+		if ph == term.X {
+			term.X = val
+		}
+		if ph == term.Y {
+			return term
+		}
+		term.Cont = Subst(term.Cont, ph, val)
+		return term
+	case AccSpec:
+		if ph == term.X {
+			term.X = val
+		}
+		if ph == term.Y {
+			return term
+		}
+		term.Cont = Subst(term.Cont, ph, val)
+		return term
+	case RelSpec:
+		if ph == term.X {
+			term.X = val
+		}
+		if ph == term.Y {
+			term.Y = val
+		}
+		term.Cont = Subst(term.Cont, ph, val)
+		return term
+	case DetSpec:
+		if ph == term.X {
+			term.X = val
+		}
+		if ph == term.Y {
+			term.Y = val
+		}
+		return term
 	default:
 		panic(ErrTermTypeUnexpected(t))
 	}
 }
 
+func substCEs(ces []ph.ADT, x ph.ADT, val chnl.ID) []ph.ADT {
+	if ces == nil {
+		return nil
+	}
+	newCEs := make([]ph.ADT, len(ces))
+	for i, ce := range ces {
+		if x == ce {
+			newCEs[i] = val
+		} else {
+			newCEs[i] = ce
+		}
+	}
+	return newCEs
+}
+
+var ErrMissing = errors.New("root doesn't exist")
+
 func ErrDoesNotExist(want ID) error {
-	return fmt.Errorf("root doesn't exist: %v", want)
+	return fmt.Errorf("%w: %v", ErrMissing, want)
+}
+
+var ErrCancelled = errors.New("process cancelled")
+
+func ErrProcCancelled(pid chnl.ID) error {
+	return fmt.Errorf("%w: %v", ErrCancelled, pid)
+}
+
+var ErrKeyReused = errors.New("idempotency key reused")
+
+func ErrReceiptMismatch(ik IK, want chnl.ID, got chnl.ID) error {
+	return fmt.Errorf("%w: %q taken by %v, not %v", ErrKeyReused, ik, want, got)
+}
+
+func ErrDigestMismatch(ik IK, want id.ADT, got id.ADT) error {
+	return fmt.Errorf("%w: %q taken with request %v, not %v", ErrKeyReused, ik, want, got)
 }
 
+// ErrTypeMismatch marks errors that retrying won't fix
+var ErrTypeMismatch = errors.New("type mismatch")
+
 func ErrRootTypeUnexpected(got Root) error {
 	return fmt.Errorf("root type unexpected: %T", got)
 }
 
 func ErrRootTypeMismatch(got, want Root) error {
-	return fmt.Errorf("root type mismatch: want %T, got %T", want, got)
+	return fmt.Errorf("root %w: want %T, got %T", ErrTypeMismatch, want, got)
 }
 
 func ErrTermTypeUnexpected(got Term) error {
@@ -326,7 +609,7 @@ func ErrTermTypeUnexpected(got Term) error {
 }
 
 func ErrTermTypeMismatch(got, want Term) error {
-	return fmt.Errorf("term type mismatch: want %T, got %T", want, got)
+	return fmt.Errorf("term %w: want %T, got %T", ErrTypeMismatch, want, got)
 }
 
 func ErrTermValueNil(pid chnl.ID) error {
@@ -337,6 +620,14 @@ func ErrValTypeUnexpected(got Value) error {
 	return fmt.Errorf("value type unexpected: %T", got)
 }
 
+func ErrValTypeMismatch(got, want Value) error {
+	return fmt.Errorf("val %w: want %T, got %T", ErrTypeMismatch, want, got)
+}
+
+func ErrContTypeMismatch(got, want Continuation) error {
+	return fmt.Errorf("cont %w: want %T, got %T", ErrTypeMismatch, want, got)
+}
+
 func ErrContTypeUnexpected(got Continuation) error {
 	return fmt.Errorf("continuation type unexpected: %T", got)
 }
@@ -2,12 +2,16 @@ package step
 
 import (
 	"os"
+	"reflect"
 	"slices"
 	"testing"
+	"time"
 
+	"smecalculus/rolevod/lib/ak"
+	"smecalculus/rolevod/lib/core"
 	"smecalculus/rolevod/lib/id"
-
-	"smecalculus/rolevod/internal/chnl"
+	"smecalculus/rolevod/lib/ph"
+	"smecalculus/rolevod/lib/sym"
 )
 
 func TestMain(m *testing.M) {
@@ -19,7 +23,7 @@ func TestCollectCtx(t *testing.T) {
 	// given
 	ce := id.New()
 	// and
-	term := SpawnSpec{CEs: []chnl.ID{ce}, Cont: CloseSpec{}}
+	term := SpawnSpec{CEs: []ph.ADT{ce}, Cont: CloseSpec{}}
 	// when
 	actualCEs := CollectCtx(id.New(), term)
 	// then
@@ -27,3 +31,294 @@ func TestCollectCtx(t *testing.T) {
 		t.Errorf("unexpected ces: want %q in %v", ce, actualCEs)
 	}
 }
+
+func TestSubst(t *testing.T) {
+
+	t.Run("Recv", func(t *testing.T) {
+		// given
+		x, y, val := id.New(), id.New(), id.New()
+		// and
+		term := RecvSpec{X: x, Y: y, Cont: WaitSpec{X: x, Cont: CloseSpec{A: y}}}
+		// when
+		actual := Subst(term, x, val)
+		// then
+		expected := RecvSpec{X: val, Y: y, Cont: WaitSpec{X: val, Cont: CloseSpec{A: y}}}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("unexpected term: want %+v, got %+v", expected, actual)
+		}
+	})
+
+	t.Run("RecvShadowing", func(t *testing.T) {
+		// given
+		x, y, val := id.New(), id.New(), id.New()
+		// and
+		term := RecvSpec{X: x, Y: y, Cont: CloseSpec{A: y}}
+		// when
+		actual := Subst(term, y, val)
+		// then
+		if !reflect.DeepEqual(actual, term) {
+			t.Errorf("unexpected term: want %+v, got %+v", term, actual)
+		}
+	})
+
+	t.Run("Lab", func(t *testing.T) {
+		// given
+		a, val := id.New(), id.New()
+		// when
+		actual := Subst(LabSpec{A: a, L: "ok"}, a, val)
+		// then
+		expected := LabSpec{A: val, L: "ok"}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("unexpected term: want %+v, got %+v", expected, actual)
+		}
+	})
+
+	t.Run("Case", func(t *testing.T) {
+		// given
+		x, z, val := id.New(), id.New(), id.New()
+		// and
+		term := CaseSpec{
+			X: x,
+			Conts: map[core.Label]Term{
+				"ok":  CloseSpec{A: z},
+				"err": FwdSpec{C: z, D: x},
+			},
+		}
+		// when
+		actual := Subst(term, z, val)
+		// then
+		expected := CaseSpec{
+			X: x,
+			Conts: map[core.Label]Term{
+				"ok":  CloseSpec{A: val},
+				"err": FwdSpec{C: val, D: x},
+			},
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("unexpected term: want %+v, got %+v", expected, actual)
+		}
+		// and
+		if term.Conts["ok"] != (CloseSpec{A: z}) {
+			t.Errorf("unexpected mutation: %+v", term)
+		}
+	})
+
+	t.Run("Spawn", func(t *testing.T) {
+		// given
+		pe, ce, val := id.New(), id.New(), id.New()
+		// and
+		term := SpawnSpec{PE: pe, CEs: []ph.ADT{ce}, Cont: WaitSpec{X: pe, Cont: CloseSpec{A: ce}}}
+		// when
+		actual := Subst(term, ce, val)
+		// then
+		expected := SpawnSpec{PE: pe, CEs: []ph.ADT{val}, Cont: WaitSpec{X: pe, Cont: CloseSpec{A: val}}}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("unexpected term: want %+v, got %+v", expected, actual)
+		}
+		// and
+		shadowed := Subst(term, pe, val)
+		if !reflect.DeepEqual(shadowed, term) {
+			t.Errorf("unexpected term: want %+v, got %+v", term, shadowed)
+		}
+	})
+
+	t.Run("Link", func(t *testing.T) {
+		// given
+		pe, ce, val := id.New(), id.New(), id.New()
+		// when
+		actual := Subst(LinkSpec{PE: pe, CEs: []ph.ADT{ce}}, pe, val)
+		// then
+		expected := LinkSpec{PE: val, CEs: []ph.ADT{ce}}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("unexpected term: want %+v, got %+v", expected, actual)
+		}
+	})
+
+	t.Run("CTA", func(t *testing.T) {
+		// given
+		term := CTASpec{Sig: id.New()}
+		// when
+		actual := Subst(term, term.Sig, id.New())
+		// then
+		if actual != term {
+			t.Errorf("unexpected term: want %+v, got %+v", term, actual)
+		}
+	})
+}
+
+func TestDataFromTerm(t *testing.T) {
+
+	t.Run("SpawnLink", func(t *testing.T) {
+		// given
+		pe, ce := sym.New("pe"), id.New()
+		// and
+		term := SpawnSpec{
+			PE:   pe,
+			CEs:  []ph.ADT{ce},
+			Cont: LinkSpec{PE: id.New(), CEs: []ph.ADT{pe}, Sig: id.New()},
+			Sig:  id.New(),
+		}
+		// when
+		dto, err := DataFromTermNilable(term)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := DataToTermNilable(dto)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// then
+		if !reflect.DeepEqual(actual, term) {
+			t.Errorf("unexpected term: want %+v, got %+v", term, actual)
+		}
+	})
+}
+
+func TestMsgFromTerm(t *testing.T) {
+
+	t.Run("SpawnIDs", func(t *testing.T) {
+		// given
+		ce := id.New()
+		term := SpawnSpec{PE: sym.New("pe"), CEs: []ph.ADT{ce}, Cont: CloseSpec{A: sym.New("pe")}, Sig: id.New()}
+		// when
+		dto := MsgFromTerm(term)
+		// then
+		want := []string{ce.String()}
+		if !reflect.DeepEqual(dto.Spawn.CEs, want) || dto.Spawn.CEPHs != nil {
+			t.Errorf("unexpected ces: want %v, got %v and %v", want, dto.Spawn.CEs, dto.Spawn.CEPHs)
+		}
+		// and
+		actual, err := MsgToTerm(dto)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(actual, term) {
+			t.Errorf("unexpected term: want %+v, got %+v", term, actual)
+		}
+	})
+
+	t.Run("LinkPHs", func(t *testing.T) {
+		// given
+		term := LinkSpec{PE: id.New(), CEs: []ph.ADT{id.New(), sym.New("ce")}, Sig: id.New()}
+		// when
+		dto := MsgFromTerm(term)
+		// then
+		if dto.Link.CEs != nil {
+			t.Errorf("unexpected ces: want nil, got %v", dto.Link.CEs)
+		}
+		// and
+		actual, err := MsgToTerm(dto)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(actual, term) {
+			t.Errorf("unexpected term: want %+v, got %+v", term, actual)
+		}
+	})
+}
+
+func TestDataFromRoot(t *testing.T) {
+
+	t.Run("Deadline", func(t *testing.T) {
+		// given
+		root := SrvRoot{
+			ID:       id.New(),
+			PID:      id.New(),
+			VID:      id.New(),
+			Cont:     WaitSpec{X: id.New(), Cont: CloseSpec{A: id.New()}},
+			Deadline: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		}
+		// when
+		dto, err := dataFromRoot(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := dataToRoot(dto)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// then
+		if !reflect.DeepEqual(actual, root) {
+			t.Errorf("unexpected root: want %+v, got %+v", root, actual)
+		}
+	})
+
+	t.Run("Queued", func(t *testing.T) {
+		// given
+		root := MsgRoot{
+			ID:      id.New(),
+			PID:     id.New(),
+			VID:     id.New(),
+			NextVID: id.New(),
+			Val:     LabSpec{A: id.New(), L: "label-1"},
+		}
+		// when
+		dto, err := dataFromRoot(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := dataToRoot(dto)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// then
+		if !reflect.DeepEqual(actual, root) {
+			t.Errorf("unexpected root: want %+v, got %+v", root, actual)
+		}
+	})
+}
+
+func TestDataFromEntry(t *testing.T) {
+
+	t.Run("Advanced", func(t *testing.T) {
+		// given
+		preStateID, stateID := id.New(), id.New()
+		entry := Entry{
+			ID:         id.New(),
+			PID:        id.New(),
+			Term:       CloseSpec{A: id.New()},
+			PreVID:     id.New(),
+			VID:        id.New(),
+			PreStateID: &preStateID,
+			StateID:    &stateID,
+			AK:         ak.New(),
+			TakenAt:    time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		}
+		// when
+		dto, err := dataFromEntry(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := dataToEntry(dto)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// then
+		if !reflect.DeepEqual(actual, entry) {
+			t.Errorf("unexpected entry: want %+v, got %+v", entry, actual)
+		}
+	})
+
+	t.Run("HalfDone", func(t *testing.T) {
+		// given
+		preStateID := id.New()
+		entry := Entry{
+			ID:         id.New(),
+			PID:        id.New(),
+			Term:       CloseSpec{A: id.New()},
+			PreVID:     id.New(),
+			VID:        id.Empty(),
+			PreStateID: &preStateID,
+			TakenAt:    time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		}
+		// when
+		dto, err := dataFromEntry(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// then
+		if dto.VID.Valid || dto.StateID.Valid || dto.AK.Valid {
+			t.Errorf("unexpected dto: %+v", dto)
+		}
+	})
+}
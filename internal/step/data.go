@@ -3,19 +3,44 @@ package step
 import (
 	"database/sql"
 	"fmt"
+	"time"
 
 	"smecalculus/rolevod/lib/ak"
 	"smecalculus/rolevod/lib/core"
 	"smecalculus/rolevod/lib/id"
 	"smecalculus/rolevod/lib/ph"
+	"smecalculus/rolevod/lib/tm"
 )
 
 type rootData struct {
-	ID   string         `db:"id"`
-	K    stepKind       `db:"kind"`
-	PID  sql.NullString `db:"pid"`
-	VID  sql.NullString `db:"vid"`
-	Spec specData       `db:"spec"`
+	ID  string         `db:"id"`
+	K   stepKind       `db:"kind"`
+	PID sql.NullString `db:"pid"`
+	VID sql.NullString `db:"vid"`
+	// optional
+	NextVID sql.NullString `db:"next_vid"`
+	Spec    specData       `db:"spec"`
+	// optional
+	Deadline sql.NullTime `db:"deadline"`
+}
+
+type receiptData struct {
+	IK      string    `db:"ik"`
+	PID     string    `db:"pid"`
+	Digest  string    `db:"digest"`
+	TakenAt time.Time `db:"taken_at"`
+}
+
+type entryData struct {
+	ID         string         `db:"id"`
+	PID        string         `db:"pid"`
+	Term       specData       `db:"term"`
+	PreVID     sql.NullString `db:"pre_vid"`
+	VID        sql.NullString `db:"vid"`
+	PreStateID sql.NullString `db:"pre_state_id"`
+	StateID    sql.NullString `db:"state_id"`
+	AK         sql.NullString `db:"ak"`
+	TakenAt    time.Time      `db:"taken_at"`
 }
 
 type stepKind int
@@ -35,7 +60,13 @@ type specData struct {
 	Recv  *recvData  `json:"recv,omitempty"`
 	Lab   *labData   `json:"lab,omitempty"`
 	Case  *caseData  `json:"case,omitempty"`
+	Link  *linkData  `json:"link,omitempty"`
+	Spawn *spawnData `json:"spawn,omitempty"`
 	Fwd   *fwdData   `json:"fwd,omitempty"`
+	Acq   *shiftData `json:"acq,omitempty"`
+	Acc   *shiftData `json:"acc,omitempty"`
+	Rel   *shiftData `json:"rel,omitempty"`
+	Det   *detData   `json:"det,omitempty"`
 	CTA   *ctaData   `json:"cta,omitempty"`
 }
 
@@ -74,11 +105,35 @@ type branchData struct {
 	Cont specData `json:"cont"`
 }
 
+type linkData struct {
+	PE  ph.Data   `json:"pe"`
+	CEs []ph.Data `json:"ces,omitempty"`
+	Sig string    `json:"sig"`
+}
+
+type spawnData struct {
+	PE   ph.Data   `json:"pe"`
+	CEs  []ph.Data `json:"ces,omitempty"`
+	Cont specData  `json:"cont"`
+	Sig  string    `json:"sig"`
+}
+
 type fwdData struct {
 	C ph.Data `json:"c"`
 	D ph.Data `json:"d"`
 }
 
+type shiftData struct {
+	X    ph.Data  `json:"x"`
+	Y    ph.Data  `json:"y"`
+	Cont specData `json:"cont"`
+}
+
+type detData struct {
+	X ph.Data `json:"x"`
+	Y ph.Data `json:"y"`
+}
+
 type ctaData struct {
 	AK  string `json:"ak"`
 	Sig string `json:"sig"`
@@ -98,6 +153,10 @@ const (
 	link
 	spawn
 	fwd
+	acq
+	acc
+	rel
+	det
 )
 
 // goverter:variables
@@ -112,6 +171,27 @@ var (
 	DataFromConts  func([]Continuation) ([]specData, error)
 )
 
+// TermData is a storable form of term
+type TermData = specData
+
+func DataFromTermNilable(t Term) (*TermData, error) {
+	if t == nil {
+		return nil, nil
+	}
+	dto, err := dataFromTerm(t)
+	if err != nil {
+		return nil, err
+	}
+	return &dto, nil
+}
+
+func DataToTermNilable(dto *TermData) (Term, error) {
+	if dto == nil {
+		return nil, nil
+	}
+	return dataToTerm(*dto)
+}
+
 func dataFromRoot(r Root) (*rootData, error) {
 	if r == nil {
 		return nil, nil
@@ -124,20 +204,23 @@ func dataFromRoot(r Root) (*rootData, error) {
 			return nil, err
 		}
 		return &rootData{
-			K:    proc,
-			ID:   root.ID.String(),
-			PID:  pid,
-			Spec: spec,
+			K:        proc,
+			ID:       root.ID.String(),
+			PID:      pid,
+			Spec:     spec,
+			Deadline: tm.ConvertTimeToNullTime(root.Deadline),
 		}, nil
 	case MsgRoot:
 		pid := id.ConvertToNullString(root.PID)
 		vid := id.ConvertToNullString(root.VID)
 		return &rootData{
-			K:    msg,
-			ID:   root.ID.String(),
-			PID:  pid,
-			VID:  vid,
-			Spec: dataFromValue(root.Val),
+			K:        msg,
+			ID:       root.ID.String(),
+			PID:      pid,
+			VID:      vid,
+			NextVID:  id.ConvertToNullString(root.NextVID),
+			Spec:     dataFromValue(root.Val),
+			Deadline: tm.ConvertTimeToNullTime(root.Deadline),
 		}, nil
 	case SrvRoot:
 		pid := id.ConvertToNullString(root.PID)
@@ -147,11 +230,12 @@ func dataFromRoot(r Root) (*rootData, error) {
 			return nil, err
 		}
 		return &rootData{
-			K:    srv,
-			ID:   root.ID.String(),
-			PID:  pid,
-			VID:  vid,
-			Spec: spec,
+			K:        srv,
+			ID:       root.ID.String(),
+			PID:      pid,
+			VID:      vid,
+			Spec:     spec,
+			Deadline: tm.ConvertTimeToNullTime(root.Deadline),
 		}, nil
 	default:
 		panic(ErrRootTypeUnexpected(root))
@@ -174,25 +258,30 @@ func dataToRoot(dto *rootData) (Root, error) {
 	if err != nil {
 		return nil, err
 	}
+	deadline := tm.ConvertTimeFromNullTime(dto.Deadline)
 	switch dto.K {
 	case proc:
 		term, err := dataToTerm(dto.Spec)
 		if err != nil {
 			return nil, err
 		}
-		return ProcRoot{ID: ident, PID: pid, Term: term}, nil
+		return ProcRoot{ID: ident, PID: pid, Term: term, Deadline: deadline}, nil
 	case msg:
 		val, err := dataToValue(dto.Spec)
 		if err != nil {
 			return nil, err
 		}
-		return MsgRoot{ID: ident, PID: pid, VID: vid, Val: val}, nil
+		nextVID, err := id.ConvertFromNullString(dto.NextVID)
+		if err != nil {
+			return nil, err
+		}
+		return MsgRoot{ID: ident, PID: pid, VID: vid, NextVID: nextVID, Val: val, Deadline: deadline}, nil
 	case srv:
 		cont, err := dataToCont(dto.Spec)
 		if err != nil {
 			return nil, err
 		}
-		return SrvRoot{ID: ident, PID: pid, VID: vid, Cont: cont}, nil
+		return SrvRoot{ID: ident, PID: pid, VID: vid, Cont: cont, Deadline: deadline}, nil
 	default:
 		panic(errUnexpectedStepKind(dto.K))
 	}
@@ -214,6 +303,37 @@ func dataFromTerm(t Term) (specData, error) {
 		return dataFromCont(term)
 	case FwdSpec:
 		return dataFromValue(term), nil
+	case AcqSpec:
+		return dataFromCont(term)
+	case AccSpec:
+		return dataFromCont(term)
+	case RelSpec:
+		return dataFromCont(term)
+	case DetSpec:
+		return dataFromValue(term), nil
+	case LinkSpec:
+		return specData{
+			K: link,
+			Link: &linkData{
+				PE:  ph.DataFromPH(term.PE),
+				CEs: ph.DataFromPHs(term.CEs),
+				Sig: term.Sig.String(),
+			},
+		}, nil
+	case SpawnSpec:
+		dto, err := dataFromTerm(term.Cont)
+		if err != nil {
+			return specData{}, err
+		}
+		return specData{
+			K: spawn,
+			Spawn: &spawnData{
+				PE:   ph.DataFromPH(term.PE),
+				CEs:  ph.DataFromPHs(term.CEs),
+				Cont: dto,
+				Sig:  term.Sig.String(),
+			},
+		}, nil
 	case CTASpec:
 		return specData{
 			K: cta,
@@ -243,6 +363,42 @@ func dataToTerm(dto specData) (Term, error) {
 		return dataToCont(dto)
 	case fwd:
 		return dataToValue(dto)
+	case acq, acc, rel:
+		return dataToCont(dto)
+	case det:
+		return dataToValue(dto)
+	case link:
+		pe, err := ph.DataToPH(dto.Link.PE)
+		if err != nil {
+			return nil, err
+		}
+		ces, err := ph.DataToPHs(dto.Link.CEs)
+		if err != nil {
+			return nil, err
+		}
+		sig, err := id.ConvertFromString(dto.Link.Sig)
+		if err != nil {
+			return nil, err
+		}
+		return LinkSpec{PE: pe, CEs: ces, Sig: sig}, nil
+	case spawn:
+		pe, err := ph.DataToPH(dto.Spawn.PE)
+		if err != nil {
+			return nil, err
+		}
+		ces, err := ph.DataToPHs(dto.Spawn.CEs)
+		if err != nil {
+			return nil, err
+		}
+		cont, err := dataToTerm(dto.Spawn.Cont)
+		if err != nil {
+			return nil, err
+		}
+		sig, err := id.ConvertFromString(dto.Spawn.Sig)
+		if err != nil {
+			return nil, err
+		}
+		return SpawnSpec{PE: pe, CEs: ces, Cont: cont, Sig: sig}, nil
 	case cta:
 		key, err := ak.ConvertFromString(dto.CTA.AK)
 		if err != nil {
@@ -283,6 +439,11 @@ func dataFromValue(v Value) specData {
 				D: ph.DataFromPH(val.D),
 			},
 		}
+	case DetSpec:
+		return specData{
+			K:   det,
+			Det: &detData{ph.DataFromPH(val.X), ph.DataFromPH(val.Y)},
+		}
 	default:
 		panic(ErrValTypeUnexpected(val))
 	}
@@ -322,6 +483,16 @@ func dataToValue(dto specData) (Value, error) {
 			return nil, err
 		}
 		return FwdSpec{C: c, D: d}, nil
+	case det:
+		x, err := ph.DataToPH(dto.Det.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := ph.DataToPH(dto.Det.Y)
+		if err != nil {
+			return nil, err
+		}
+		return DetSpec{X: x, Y: y}, nil
 	default:
 		panic(errUnexpectedTermKind(dto.K))
 	}
@@ -378,6 +549,24 @@ func dataFromCont(c Continuation) (specData, error) {
 				D: ph.DataFromPH(cont.D),
 			},
 		}, nil
+	case AcqSpec:
+		dto, err := dataFromShift(cont.X, cont.Y, cont.Cont)
+		if err != nil {
+			return specData{}, err
+		}
+		return specData{K: acq, Acq: dto}, nil
+	case AccSpec:
+		dto, err := dataFromShift(cont.X, cont.Y, cont.Cont)
+		if err != nil {
+			return specData{}, err
+		}
+		return specData{K: acc, Acc: dto}, nil
+	case RelSpec:
+		dto, err := dataFromShift(cont.X, cont.Y, cont.Cont)
+		if err != nil {
+			return specData{}, err
+		}
+		return specData{K: rel, Rel: dto}, nil
 	default:
 		panic(ErrContTypeUnexpected(cont))
 	}
@@ -433,11 +622,124 @@ func dataToCont(dto specData) (Continuation, error) {
 			return nil, err
 		}
 		return FwdSpec{C: c, D: d}, nil
+	case acq:
+		x, y, cont, err := dataToShift(dto.Acq)
+		if err != nil {
+			return nil, err
+		}
+		return AcqSpec{X: x, Y: y, Cont: cont}, nil
+	case acc:
+		x, y, cont, err := dataToShift(dto.Acc)
+		if err != nil {
+			return nil, err
+		}
+		return AccSpec{X: x, Y: y, Cont: cont}, nil
+	case rel:
+		x, y, cont, err := dataToShift(dto.Rel)
+		if err != nil {
+			return nil, err
+		}
+		return RelSpec{X: x, Y: y, Cont: cont}, nil
 	default:
 		panic(errUnexpectedTermKind(dto.K))
 	}
 }
 
+func dataFromShift(x, y ph.ADT, t Term) (*shiftData, error) {
+	cont, err := dataFromTerm(t)
+	if err != nil {
+		return nil, err
+	}
+	return &shiftData{
+		X:    ph.DataFromPH(x),
+		Y:    ph.DataFromPH(y),
+		Cont: cont,
+	}, nil
+}
+
+func dataToShift(dto *shiftData) (ph.ADT, ph.ADT, Term, error) {
+	x, err := ph.DataToPH(dto.X)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	y, err := ph.DataToPH(dto.Y)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cont, err := dataToTerm(dto.Cont)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return x, y, cont, nil
+}
+
+func dataFromEntry(e Entry) (entryData, error) {
+	term, err := dataFromTerm(e.Term)
+	if err != nil {
+		return entryData{}, err
+	}
+	return entryData{
+		ID:         e.ID.String(),
+		PID:        e.PID.String(),
+		Term:       term,
+		PreVID:     id.ConvertToNullString(e.PreVID),
+		VID:        id.ConvertToNullString(e.VID),
+		PreStateID: id.ConvertPtrToNullString(e.PreStateID),
+		StateID:    id.ConvertPtrToNullString(e.StateID),
+		AK:         sql.NullString{String: e.AK.String(), Valid: e.AK != ak.ADT{}},
+		TakenAt:    e.TakenAt,
+	}, nil
+}
+
+func dataToEntry(dto entryData) (Entry, error) {
+	ident, err := id.ConvertFromString(dto.ID)
+	if err != nil {
+		return Entry{}, err
+	}
+	pid, err := id.ConvertFromString(dto.PID)
+	if err != nil {
+		return Entry{}, err
+	}
+	term, err := dataToTerm(dto.Term)
+	if err != nil {
+		return Entry{}, err
+	}
+	preVID, err := id.ConvertFromNullString(dto.PreVID)
+	if err != nil {
+		return Entry{}, err
+	}
+	vid, err := id.ConvertFromNullString(dto.VID)
+	if err != nil {
+		return Entry{}, err
+	}
+	preStateID, err := id.ConvertPtrFromNullString(dto.PreStateID)
+	if err != nil {
+		return Entry{}, err
+	}
+	stateID, err := id.ConvertPtrFromNullString(dto.StateID)
+	if err != nil {
+		return Entry{}, err
+	}
+	var key ak.ADT
+	if dto.AK.Valid {
+		key, err = ak.ConvertFromString(dto.AK.String)
+		if err != nil {
+			return Entry{}, err
+		}
+	}
+	return Entry{
+		ID:         ident,
+		PID:        pid,
+		Term:       term,
+		PreVID:     preVID,
+		VID:        vid,
+		PreStateID: preStateID,
+		StateID:    stateID,
+		AK:         key,
+		TakenAt:    dto.TakenAt,
+	}, nil
+}
+
 func errUnexpectedTermKind(k termKind) error {
 	return fmt.Errorf("unexpected term kind: %v", k)
 }
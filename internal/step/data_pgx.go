@@ -1,26 +1,26 @@
 package step
 
 import (
-	"context"
 	"errors"
 	"log/slog"
+	"time"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 
 	"smecalculus/rolevod/internal/chnl"
 	"smecalculus/rolevod/lib/core"
+	"smecalculus/rolevod/lib/data"
+	"smecalculus/rolevod/lib/id"
 )
 
 // Adapter
 type repoPgx struct {
-	pool *pgxpool.Pool
-	log  *slog.Logger
+	log *slog.Logger
 }
 
-func newRepoPgx(p *pgxpool.Pool, l *slog.Logger) *repoPgx {
+func newRepoPgx(l *slog.Logger) *repoPgx {
 	name := slog.String("name", "stepRepoPgx")
-	return &repoPgx{p, l.With(name)}
+	return &repoPgx{l.With(name)}
 }
 
 // for compilation purposes
@@ -28,71 +28,497 @@ func newRepo() Repo {
 	return &repoPgx{}
 }
 
-func (r *repoPgx) Insert(root Root) error {
-	ctx := context.Background()
-	tx, err := r.pool.Begin(ctx)
-	if err != nil {
-		return err
-	}
+func (r *repoPgx) Insert(source data.Source, root Root) error {
+	ds := data.MustConform[data.SourcePgx](source)
 	dto, err := dataFromRoot(root)
 	if err != nil {
 		return err
 	}
 	query := `
 		INSERT INTO steps (
-			id, kind, pid, vid, spec
+			id, kind, pid, vid, next_vid, spec, deadline
 		) VALUES (
-			@id, @kind, @pid, @vid, @spec
+			@id, @kind, @pid, @vid, @next_vid, @spec, @deadline
 		)`
 	args := pgx.NamedArgs{
-		"id":   dto.ID,
-		"kind": dto.K,
-		"pid":  dto.PID,
-		"vid":  dto.VID,
-		"spec": dto.Spec,
+		"id":       dto.ID,
+		"kind":     dto.K,
+		"pid":      dto.PID,
+		"vid":      dto.VID,
+		"next_vid": dto.NextVID,
+		"spec":     dto.Spec,
+		"deadline": dto.Deadline,
 	}
-	_, err = tx.Exec(ctx, query, args)
+	_, err = ds.Conn.Exec(ds.Ctx, query, args)
 	if err != nil {
 		r.log.Error("query execution failed", slog.Any("reason", err))
-		return errors.Join(err, tx.Rollback(ctx))
+		return err
+	}
+	switch root := root.(type) {
+	case MsgRoot:
+		return r.notify(ds, chnl.Event{Kind: chnl.Arrived, VID: root.VID, SID: root.ID})
+	case SrvRoot:
+		return r.notify(ds, chnl.Event{Kind: chnl.Awaited, VID: root.VID, SID: root.ID})
+	default:
+		return nil
+	}
+}
+
+func (r *repoPgx) notify(ds data.SourcePgx, ev chnl.Event) error {
+	payload, err := chnl.DataFromEvent(ev)
+	if err != nil {
+		return err
 	}
-	return tx.Commit(ctx)
+	err = ds.Notify(chnl.Topic(ev.VID), payload)
+	if err != nil {
+		r.log.Error("notification failed", slog.Any("reason", err), slog.Any("event", ev))
+		return err
+	}
+	return nil
 }
 
-func (r *repoPgx) SelectAll() ([]Ref, error) {
+func (r *repoPgx) SelectAll(source data.Source) ([]Ref, error) {
 	return nil, nil
 }
 
-func (r *repoPgx) SelectByID(rid ID) (Root, error) {
+func (r *repoPgx) SelectByID(source data.Source, rid ID) (Root, error) {
 	query := `
 		SELECT
-			id, kind, pid, vid, spec
+			id, kind, pid, vid, next_vid, spec, deadline
 		FROM steps
 		WHERE id = $1`
-	return r.execute(query, rid.String())
+	return r.execute(source, query, rid.String())
 }
 
-func (r *repoPgx) SelectByPID(pid chnl.ID) (Root, error) {
+func (r *repoPgx) SelectByPID(source data.Source, pid chnl.ID) (Root, error) {
 	query := `
 		SELECT
-			id, kind, pid, vid, spec
+			id, kind, pid, vid, next_vid, spec, deadline
 		FROM steps
 		WHERE pid = $1`
-	return r.execute(query, pid.String())
+	return r.execute(source, query, pid.String())
+}
+
+func (r *repoPgx) SelectByVID(source data.Source, vid chnl.ID) (Root, error) {
+	query := `
+		SELECT
+			id, kind, pid, vid, next_vid, spec, deadline
+		FROM steps
+		WHERE vid = $1
+		ORDER BY id
+		LIMIT 1`
+	return r.execute(source, query, vid.String())
 }
 
-func (r *repoPgx) SelectByVID(vid chnl.ID) (Root, error) {
+func (r *repoPgx) SelectReady(source data.Source, limit int) ([]ProcRoot, error) {
+	ds := data.MustConform[data.SourcePgx](source)
 	query := `
 		SELECT
-			id, kind, pid, vid, spec
+			id, kind, pid, vid, next_vid, spec, deadline
 		FROM steps
-		WHERE vid = $1`
-	return r.execute(query, vid.String())
+		WHERE kind = $1
+			AND (spec->>'k')::smallint <> $2
+		ORDER BY id
+		LIMIT $3`
+	rows, err := ds.Conn.Query(ds.Ctx, query, proc, cta, limit)
+	if err != nil {
+		r.log.Error("query execution failed", slog.Any("reason", err))
+		return nil, err
+	}
+	defer rows.Close()
+	dtos, err := pgx.CollectRows(rows, pgx.RowToStructByName[rootData])
+	if err != nil {
+		r.log.Error("rows collection failed", slog.Any("reason", err))
+		return nil, err
+	}
+	procs := make([]ProcRoot, 0, len(dtos))
+	for _, dto := range dtos {
+		root, err := dataToRoot(&dto)
+		if err != nil {
+			r.log.Error("dto mapping failed", slog.Any("reason", err))
+			return nil, err
+		}
+		procs = append(procs, root.(ProcRoot))
+	}
+	r.log.Log(ds.Ctx, core.LevelTrace, "steps selection succeeded", slog.Any("procs", procs))
+	return procs, nil
+}
+
+func (r *repoPgx) SelectQueueLen(source data.Source, vid chnl.ID) (int, error) {
+	ds := data.MustConform[data.SourcePgx](source)
+	// queued msgs sit on consecutive predecessors of the latest version
+	query := `
+		WITH RECURSIVE queue AS (
+			SELECT s.vid
+			FROM channels c
+			JOIN steps s ON s.vid = c.pre_id AND s.next_vid = c.id
+			WHERE c.id = $1
+			UNION ALL
+			SELECT s.vid
+			FROM queue q
+			JOIN channels c ON c.id = q.vid
+			JOIN steps s ON s.vid = c.pre_id AND s.next_vid = c.id
+		)
+		SELECT count(*) FROM queue`
+	var n int
+	err := ds.Conn.QueryRow(ds.Ctx, query, vid.String()).Scan(&n)
+	if err != nil {
+		r.log.Error("query execution failed", slog.Any("reason", err), slog.Any("vid", vid))
+		return 0, err
+	}
+	return n, nil
+}
+
+func (r *repoPgx) EnqueueAcquirer(source data.Source, root SrvRoot) error {
+	ds := data.MustConform[data.SourcePgx](source)
+	dto, err := dataFromRoot(root)
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO acquirers (
+			id, kind, pid, vid, next_vid, spec, deadline
+		) VALUES (
+			@id, @kind, @pid, @vid, @next_vid, @spec, @deadline
+		)`
+	args := pgx.NamedArgs{
+		"id":       dto.ID,
+		"kind":     dto.K,
+		"pid":      dto.PID,
+		"vid":      dto.VID,
+		"next_vid": dto.NextVID,
+		"spec":     dto.Spec,
+		"deadline": dto.Deadline,
+	}
+	_, err = ds.Conn.Exec(ds.Ctx, query, args)
+	if err != nil {
+		r.log.Error("query execution failed", slog.Any("reason", err))
+		return err
+	}
+	return nil
+}
+
+func (r *repoPgx) DequeueAcquirer(source data.Source, vid chnl.ID) (Root, error) {
+	query := `
+		DELETE FROM acquirers
+		WHERE pos = (
+			SELECT pos
+			FROM acquirers
+			WHERE vid = $1
+			ORDER BY pos
+			LIMIT 1
+			FOR UPDATE
+		)
+		RETURNING id, kind, pid, vid, next_vid, spec, deadline`
+	return r.execute(source, query, vid.String())
+}
+
+func (r *repoPgx) Delete(source data.Source, rid ID) error {
+	ds := data.MustConform[data.SourcePgx](source)
+	query := `
+		DELETE FROM steps
+		WHERE id = $1`
+	ct, err := ds.Conn.Exec(ds.Ctx, query, rid.String())
+	if err != nil {
+		r.log.Error("query execution failed", slog.Any("reason", err))
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return ErrDoesNotExist(rid)
+	}
+	return nil
+}
+
+func (r *repoPgx) SelectPending(source data.Source, pids []chnl.ID) ([]Root, error) {
+	if len(pids) == 0 {
+		return []Root{}, nil
+	}
+	ds := data.MustConform[data.SourcePgx](source)
+	query := `
+		SELECT
+			s.id, s.kind, s.pid, s.vid, s.next_vid, s.spec, s.deadline
+		FROM steps s
+		WHERE s.kind IN ($1, $2)
+			AND s.pid = ANY($3)
+			AND s.interrupted_at IS NULL
+			AND NOT EXISTS (
+				SELECT 1
+				FROM channels
+				WHERE pre_id = s.vid
+			)
+		ORDER BY s.id`
+	pidStrs := make([]string, 0, len(pids))
+	for _, pid := range pids {
+		pidStrs = append(pidStrs, pid.String())
+	}
+	rows, err := ds.Conn.Query(ds.Ctx, query, msg, srv, pidStrs)
+	if err != nil {
+		r.log.Error("query execution failed", slog.Any("reason", err))
+		return nil, err
+	}
+	defer rows.Close()
+	dtos, err := pgx.CollectRows(rows, pgx.RowToStructByName[rootData])
+	if err != nil {
+		r.log.Error("rows collection failed", slog.Any("reason", err))
+		return nil, err
+	}
+	roots := make([]Root, 0, len(dtos))
+	for _, dto := range dtos {
+		root, err := dataToRoot(&dto)
+		if err != nil {
+			r.log.Error("dto mapping failed", slog.Any("reason", err))
+			return nil, err
+		}
+		roots = append(roots, root)
+	}
+	r.log.Log(ds.Ctx, core.LevelTrace, "steps selection succeeded", slog.Any("roots", roots))
+	return roots, nil
+}
+
+func (r *repoPgx) SelectExpired(source data.Source, now time.Time, limit int) ([]Root, error) {
+	ds := data.MustConform[data.SourcePgx](source)
+	query := `
+		SELECT
+			s.id, s.kind, s.pid, s.vid, s.next_vid, s.spec, s.deadline
+		FROM steps s
+		WHERE s.kind IN ($1, $2)
+			AND s.deadline <= $3
+			AND s.interrupted_at IS NULL
+			AND NOT EXISTS (
+				SELECT 1
+				FROM channels
+				WHERE pre_id = s.vid
+			)
+		ORDER BY s.deadline
+		LIMIT $4`
+	rows, err := ds.Conn.Query(ds.Ctx, query, msg, srv, now, limit)
+	if err != nil {
+		r.log.Error("query execution failed", slog.Any("reason", err))
+		return nil, err
+	}
+	defer rows.Close()
+	dtos, err := pgx.CollectRows(rows, pgx.RowToStructByName[rootData])
+	if err != nil {
+		r.log.Error("rows collection failed", slog.Any("reason", err))
+		return nil, err
+	}
+	roots := make([]Root, 0, len(dtos))
+	for _, dto := range dtos {
+		root, err := dataToRoot(&dto)
+		if err != nil {
+			r.log.Error("dto mapping failed", slog.Any("reason", err))
+			return nil, err
+		}
+		roots = append(roots, root)
+	}
+	r.log.Log(ds.Ctx, core.LevelTrace, "steps selection succeeded", slog.Any("roots", roots))
+	return roots, nil
+}
+
+func (r *repoPgx) Interrupt(source data.Source, sid ID, cause Cause, now time.Time) (bool, error) {
+	ds := data.MustConform[data.SourcePgx](source)
+	query := `
+		UPDATE steps s
+		SET interrupted_at = $2, cause = $3
+		WHERE s.id = $1
+			AND s.interrupted_at IS NULL
+			AND NOT EXISTS (
+				SELECT 1
+				FROM channels
+				WHERE pre_id = s.vid
+			)`
+	ct, err := ds.Conn.Exec(ds.Ctx, query, sid.String(), now, cause)
+	if err != nil {
+		r.log.Error("query execution failed", slog.Any("reason", err), slog.Any("id", sid))
+		return false, err
+	}
+	return ct.RowsAffected() == 1, nil
+}
+
+func (r *repoPgx) Cancel(source data.Source, pid chnl.ID, now time.Time) error {
+	ds := data.MustConform[data.SourcePgx](source)
+	insert := `
+		INSERT INTO cancellations (
+			pid, cancelled_at
+		) VALUES (
+			$1, $2
+		)
+		ON CONFLICT (pid) DO NOTHING`
+	_, err := ds.Conn.Exec(ds.Ctx, insert, pid.String(), now)
+	if err != nil {
+		r.log.Error("query execution failed", slog.Any("reason", err), slog.Any("pid", pid))
+		return err
+	}
+	// queued acquirers won't be served anymore
+	dequeue := `
+		DELETE FROM acquirers
+		WHERE pid = $1`
+	_, err = ds.Conn.Exec(ds.Ctx, dequeue, pid.String())
+	if err != nil {
+		r.log.Error("query execution failed", slog.Any("reason", err), slog.Any("pid", pid))
+		return err
+	}
+	// pending msgs and srvs are interrupted by caller
+	remove := `
+		DELETE FROM steps
+		WHERE kind = $1
+			AND pid = $2`
+	_, err = ds.Conn.Exec(ds.Ctx, remove, proc, pid.String())
+	if err != nil {
+		r.log.Error("query execution failed", slog.Any("reason", err), slog.Any("pid", pid))
+		return err
+	}
+	return nil
+}
+
+func (r *repoPgx) SelectCancelled(source data.Source, pid chnl.ID) (bool, error) {
+	ds := data.MustConform[data.SourcePgx](source)
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM cancellations
+			WHERE pid = $1
+		)`
+	var cancelled bool
+	err := ds.Conn.QueryRow(ds.Ctx, query, pid.String()).Scan(&cancelled)
+	if err != nil {
+		r.log.Error("query execution failed", slog.Any("reason", err), slog.Any("pid", pid))
+		return false, err
+	}
+	return cancelled, nil
+}
+
+func (r *repoPgx) InsertReceipt(source data.Source, root Receipt) (bool, error) {
+	ds := data.MustConform[data.SourcePgx](source)
+	// concurrent insertion waits for the first one to commit or rollback
+	query := `
+		INSERT INTO receipts (
+			ik, pid, digest, taken_at
+		) VALUES (
+			@ik, @pid, @digest, @taken_at
+		)
+		ON CONFLICT (ik) DO NOTHING`
+	args := pgx.NamedArgs{
+		"ik":       root.IK,
+		"pid":      root.PID.String(),
+		"digest":   root.Digest.String(),
+		"taken_at": root.TakenAt,
+	}
+	ct, err := ds.Conn.Exec(ds.Ctx, query, args)
+	if err != nil {
+		r.log.Error("query execution failed", slog.Any("reason", err), slog.Any("ik", root.IK))
+		return false, err
+	}
+	return ct.RowsAffected() == 1, nil
+}
+
+func (r *repoPgx) SelectReceipt(source data.Source, ik IK) (Receipt, error) {
+	ds := data.MustConform[data.SourcePgx](source)
+	query := `
+		SELECT
+			ik, pid, digest, taken_at
+		FROM receipts
+		WHERE ik = $1`
+	rows, err := ds.Conn.Query(ds.Ctx, query, ik)
+	if err != nil {
+		r.log.Error("query execution failed", slog.Any("reason", err), slog.Any("ik", ik))
+		return Receipt{}, err
+	}
+	defer rows.Close()
+	dto, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[receiptData])
+	if err != nil {
+		r.log.Error("row collection failed", slog.Any("reason", err), slog.Any("ik", ik))
+		return Receipt{}, err
+	}
+	pid, err := id.ConvertFromString(dto.PID)
+	if err != nil {
+		r.log.Error("dto mapping failed", slog.Any("reason", err), slog.Any("ik", ik))
+		return Receipt{}, err
+	}
+	digest, err := id.ConvertFromString(dto.Digest)
+	if err != nil {
+		r.log.Error("dto mapping failed", slog.Any("reason", err), slog.Any("ik", ik))
+		return Receipt{}, err
+	}
+	return Receipt{IK: dto.IK, PID: pid, Digest: digest, TakenAt: dto.TakenAt}, nil
+}
+
+func (r *repoPgx) InsertEntry(source data.Source, root Entry) error {
+	ds := data.MustConform[data.SourcePgx](source)
+	dto, err := dataFromEntry(root)
+	if err != nil {
+		r.log.Error("dto mapping failed", slog.Any("reason", err), slog.Any("id", root.ID))
+		return err
+	}
+	query := `
+		INSERT INTO journal (
+			id, pid, term, pre_vid, vid, pre_state_id, state_id, ak, taken_at
+		) VALUES (
+			@id, @pid, @term, @pre_vid, @vid, @pre_state_id, @state_id, @ak, @taken_at
+		)`
+	args := pgx.NamedArgs{
+		"id":           dto.ID,
+		"pid":          dto.PID,
+		"term":         dto.Term,
+		"pre_vid":      dto.PreVID,
+		"vid":          dto.VID,
+		"pre_state_id": dto.PreStateID,
+		"state_id":     dto.StateID,
+		"ak":           dto.AK,
+		"taken_at":     dto.TakenAt,
+	}
+	_, err = ds.Conn.Exec(ds.Ctx, query, args)
+	if err != nil {
+		r.log.Error("query execution failed", slog.Any("reason", err), slog.Any("id", dto.ID))
+		return err
+	}
+	return nil
+}
+
+func (r *repoPgx) SelectEntries(source data.Source, pids []chnl.ID, after ID, limit int) ([]Entry, error) {
+	if len(pids) == 0 {
+		return []Entry{}, nil
+	}
+	ds := data.MustConform[data.SourcePgx](source)
+	// ids are ordered by time of generation
+	query := `
+		SELECT
+			id, pid, term, pre_vid, vid, pre_state_id, state_id, ak, taken_at
+		FROM journal
+		WHERE pid = ANY($1)
+			AND id > $2
+		ORDER BY id
+		LIMIT $3`
+	pidStrs := make([]string, 0, len(pids))
+	for _, pid := range pids {
+		pidStrs = append(pidStrs, pid.String())
+	}
+	rows, err := ds.Conn.Query(ds.Ctx, query, pidStrs, after.String(), limit)
+	if err != nil {
+		r.log.Error("query execution failed", slog.Any("reason", err))
+		return nil, err
+	}
+	defer rows.Close()
+	dtos, err := pgx.CollectRows(rows, pgx.RowToStructByName[entryData])
+	if err != nil {
+		r.log.Error("rows collection failed", slog.Any("reason", err))
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(dtos))
+	for _, dto := range dtos {
+		entry, err := dataToEntry(dto)
+		if err != nil {
+			r.log.Error("dto mapping failed", slog.Any("reason", err), slog.Any("id", dto.ID))
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
 }
 
-func (r *repoPgx) execute(query string, arg string) (Root, error) {
-	ctx := context.Background()
-	rows, err := r.pool.Query(ctx, query, arg)
+func (r *repoPgx) execute(source data.Source, query string, arg string) (Root, error) {
+	ds := data.MustConform[data.SourcePgx](source)
+	rows, err := ds.Conn.Query(ds.Ctx, query, arg)
 	if err != nil {
 		r.log.Error("query execution failed", slog.Any("reason", err))
 		return nil, err
@@ -111,6 +537,6 @@ func (r *repoPgx) execute(query string, arg string) (Root, error) {
 		r.log.Error("dto mapping failed", slog.Any("reason", err))
 		return nil, err
 	}
-	r.log.Log(ctx, core.LevelTrace, "step selection succeeded", slog.Any("root", root))
+	r.log.Log(ds.Ctx, core.LevelTrace, "step selection succeeded", slog.Any("root", root))
 	return root, nil
 }
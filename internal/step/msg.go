@@ -42,34 +42,43 @@ type ProcRootMsg struct {
 type TermKind string
 
 const (
-	Close = TermKind("close")
-	Wait  = TermKind("wait")
-	Send  = TermKind("send")
-	Recv  = TermKind("recv")
-	Lab   = TermKind("lab")
-	Case  = TermKind("case")
-	CTA   = TermKind("cta")
-	Link  = TermKind("link")
-	Spawn = TermKind("spawn")
-	Fwd   = TermKind("fwd")
+	Close   = TermKind("close")
+	Wait    = TermKind("wait")
+	Send    = TermKind("send")
+	Recv    = TermKind("recv")
+	Lab     = TermKind("lab")
+	Case    = TermKind("case")
+	CTA     = TermKind("cta")
+	Link    = TermKind("link")
+	Spawn   = TermKind("spawn")
+	Fwd     = TermKind("fwd")
+	Acquire = TermKind("acquire")
+	Accept  = TermKind("accept")
+	Release = TermKind("release")
+	Detach  = TermKind("detach")
 )
 
 var termKindRequired = []validation.Rule{
 	validation.Required,
-	validation.In(Close, Wait, Send, Recv, Lab, Case, Spawn, Fwd, CTA),
+	validation.In(Close, Wait, Send, Recv, Lab, Case, Link, Spawn, Fwd, Acquire, Accept, Release, Detach, CTA),
 }
 
 type TermMsg struct {
-	K     TermKind  `json:"kind"`
-	Close *CloseMsg `json:"close,omitempty"`
-	Wait  *WaitMsg  `json:"wait,omitempty"`
-	Send  *SendMsg  `json:"send,omitempty"`
-	Recv  *RecvMsg  `json:"recv,omitempty"`
-	Lab   *LabMsg   `json:"lab,omitempty"`
-	Case  *CaseMsg  `json:"case,omitempty"`
-	Spawn *SpawnMsg `json:"spawn,omitempty"`
-	Fwd   *FwdMsg   `json:"fwd,omitempty"`
-	CTA   *CTAMsg   `json:"cta,omitempty"`
+	K       TermKind   `json:"kind"`
+	Close   *CloseMsg  `json:"close,omitempty"`
+	Wait    *WaitMsg   `json:"wait,omitempty"`
+	Send    *SendMsg   `json:"send,omitempty"`
+	Recv    *RecvMsg   `json:"recv,omitempty"`
+	Lab     *LabMsg    `json:"lab,omitempty"`
+	Case    *CaseMsg   `json:"case,omitempty"`
+	Link    *LinkMsg   `json:"link,omitempty"`
+	Spawn   *SpawnMsg  `json:"spawn,omitempty"`
+	Fwd     *FwdMsg    `json:"fwd,omitempty"`
+	Acquire *ShiftMsg  `json:"acquire,omitempty"`
+	Accept  *ShiftMsg  `json:"accept,omitempty"`
+	Release *ShiftMsg  `json:"release,omitempty"`
+	Detach  *DetachMsg `json:"detach,omitempty"`
+	CTA     *CTAMsg    `json:"cta,omitempty"`
 }
 
 func (dto TermMsg) Validate() error {
@@ -81,8 +90,13 @@ func (dto TermMsg) Validate() error {
 		validation.Field(&dto.Recv, validation.Required.When(dto.K == Recv)),
 		validation.Field(&dto.Lab, validation.Required.When(dto.K == Lab)),
 		validation.Field(&dto.Case, validation.Required.When(dto.K == Case)),
+		validation.Field(&dto.Link, validation.Required.When(dto.K == Link)),
 		validation.Field(&dto.Spawn, validation.Required.When(dto.K == Spawn)),
 		validation.Field(&dto.Fwd, validation.Required.When(dto.K == Fwd)),
+		validation.Field(&dto.Acquire, validation.Required.When(dto.K == Acquire)),
+		validation.Field(&dto.Accept, validation.Required.When(dto.K == Accept)),
+		validation.Field(&dto.Release, validation.Required.When(dto.K == Release)),
+		validation.Field(&dto.Detach, validation.Required.When(dto.K == Detach)),
 		validation.Field(&dto.CTA, validation.Required.When(dto.K == CTA)),
 	)
 }
@@ -175,17 +189,37 @@ func (dto BranchMsg) Validate() error {
 	)
 }
 
+type LinkMsg struct {
+	PE  ph.Msg   `json:"pe"`
+	CEs []string `json:"ces,omitempty"`
+	// supersedes ces if some of endpoints are placeholders
+	CEPHs []ph.Msg `json:"ce_phs,omitempty"`
+	Sig   string   `json:"sig_id"`
+}
+
+func (dto LinkMsg) Validate() error {
+	return validation.ValidateStruct(&dto,
+		validation.Field(&dto.PE, validation.Required),
+		validation.Field(&dto.CEs, append(core.CtxOptional, validation.Nil.When(dto.CEPHs != nil))...),
+		validation.Field(&dto.CEPHs, core.CtxOptional...),
+		validation.Field(&dto.Sig, id.Required...),
+	)
+}
+
 type SpawnMsg struct {
-	PE   ph.Msg   `json:"pe"`
-	CEs  []string `json:"ces"`
-	Cont TermMsg  `json:"cont"`
-	Sig  string   `json:"sig_id"`
+	PE  ph.Msg   `json:"pe"`
+	CEs []string `json:"ces,omitempty"`
+	// supersedes ces if some of endpoints are placeholders
+	CEPHs []ph.Msg `json:"ce_phs,omitempty"`
+	Cont  TermMsg  `json:"cont"`
+	Sig   string   `json:"sig_id"`
 }
 
 func (dto SpawnMsg) Validate() error {
 	return validation.ValidateStruct(&dto,
 		validation.Field(&dto.PE, validation.Required),
-		validation.Field(&dto.CEs, core.CtxOptional...),
+		validation.Field(&dto.CEs, append(core.CtxOptional, validation.Nil.When(dto.CEPHs != nil))...),
+		validation.Field(&dto.CEPHs, core.CtxOptional...),
 		validation.Field(&dto.Cont, validation.Required),
 		validation.Field(&dto.Sig, id.Required...),
 	)
@@ -203,6 +237,32 @@ func (dto FwdMsg) Validate() error {
 	)
 }
 
+type ShiftMsg struct {
+	X    ph.Msg  `json:"x"`
+	Y    ph.Msg  `json:"y"`
+	Cont TermMsg `json:"cont"`
+}
+
+func (dto ShiftMsg) Validate() error {
+	return validation.ValidateStruct(&dto,
+		validation.Field(&dto.X, validation.Required),
+		validation.Field(&dto.Y, validation.Required),
+		validation.Field(&dto.Cont, validation.Required),
+	)
+}
+
+type DetachMsg struct {
+	X ph.Msg `json:"x"`
+	Y ph.Msg `json:"y"`
+}
+
+func (dto DetachMsg) Validate() error {
+	return validation.ValidateStruct(&dto,
+		validation.Field(&dto.X, validation.Required),
+		validation.Field(&dto.Y, validation.Required),
+	)
+}
+
 type CTAMsg struct {
 	AK  string `json:"access_key"`
 	Sig string `json:"sig_id"`
@@ -228,6 +288,30 @@ var (
 	MsgToProcRoot   func(ProcRootMsg) (ProcRoot, error)
 )
 
+type EntryMsg struct {
+	ID         string  `json:"id"`
+	PID        string  `json:"pid"`
+	Term       TermMsg `json:"term"`
+	PreVID     string  `json:"pre_vid"`
+	VID        string  `json:"vid"`
+	PreStateID *string `json:"pre_state_id"`
+	StateID    *string `json:"state_id"`
+	AK         string  `json:"access_key"`
+	TakenAt    string  `json:"taken_at"`
+}
+
+// goverter:variables
+// goverter:output:format assign-variable
+// goverter:extend smecalculus/rolevod/lib/id:Convert.*
+// goverter:extend smecalculus/rolevod/lib/ak:Convert.*
+// goverter:extend smecalculus/rolevod/lib/tm:Convert.*
+// goverter:extend MsgFromTerm
+// goverter:extend MsgToTerm
+var (
+	MsgFromEntries func([]Entry) []EntryMsg
+	MsgToEntries   func([]EntryMsg) ([]Entry, error)
+)
+
 func MsgFromTermNilable(t Term) *TermMsg {
 	if t == nil {
 		return nil
@@ -290,14 +374,27 @@ func MsgFromTerm(t Term) TermMsg {
 				Brs: brs,
 			},
 		}
+	case LinkSpec:
+		ces, cePHs := msgFromCEs(term.CEs)
+		return TermMsg{
+			K: Link,
+			Link: &LinkMsg{
+				PE:    ph.MsgFromPH(term.PE),
+				CEs:   ces,
+				CEPHs: cePHs,
+				Sig:   term.Sig.String(),
+			},
+		}
 	case SpawnSpec:
+		ces, cePHs := msgFromCEs(term.CEs)
 		return TermMsg{
 			K: Spawn,
 			Spawn: &SpawnMsg{
-				PE:   ph.MsgFromPH(term.PE),
-				CEs:  id.ConvertToStrings(term.CEs),
-				Cont: MsgFromTerm(term.Cont),
-				Sig:  term.Sig.String(),
+				PE:    ph.MsgFromPH(term.PE),
+				CEs:   ces,
+				CEPHs: cePHs,
+				Cont:  MsgFromTerm(term.Cont),
+				Sig:   term.Sig.String(),
 			},
 		}
 	case FwdSpec:
@@ -308,6 +405,20 @@ func MsgFromTerm(t Term) TermMsg {
 				D: ph.MsgFromPH(term.D),
 			},
 		}
+	case AcqSpec:
+		return TermMsg{K: Acquire, Acquire: msgFromShift(term.X, term.Y, term.Cont)}
+	case AccSpec:
+		return TermMsg{K: Accept, Accept: msgFromShift(term.X, term.Y, term.Cont)}
+	case RelSpec:
+		return TermMsg{K: Release, Release: msgFromShift(term.X, term.Y, term.Cont)}
+	case DetSpec:
+		return TermMsg{
+			K: Detach,
+			Detach: &DetachMsg{
+				X: ph.MsgFromPH(term.X),
+				Y: ph.MsgFromPH(term.Y),
+			},
+		}
 	case CTASpec:
 		return TermMsg{
 			K: CTA,
@@ -390,12 +501,26 @@ func MsgToTerm(dto TermMsg) (Term, error) {
 			conts[core.Label(b.Label)] = cont
 		}
 		return CaseSpec{X: x, Conts: conts}, nil
+	case Link:
+		pe, err := ph.MsgToPH(dto.Link.PE)
+		if err != nil {
+			return nil, err
+		}
+		ces, err := msgToCEs(dto.Link.CEs, dto.Link.CEPHs)
+		if err != nil {
+			return nil, err
+		}
+		sigID, err := id.ConvertFromString(dto.Link.Sig)
+		if err != nil {
+			return nil, err
+		}
+		return LinkSpec{PE: pe, CEs: ces, Sig: sigID}, nil
 	case Spawn:
 		pe, err := ph.MsgToPH(dto.Spawn.PE)
 		if err != nil {
 			return nil, err
 		}
-		ces, err := id.ConvertFromStrings(dto.Spawn.CEs)
+		ces, err := msgToCEs(dto.Spawn.CEs, dto.Spawn.CEPHs)
 		if err != nil {
 			return nil, err
 		}
@@ -418,6 +543,34 @@ func MsgToTerm(dto TermMsg) (Term, error) {
 			return nil, err
 		}
 		return FwdSpec{C: c, D: d}, nil
+	case Acquire:
+		x, y, cont, err := msgToShift(dto.Acquire)
+		if err != nil {
+			return nil, err
+		}
+		return AcqSpec{X: x, Y: y, Cont: cont}, nil
+	case Accept:
+		x, y, cont, err := msgToShift(dto.Accept)
+		if err != nil {
+			return nil, err
+		}
+		return AccSpec{X: x, Y: y, Cont: cont}, nil
+	case Release:
+		x, y, cont, err := msgToShift(dto.Release)
+		if err != nil {
+			return nil, err
+		}
+		return RelSpec{X: x, Y: y, Cont: cont}, nil
+	case Detach:
+		x, err := ph.MsgToPH(dto.Detach.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := ph.MsgToPH(dto.Detach.Y)
+		if err != nil {
+			return nil, err
+		}
+		return DetSpec{X: x, Y: y}, nil
 	case CTA:
 		key, err := ak.ConvertFromString(dto.CTA.AK)
 		if err != nil {
@@ -433,6 +586,64 @@ func MsgToTerm(dto TermMsg) (Term, error) {
 	}
 }
 
+func msgFromShift(x, y ph.ADT, t Term) *ShiftMsg {
+	return &ShiftMsg{
+		X:    ph.MsgFromPH(x),
+		Y:    ph.MsgFromPH(y),
+		Cont: MsgFromTerm(t),
+	}
+}
+
+func msgToShift(dto *ShiftMsg) (ph.ADT, ph.ADT, Term, error) {
+	x, err := ph.MsgToPH(dto.X)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	y, err := ph.MsgToPH(dto.Y)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cont, err := MsgToTerm(dto.Cont)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return x, y, cont, nil
+}
+
+// channel ids go to ces as before, placeholders need ce_phs
+func msgFromCEs(ces []ph.ADT) ([]string, []ph.Msg) {
+	if ces == nil {
+		return nil, nil
+	}
+	ids := make([]string, 0, len(ces))
+	for _, ce := range ces {
+		ceID, ok := ce.(id.ADT)
+		if !ok {
+			return nil, ph.MsgFromPHs(ces)
+		}
+		ids = append(ids, ceID.String())
+	}
+	return ids, nil
+}
+
+func msgToCEs(ids []string, dtos []ph.Msg) ([]ph.ADT, error) {
+	if dtos != nil {
+		return ph.MsgToPHs(dtos)
+	}
+	if ids == nil {
+		return nil, nil
+	}
+	ces := make([]ph.ADT, len(ids))
+	for i, s := range ids {
+		ceID, err := id.ConvertFromString(s)
+		if err != nil {
+			return nil, err
+		}
+		ces[i] = ceID
+	}
+	return ces, nil
+}
+
 func ErrUnexpectedTermKind(k TermKind) error {
 	return fmt.Errorf("unexpected term kind: %v", k)
 }
@@ -1,6 +1,7 @@
 package ak
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/rs/xid"
@@ -32,6 +33,8 @@ func ConvertToString(ak ADT) string {
 	return xid.ID(ak).String()
 }
 
+var ErrUnexpected = errors.New("unexpected access key")
+
 func ErrUnexpectedKey(k ADT) error {
-	return fmt.Errorf("unexpected access key: %v", k)
+	return fmt.Errorf("%w: %v", ErrUnexpected, k)
 }
@@ -16,4 +16,8 @@ type mapping struct {
 
 type postgres struct {
 	Url string `mapstructure:"url"`
+	// pool size, shared by api requests and reduction workers
+	MaxConns int `mapstructure:"max_conns"`
 }
+
+const DefaultMaxConns = 8
@@ -0,0 +1,230 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// port
+//
+// Source is an ambient unit of work shared by repos
+type Source interface {
+	source()
+}
+
+// port
+type Operator interface {
+	// Explicit runs fn in a single transaction,
+	// which commits only if fn succeeds
+	Explicit(context.Context, func(Source) error) error
+	// Implicit runs fn without transaction
+	Implicit(context.Context, func(Source) error) error
+}
+
+// port
+//
+// Subscriber delivers payloads notified on topic by committed units of work
+type Subscriber interface {
+	// Subscribe returns payloads channel and cancellation func,
+	// channel is closed after cancellation or once payloads are lost,
+	// in the latter case subscriber resubscribes and resyncs its state
+	Subscribe(topic string) (<-chan []byte, func())
+}
+
+// adapter
+type SourcePgx struct {
+	Ctx  context.Context
+	Conn Conn
+}
+
+func (SourcePgx) source() {}
+
+// Notify publishes payload on topic, transactional units of work
+// deliver it on commit only
+func (ds SourcePgx) Notify(topic string, payload []byte) error {
+	msg, err := json.Marshal(notification{topic, payload})
+	if err != nil {
+		return err
+	}
+	_, err = ds.Conn.Exec(ds.Ctx, "SELECT pg_notify($1, $2)", notifyChannel, string(msg))
+	return err
+}
+
+// all topics share single postgres channel
+const notifyChannel = "rolevod_events"
+
+type notification struct {
+	Topic   string          `json:"t"`
+	Payload json.RawMessage `json:"p"`
+}
+
+// adapter
+type subscriberPgx struct {
+	url  string
+	mu   sync.Mutex
+	subs map[string]map[chan []byte]struct{}
+	log  *slog.Logger
+}
+
+func (s *subscriberPgx) Subscribe(topic string) (<-chan []byte, func()) {
+	sub := make(chan []byte, subBufferSize)
+	s.mu.Lock()
+	if s.subs[topic] == nil {
+		s.subs[topic] = make(map[chan []byte]struct{})
+	}
+	s.subs[topic][sub] = struct{}{}
+	s.mu.Unlock()
+	cancel := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.evict(topic, sub)
+	}
+	return sub, cancel
+}
+
+// evict closes subscription unless it's evicted already, caller holds the lock
+func (s *subscriberPgx) evict(topic string, sub chan []byte) {
+	_, ok := s.subs[topic][sub]
+	if !ok {
+		return
+	}
+	delete(s.subs[topic], sub)
+	if len(s.subs[topic]) == 0 {
+		delete(s.subs, topic)
+	}
+	close(sub)
+}
+
+// evictAll closes all subscriptions, e.g. when notifications could be missed
+func (s *subscriberPgx) evictAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for topic, subs := range s.subs {
+		for sub := range subs {
+			s.evict(topic, sub)
+		}
+	}
+}
+
+const subBufferSize = 16
+
+// listen runs on dedicated connection, so that pool stays intact
+func (s *subscriberPgx) listen(ctx context.Context) {
+	for {
+		err := s.listenOnce(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		s.log.Error("listening failed", slog.Any("reason", err))
+		// notifications sent while reconnecting are lost
+		s.evictAll()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (s *subscriberPgx) listenOnce(ctx context.Context) error {
+	conn, err := pgx.Connect(ctx, s.url)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+	_, err = conn.Exec(ctx, "LISTEN "+notifyChannel)
+	if err != nil {
+		return err
+	}
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		var msg notification
+		err = json.Unmarshal([]byte(n.Payload), &msg)
+		if err != nil {
+			s.log.Error("notification unmarshalling failed", slog.Any("reason", err))
+			continue
+		}
+		s.dispatch(msg)
+	}
+}
+
+func (s *subscriberPgx) dispatch(msg notification) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sub := range s.subs[msg.Topic] {
+		select {
+		case sub <- msg.Payload:
+		default:
+			// slow subscribers are evicted rather than block others,
+			// so that they know to resync instead of missing events silently
+			s.log.Warn("subscriber evicted", slog.String("topic", msg.Topic))
+			s.evict(msg.Topic, sub)
+		}
+	}
+}
+
+// common part of pool and transaction
+type Conn interface {
+	Exec(context.Context, string, ...any) (pgconn.CommandTag, error)
+	Query(context.Context, string, ...any) (pgx.Rows, error)
+	QueryRow(context.Context, string, ...any) pgx.Row
+	SendBatch(context.Context, *pgx.Batch) pgx.BatchResults
+}
+
+// adapter
+type operatorPgx struct {
+	pool *pgxpool.Pool
+	log  *slog.Logger
+}
+
+func newOperatorPgx(p *pgxpool.Pool, l *slog.Logger) *operatorPgx {
+	name := slog.String("name", "dataOperatorPgx")
+	return &operatorPgx{p, l.With(name)}
+}
+
+func (o *operatorPgx) Explicit(ctx context.Context, fn func(Source) error) error {
+	tx, err := o.pool.Begin(ctx)
+	if err != nil {
+		o.log.Error("transaction beginning failed", slog.Any("reason", err))
+		return err
+	}
+	err = fn(SourcePgx{ctx, tx})
+	if err != nil {
+		return errors.Join(err, tx.Rollback(ctx))
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		o.log.Error("transaction commit failed", slog.Any("reason", err))
+		return err
+	}
+	return nil
+}
+
+func (o *operatorPgx) Implicit(ctx context.Context, fn func(Source) error) error {
+	return fn(SourcePgx{ctx, o.pool})
+}
+
+// MustConform asserts that source fits the adapter
+func MustConform[T Source](source Source) T {
+	ds, ok := source.(T)
+	if !ok {
+		panic(ErrSourceTypeUnexpected(source))
+	}
+	return ds
+}
+
+func ErrSourceTypeUnexpected(got Source) error {
+	return fmt.Errorf("source type unexpected: %T", got)
+}
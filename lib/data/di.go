@@ -2,6 +2,8 @@ package data
 
 import (
 	"context"
+	"fmt"
+	"log/slog"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"go.uber.org/fx"
@@ -12,6 +14,8 @@ import (
 var Module = fx.Module("lib/data",
 	fx.Provide(
 		newPgx,
+		fx.Annotate(newOperatorPgx, fx.As(new(Operator))),
+		fx.Annotate(newSubscriberPgx, fx.As(new(Subscriber))),
 	),
 	fx.Provide(
 		fx.Private,
@@ -20,20 +24,57 @@ var Module = fx.Module("lib/data",
 )
 
 func newCfg(k core.Keeper) (*props, error) {
-	props := &props{}
+	props := &props{
+		Protocol: protocol{Postgres: postgres{MaxConns: DefaultMaxConns}},
+	}
 	err := k.Load("storage", props)
 	if err != nil {
 		return nil, err
 	}
+	if props.Protocol.Postgres.MaxConns < 1 {
+		return nil, fmt.Errorf("max conns not positive: %v", props.Protocol.Postgres.MaxConns)
+	}
 	return props, nil
 }
 
+func newSubscriberPgx(p *props, l *slog.Logger, lc fx.Lifecycle) *subscriberPgx {
+	name := slog.String("name", "dataSubscriberPgx")
+	s := &subscriberPgx{
+		url:  p.Protocol.Postgres.Url,
+		subs: make(map[string]map[chan []byte]struct{}),
+		log:  l.With(name),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	lc.Append(
+		fx.Hook{
+			OnStart: func(context.Context) error {
+				go func() {
+					defer close(done)
+					s.listen(ctx)
+				}()
+				return nil
+			},
+			OnStop: func(stopCtx context.Context) error {
+				cancel()
+				select {
+				case <-done:
+					return nil
+				case <-stopCtx.Done():
+					return stopCtx.Err()
+				}
+			},
+		},
+	)
+	return s
+}
+
 func newPgx(p *props, lc fx.Lifecycle) (*pgxpool.Pool, error) {
 	config, err := pgxpool.ParseConfig(p.Protocol.Postgres.Url)
 	if err != nil {
 		return nil, err
 	}
-	config.MaxConns = 2
+	config.MaxConns = int32(p.Protocol.Postgres.MaxConns)
 	pgx, err := pgxpool.NewWithConfig(context.Background(), config)
 	if err != nil {
 		return nil, err
@@ -1,7 +1,10 @@
 package id
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
+	"time"
 
 	"github.com/rs/xid"
 )
@@ -18,6 +21,14 @@ func New() ADT {
 	return ADT(xid.New())
 }
 
+// FromDigest derives id from content, so equal contents get equal ids
+func FromDigest(content []byte) ADT {
+	sum := sha256.Sum256(content)
+	var digest xid.ID
+	copy(digest[:], sum[:])
+	return ADT(digest)
+}
+
 func Empty() ADT {
 	return ADT(xid.NilID())
 }
@@ -26,6 +37,22 @@ func (id ADT) IsEmpty() bool {
 	return xid.ID(id).IsZero()
 }
 
+// Compare orders ids by time of generation, with precision of a second
+// across processes and strictly within a process
+func (id ADT) Compare(other ADT) int {
+	return xid.ID(id).Compare(xid.ID(other))
+}
+
+// Bound is the greatest id which could be generated at the second of t
+func Bound(t time.Time) ADT {
+	var bound xid.ID
+	binary.BigEndian.PutUint32(bound[:4], uint32(t.Unix()))
+	for i := 4; i < len(bound); i++ {
+		bound[i] = 0xff
+	}
+	return ADT(bound)
+}
+
 func (id ADT) String() string {
 	return xid.ID(id).String()
 }
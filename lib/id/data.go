@@ -24,3 +24,14 @@ func ConvertPtrToNullString(id *ADT) sql.NullString {
 	}
 	return sql.NullString{String: id.String(), Valid: true}
 }
+
+func ConvertPtrFromNullString(dto sql.NullString) (*ADT, error) {
+	if !dto.Valid {
+		return nil, nil
+	}
+	id, err := ConvertFromString(dto.String)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
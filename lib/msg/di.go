@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -52,6 +53,11 @@ func newEcho(p *props, l *slog.Logger, lc fx.Lifecycle) *echo.Echo {
 			return nil
 		},
 	}))
+	// long lived requests, such as event streams, end on shutdown
+	baseCtx, cancel := context.WithCancel(context.Background())
+	e.Server.BaseContext = func(net.Listener) context.Context {
+		return baseCtx
+	}
 	lc.Append(
 		fx.Hook{
 			OnStart: func(ctx context.Context) error {
@@ -59,6 +65,7 @@ func newEcho(p *props, l *slog.Logger, lc fx.Lifecycle) *echo.Echo {
 				return nil
 			},
 			OnStop: func(ctx context.Context) error {
+				cancel()
 				return e.Shutdown(ctx)
 			},
 		},
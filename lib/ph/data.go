@@ -42,3 +42,29 @@ func DataToPH(dto Data) (ADT, error) {
 		panic(fmt.Errorf("unexpected placeholder kind: %v", dto.K))
 	}
 }
+
+func DataFromPHs(phs []ADT) []Data {
+	if phs == nil {
+		return nil
+	}
+	dtos := make([]Data, len(phs))
+	for i, ph := range phs {
+		dtos[i] = DataFromPH(ph)
+	}
+	return dtos
+}
+
+func DataToPHs(dtos []Data) ([]ADT, error) {
+	if dtos == nil {
+		return nil, nil
+	}
+	phs := make([]ADT, len(dtos))
+	for i, dto := range dtos {
+		ph, err := DataToPH(dto)
+		if err != nil {
+			return nil, err
+		}
+		phs[i] = ph
+	}
+	return phs, nil
+}
@@ -56,3 +56,29 @@ func MsgToPH(dto Msg) (ADT, error) {
 		panic(fmt.Errorf("unexpected placeholder kind: %v", dto.K))
 	}
 }
+
+func MsgFromPHs(phs []ADT) []Msg {
+	if phs == nil {
+		return nil
+	}
+	dtos := make([]Msg, len(phs))
+	for i, ph := range phs {
+		dtos[i] = MsgFromPH(ph)
+	}
+	return dtos
+}
+
+func MsgToPHs(dtos []Msg) ([]ADT, error) {
+	if dtos == nil {
+		return nil, nil
+	}
+	phs := make([]ADT, len(dtos))
+	for i, dto := range dtos {
+		ph, err := MsgToPH(dto)
+		if err != nil {
+			return nil, err
+		}
+		phs[i] = ph
+	}
+	return phs, nil
+}
@@ -0,0 +1,58 @@
+package tm
+
+import (
+	"database/sql"
+	"time"
+)
+
+// zero duration means no timeout
+func ConvertDurationToString(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+func ConvertDurationFromString(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func ConvertDurationToInt(d time.Duration) int64 {
+	return d.Milliseconds()
+}
+
+func ConvertDurationFromInt(ms int64) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
+
+// zero time means no deadline
+func ConvertTimeToString(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func ConvertTimeFromString(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+func ConvertTimeToNullTime(t time.Time) sql.NullTime {
+	if t.IsZero() {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t, Valid: true}
+}
+
+func ConvertTimeFromNullTime(dto sql.NullTime) time.Time {
+	if dto.Valid {
+		return dto.Time
+	}
+	return time.Time{}
+}
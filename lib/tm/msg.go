@@ -0,0 +1,29 @@
+package tm
+
+import (
+	"errors"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+var DurationOptional = []validation.Rule{
+	validation.By(func(v any) error {
+		s, _ := v.(string)
+		if s == "" {
+			return nil
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		if d < 0 {
+			return errors.New("must be positive")
+		}
+		return nil
+	}),
+}
+
+var TimeOptional = []validation.Rule{
+	validation.Date(time.RFC3339Nano),
+}
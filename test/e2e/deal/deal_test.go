@@ -11,6 +11,7 @@ import (
 
 	"smecalculus/rolevod/lib/core"
 	"smecalculus/rolevod/lib/id"
+	"smecalculus/rolevod/lib/ph"
 	"smecalculus/rolevod/lib/sym"
 
 	"smecalculus/rolevod/internal/chnl"
@@ -173,7 +174,7 @@ func TestTake(t *testing.T) {
 			Deal: bigDeal.ID,
 			Sig:  waiterSig.ID,
 			TEs: []chnl.ID{
-				closer.ID,
+				closer.PE.ID,
 			},
 		}
 		waiter, err := dealAPI.Involve(waiterSpec)
@@ -183,9 +184,10 @@ func TestTake(t *testing.T) {
 		// and
 		closeSpec := deal.TranSpec{
 			Deal: bigDeal.ID,
-			PID:  closer.ID,
+			PID:  closer.PE.ID,
+			Key:  closer.AK,
 			Term: step.CloseSpec{
-				A: closer.ID,
+				A: closer.PE.ID,
 			},
 		}
 		// when
@@ -196,11 +198,12 @@ func TestTake(t *testing.T) {
 		// and
 		waitSpec := deal.TranSpec{
 			Deal: bigDeal.ID,
-			PID:  waiter.ID,
+			PID:  waiter.PE.ID,
+			Key:  waiter.AK,
 			Term: step.WaitSpec{
-				X: closer.ID,
+				X: closer.PE.ID,
 				Cont: step.CloseSpec{
-					A: waiter.ID,
+					A: waiter.PE.ID,
 				},
 			},
 		}
@@ -307,8 +310,8 @@ func TestTake(t *testing.T) {
 			Deal: bigDeal.ID,
 			Sig:  oneSig2.ID,
 			TEs: []chnl.ID{
-				receiver.ID,
-				message.ID,
+				receiver.PE.ID,
+				message.PE.ID,
 			},
 		}
 		sender, err := dealAPI.Involve(senderSpec)
@@ -318,14 +321,15 @@ func TestTake(t *testing.T) {
 		// and
 		recvSpec := deal.TranSpec{
 			Deal: bigDeal.ID,
-			PID:  receiver.ID,
+			PID:  receiver.PE.ID,
+			Key:  receiver.AK,
 			Term: step.RecvSpec{
-				X: receiver.ID,
-				Y: message.ID,
+				X: receiver.PE.ID,
+				Y: message.PE.ID,
 				Cont: step.WaitSpec{
-					X: message.ID,
+					X: message.PE.ID,
 					Cont: step.CloseSpec{
-						A: receiver.ID,
+						A: receiver.PE.ID,
 					},
 				},
 			},
@@ -338,10 +342,11 @@ func TestTake(t *testing.T) {
 		// and
 		sendSpec := deal.TranSpec{
 			Deal: bigDeal.ID,
-			PID:  sender.ID,
+			PID:  sender.PE.ID,
+			Key:  sender.AK,
 			Term: step.SendSpec{
-				A: receiver.ID,
-				B: message.ID,
+				A: receiver.PE.ID,
+				B: message.PE.ID,
 			},
 		}
 		// and
@@ -428,7 +433,7 @@ func TestTake(t *testing.T) {
 			Deal: bigDeal.ID,
 			Sig:  oneSig.ID,
 			TEs: []chnl.ID{
-				follower.ID,
+				follower.PE.ID,
 			},
 		}
 		decider, err := dealAPI.Involve(deciderSpec)
@@ -438,12 +443,13 @@ func TestTake(t *testing.T) {
 		// and
 		caseSpec := deal.TranSpec{
 			Deal: bigDeal.ID,
-			PID:  follower.ID,
+			PID:  follower.PE.ID,
+			Key:  follower.AK,
 			Term: step.CaseSpec{
-				X: follower.ID,
+				X: follower.PE.ID,
 				Conts: map[core.Label]step.Term{
 					label: step.CloseSpec{
-						A: follower.ID,
+						A: follower.PE.ID,
 					},
 				},
 			},
@@ -456,9 +462,10 @@ func TestTake(t *testing.T) {
 		// and
 		labSpec := deal.TranSpec{
 			Deal: bigDeal.ID,
-			PID:  decider.ID,
+			PID:  decider.PE.ID,
+			Key:  decider.AK,
 			Term: step.LabSpec{
-				A: follower.ID,
+				A: follower.PE.ID,
 				L: label,
 			},
 		}
@@ -471,6 +478,430 @@ func TestTake(t *testing.T) {
 		// TODO добавить проверку
 	})
 
+	t.Run("LabLabQueued", func(t *testing.T) {
+		tc.Setup(t)
+		// given
+		label := core.Label("label-1")
+		// and
+		plusRoleSpec := role.Spec{
+			FQN: "plus-role",
+			State: state.PlusSpec{
+				Choices: map[core.Label]state.Spec{
+					label: state.PlusSpec{
+						Choices: map[core.Label]state.Spec{
+							label: state.OneSpec{},
+						},
+					},
+				},
+			},
+		}
+		plusRole, err := roleAPI.Create(plusRoleSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		oneRoleSpec := role.Spec{
+			FQN:   "one-role",
+			State: state.OneSpec{},
+		}
+		oneRole, err := roleAPI.Create(oneRoleSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		plusSigSpec := sig.Spec{
+			FQN: "sig-1",
+			PE: chnl.Spec{
+				Key:  "chnl-1",
+				Link: plusRole.FQN,
+			},
+		}
+		plusSig, err := sigAPI.Create(plusSigSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		oneSigSpec := sig.Spec{
+			FQN: "sig-2",
+			PE: chnl.Spec{
+				Key:  "chnl-2",
+				Link: oneRole.FQN,
+			},
+			CEs: []chnl.Spec{
+				plusSig.PE,
+			},
+		}
+		oneSig, err := sigAPI.Create(oneSigSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		bigDealSpec := deal.Spec{
+			Name: "deal-1",
+		}
+		bigDeal, err := dealAPI.Create(bigDealSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		producerSpec := deal.PartSpec{
+			Deal: bigDeal.ID,
+			Sig:  plusSig.ID,
+		}
+		producer, err := dealAPI.Involve(producerSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		consumerSpec := deal.PartSpec{
+			Deal: bigDeal.ID,
+			Sig:  oneSig.ID,
+			TEs: []chnl.ID{
+				producer.PE.ID,
+			},
+		}
+		consumer, err := dealAPI.Involve(consumerSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// when
+		viaID := producer.PE.ID
+		for i := 0; i < 2; i++ {
+			labSpec := deal.TranSpec{
+				Deal: bigDeal.ID,
+				PID:  producer.PE.ID,
+				Key:  producer.AK,
+				Term: step.LabSpec{
+					A: viaID,
+					L: label,
+				},
+			}
+			err = dealAPI.Take(labSpec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// and
+			obligSpec := deal.ObligSpec{
+				Deal: bigDeal.ID,
+				VID:  viaID,
+			}
+			obligs, err := dealAPI.RetrieveObligations(obligSpec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			viaID = obligs[0].VID
+		}
+		// and
+		closeSpec := deal.TranSpec{
+			Deal: bigDeal.ID,
+			PID:  producer.PE.ID,
+			Key:  producer.AK,
+			Term: step.CloseSpec{
+				A: viaID,
+			},
+		}
+		err = dealAPI.Take(closeSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		caseSpec := deal.TranSpec{
+			Deal: bigDeal.ID,
+			PID:  consumer.PE.ID,
+			Key:  consumer.AK,
+			Term: step.CaseSpec{
+				X: producer.PE.ID,
+				Conts: map[core.Label]step.Term{
+					label: step.CaseSpec{
+						X: producer.PE.ID,
+						Conts: map[core.Label]step.Term{
+							label: step.WaitSpec{
+								X: producer.PE.ID,
+								Cont: step.CloseSpec{
+									A: consumer.PE.ID,
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+		err = dealAPI.Take(caseSpec)
+		// then
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("LabLabParametric", func(t *testing.T) {
+		tc.Setup(t)
+		// given
+		label := core.Label("label-1")
+		// and
+		cellRoleSpec := role.Spec{
+			FQN:    "cell-role",
+			Params: []string{"a"},
+			State: state.PlusSpec{
+				Choices: map[core.Label]state.Spec{
+					label: state.PlusSpec{
+						Choices: map[core.Label]state.Spec{
+							label: state.VarSpec{Name: "a"},
+						},
+					},
+				},
+			},
+		}
+		cellRole, err := roleAPI.Create(cellRoleSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		boxRoleSpec := role.Spec{
+			FQN: "box-role",
+			State: state.LinkSpec{
+				Role: cellRole.FQN,
+				Args: []state.Spec{state.OneSpec{}},
+			},
+		}
+		boxRole, err := roleAPI.Create(boxRoleSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		boxSigSpec := sig.Spec{
+			FQN: "sig-1",
+			PE: chnl.Spec{
+				Key:  "chnl-1",
+				Link: boxRole.FQN,
+			},
+		}
+		boxSig, err := sigAPI.Create(boxSigSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		bigDealSpec := deal.Spec{
+			Name: "deal-1",
+		}
+		bigDeal, err := dealAPI.Create(bigDealSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		producerSpec := deal.PartSpec{
+			Deal: bigDeal.ID,
+			Sig:  boxSig.ID,
+		}
+		producer, err := dealAPI.Involve(producerSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// when
+		viaID := producer.PE.ID
+		for i := 0; i < 2; i++ {
+			labSpec := deal.TranSpec{
+				Deal: bigDeal.ID,
+				PID:  producer.PE.ID,
+				Key:  producer.AK,
+				Term: step.LabSpec{
+					A: viaID,
+					L: label,
+				},
+			}
+			// second take goes on via advanced to instantiated state
+			err = dealAPI.Take(labSpec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// and
+			obligSpec := deal.ObligSpec{
+				Deal: bigDeal.ID,
+				VID:  viaID,
+			}
+			obligs, err := dealAPI.RetrieveObligations(obligSpec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			viaID = obligs[0].VID
+		}
+		// and
+		closeSpec := deal.TranSpec{
+			Deal: bigDeal.ID,
+			PID:  producer.PE.ID,
+			Key:  producer.AK,
+			Term: step.CloseSpec{
+				A: viaID,
+			},
+		}
+		err = dealAPI.Take(closeSpec)
+		// then
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("SendQueued", func(t *testing.T) {
+		tc.Setup(t)
+		// given
+		tensorRoleSpec := role.Spec{
+			FQN: "tensor-role",
+			State: state.TensorSpec{
+				B: state.OneSpec{},
+				C: state.OneSpec{},
+			},
+		}
+		tensorRole, err := roleAPI.Create(tensorRoleSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		oneRoleSpec := role.Spec{
+			FQN:   "one-role",
+			State: state.OneSpec{},
+		}
+		oneRole, err := roleAPI.Create(oneRoleSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		oneSigSpec1 := sig.Spec{
+			FQN: "sig-1",
+			PE: chnl.Spec{
+				Key:  "chnl-1",
+				Link: oneRole.FQN,
+			},
+		}
+		oneSig1, err := sigAPI.Create(oneSigSpec1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		tensorSigSpec := sig.Spec{
+			FQN: "sig-2",
+			PE: chnl.Spec{
+				Key:  "chnl-2",
+				Link: tensorRole.FQN,
+			},
+			CEs: []chnl.Spec{
+				oneSig1.PE,
+			},
+		}
+		tensorSig, err := sigAPI.Create(tensorSigSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		oneSigSpec2 := sig.Spec{
+			FQN: "sig-3",
+			PE: chnl.Spec{
+				Key:  "chnl-3",
+				Link: oneRole.FQN,
+			},
+			CEs: []chnl.Spec{
+				tensorSig.PE,
+			},
+		}
+		oneSig2, err := sigAPI.Create(oneSigSpec2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		bigDealSpec := deal.Spec{
+			Name: "deal-1",
+		}
+		bigDeal, err := dealAPI.Create(bigDealSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		messageSpec := deal.PartSpec{
+			Deal: bigDeal.ID,
+			Sig:  oneSig1.ID,
+		}
+		message, err := dealAPI.Involve(messageSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		senderSpec := deal.PartSpec{
+			Deal: bigDeal.ID,
+			Sig:  tensorSig.ID,
+			TEs: []chnl.ID{
+				message.PE.ID,
+			},
+		}
+		sender, err := dealAPI.Involve(senderSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		receiverSpec := deal.PartSpec{
+			Deal: bigDeal.ID,
+			Sig:  oneSig2.ID,
+			TEs: []chnl.ID{
+				sender.PE.ID,
+			},
+		}
+		receiver, err := dealAPI.Involve(receiverSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// when
+		sendSpec := deal.TranSpec{
+			Deal: bigDeal.ID,
+			PID:  sender.PE.ID,
+			Key:  sender.AK,
+			Term: step.SendSpec{
+				A: sender.PE.ID,
+				B: message.PE.ID,
+			},
+		}
+		err = dealAPI.Take(sendSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		reuseSpec := deal.TranSpec{
+			Deal: bigDeal.ID,
+			PID:  sender.PE.ID,
+			Key:  sender.AK,
+			Term: step.WaitSpec{
+				X: message.PE.ID,
+				Cont: step.CloseSpec{
+					A: sender.PE.ID,
+				},
+			},
+		}
+		err = dealAPI.Take(reuseSpec)
+		// then
+		if err == nil {
+			t.Error("sent channel reused by sender")
+		}
+		// and
+		recvSpec := deal.TranSpec{
+			Deal: bigDeal.ID,
+			PID:  receiver.PE.ID,
+			Key:  receiver.AK,
+			Term: step.RecvSpec{
+				X: sender.PE.ID,
+				Y: message.PE.ID,
+				Cont: step.WaitSpec{
+					X: message.PE.ID,
+					Cont: step.WaitSpec{
+						X: sender.PE.ID,
+						Cont: step.CloseSpec{
+							A: receiver.PE.ID,
+						},
+					},
+				},
+			},
+		}
+		err = dealAPI.Take(recvSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
 	t.Run("Spawn", func(t *testing.T) {
 		tc.Setup(t)
 		// given
@@ -552,7 +983,7 @@ func TestTake(t *testing.T) {
 			Deal: bigDeal.ID,
 			Sig:  oneSig2.ID,
 			TEs: []chnl.ID{
-				injectee.ID,
+				injectee.PE.ID,
 			},
 		}
 		spawner, err := dealAPI.Involve(spawnerSpec)
@@ -564,16 +995,17 @@ func TestTake(t *testing.T) {
 		// and
 		spawnSpec := deal.TranSpec{
 			Deal: bigDeal.ID,
-			PID:  spawner.ID,
+			PID:  spawner.PE.ID,
+			Key:  spawner.AK,
 			Term: step.SpawnSpec{
 				PE: z,
-				CEs: []chnl.ID{
-					injectee.ID,
+				CEs: []ph.ADT{
+					injectee.PE.ID,
 				},
 				Cont: step.WaitSpec{
 					X: z,
 					Cont: step.CloseSpec{
-						A: spawner.ID,
+						A: spawner.PE.ID,
 					},
 				},
 				Sig: oneSig3.ID,
@@ -588,6 +1020,92 @@ func TestTake(t *testing.T) {
 		// TODO добавить проверку
 	})
 
+	t.Run("SpawnBody", func(t *testing.T) {
+		tc.Setup(t)
+		// given
+		oneRole, err := roleAPI.Create(
+			role.Spec{
+				FQN:   "one-role",
+				State: state.OneSpec{},
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		oneSig1, err := sigAPI.Create(
+			sig.Spec{
+				FQN: "sig-1",
+				PE: chnl.Spec{
+					Key:  "chnl-1",
+					Link: oneRole.FQN,
+				},
+				Body: step.CloseSpec{
+					A: sym.New("chnl-1"),
+				},
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		oneSig2, err := sigAPI.Create(
+			sig.Spec{
+				FQN: "sig-2",
+				PE: chnl.Spec{
+					Key:  "chnl-2",
+					Link: oneRole.FQN,
+				},
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		bigDeal, err := dealAPI.Create(
+			deal.Spec{
+				Name: "deal-1",
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		spawner, err := dealAPI.Involve(
+			deal.PartSpec{
+				Deal: bigDeal.ID,
+				Sig:  oneSig2.ID,
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		z := sym.New("z")
+		// and
+		spawnSpec := deal.TranSpec{
+			Deal: bigDeal.ID,
+			PID:  spawner.PE.ID,
+			Key:  spawner.AK,
+			Term: step.SpawnSpec{
+				PE: z,
+				Cont: step.WaitSpec{
+					X: z,
+					Cont: step.CloseSpec{
+						A: spawner.PE.ID,
+					},
+				},
+				Sig: oneSig1.ID,
+			},
+		}
+		// when
+		err = dealAPI.Take(spawnSpec)
+		// then
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
 	t.Run("Fwd", func(t *testing.T) {
 		tc.Setup(t)
 		// given
@@ -668,7 +1186,7 @@ func TestTake(t *testing.T) {
 			Deal: bigDeal.ID,
 			Sig:  oneSig2.ID,
 			TEs: []chnl.ID{
-				closer.ID,
+				closer.PE.ID,
 			},
 		}
 		forwarder, err := dealAPI.Involve(forwarderSpec)
@@ -680,7 +1198,7 @@ func TestTake(t *testing.T) {
 			Deal: bigDeal.ID,
 			Sig:  oneSig3.ID,
 			TEs: []chnl.ID{
-				forwarder.ID,
+				forwarder.PE.ID,
 			},
 		}
 		waiter, err := dealAPI.Involve(waiterSpec)
@@ -690,9 +1208,10 @@ func TestTake(t *testing.T) {
 		// and
 		closeSpec := deal.TranSpec{
 			Deal: bigDeal.ID,
-			PID:  closer.ID,
+			PID:  closer.PE.ID,
+			Key:  closer.AK,
 			Term: step.CloseSpec{
-				A: closer.ID,
+				A: closer.PE.ID,
 			},
 		}
 		err = dealAPI.Take(closeSpec)
@@ -703,10 +1222,11 @@ func TestTake(t *testing.T) {
 		fwdSpec := deal.TranSpec{
 			Deal: bigDeal.ID,
 			// канал пересыльщика должен закрыться?
-			PID: forwarder.ID,
+			PID: forwarder.PE.ID,
+			Key: forwarder.AK,
 			Term: step.FwdSpec{
-				C: forwarder.ID,
-				D: closer.ID,
+				C: forwarder.PE.ID,
+				D: closer.PE.ID,
 			},
 		}
 		err = dealAPI.Take(fwdSpec)
@@ -716,11 +1236,12 @@ func TestTake(t *testing.T) {
 		// and
 		waitSpec := deal.TranSpec{
 			Deal: bigDeal.ID,
-			PID:  waiter.ID,
+			PID:  waiter.PE.ID,
+			Key:  waiter.AK,
 			Term: step.WaitSpec{
-				X: forwarder.ID,
+				X: forwarder.PE.ID,
 				Cont: step.CloseSpec{
-					A: waiter.ID,
+					A: waiter.PE.ID,
 				},
 			},
 		}
@@ -731,4 +1252,170 @@ func TestTake(t *testing.T) {
 		// then
 		// TODO добавить проверку
 	})
+
+	t.Run("AcquireAccept", func(t *testing.T) {
+		tc.Setup(t)
+		// given
+		sharedRole, err := roleAPI.Create(
+			role.Spec{
+				FQN: "shared-role",
+				State: state.UpSpec{
+					A: state.DownSpec{
+						A: state.LinkSpec{Role: "shared-role"},
+					},
+				},
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		oneRole, err := roleAPI.Create(
+			role.Spec{
+				FQN:   "one-role",
+				State: state.OneSpec{},
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		sharedSig, err := sigAPI.Create(
+			sig.Spec{
+				FQN: "sig-1",
+				PE: chnl.Spec{
+					Key:  "chnl-1",
+					Link: sharedRole.FQN,
+				},
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		clientSig, err := sigAPI.Create(
+			sig.Spec{
+				FQN: "sig-2",
+				PE: chnl.Spec{
+					Key:  "chnl-2",
+					Link: oneRole.FQN,
+				},
+				CEs: []chnl.Spec{
+					sharedSig.PE,
+				},
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		bigDeal, err := dealAPI.Create(
+			deal.Spec{
+				Name: "deal-1",
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		provider, err := dealAPI.Involve(
+			deal.PartSpec{
+				Deal: bigDeal.ID,
+				Sig:  sharedSig.ID,
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and
+		var clients []deal.PartRoot
+		for range 2 {
+			client, err := dealAPI.Involve(
+				deal.PartSpec{
+					Deal: bigDeal.ID,
+					Sig:  clientSig.ID,
+					TEs: []chnl.ID{
+						provider.PE.ID,
+					},
+				},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			clients = append(clients, client)
+		}
+		// and
+		x := sym.New("x")
+		for _, client := range clients {
+			acqSpec := deal.TranSpec{
+				Deal: bigDeal.ID,
+				PID:  client.PE.ID,
+				Key:  client.AK,
+				Term: step.AcqSpec{
+					X: provider.PE.ID,
+					Y: x,
+					Cont: step.RelSpec{
+						X: x,
+						Y: provider.PE.ID,
+						Cont: step.CloseSpec{
+							A: client.PE.ID,
+						},
+					},
+				},
+			}
+			err = dealAPI.Take(acqSpec)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		// when
+		y := sym.New("y")
+		for range clients {
+			accSpec := deal.TranSpec{
+				Deal: bigDeal.ID,
+				PID:  provider.PE.ID,
+				Key:  provider.AK,
+				Term: step.AccSpec{
+					X: provider.PE.ID,
+					Y: y,
+					Cont: step.DetSpec{
+						X: y,
+						Y: provider.PE.ID,
+					},
+				},
+			}
+			err = dealAPI.Take(accSpec)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		// then
+		histSpec := deal.HistorySpec{
+			Deal:  bigDeal.ID,
+			Limit: 100,
+		}
+		entries, err := dealAPI.RetrieveHistory(histSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		acquirers := make(map[chnl.ID]int, len(clients))
+		accepts := 0
+		for _, entry := range entries {
+			switch entry.Term.(type) {
+			case step.AcqSpec:
+				acquirers[entry.PID]++
+			case step.AccSpec:
+				accepts++
+			}
+		}
+		// and
+		for _, client := range clients {
+			if acquirers[client.PE.ID] != 1 {
+				t.Errorf("unexpected acquisitions by %v; want: %v, got: %v", client.PE.ID, 1, acquirers[client.PE.ID])
+			}
+		}
+		if accepts != len(clients) {
+			t.Errorf("unexpected acceptances; want: %v, got: %v", len(clients), accepts)
+		}
+	})
 }